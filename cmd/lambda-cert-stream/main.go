@@ -25,6 +25,14 @@ const (
 	kAttrValidUntil     = "ValidUntil"
 	kAttrRevocationTime = "RevocationTime"
 	kAttrData           = "Data"
+	kAttrProfile        = "Profile"
+	kAttrPredecessor    = "Predecessor"
+	kAttrSuccessor      = "Successor"
+	kAttrHoldTime       = "HoldTime"
+
+	kAttrRevocationReason  = "RevocationReason"
+	kAttrRevokedBy         = "RevokedBy"
+	kAttrRevocationComment = "RevocationComment"
 )
 
 var (
@@ -86,11 +94,44 @@ func parseCertificate(data map[string]events.DynamoDBAttributeValue) (info pki.C
 			}
 			if intv > 0 {
 				rt := time.Unix(intv, 0).UTC()
-				info.Revoked = &rt
+				if rt.After(time.Now()) {
+					info.RevokeAt = &rt
+				} else {
+					info.Revoked = &rt
+				}
 			}
+		case kAttrHoldTime:
+			intv, err := v.Integer()
+			if err != nil {
+				return info, err
+			}
+			if intv > 0 {
+				ht := time.Unix(intv, 0).UTC()
+				info.OnHold = &ht
+			}
+		case kAttrRevocationReason:
+			intv, err := v.Integer()
+			if err != nil {
+				return info, err
+			}
+			info.RevocationReason = pki.RevocationReason(intv)
+		case kAttrRevokedBy:
+			info.RevokedBy = v.String()
+		case kAttrRevocationComment:
+			info.RevocationComment = v.String()
+		case kAttrProfile:
+			info.Profile = v.String()
+		case kAttrPredecessor:
+			info.Predecessor = hex.EncodeToString(v.Binary())
+		case kAttrSuccessor:
+			info.Successor = hex.EncodeToString(v.Binary())
 		}
 	}
 
+	if info.Revoked != nil {
+		info.OnHold = nil
+	}
+
 	return info, nil
 }
 
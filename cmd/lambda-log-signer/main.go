@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	awsservices "github.com/empathybroker/aws-vpn/pkg/aws"
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	awspki "github.com/empathybroker/aws-vpn/pkg/pki/aws"
+	"github.com/empathybroker/aws-vpn/pkg/pki/storage"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	if os.Getenv("DEBUG") == "true" {
+		log.SetLevel(log.DebugLevel)
+	}
+	log.SetFormatter(&log.JSONFormatter{
+		TimestampFormat: time.RFC3339Nano,
+		FieldMap: log.FieldMap{
+			log.FieldKeyTime: "@timestamp",
+		},
+	})
+
+	pki.RegisterSignerBackend(awspki.NewKMSBackend(awsservices.NewKMSClient()))
+
+	pkiStorage, pkiLog := storage.FromEnv()
+	if pkiLog == nil {
+		log.Fatal("Issuance log not configured")
+	}
+
+	logPKI = pki.NewPKI(pkiStorage)
+	logPKI.SetIssuanceLog(pkiLog)
+}
+
+var (
+	snsClient = awsservices.NewSNSClient()
+	logPKI    *pki.PKI
+)
+
+func handler(ctx context.Context) error {
+	th, err := logPKI.SignTreeHead(ctx)
+	if err != nil {
+		return errors.Wrap(err, "signing tree head")
+	}
+
+	log.Infof("Signed tree head of size %d: %s", th.Size, hex.EncodeToString(th.Hash))
+
+	event := map[string]interface{}{
+		"event":    "log_tree_head",
+		"treeHead": th,
+	}
+
+	// Publishing the head outside AWS lets auditors detect a rewritten log
+	if err := awsservices.PublishEvent(snsClient, ctx, event); err != nil {
+		log.WithError(err).Error("Error publishing event")
+	}
+
+	return nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
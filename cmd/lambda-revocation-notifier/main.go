@@ -95,7 +95,8 @@ func handler(ctx context.Context) error {
 
 	targets := make(map[string][]*pki.CertificateInfo)
 	for _, cert := range certs {
-		if cert.Revoked != nil {
+		// Renewed certificates are already being replaced
+		if cert.Revoked != nil || cert.Successor != "" {
 			continue
 		}
 
@@ -11,7 +11,9 @@ import (
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	awsservices "github.com/empathybroker/aws-vpn/pkg/aws"
 	"github.com/empathybroker/aws-vpn/pkg/pki"
+	awspki "github.com/empathybroker/aws-vpn/pkg/pki/aws"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
@@ -19,6 +21,8 @@ import (
 var (
 	sess    = session.Must(session.NewSession())
 	secrets = secretsmanager.New(sess)
+
+	pkiStorage = awspki.NewAWSStorage(awsservices.NewSecretsManagerClient(), awsservices.NewDynamoDBClient())
 )
 
 const (
@@ -41,6 +45,8 @@ type SecretRotationEvent struct {
 }
 
 func init() {
+	pki.RegisterSignerBackend(awspki.NewKMSBackend(awsservices.NewKMSClient()))
+
 	if os.Getenv("DEBUG") == "true" {
 		log.SetLevel(log.DebugLevel)
 	}
@@ -52,7 +58,13 @@ func init() {
 	})
 }
 
-func renewCAKey(ctx context.Context, secretId string, caName string, serialNumber string) (pki.CAData, error) {
+func renewCAKey(ctx context.Context, secretId string, caName string, serialNumber string, alg pki.KeyAlgorithm) (pki.CAData, error) {
+	// A CA which may issue for any email address can't be created by mistake, e.g. missing AUTH_HOSTED_DOMAINS
+	if len(pki.DefaultNameConstraints.PermittedEmailDomains) == 0 {
+		return pki.CAData{}, errors.New("no email domains to constrain the CA to, set AUTH_HOSTED_DOMAINS as on the authorizer or PKI_NAME_CONSTRAINTS_EMAIL_DOMAINS")
+	}
+	caOpts := []pki.CertOptions{pki.WithNameConstraints(pki.DefaultNameConstraints)}
+
 	res, err := secrets.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
 		SecretId:     aws.String(secretId),
 		VersionStage: aws.String(kStageCurrent),
@@ -62,7 +74,7 @@ func renewCAKey(ctx context.Context, secretId string, caName string, serialNumbe
 			if awsErr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
 				log.Warnf("Secret is empty. Generating new CA Key")
 
-				return pki.NewCAKey(caName, serialNumber, kCAValidity)
+				return pki.NewCAKey(caName, serialNumber, alg, kCAValidity, caOpts...)
 			}
 		} else {
 			return pki.CAData{}, errors.Wrap(err, "obtaining current key")
@@ -73,10 +85,64 @@ func renewCAKey(ctx context.Context, secretId string, caName string, serialNumbe
 	if err := json.Unmarshal(res.SecretBinary, &oldCA); err != nil {
 		log.WithError(err).Errorf("Error unmarshalling current key. New key will not be cross-signed")
 
-		return pki.NewCAKey(caName, serialNumber, kCAValidity)
+		return pki.NewCAKey(caName, serialNumber, alg, kCAValidity, caOpts...)
+	}
+
+	// Scheduled rotations of an intermediate fail here until the schedule is disabled, see the README
+	newCA, err := oldCA.Renew(caName, serialNumber, alg, kCAValidity, caOpts...)
+	if err == pki.ErrIntermediateRenewal {
+		log.Error("The CA is an intermediate. Disable the rotation schedule of the secret and renew it with pki-root")
+		return pki.CAData{}, err
+	} else if err != nil {
+		return pki.CAData{}, err
+	}
+
+	// The old key is kept to sign its CRL until it expires, this one is served if it can't be opened
+	revoked, err := pkiStorage.ListRevokedCerts(ctx)
+	if err != nil {
+		return pki.CAData{}, errors.Wrap(err, "listing revoked certificates")
+	}
+
+	newCA.PrevCRL, err = oldCA.CreateCRL(revoked)
+	if err != nil {
+		return pki.CAData{}, errors.Wrap(err, "signing previous CA CRL")
 	}
 
-	return oldCA.Renew(caName, serialNumber, kCAValidity)
+	return newCA, nil
+}
+
+// testPendingCA checks the pending CA against the current one with pki.CheckRotation
+func testPendingCA(ctx context.Context, secretId string, token string) error {
+	res, err := secrets.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId:     aws.String(secretId),
+		VersionId:    aws.String(token),
+		VersionStage: aws.String(kStagePending),
+	})
+	if err != nil {
+		return errors.Wrap(err, "obtaining pending key")
+	}
+
+	var pendingCA pki.CAData
+	if err := json.Unmarshal(res.SecretBinary, &pendingCA); err != nil {
+		return errors.Wrap(err, "unmarshalling pending key")
+	}
+
+	var currentCA *pki.CAData
+	res, err = secrets.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId:     aws.String(secretId),
+		VersionStage: aws.String(kStageCurrent),
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+		log.Warn("Secret is empty. Testing the first CA alone")
+	} else if err != nil {
+		return errors.Wrap(err, "obtaining current key")
+	} else if err := json.Unmarshal(res.SecretBinary, &currentCA); err != nil {
+		// As in createSecret, an unreadable current key is replaced without cross-signing
+		log.WithError(err).Error("Error unmarshalling current key. Testing the pending CA alone")
+		currentCA = nil
+	}
+
+	return pki.CheckRotation(ctx, currentCA, pendingCA, time.Now())
 }
 
 func Handler(ctx context.Context, event SecretRotationEvent) error {
@@ -93,7 +159,25 @@ func Handler(ctx context.Context, event SecretRotationEvent) error {
 			return errors.New("Missing environment variable PKI_CA_NAME")
 		}
 
-		newCA, err := renewCAKey(ctx, event.SecretId, caName, event.ClientRequestToken)
+		alg, err := pki.CAKeyAlgorithmFromEnv()
+		if err != nil {
+			return errors.Wrap(err, "obtaining CA key algorithm")
+		}
+
+		// Keys in a SignerBackend are created on every call, so retries must stop before generating another one
+		_, err = secrets.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId:     aws.String(event.SecretId),
+			VersionId:    aws.String(event.ClientRequestToken),
+			VersionStage: aws.String(kStagePending),
+		})
+		if err == nil {
+			log.Infof("Pending key already exists. Could be a retry. Skipping")
+			return nil
+		} else if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != secretsmanager.ErrCodeResourceNotFoundException {
+			return errors.Wrap(err, "checking pending key")
+		}
+
+		newCA, err := renewCAKey(ctx, event.SecretId, caName, event.ClientRequestToken, alg)
 		if err != nil {
 			return errors.Wrap(err, "renewing CA key")
 		}
@@ -123,7 +207,13 @@ func Handler(ctx context.Context, event SecretRotationEvent) error {
 	case kStepSet:
 		// Do nothing
 	case kStepTest:
-		// TODO
+		// Failing the step aborts the rotation, the pending CA never becomes current
+		if err := testPendingCA(ctx, event.SecretId, event.ClientRequestToken); err != nil {
+			log.WithError(err).Error("Pending CA failed the rotation test")
+			return errors.Wrap(err, "testing pending CA")
+		}
+
+		log.Info("Pending CA passed the rotation test")
 	case kStepFinish:
 		secretInfo, err := secrets.DescribeSecretWithContext(ctx, &secretsmanager.DescribeSecretInput{
 			SecretId: aws.String(event.SecretId),
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/kelseyhightower/envconfig"
+	log "github.com/sirupsen/logrus"
+)
+
+const kConfigPrefix = "PKI_STATIC_KEY"
+
+// The overlap must cover the validity of every profile, so the profiles issued just before a rotation keep working
+// until they expire. It defaults to the longest profile validity.
+var configRotation struct {
+	MaxAge  time.Duration `split_words:"true" default:"4320h"`
+	Overlap time.Duration
+}
+
+func init() {
+	envconfig.MustProcess(kConfigPrefix, &configRotation)
+
+	maxValidity := pki.Profiles.MaxValidity()
+	if configRotation.Overlap == 0 {
+		configRotation.Overlap = maxValidity
+	} else if configRotation.Overlap < maxValidity {
+		log.Fatalf("PKI_STATIC_KEY_OVERLAP %s is shorter than the longest profile validity %s", configRotation.Overlap, maxValidity)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	awsservices "github.com/empathybroker/aws-vpn/pkg/aws"
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/empathybroker/aws-vpn/pkg/pki/storage"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	snsClient  = awsservices.NewSNSClient()
+	pkiStorage pki.PKIStorage
+	caStore    storage.CAStore
+)
+
+func init() {
+	if os.Getenv("DEBUG") == "true" {
+		log.SetLevel(log.DebugLevel)
+	}
+	log.SetFormatter(&log.JSONFormatter{
+		TimestampFormat: time.RFC3339Nano,
+		FieldMap: log.FieldMap{
+			log.FieldKeyTime: "@timestamp",
+		},
+	})
+
+	pkiStorage, _ = storage.FromEnv()
+
+	var ok bool
+	if caStore, ok = pkiStorage.(storage.CAStore); !ok {
+		log.Fatal("Storage doesn't give access to the CA data")
+	}
+}
+
+// getCAData reads the CA data along its version in the storages which can write it only over that version
+func getCAData(ctx context.Context) (*pki.CAData, string, error) {
+	if updater, ok := caStore.(pki.CAUpdater); ok {
+		return updater.GetCADataVersion(ctx)
+	}
+
+	data, err := caStore.GetCAData(ctx)
+	return data, "", err
+}
+
+// putCAData writes the CA data over version, so a CA rotation in progress or finished meanwhile isn't undone
+func putCAData(ctx context.Context, data pki.CAData, version string) error {
+	if updater, ok := caStore.(pki.CAUpdater); ok {
+		return updater.UpdateCAData(ctx, data, version)
+	}
+
+	return caStore.PutCAData(ctx, data)
+}
+
+// handler runs on a schedule and moves the static key rotation forward: it starts one when the key is older than
+// PKI_STATIC_KEY_MAX_AGE and retires the old key after PKI_STATIC_KEY_OVERLAP. Once PKI_TLS_CRYPT_V2 is enabled it
+// switches to tls-crypt-v2 instead, after any rotation in progress is over, and the static key is no longer rotated.
+func handler(ctx context.Context) error {
+	data, version, err := getCAData(ctx)
+	if err != nil {
+		return errors.Wrap(err, "reading CA data")
+	} else if data == nil {
+		return errors.New("no CA data")
+	}
+
+	now := time.Now()
+	var newData pki.CAData
+	var eventType string
+
+	phase := data.StaticKeyPhase(now)
+	switch {
+	case pki.TLSCryptV2Enabled() && data.TLSCryptV2Switch == nil && phase == pki.StaticKeyCurrent:
+		if newData, err = data.StartTLSCryptV2Switch(now); err != nil {
+			return errors.Wrap(err, "starting switch to tls-crypt-v2")
+		}
+		eventType = "tls_crypt_v2_switch_started"
+		log.Info("Started the switch to tls-crypt-v2, the servers keep accepting tls-crypt on the main instance")
+	case pki.TLSCryptV2Enabled() && data.TLSCryptV2Switch != nil:
+		if !data.TLSCryptV2Switch.LegacyEnd.IsZero() {
+			log.Debugf("Switched to tls-crypt-v2, tls-crypt accepted until %s", data.TLSCryptV2Switch.LegacyEnd.Format(time.RFC3339))
+			return nil
+		}
+
+		if now.Sub(data.TLSCryptV2Switch.Started) < pki.TLSCryptV2SettleTime {
+			log.Debug("Switch to tls-crypt-v2 not settled yet")
+			return nil
+		}
+
+		certs, err := pkiStorage.ListAllCerts(ctx)
+		if err != nil {
+			return errors.Wrap(err, "listing certificates")
+		}
+
+		if newData, err = data.SetTLSCryptV2LegacyEnd(now, certs); err != nil {
+			return errors.Wrap(err, "ending switch to tls-crypt-v2")
+		}
+		eventType = "tls_crypt_v2_legacy_end_set"
+		log.Infof("Switch to tls-crypt-v2 settled, tls-crypt accepted until %s", newData.TLSCryptV2Switch.LegacyEnd.Format(time.RFC3339))
+	default:
+		switch phase {
+		case pki.StaticKeyCurrent:
+			// Keys from before rotations existed have no creation time. They are counted from now instead of being
+			// rotated right away, which leaves time to deploy the overlap instance on the servers.
+			if data.StaticKeyCreated.IsZero() {
+				newData = *data
+				newData.StaticKeyCreated = now.UTC()
+				eventType = "static_key_created_recorded"
+				log.Infof("Static key from before rotations, rotated after %s", newData.StaticKeyCreated.Add(configRotation.MaxAge).Format(time.RFC3339))
+				break
+			}
+
+			if now.Sub(data.StaticKeyCreated) < configRotation.MaxAge {
+				log.Debugf("Static key created on %s, not rotated yet", data.StaticKeyCreated.Format(time.RFC3339))
+				return nil
+			}
+
+			if newData, err = data.StartStaticKeyRotation(now, configRotation.Overlap); err != nil {
+				return errors.Wrap(err, "starting static key rotation")
+			}
+			eventType = "static_key_rotation_started"
+			log.Infof("Started static key rotation, the old key is retired after %s", newData.StaticKeyRotation.OverlapEnd.Format(time.RFC3339))
+		case pki.StaticKeyOverlap:
+			log.Infof("Static key rotation in progress, the old key is retired after %s", data.StaticKeyRotation.OverlapEnd.Format(time.RFC3339))
+			return nil
+		case pki.StaticKeyRetiring:
+			if newData, err = data.RetireStaticKey(now); err != nil {
+				return errors.Wrap(err, "retiring static key")
+			}
+			eventType = "static_key_retired"
+			log.Info("Retired the old static key")
+		default:
+			return errors.Errorf("unknown static key phase %s", phase)
+		}
+	}
+
+	switch err := putCAData(ctx, newData, version); err {
+	case nil:
+	case pki.ErrCARotationPending, pki.ErrCADataChanged:
+		// The next scheduled run starts over from the data written by the CA rotation
+		log.WithError(err).Warn("CA data not written")
+		return nil
+	default:
+		return errors.Wrap(err, "writing CA data")
+	}
+
+	event := map[string]interface{}{
+		"event":   eventType,
+		"created": newData.StaticKeyCreated,
+	}
+	if newData.StaticKeyRotation != nil {
+		event["overlapEnd"] = newData.StaticKeyRotation.OverlapEnd
+	}
+	if newData.TLSCryptV2Switch != nil {
+		event["tlsCryptV2Switch"] = newData.TLSCryptV2Switch
+	}
+
+	if err := awsservices.PublishEvent(snsClient, ctx, event); err != nil {
+		log.WithError(err).Error("Error publishing event")
+	}
+
+	return nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
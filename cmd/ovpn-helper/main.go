@@ -8,15 +8,24 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
-	jose "gopkg.in/square/go-jose.v2"
 )
 
 const (
 	kConfigLocationEnv = "OPENVPN_CONFIG_FILE"
 	kServiceUnitEnv    = "OPENVPN_SERVICE_UNIT"
+	kCRLLocationEnv    = "OPENVPN_CRL_FILE"
+	kKeyAlgorithmEnv   = "OPENVPN_KEY_ALGORITHM"
+
+	// The second instance accepting the next static key while it's rotated
+	kOverlapConfigLocationEnv = "OPENVPN_OVERLAP_CONFIG_FILE"
+	kOverlapServiceUnitEnv    = "OPENVPN_OVERLAP_SERVICE_UNIT"
+
+	kDefaultCRLLocation = "/etc/openvpn/crl.pem"
 )
 
 func init() {
@@ -40,18 +49,24 @@ func getServerConfig(ctx context.Context) {
 		log.Fatalf("Missing %s environment variable", kConfigLocationEnv)
 	}
 
-	privKey, err := pki.NewPrivateKey()
+	alg, err := pki.KeyAlgorithmFromEnv(kKeyAlgorithmEnv)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid key algorithm")
+	}
+
+	privKey, err := pki.NewPrivateKey(alg)
 	if err != nil {
 		log.WithError(err).Fatal("error generating key")
 	}
 
 	params := map[string]interface{}{
-		"publicKey": jose.JSONWebKey{Key: pki.GetPublicKey(privKey)},
+		"publicKey": pki.NewJSONWebKey(pki.GetPublicKey(privKey)),
 	}
 
 	var result struct {
-		Message string `json:"message"`
-		Config  []byte `json:"config"`
+		Message       string `json:"message"`
+		Config        []byte `json:"config"`
+		OverlapConfig []byte `json:"overlapConfig"`
 	}
 
 	status, err := apiRequest(ctx, http.MethodPost, "/server/config", params, &result)
@@ -68,17 +83,114 @@ func getServerConfig(ctx context.Context) {
 		log.WithError(err).Fatal("Error encoding private key")
 	}
 
-	configData := bytes.ReplaceAll(result.Config, []byte("%PRIVATEKEY%"), encodedKey)
+	configData := serverConfigData(result.Config, encodedKey)
 	if err := ioutil.WriteFile(configFileName, configData, 0600); err != nil {
 		log.WithError(err).Fatal("Could not save new configuration file")
 	}
 
+	// The server config references the CRL with crl-verify, so it must exist before restarting
+	if err := updateCRL(ctx); err != nil {
+		log.WithError(err).Fatal("Error updating CRL")
+	}
+
 	if serviceUnit, ok := os.LookupEnv(kServiceUnitEnv); ok {
 		if err := restartService(ctx, serviceUnit); err != nil {
 			log.WithError(err).Fatal("Error restarting OpenVPN service")
 		}
 	}
 
+	if err := updateOverlapInstance(ctx, result.OverlapConfig, encodedKey); err != nil {
+		log.WithError(err).Fatal("Error updating overlap OpenVPN instance")
+	}
+
+	log.Exit(0)
+}
+
+// updateOverlapInstance runs the second instance only while the static key is rotated
+func updateOverlapInstance(ctx context.Context, config []byte, encodedKey []byte) error {
+	configFileName, ok := os.LookupEnv(kOverlapConfigLocationEnv)
+	if !ok {
+		if len(config) > 0 {
+			log.Warnf("Static key rotation in progress, but %s is not set. New client profiles can't connect", kOverlapConfigLocationEnv)
+		}
+		return nil
+	}
+
+	serviceUnit, hasUnit := os.LookupEnv(kOverlapServiceUnitEnv)
+	if len(config) == 0 {
+		if hasUnit {
+			return stopService(ctx, serviceUnit)
+		}
+		return nil
+	}
+
+	configData := serverConfigData(config, encodedKey)
+	if err := ioutil.WriteFile(configFileName, configData, 0600); err != nil {
+		return errors.Wrap(err, "saving overlap configuration file")
+	}
+
+	if hasUnit {
+		return restartService(ctx, serviceUnit)
+	}
+	return nil
+}
+
+func crlFileName() string {
+	if fileName, ok := os.LookupEnv(kCRLLocationEnv); ok {
+		return fileName
+	}
+	return kDefaultCRLLocation
+}
+
+// serverConfigData fills in the placeholders of a server config with the local key and CRL location
+func serverConfigData(config []byte, encodedKey []byte) []byte {
+	configData := bytes.ReplaceAll(config, []byte("%PRIVATEKEY%"), encodedKey)
+	return bytes.ReplaceAll(configData, []byte("%CRLFILE%"), []byte(crlFileName()))
+}
+
+func updateCRL(ctx context.Context) error {
+	fileName := crlFileName()
+
+	var result struct {
+		Message string `json:"message"`
+		CRL     []byte `json:"crl"`
+	}
+
+	status, err := apiRequest(ctx, http.MethodGet, "/server/crl", nil, &result)
+	if err != nil {
+		return errors.Wrap(err, "making service call")
+	}
+
+	if status != http.StatusOK {
+		return errors.Errorf("HTTP error %d: %s", status, result.Message)
+	}
+
+	// Never replace a working CRL with one OpenVPN can't load
+	if _, err := pki.DecodePEMCRLs(result.CRL); err != nil {
+		return errors.Wrap(err, "validating CRL")
+	}
+
+	// OpenVPN reloads the CRL when the file changes, so it's replaced atomically
+	tmpFileName := fileName + ".tmp"
+	if err := ioutil.WriteFile(tmpFileName, result.CRL, 0644); err != nil {
+		return errors.Wrap(err, "writing CRL")
+	}
+
+	if err := os.Rename(tmpFileName, fileName); err != nil {
+		return errors.Wrap(err, "replacing CRL")
+	}
+
+	log.Debugf("Updated CRL at %s", fileName)
+	return nil
+}
+
+func getCRL(ctx context.Context) {
+	log.Debugf("Fetching CRL")
+
+	if err := updateCRL(ctx); err != nil {
+		log.WithError(err).Fatal("Error updating CRL")
+	}
+
 	log.Exit(0)
 }
 
@@ -128,6 +240,51 @@ func tlsVerify(ctx context.Context) {
 	log.Exit(0)
 }
 
+// tlsCryptV2Verify checks the certificate a tls-crypt-v2 client key was issued for, before the TLS handshake
+func tlsCryptV2Verify(ctx context.Context) {
+	if metadataType := os.Getenv("metadata_type"); metadataType != strconv.Itoa(pki.TLSCryptV2MetadataUser) {
+		log.Fatalf("Unexpected tls-crypt-v2 metadata type %s", metadataType)
+	}
+
+	data, err := ioutil.ReadFile(os.Getenv("metadata_file"))
+	if err != nil {
+		log.WithError(err).Fatal("Error reading tls-crypt-v2 metadata")
+	}
+
+	metadata, err := pki.ParseTLSCryptV2Metadata(data)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid tls-crypt-v2 metadata")
+	}
+
+	if metadata.Expired(time.Now()) {
+		log.Fatalf("tls-crypt-v2 key for %s has expired", metadata.Serial)
+	}
+
+	log.Debugf("Validating tls-crypt-v2 key for %s", metadata.Serial)
+
+	params := map[string]interface{}{
+		"untrusted_ip": os.Getenv("untrusted_ip"),
+		"serial":       metadata.Serial,
+		"tlsCryptV2":   true,
+	}
+
+	var result struct {
+		Message string `json:"message"`
+	}
+
+	status, err := apiRequest(ctx, http.MethodPost, "/server/verify", params, &result)
+	if err != nil {
+		log.WithError(err).Fatalf("Error making service call")
+	}
+
+	if status != http.StatusOK {
+		log.WithField("status", strconv.Itoa(status)).Fatalf("HTTP error: %s", result.Message)
+	}
+
+	log.Debugf("tls-crypt-v2 key validation successful!")
+	log.Exit(0)
+}
+
 func clientConnect(ctx context.Context) {
 	if len(os.Args) != 2 {
 		log.Fatalf("Invalid arguments")
@@ -216,6 +373,8 @@ func main() {
 		switch scriptType {
 		case "tls-verify":
 			tlsVerify(ctx)
+		case "tls-crypt-v2-verify":
+			tlsCryptV2Verify(ctx)
 		case "client-connect":
 			clientConnect(ctx)
 		case "client-disconnect":
@@ -233,6 +392,8 @@ func main() {
 	switch os.Args[1] {
 	case "getconfig":
 		getServerConfig(ctx)
+	case "getcrl":
+		getCRL(ctx)
 	default:
 		log.Fatalf("Unknown command %s", os.Args[1])
 	}
@@ -60,6 +60,9 @@ func apiRequest(ctx context.Context, method string, url string, params map[strin
 	}
 
 	req, err := http.NewRequest(method, apiBase+url, &body)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not create request")
+	}
 	req.Header.Set("Content-Type", kJsonContentType)
 
 	res, err := ctxhttp.Do(ctx, client, req)
@@ -78,7 +81,8 @@ func apiRequest(ctx context.Context, method string, url string, params map[strin
 }
 
 func restartService(ctx context.Context, unit string) error {
-	ctx, _ = context.WithTimeout(ctx, 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
 
 	conn, err := dbus.NewSystemConnection()
 	if err != nil {
@@ -103,6 +107,33 @@ func restartService(ctx context.Context, unit string) error {
 	case <-ctx.Done():
 		return ctx.Err()
 	}
+}
+
+func stopService(ctx context.Context, unit string) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	conn, err := dbus.NewSystemConnection()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ch := make(chan string)
+	if _, err := conn.StopUnit(unit, "replace", ch); err != nil {
+		return err
+	}
 
-	return nil
+	select {
+	case result := <-ch:
+		switch result {
+		case "done", "skipped":
+			log.Debugf("Stopped service %s", unit)
+			return nil
+		default:
+			return fmt.Errorf("error stopping: %s", result)
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
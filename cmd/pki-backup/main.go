@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	awsservices "github.com/empathybroker/aws-vpn/pkg/aws"
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	awspki "github.com/empathybroker/aws-vpn/pkg/pki/aws"
+	"github.com/empathybroker/aws-vpn/pkg/pki/storage"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const kPassphraseEnv = "PKI_BACKUP_PASSPHRASE"
+
+func init() {
+	log.SetFormatter(&log.TextFormatter{
+		DisableTimestamp: true,
+	})
+}
+
+func readPassphrase(confirm bool) []byte {
+	if passphrase, ok := os.LookupEnv(kPassphraseEnv); ok {
+		return []byte(passphrase)
+	}
+
+	fmt.Fprint(os.Stderr, "Backup passphrase: ")
+	passphrase, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		log.WithError(err).Fatal("Error reading passphrase")
+	}
+
+	if confirm {
+		fmt.Fprint(os.Stderr, "Confirm passphrase: ")
+		again, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			log.WithError(err).Fatal("Error reading passphrase")
+		}
+
+		if string(again) != string(passphrase) {
+			log.Fatal("Passphrases don't match")
+		}
+	}
+
+	if len(passphrase) == 0 {
+		log.Fatal("Empty passphrase")
+	}
+
+	return passphrase
+}
+
+// sealerFlags registers the backup encryption, with a KMS key or else a passphrase
+func sealerFlags(fs *flag.FlagSet, confirm bool) func() pki.Sealer {
+	kmsKeyId := fs.String("kms-key-id", "", "KMS key encrypting the backup, instead of a passphrase")
+
+	return func() pki.Sealer {
+		if *kmsKeyId != "" {
+			return awspki.NewKMSSealer(awsservices.NewKMSClient(), *kmsKeyId)
+		}
+		return pki.NewPassphraseSealer(readPassphrase(confirm))
+	}
+}
+
+func backup(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	from := fs.String("from", "", "Storage URI, as in pki-migrate")
+	out := fs.String("out", "pki-backup.pem", "File where the encrypted backup is written")
+	sealer := sealerFlags(fs, true)
+	_ = fs.Parse(args)
+
+	if *from == "" {
+		log.Fatal("Missing -from")
+	}
+
+	if _, err := os.Stat(*out); err == nil {
+		log.Fatalf("%s already exists", *out)
+	}
+
+	s, l, err := storage.Open(ctx, *from)
+	if err != nil {
+		log.WithError(err).Fatal("Error opening storage")
+	}
+
+	b, err := storage.NewBackup(ctx, s, l)
+	if err != nil {
+		log.WithError(err).Fatal("Error reading storage")
+	}
+
+	data, err := b.Seal(sealer())
+	if err != nil {
+		log.WithError(err).Fatal("Error encrypting backup")
+	}
+
+	if err := ioutil.WriteFile(*out, data, 0600); err != nil {
+		log.WithError(err).Fatal("Error writing backup")
+	}
+
+	log.Infof("Backup of %d certificates and %d log entries written to %s", len(b.Certs), len(b.Log), *out)
+}
+
+func restore(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	in := fs.String("in", "pki-backup.pem", "File with the encrypted backup")
+	to := fs.String("to", "", "Storage URI, as in pki-migrate")
+	sealer := sealerFlags(fs, false)
+	_ = fs.Parse(args)
+
+	if *to == "" {
+		log.Fatal("Missing -to")
+	}
+
+	data, err := ioutil.ReadFile(*in)
+	if err != nil {
+		log.WithError(err).Fatal("Error reading backup")
+	}
+
+	b, err := pki.OpenBackup(data, sealer())
+	if err != nil {
+		log.WithError(err).Fatal("Error opening backup")
+	}
+
+	log.Infof("Restoring backup from %s with %d certificates and %d log entries", b.CreatedAt.Format("2006-01-02 15:04:05"), len(b.Certs), len(b.Log))
+
+	s, l, err := storage.Open(ctx, *to)
+	if err != nil {
+		log.WithError(err).Fatal("Error opening storage")
+	}
+
+	problems, err := storage.Restore(ctx, b, s, l)
+	if err != nil {
+		log.WithError(err).Fatal("Error restoring backup")
+	}
+
+	for _, problem := range problems {
+		log.Error(problem)
+	}
+
+	if len(problems) > 0 {
+		log.Errorf("Restore verification failed with %d problems", len(problems))
+		os.Exit(1)
+	}
+
+	log.Info("Backup restored")
+}
+
+func main() {
+	ctx := context.Background()
+
+	if len(os.Args) < 2 {
+		log.Fatalf("Usage: %s backup|restore [flags]", os.Args[0])
+	}
+
+	switch os.Args[1] {
+	case "backup":
+		backup(ctx, os.Args[2:])
+	case "restore":
+		restore(ctx, os.Args[2:])
+	default:
+		log.Fatalf("Unknown command %s", os.Args[1])
+	}
+}
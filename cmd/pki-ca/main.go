@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	awsservices "github.com/empathybroker/aws-vpn/pkg/aws"
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	awspki "github.com/empathybroker/aws-vpn/pkg/pki/aws"
+	"github.com/empathybroker/aws-vpn/pkg/pki/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+const kTimeFormat = "2006-01-02 15:04:05"
+
+func init() {
+	log.SetFormatter(&log.TextFormatter{
+		DisableTimestamp: true,
+	})
+
+	pki.RegisterSignerBackend(awspki.NewKMSBackend(awsservices.NewKMSClient()))
+}
+
+func openStore(ctx context.Context, uri string) storage.Store {
+	s, _, err := storage.Open(ctx, uri)
+	if err != nil {
+		log.WithError(err).Fatal("Error opening storage")
+	}
+	return s
+}
+
+func openHistory(ctx context.Context, uri string) (storage.Store, pki.CAHistory) {
+	s := openStore(ctx, uri)
+	history, ok := s.(pki.CAHistory)
+	if !ok {
+		log.Fatalf("Storage %s keeps no CA versions", uri)
+	}
+
+	return s, history
+}
+
+func listVersions(ctx context.Context, history pki.CAHistory) []*pki.CAVersion {
+	versions, err := history.ListCAVersions(ctx)
+	if err != nil {
+		log.WithError(err).Fatal("Error listing CA versions")
+	}
+	return versions
+}
+
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// crossSignStatus tells how clients holding certificates from other CAs trust the version
+func crossSignStatus(data *pki.CAData) string {
+	switch {
+	case data.CrossCert != nil && data.PrevCACert != nil:
+		if err := data.CrossCert.CheckSignatureFrom(data.PrevCACert); err != nil {
+			return "invalid cross-signature by " + data.PrevCACert.Subject.CommonName
+		}
+		return "cross-signed by " + data.PrevCACert.Subject.CommonName
+	case len(data.Chain) > 0:
+		return "intermediate of " + data.Chain[len(data.Chain)-1].Subject.CommonName
+	case data.PrevCACert != nil:
+		return "trusts " + data.PrevCACert.Subject.CommonName
+	default:
+		return "none"
+	}
+}
+
+func versions(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("versions", flag.ExitOnError)
+	uri := fs.String("storage", "aws:", "Storage URI, as in pki-migrate")
+	_ = fs.Parse(args)
+
+	_, history := openHistory(ctx, *uri)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tCREATED\tSTAGES\tSUBJECT\tSERIAL\tNOT BEFORE\tNOT AFTER\tSHA-256\tCROSS-SIGN")
+	for _, v := range listVersions(ctx, history) {
+		stages := fmt.Sprint(v.Stages)
+		if v.Current && len(v.Stages) == 0 {
+			stages = "[current]"
+		}
+
+		if v.Data == nil {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%v\n", v.Id, v.Created.Format(kTimeFormat), stages, v.Err)
+			continue
+		}
+
+		cert := v.Data.CACert
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%x\t%s\t%s\t%s\t%s\n", v.Id, v.Created.Format(kTimeFormat), stages,
+			cert.Subject.CommonName, cert.SerialNumber.Bytes(), cert.NotBefore.Format(kTimeFormat),
+			cert.NotAfter.Format(kTimeFormat), fingerprint(cert), crossSignStatus(v.Data))
+	}
+	_ = w.Flush()
+}
+
+func issuers(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("issuers", flag.ExitOnError)
+	uri := fs.String("storage", "aws:", "Storage URI, as in pki-migrate")
+	_ = fs.Parse(args)
+
+	s, history := openHistory(ctx, *uri)
+
+	certs, err := s.ListAllCerts(ctx)
+	if err != nil {
+		log.WithError(err).Fatal("Error listing certificates")
+	}
+
+	now := time.Now()
+	count := func(certs []*pki.CertificateInfo) string {
+		status := map[pki.CertStatus]int{}
+		for _, info := range certs {
+			status[info.Status(now)]++
+		}
+		return fmt.Sprintf("%d\t%d\t%d", status[pki.CertStatusActive], status[pki.CertStatusRevoked], status[pki.CertStatusExpired])
+	}
+
+	grouped, unknown := pki.GroupByIssuer(listVersions(ctx, history), certs)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CA\tKEY ID\tVERSIONS\tACTIVE\tREVOKED\tEXPIRED")
+	for _, issuer := range grouped {
+		var ids []string
+		for _, v := range issuer.Versions {
+			ids = append(ids, v.Id)
+		}
+
+		fmt.Fprintf(w, "%s\t%x\t%v\t%s\n", issuer.CACert.Subject.CommonName, issuer.CACert.SubjectKeyId, ids, count(issuer.Certs))
+	}
+	if len(unknown) > 0 {
+		fmt.Fprintf(w, "(unknown)\t\t\t%s\n", count(unknown))
+	}
+	_ = w.Flush()
+}
+
+func activeCerts(ctx context.Context, s storage.Store) []*pki.CertificateInfo {
+	var certs []*pki.CertificateInfo
+	query := pki.CertQuery{Status: pki.CertStatusActive, Limit: pki.MaxCertQueryLimit}
+	for {
+		page, err := s.ListCerts(ctx, query)
+		if err != nil {
+			log.WithError(err).Fatal("Error listing certificates")
+		}
+		certs = append(certs, page.Certs...)
+
+		if page.NextCursor == "" {
+			return certs
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+// verify checks the active certificates, or the given one, chain to the current CA data as the servers see it
+func verify(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	uri := fs.String("storage", "aws:", "Storage URI, as in pki-migrate")
+	serial := fs.String("serial", "", "Serial of the certificate to verify, instead of all the active ones")
+	_ = fs.Parse(args)
+
+	s := openStore(ctx, *uri)
+
+	data, err := s.GetCAData(ctx)
+	if err != nil {
+		log.WithError(err).Fatal("Error reading CA data")
+	} else if data == nil {
+		log.Fatal("No CA data stored")
+	}
+
+	var certs []*pki.CertificateInfo
+	if *serial != "" {
+		serialBytes, err := pki.DecodeSerial(*serial)
+		if err != nil {
+			log.WithError(err).Fatal("Invalid serial")
+		}
+
+		info, err := s.GetCertBySerial(ctx, serialBytes)
+		if err != nil {
+			log.WithError(err).Fatal("Error fetching certificate")
+		} else if info == nil {
+			log.Fatalf("Certificate %s not found", *serial)
+		}
+		certs = append(certs, info)
+	} else {
+		certs = activeCerts(ctx, s)
+	}
+
+	verifier := pki.NewVerifier(*data)
+	now := time.Now()
+	paths := map[pki.VerifyPath]int{}
+	failed := 0
+	for _, info := range certs {
+		logger := log.WithFields(log.Fields{
+			"serial":  info.Serial,
+			"subject": info.Subject,
+		})
+
+		verification, err := verifier.Verify(info.Certificate, now)
+		if err != nil {
+			logger.WithError(err).Error("Certificate not trusted")
+			failed++
+			continue
+		}
+
+		paths[verification.Path]++
+		for _, warning := range verification.Warnings {
+			logger.Warn(warning)
+		}
+		if *serial != "" {
+			logger.WithField("path", verification.Path).Info("Certificate trusted")
+		}
+	}
+
+	log.Infof("%d certificates through the current CA, %d through the previous one, %d not trusted",
+		paths[pki.VerifyPathCurrent], paths[pki.VerifyPathPrevious], failed)
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func rollback(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	uri := fs.String("storage", "aws:", "Storage URI, as in pki-migrate")
+	id := fs.String("version", "", "Version to make current")
+	force := fs.Bool("force", false, "Roll back even if active certificates won't be trusted anymore or the control channel keys change")
+	_ = fs.Parse(args)
+
+	if *id == "" {
+		log.Fatal("Missing -version")
+	}
+
+	s, history := openHistory(ctx, *uri)
+
+	var target, current *pki.CAVersion
+	for _, v := range listVersions(ctx, history) {
+		if v.Id == *id {
+			target = v
+		}
+		if v.Current {
+			current = v
+		}
+	}
+
+	switch {
+	case target == nil:
+		log.Fatalf("Version %s not found", *id)
+	case target.Data == nil:
+		log.WithError(target.Err).Fatalf("Version %s can't be read", *id)
+	case target.Current:
+		log.Fatalf("Version %s is already current", *id)
+	}
+
+	// The test of a rotation without its previous CA checks the version can still sign and be trusted
+	if err := pki.CheckRotation(ctx, nil, *target.Data, time.Now()); err != nil {
+		log.WithError(err).Fatalf("Version %s can't be used", *id)
+	}
+
+	var untrusted []*pki.CertificateInfo
+	verifier := pki.NewVerifier(*target.Data)
+	now := time.Now()
+	for _, info := range activeCerts(ctx, s) {
+		if _, err := verifier.Verify(info.Certificate, now); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"serial":  info.Serial,
+				"subject": info.Subject,
+			}).Warn("Active certificate not trusted by the version")
+			untrusted = append(untrusted, info)
+		}
+	}
+
+	if len(untrusted) > 0 && !*force {
+		log.Fatalf("%d active certificates won't be trusted after the rollback, use -force to proceed", len(untrusted))
+	}
+
+	// The static and tls-crypt-v2 keys may have been rotated after the version, which would disconnect the clients
+	if current != nil && current.Data != nil {
+		if diff := pki.ControlKeysDiff(*current.Data, *target.Data); len(diff) > 0 && !*force {
+			log.Fatalf("The rollback changes the %s of the client profiles, use -force to proceed", strings.Join(diff, ", "))
+		} else if len(diff) > 0 {
+			log.Warnf("The rollback changes the %s, clients need new profiles", strings.Join(diff, ", "))
+		}
+	}
+
+	if err := history.RollbackCA(ctx, *id); err != nil {
+		log.WithError(err).Fatal("Error rolling back")
+	}
+
+	log.Infof("Version %s is current, servers pick it up within a minute", *id)
+}
+
+func main() {
+	ctx := context.Background()
+
+	if len(os.Args) < 2 {
+		log.Fatalf("Usage: %s versions|issuers|verify|rollback [flags]", os.Args[0])
+	}
+
+	switch os.Args[1] {
+	case "versions":
+		versions(ctx, os.Args[2:])
+	case "issuers":
+		issuers(ctx, os.Args[2:])
+	case "verify":
+		verify(ctx, os.Args[2:])
+	case "rollback":
+		rollback(ctx, os.Args[2:])
+	default:
+		log.Fatalf("Unknown command %s", os.Args[1])
+	}
+}
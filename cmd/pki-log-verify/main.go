@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/empathybroker/aws-vpn/pkg/pki/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	log.SetFormatter(&log.TextFormatter{
+		DisableTimestamp: true,
+	})
+}
+
+func main() {
+	ctx := context.Background()
+
+	uri := flag.String("storage", "aws:", "Storage URI, as in pki-migrate")
+	importCerts := flag.Bool("import", false, "Log the certificates stored before the issuance log was enabled")
+	flag.Parse()
+
+	s, l, err := storage.Open(ctx, *uri)
+	if err != nil {
+		log.WithError(err).Fatal("Error opening storage")
+	}
+
+	if l == nil {
+		log.Fatalf("Storage %s has no issuance log, set PKI_AWS_LOG_TABLE_NAME or log-table", *uri)
+	}
+
+	p := pki.NewPKI(s)
+	p.SetIssuanceLog(l)
+
+	if *importCerts {
+		imported, err := p.ImportIssuanceLog(ctx)
+		log.Infof("Imported %d certificates into the issuance log", len(imported))
+		if err != nil {
+			log.WithError(err).Fatal("Error importing certificates")
+		}
+
+		if _, err := p.SignTreeHead(ctx); err != nil {
+			log.WithError(err).Fatal("Error signing tree head")
+		}
+	}
+
+	problems, err := p.VerifyIssuanceLog(ctx)
+	if err != nil {
+		log.WithError(err).Fatal("Error verifying issuance log")
+	}
+
+	for _, problem := range problems {
+		log.Error(problem)
+	}
+
+	if len(problems) > 0 {
+		log.Errorf("Issuance log verification failed with %d problems", len(problems))
+		os.Exit(1)
+	}
+
+	log.Info("Issuance log verified")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	log.SetFormatter(&log.TextFormatter{
+		DisableTimestamp: true,
+	})
+}
+
+func main() {
+	ctx := context.Background()
+
+	from := flag.String("from", "", "Source storage URI, e.g. aws:?region=eu-west-1&profile=old, fs:/dir, postgres://... or sqlite3:/file")
+	to := flag.String("to", "", "Destination storage URI")
+	stateFile := flag.String("state", "pki-migrate.state", "File keeping the progress, to resume an interrupted migration")
+	batch := flag.Int("batch", 100, "Certificates and log entries copied per batch")
+	dryRun := flag.Bool("dry-run", false, "Only count what would be copied")
+	verifyOnly := flag.Bool("verify-only", false, "Only compare both storages")
+	flag.Parse()
+
+	if *from == "" || *to == "" {
+		log.Fatal("Missing -from or -to")
+	}
+
+	src, srcLog, err := storage.Open(ctx, *from)
+	if err != nil {
+		log.WithError(err).Fatal("Error opening source storage")
+	}
+
+	dst, dstLog, err := storage.Open(ctx, *to)
+	if err != nil {
+		log.WithError(err).Fatal("Error opening destination storage")
+	}
+
+	m := &storage.Migration{
+		From:      src,
+		FromLog:   srcLog,
+		To:        dst,
+		ToLog:     dstLog,
+		StateFile: *stateFile,
+		Id:        *from + " " + *to,
+		BatchSize: *batch,
+		DryRun:    *dryRun,
+	}
+
+	if !*verifyOnly {
+		result, err := m.Run(ctx)
+		if err != nil {
+			log.WithError(err).Fatal("Error migrating storage")
+		}
+
+		if *dryRun {
+			log.Infof("Would copy %d certificates and %d log entries", result.Certs, result.LogEntries)
+			return
+		}
+		log.Infof("Copied %d certificates and %d log entries", result.Certs, result.LogEntries)
+	}
+
+	problems, err := m.Verify(ctx)
+	if err != nil {
+		log.WithError(err).Fatal("Error verifying migration")
+	}
+
+	for _, problem := range problems {
+		log.Error(problem)
+	}
+
+	if len(problems) > 0 {
+		log.Errorf("Migration verification failed with %d problems", len(problems))
+		os.Exit(1)
+	}
+
+	log.Info("Migration verified")
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	awspki "github.com/empathybroker/aws-vpn/pkg/pki/aws"
+	fspki "github.com/empathybroker/aws-vpn/pkg/pki/fs"
+	"github.com/empathybroker/aws-vpn/pkg/pki/storage"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	kPassphraseEnv = "PKI_ROOT_PASSPHRASE"
+
+	kStageCurrent = "AWSCURRENT"
+
+	kRootValidity         = 10 * 365 * 24 * time.Hour
+	kIntermediateValidity = 90 * 24 * time.Hour
+)
+
+func init() {
+	log.SetFormatter(&log.TextFormatter{
+		DisableTimestamp: true,
+	})
+}
+
+func readPassphrase(confirm bool) []byte {
+	if passphrase, ok := os.LookupEnv(kPassphraseEnv); ok {
+		return []byte(passphrase)
+	}
+
+	fmt.Fprint(os.Stderr, "Root CA passphrase: ")
+	passphrase, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		log.WithError(err).Fatal("Error reading passphrase")
+	}
+
+	if confirm {
+		fmt.Fprint(os.Stderr, "Confirm passphrase: ")
+		again, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			log.WithError(err).Fatal("Error reading passphrase")
+		}
+
+		if string(again) != string(passphrase) {
+			log.Fatal("Passphrases don't match")
+		}
+	}
+
+	if len(passphrase) == 0 {
+		log.Fatal("Empty passphrase")
+	}
+
+	return passphrase
+}
+
+// nameConstraintFlags registers the permitted names, defaulting to pki.DefaultNameConstraints. Email domains are
+// required.
+func nameConstraintFlags(fs *flag.FlagSet) func() pki.CertOptions {
+	emailDomains := fs.String("email-domains", strings.Join(pki.DefaultNameConstraints.PermittedEmailDomains, ","), "Comma separated email domains the CA may issue for")
+	dnsDomains := fs.String("dns-domains", strings.Join(pki.DefaultNameConstraints.PermittedDNSDomains, ","), "Comma separated DNS domains the CA may issue for")
+
+	return func() pki.CertOptions {
+		nc := pki.ParseNameConstraints(*emailDomains, *dnsDomains)
+		if len(nc.PermittedEmailDomains) == 0 {
+			log.Fatal("Missing -email-domains, or AUTH_HOSTED_DOMAINS or PKI_NAME_CONSTRAINTS_EMAIL_DOMAINS in the environment")
+		}
+		return pki.WithNameConstraints(nc)
+	}
+}
+
+func initRoot(args []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	caName := fs.String("name", "", "Common name of the root CA")
+	out := fs.String("out", "root-ca.pem", "File where the encrypted root CA is written")
+	algName := fs.String("algorithm", string(pki.KeyAlgorithmP384), "Key algorithm of the root CA")
+	validity := fs.Duration("validity", kRootValidity, "Validity of the root CA")
+	constraints := nameConstraintFlags(fs)
+	_ = fs.Parse(args)
+
+	if *caName == "" {
+		log.Fatal("Missing -name")
+	}
+
+	if _, err := os.Stat(*out); err == nil {
+		log.Fatalf("%s already exists", *out)
+	}
+
+	alg, err := pki.ParseKeyAlgorithm(*algName)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid key algorithm")
+	}
+
+	root, err := pki.NewRootCA(*caName, uuid.New().String(), alg, *validity, constraints())
+	if err != nil {
+		log.WithError(err).Fatal("Error creating root CA")
+	}
+
+	data, err := root.Encrypt(readPassphrase(true))
+	if err != nil {
+		log.WithError(err).Fatal("Error encrypting root CA")
+	}
+
+	if err := ioutil.WriteFile(*out, data, 0600); err != nil {
+		log.WithError(err).Fatal("Error writing root CA")
+	}
+
+	fmt.Printf("%s", pki.EncodePEMCert(root.CACert))
+	log.Infof("Root CA written to %s. Keep it offline.", *out)
+}
+
+func issueIntermediate(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("issue", flag.ExitOnError)
+	rootFile := fs.String("root", "root-ca.pem", "File with the encrypted root CA")
+	secretId := fs.String("secret", "VPN/CAPrivateKey", "Secrets Manager secret holding the issuing CA")
+	storageDir := fs.String("dir", "", "Directory of a filesystem storage holding the issuing CA, instead of Secrets Manager")
+	caName := fs.String("name", "", "Common name of the issuing CA")
+	algName := fs.String("algorithm", string(pki.KeyAlgorithmP256), "Key algorithm of the issuing CA")
+	validity := fs.Duration("validity", kIntermediateValidity, "Validity of the issuing CA")
+	constraints := nameConstraintFlags(fs)
+	_ = fs.Parse(args)
+
+	if *caName == "" {
+		log.Fatal("Missing -name")
+	}
+
+	alg, err := pki.ParseCAKeyAlgorithm(*algName)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid key algorithm")
+	}
+
+	data, err := ioutil.ReadFile(*rootFile)
+	if err != nil {
+		log.WithError(err).Fatal("Error reading root CA")
+	}
+
+	root, err := pki.DecryptRootCA(data, readPassphrase(false))
+	if err != nil {
+		log.WithError(err).Fatal("Error decrypting root CA")
+	}
+
+	serialNumber := uuid.New().String()
+	if *storageDir != "" {
+		sealer, err := storage.Sealer()
+		if err != nil {
+			log.WithError(err).Fatal("Error configuring CA data sealing")
+		}
+
+		issueToStore(ctx, root, fspki.NewFSStorage(*storageDir, sealer), *caName, serialNumber, alg, *validity, constraints())
+		return
+	}
+
+	// The fs and sql storages keep the issuing CA themselves
+	if store := storage.CAStoreFromEnv(); store != nil {
+		issueToStore(ctx, root, store, *caName, serialNumber, alg, *validity, constraints())
+		return
+	}
+
+	sess := session.Must(session.NewSession())
+	secrets := secretsmanager.New(sess)
+
+	kmsConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.WithError(err).Fatal("Error loading AWS config")
+	}
+	pki.RegisterSignerBackend(awspki.NewKMSBackend(kms.NewFromConfig(kmsConfig)))
+
+	var oldCA *pki.CAData
+	var newCA pki.CAData
+	res, err := secrets.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId:     aws.String(*secretId),
+		VersionStage: aws.String(kStageCurrent),
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+		log.Warn("Secret is empty. Issuing first intermediate")
+
+		newCA, err = root.NewIntermediate(*caName, serialNumber, alg, *validity, constraints())
+		if err != nil {
+			log.WithError(err).Fatal("Error issuing intermediate")
+		}
+	} else if err != nil {
+		log.WithError(err).Fatal("Error obtaining current CA")
+	} else {
+		oldCA = new(pki.CAData)
+		if err := json.Unmarshal(res.SecretBinary, oldCA); err != nil {
+			log.WithError(err).Fatal("Error unmarshalling current CA")
+		}
+
+		newCA, err = root.RenewIntermediate(*oldCA, *caName, serialNumber, alg, *validity, constraints())
+		if err != nil {
+			log.WithError(err).Fatal("Error renewing intermediate")
+		}
+
+		// The old intermediate key is kept to sign its CRL, this one is served if it can't be opened
+		awsStore := awspki.NewAWSStorage(secrets, dynamodb.New(sess))
+		revoked, err := awsStore.ListRevokedCerts(ctx)
+		if err != nil {
+			log.WithError(err).Fatal("Error listing revoked certificates")
+		}
+
+		newCA.PrevCRL, err = oldCA.CreateCRL(revoked)
+		if err != nil {
+			log.WithError(err).Fatal("Error signing previous CA CRL")
+		}
+	}
+
+	// The same test as the testSecret step of lambda-rotate-ca, which this write bypasses
+	if err := pki.CheckRotation(ctx, oldCA, newCA, time.Now()); err != nil {
+		log.WithError(err).Fatal("New CA failed the rotation test")
+	}
+
+	keyData, err := newCA.MarshalJSON()
+	if err != nil {
+		log.WithError(err).Fatal("Error encoding new CA")
+	}
+
+	put, err := secrets.PutSecretValueWithContext(ctx, &secretsmanager.PutSecretValueInput{
+		ClientRequestToken: aws.String(serialNumber),
+		SecretId:           aws.String(*secretId),
+		SecretBinary:       keyData,
+		VersionStages:      aws.StringSlice([]string{kStageCurrent}),
+	})
+	if err != nil {
+		log.WithError(err).Fatal("Error writing new CA")
+	}
+
+	fmt.Printf("%s", pki.EncodePEMCert(newCA.CACert))
+	log.Infof("Issuing CA stored with version ID %s", aws.StringValue(put.VersionId))
+}
+
+// issueToStore is issueIntermediate for a storage keeping the CA data itself, sealed as configured with PKI_SEAL_*
+func issueToStore(ctx context.Context, root pki.RootCA, store storage.CAStore, caName string, serialNumber string, alg pki.KeyAlgorithm, validity time.Duration, caOpts pki.CertOptions) {
+	oldCA, err := store.GetCAData(ctx)
+	if err != nil {
+		log.WithError(err).Fatal("Error obtaining current CA")
+	}
+
+	var newCA pki.CAData
+	if oldCA == nil {
+		log.Warn("Storage is empty. Issuing first intermediate")
+
+		newCA, err = root.NewIntermediate(caName, serialNumber, alg, validity, caOpts)
+		if err != nil {
+			log.WithError(err).Fatal("Error issuing intermediate")
+		}
+	} else {
+		newCA, err = root.RenewIntermediate(*oldCA, caName, serialNumber, alg, validity, caOpts)
+		if err != nil {
+			log.WithError(err).Fatal("Error renewing intermediate")
+		}
+
+		revoked, err := store.ListRevokedCerts(ctx)
+		if err != nil {
+			log.WithError(err).Fatal("Error listing revoked certificates")
+		}
+
+		newCA.PrevCRL, err = oldCA.CreateCRL(revoked)
+		if err != nil {
+			log.WithError(err).Fatal("Error signing previous CA CRL")
+		}
+	}
+
+	if err := pki.CheckRotation(ctx, oldCA, newCA, time.Now()); err != nil {
+		log.WithError(err).Fatal("New CA failed the rotation test")
+	}
+
+	if err := store.PutCAData(ctx, newCA); err != nil {
+		log.WithError(err).Fatal("Error writing new CA")
+	}
+
+	fmt.Printf("%s", pki.EncodePEMCert(newCA.CACert))
+	log.Info("Issuing CA stored")
+}
+
+func main() {
+	ctx := context.Background()
+
+	if len(os.Args) < 2 {
+		log.Fatalf("Usage: %s init|issue [flags]", os.Args[0])
+	}
+
+	switch os.Args[1] {
+	case "init":
+		initRoot(os.Args[2:])
+	case "issue":
+		issueIntermediate(ctx, os.Args[2:])
+	default:
+		log.Fatalf("Unknown command %s", os.Args[1])
+	}
+}
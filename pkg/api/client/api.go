@@ -7,15 +7,24 @@ import (
 	awsservices "github.com/empathybroker/aws-vpn/pkg/aws"
 	"github.com/empathybroker/aws-vpn/pkg/pki"
 	awspki "github.com/empathybroker/aws-vpn/pkg/pki/aws"
+	"github.com/empathybroker/aws-vpn/pkg/pki/storage"
 	"github.com/gorilla/mux"
 )
 
 var (
-	pkiStorage = awspki.NewAWSStorage(awsservices.NewSecretsManagerClient(), awsservices.NewDynamoDBClient())
-	apiPKI     = pki.NewPKI(pkiStorage)
-	apiSNS     = awsservices.NewSNSClient()
+	pkiStorage, pkiLog = storage.FromEnv()
+	apiPKI             = pki.NewPKI(pkiStorage)
+	apiSNS             = awsservices.NewSNSClient()
 )
 
+func init() {
+	pki.RegisterSignerBackend(awspki.NewKMSBackend(awsservices.NewKMSClient()))
+
+	if pkiLog != nil {
+		apiPKI.SetIssuanceLog(pkiLog)
+	}
+}
+
 func NewRouter() *mux.Router {
 	r := mux.NewRouter()
 	r.Use(func(handler http.Handler) http.Handler {
@@ -26,6 +35,9 @@ func NewRouter() *mux.Router {
 	r.HandleFunc("/certificates", apiNewCert).Methods(http.MethodPut)
 	r.HandleFunc("/certificates/{serial}", apiGetCert).Methods(http.MethodGet)
 	r.HandleFunc("/certificates/{serial}", apiRevokeCert).Methods(http.MethodDelete)
+	r.HandleFunc("/certificates/{serial}/renew", apiRenewCert).Methods(http.MethodPost)
+	r.HandleFunc("/certificates/{serial}/hold", apiHoldCert).Methods(http.MethodPost)
+	r.HandleFunc("/certificates/{serial}/reinstate", apiReinstateCert).Methods(http.MethodPost)
 
 	return r
 }
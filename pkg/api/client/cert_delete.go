@@ -1,18 +1,50 @@
 package clientapi
 
 import (
+	"encoding/json"
+	"io"
 	"net/http"
 
 	"github.com/empathybroker/aws-vpn/pkg/api"
 	awsservices "github.com/empathybroker/aws-vpn/pkg/aws"
 	"github.com/empathybroker/aws-vpn/pkg/pki"
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	kMaxRevocationComment = 1024
+)
+
+type revokeRequest struct {
+	Reason  string `json:"reason"`
+	Comment string `json:"comment"`
+}
+
 func apiRevokeCert(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
+	// The body is optional, older clients send none
+	var request revokeRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+			api.ErrorResponse(w, http.StatusBadRequest, err, "Invalid input")
+			return
+		}
+	}
+
+	reason, err := pki.ParseRevocationReason(request.Reason)
+	if err != nil {
+		api.ErrorResponse(w, http.StatusBadRequest, err, "Invalid revocation reason")
+		return
+	}
+
+	if len(request.Comment) > kMaxRevocationComment {
+		api.ErrorResponse(w, http.StatusBadRequest, nil, "Comment too long")
+		return
+	}
+
 	serial, err := pki.DecodeSerial(vars["serial"])
 	if err != nil {
 		api.ErrorResponse(w, http.StatusBadRequest, err, "Invalid serial")
@@ -36,8 +68,15 @@ func apiRevokeCert(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cert, err = apiPKI.RevokeCert(r.Context(), serial)
-	if err != nil {
+	cert, err = apiPKI.RevokeCert(r.Context(), serial, pki.Revocation{
+		Reason:    reason,
+		RevokedBy: userInfo.Email,
+		Comment:   request.Comment,
+	})
+	if errors.Cause(err) == pki.ErrInvalidCertState {
+		api.ErrorResponse(w, http.StatusConflict, err, "Certificate is already revoked")
+		return
+	} else if err != nil {
 		api.ErrorResponse(w, http.StatusInternalServerError, err, "Error revoking certificate")
 		return
 	}
@@ -50,6 +89,7 @@ func apiRevokeCert(w http.ResponseWriter, r *http.Request) {
 	event := api.J{
 		"event":      "cert_revoked",
 		"revoked_by": userInfo.Email,
+		"reason":     reason.String(),
 		"cert":       cert,
 	}
 
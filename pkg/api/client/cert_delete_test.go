@@ -0,0 +1,28 @@
+package clientapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRevokeCertTwiceConflicts(t *testing.T) {
+	certs := useTestPKI(t, 1)
+
+	revoke := func() *httptest.ResponseRecorder {
+		r := newUserRequest(t, http.MethodDelete, "/certificates/"+certs[0].Serial, revokeRequest{})
+		w := httptest.NewRecorder()
+		apiRevokeCert(w, mux.SetURLVars(r, map[string]string{"serial": certs[0].Serial}))
+		return w
+	}
+
+	if w := revoke(); w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+
+	if w := revoke(); w.Code != http.StatusConflict {
+		t.Fatalf("expected status %d revoking twice, got %d: %s", http.StatusConflict, w.Code, w.Body)
+	}
+}
@@ -0,0 +1,144 @@
+package clientapi
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/api"
+	"github.com/empathybroker/aws-vpn/pkg/ovpn"
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+type exportFormat string
+
+const (
+	kFormatOpenVPN exportFormat = "ovpn"
+	kFormatPEM     exportFormat = "pem"
+	kFormatPKCS12  exportFormat = "p12"
+
+	kContentTypeOpenVPN = "application/x-openvpn-profile"
+	kContentTypePEM     = "application/x-pem-file"
+	kContentTypePKCS12  = "application/x-pkcs12"
+)
+
+var contentTypes = map[exportFormat]string{
+	kFormatOpenVPN: kContentTypeOpenVPN,
+	kFormatPEM:     kContentTypePEM,
+	kFormatPKCS12:  kContentTypePKCS12,
+}
+
+// requestedFormat takes the format from the query string or, failing that, the first known type in Accept.
+// Anything else gets the OpenVPN profile, as before.
+func requestedFormat(r *http.Request) (exportFormat, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		if _, ok := contentTypes[exportFormat(format)]; !ok {
+			return "", errors.Errorf("unknown format %q", format)
+		}
+		return exportFormat(format), nil
+	}
+
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+
+		for format, contentType := range contentTypes {
+			if mediaType == contentType {
+				return format, nil
+			}
+		}
+	}
+
+	return kFormatOpenVPN, nil
+}
+
+// checkExport validates the export options before anything is issued
+func checkExport(w http.ResponseWriter, r *http.Request) (exportFormat, bool) {
+	format, err := requestedFormat(r)
+	if err != nil {
+		api.ErrorResponse(w, http.StatusBadRequest, err, "Invalid format")
+		return "", false
+	}
+
+	return format, true
+}
+
+func writeCertificate(w http.ResponseWriter, r *http.Request, cert *pki.CertificateInfo, format exportFormat, password string) {
+	if format == kFormatOpenVPN {
+		writeClientConfig(w, r, cert)
+		return
+	}
+
+	// The issuing CA followed by its own chain up to the root
+	chain := append([]*x509.Certificate{apiPKI.GetCACert(r.Context())}, apiPKI.GetCAChain(r.Context())...)
+
+	var data []byte
+	switch format {
+	case kFormatPEM:
+		data = pki.EncodePEMBundle(cert.Certificate, chain)
+	case kFormatPKCS12:
+		var err error
+		if data, err = pki.EncodePKCS12(cert.Certificate, chain, password); err != nil {
+			api.ErrorResponse(w, http.StatusInternalServerError, err, "Error encoding PKCS#12")
+			return
+		}
+	}
+
+	writeFile(w, format, data)
+}
+
+func writeClientConfig(w http.ResponseWriter, r *http.Request, cert *pki.CertificateInfo) {
+	configData := ovpn.ConfigData{
+		Certificate: cert.Certificate,
+
+		CACert:     apiPKI.GetCACert(r.Context()),
+		PrevCACert: apiPKI.GetPrevCACert(r.Context()),
+		CrossCert:  apiPKI.GetCrossCert(r.Context()),
+		Chain:      apiPKI.GetCAChain(r.Context()),
+
+		StaticKey: apiPKI.GetClientStaticKey(r.Context()),
+		Overlap:   apiPKI.GetStaticKeyRotation(r.Context()) != nil,
+	}
+
+	if key := apiPKI.GetTLSCryptV2Key(r.Context()); key != nil {
+		var err error
+		if configData.TLSCryptV2ClientKey, err = key.ClientKey(cert); err != nil {
+			api.ErrorResponse(w, http.StatusInternalServerError, err, "Error creating tls-crypt-v2 key")
+			return
+		}
+		// Until the switch to tls-crypt-v2 is over the overlap instance takes the tls-crypt-v2 profiles, and the
+		// main one once the legacy tls-crypt profiles have expired
+		configData.Overlap = apiPKI.TLSCryptV2Legacy(r.Context(), time.Now())
+	}
+
+	var buf bytes.Buffer
+	if err := ovpn.GetClientConfig(&buf, configData); err != nil {
+		api.ErrorResponse(w, http.StatusInternalServerError, err, "Error writing OpenVPN profile")
+		return
+	}
+
+	writeFile(w, kFormatOpenVPN, buf.Bytes())
+}
+
+func writeFile(w http.ResponseWriter, format exportFormat, data []byte) {
+	fname := "OpenVPN"
+	if caName := os.Getenv("PKI_CLIENT_CERT_NAME"); caName != "" {
+		fname = caName
+	}
+
+	w.Header().Set("X-VPN-Filename", fmt.Sprintf("%s.%s", fname, format))
+	w.Header().Set("Content-Type", contentTypes[format])
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		log.WithError(err).Error("Error writing binary response")
+	}
+}
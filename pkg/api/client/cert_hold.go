@@ -0,0 +1,74 @@
+package clientapi
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/empathybroker/aws-vpn/pkg/api"
+	awsservices "github.com/empathybroker/aws-vpn/pkg/aws"
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+func apiHoldCert(w http.ResponseWriter, r *http.Request) {
+	changeHold(w, r, "cert_held", apiPKI.HoldCert)
+}
+
+func apiReinstateCert(w http.ResponseWriter, r *http.Request) {
+	changeHold(w, r, "cert_reinstated", apiPKI.ReinstateCert)
+}
+
+func changeHold(w http.ResponseWriter, r *http.Request, eventName string, op func(context.Context, []byte) (*pki.CertificateInfo, error)) {
+	vars := mux.Vars(r)
+
+	serial, err := pki.DecodeSerial(vars["serial"])
+	if err != nil {
+		api.ErrorResponse(w, http.StatusBadRequest, err, "Invalid serial")
+		return
+	}
+
+	_, userInfo, err := api.GetAPIGWPrincipal(r)
+	if err != nil {
+		api.ErrorResponse(w, http.StatusInternalServerError, err, "Error obtaining principal")
+		return
+	}
+
+	if !userInfo.IsAdmin {
+		api.ErrorResponse(w, http.StatusForbidden, nil, "Forbidden")
+		return
+	}
+
+	cert, err := apiPKI.GetCertBySerial(r.Context(), serial)
+	if err != nil {
+		api.ErrorResponse(w, http.StatusInternalServerError, err, "Error obtaining certificate")
+		return
+	}
+
+	if cert == nil {
+		api.ErrorResponse(w, http.StatusNotFound, nil, "Not Found")
+		return
+	}
+
+	cert, err = op(r.Context(), serial)
+	if errors.Cause(err) == pki.ErrInvalidCertState {
+		api.ErrorResponse(w, http.StatusConflict, err, "Certificate is revoked or already in that state")
+		return
+	} else if err != nil {
+		api.ErrorResponse(w, http.StatusInternalServerError, err, "Error updating certificate")
+		return
+	}
+
+	event := api.J{
+		"event":      eventName,
+		"changed_by": userInfo.Email,
+		"cert":       cert,
+	}
+
+	if err := awsservices.PublishEvent(apiSNS, r.Context(), event); err != nil {
+		log.WithError(err).Error("Error publishing event")
+	}
+
+	api.JsonResponse(w, http.StatusOK, cert)
+}
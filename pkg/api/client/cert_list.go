@@ -2,10 +2,20 @@ package clientapi
 
 import (
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/empathybroker/aws-vpn/pkg/api"
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/pkg/errors"
 )
 
+// kQueryParams select the paginated listing, only for admins
+var kQueryParams = []string{"type", "status", "subject", "keyId", "issuedAfter", "issuedBefore", "expiresAfter",
+	"expiresBefore", "sort", "order", "limit", "cursor"}
+
 func apiGetCerts(w http.ResponseWriter, r *http.Request) {
 	_, userInfo, err := api.GetAPIGWPrincipal(r)
 	if err != nil {
@@ -13,8 +23,21 @@ func apiGetCerts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	params := r.URL.Query()
+	for _, name := range kQueryParams {
+		if _, ok := params[name]; ok {
+			if !userInfo.IsAdmin {
+				api.ErrorResponse(w, http.StatusForbidden, nil, "Forbidden")
+				return
+			}
+
+			queryCerts(w, r, params)
+			return
+		}
+	}
+
 	subject := userInfo.Email
-	if userInfo.IsAdmin && r.URL.Query().Get("all") == "true" {
+	if userInfo.IsAdmin && params.Get("all") == "true" {
 		subject = ""
 	}
 
@@ -29,3 +52,95 @@ func apiGetCerts(w http.ResponseWriter, r *http.Request) {
 		"certs":   certs,
 	})
 }
+
+func queryCerts(w http.ResponseWriter, r *http.Request, params url.Values) {
+	query, err := parseCertQuery(params)
+	if err != nil {
+		api.ErrorResponse(w, http.StatusBadRequest, err, err.Error())
+		return
+	}
+
+	// Storages paging in an order of their own would only sort each page
+	if !apiPKI.SortsAcrossPages() && (params.Get("sort") != "" || params.Get("order") != "") {
+		api.ErrorResponse(w, http.StatusBadRequest, nil, "Sorting is not supported by the storage")
+		return
+	}
+
+	page, err := apiPKI.QueryCerts(r.Context(), query)
+	if errors.Cause(err) == pki.ErrInvalidCursor {
+		api.ErrorResponse(w, http.StatusBadRequest, err, "Invalid cursor")
+		return
+	} else if err != nil {
+		api.ErrorResponse(w, http.StatusInternalServerError, err, "Error listing certs")
+		return
+	}
+
+	api.JsonResponse(w, http.StatusOK, api.J{
+		"isAdmin":    true,
+		"certs":      page.Certs,
+		"nextCursor": page.NextCursor,
+	})
+}
+
+func parseCertQuery(params url.Values) (pki.CertQuery, error) {
+	query := pki.CertQuery{
+		Status:        pki.CertStatus(params.Get("status")),
+		SubjectPrefix: params.Get("subject"),
+		KeyId:         params.Get("keyId"),
+		SortBy:        pki.CertSortField(params.Get("sort")),
+		Cursor:        params.Get("cursor"),
+	}
+
+	if certType := params.Get("type"); certType != "" {
+		for _, t := range []pki.CertType{pki.CertTypeClient, pki.CertTypeServer, pki.CertTypeCA} {
+			if strings.EqualFold(certType, string(t)) {
+				query.Type = t
+			}
+		}
+
+		if query.Type == "" {
+			return query, errors.Errorf("Invalid certificate type %q", certType)
+		}
+	}
+
+	switch order := params.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return query, errors.Errorf("Invalid order %q", order)
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return query, errors.Errorf("Invalid limit %q", limit)
+		}
+		query.Limit = n
+	}
+
+	for name, t := range map[string]*time.Time{
+		"issuedAfter":   &query.IssuedAfter,
+		"issuedBefore":  &query.IssuedBefore,
+		"expiresAfter":  &query.ExpiresAfter,
+		"expiresBefore": &query.ExpiresBefore,
+	} {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return query, errors.Errorf("Invalid %s, expected an RFC 3339 time", name)
+		}
+		*t = parsed
+	}
+
+	query, err := query.Normalize()
+	if err != nil {
+		return query, errors.Wrap(err, "Invalid query")
+	}
+
+	return query, nil
+}
@@ -0,0 +1,57 @@
+package clientapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	mempki "github.com/empathybroker/aws-vpn/pkg/pki/memory"
+	"github.com/google/uuid"
+)
+
+// unsortedStorage pages like the aws storage, which only sorts within each page
+type unsortedStorage struct {
+	pki.PKIStorage
+}
+
+func (unsortedStorage) UnsortedPages() {}
+
+func TestQueryCertsSortUnsupported(t *testing.T) {
+	useTestPKI(t, 3)
+
+	query := func(params url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/certificates?"+params.Encode(), nil)
+		w := httptest.NewRecorder()
+		queryCerts(w, r, params)
+		return w
+	}
+
+	sorted := url.Values{"sort": {"expiresAt"}, "order": {"desc"}}
+	if w := query(sorted); w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+
+	s := mempki.NewMemStorage()
+	caData, err := pki.NewCAKey("Test CA", uuid.New().String(), pki.KeyAlgorithmP256, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutCAData(context.Background(), caData); err != nil {
+		t.Fatal(err)
+	}
+
+	apiPKI = pki.NewPKI(unsortedStorage{s})
+	for _, params := range []url.Values{{"sort": {"issuedAt"}}, {"order": {"asc"}}, sorted} {
+		if w := query(params); w.Code != http.StatusBadRequest {
+			t.Errorf("%v: expected status %d, got %d: %s", params, http.StatusBadRequest, w.Code, w.Body)
+		}
+	}
+
+	if w := query(url.Values{"limit": {"2"}}); w.Code != http.StatusOK {
+		t.Fatalf("expected status %d without sorting, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+}
@@ -1,41 +1,49 @@
 package clientapi
 
 import (
-	"bytes"
 	"crypto/x509/pkix"
 	"encoding/asn1"
-	"encoding/json"
-	"fmt"
 	"net/http"
 	"os"
-	"time"
 
 	"github.com/empathybroker/aws-vpn/pkg/api"
 
 	awsservices "github.com/empathybroker/aws-vpn/pkg/aws"
-	"github.com/empathybroker/aws-vpn/pkg/ovpn"
+	"github.com/empathybroker/aws-vpn/pkg/gsuite"
 	"github.com/empathybroker/aws-vpn/pkg/pki"
 	log "github.com/sirupsen/logrus"
-	jose "gopkg.in/square/go-jose.v2"
 )
 
 const (
 	kMaxClientCerts = 2
+
+	kDirectorySchema   = "VPN"
+	kDirectoryProfiles = "profiles"
 )
 
-type newCertRequest struct {
-	PublicKey jose.JSONWebKey `json:"publicKey"`
+// profileAllowed checks the profiles listed for the user in the directory. The default profile is always allowed.
+func profileAllowed(userInfo *gsuite.UserInfo, name string) bool {
+	if name == pki.DefaultProfileName {
+		return true
+	}
+
+	for _, allowed := range userInfo.SchemaStrings(kDirectorySchema, kDirectoryProfiles) {
+		if allowed == name {
+			return true
+		}
+	}
+	return false
 }
 
 func apiNewCert(w http.ResponseWriter, r *http.Request) {
-	var request newCertRequest
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	request, err := api.DecodeKeyRequest(w, r)
+	if err != nil {
 		api.ErrorResponse(w, http.StatusBadRequest, err, "Invalid input")
 		return
 	}
 
-	if !request.PublicKey.Valid() {
-		api.ErrorResponse(w, http.StatusBadRequest, nil, "Invalid public key")
+	format, ok := checkExport(w, r)
+	if !ok {
 		return
 	}
 
@@ -45,24 +53,32 @@ func apiNewCert(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	currentCerts, err := apiPKI.ListCerts(r.Context(), userInfo.Email)
-	if err != nil {
-		api.ErrorResponse(w, http.StatusInternalServerError, err, "Error listing certs")
+	profileName := request.Profile
+	if profileName == "" {
+		profileName = pki.DefaultProfileName
+	}
+
+	profile, err := pki.Profiles.Get(profileName)
+	if err != nil || profile.Name == pki.ProfileServer {
+		api.ErrorResponse(w, http.StatusBadRequest, err, "Invalid profile")
 		return
 	}
 
-	nonRevoked := 0
-	for _, cCert := range currentCerts {
-		if cCert.Revoked == nil {
-			nonRevoked += 1
+	if !profileAllowed(userInfo, profileName) {
+		api.ErrorResponse(w, http.StatusForbidden, nil, "Profile not allowed")
+		return
+	}
 
-			if nonRevoked >= kMaxClientCerts {
-				if _, err := apiPKI.RevokeCert(r.Context(), cCert.SerialBytes); err != nil {
-					log.WithError(err).Error("Error revoking certificate")
-					// Don't fail
-				}
-			}
-		}
+	pubKey, certOpts, err := request.Parse(profile.CSRPolicy(userInfo.Email))
+	if err != nil {
+		api.ErrorResponse(w, http.StatusBadRequest, err, "Invalid public key")
+		return
+	}
+
+	currentCerts, err := apiPKI.ListCerts(r.Context(), userInfo.Email)
+	if err != nil {
+		api.ErrorResponse(w, http.StatusInternalServerError, err, "Error listing certs")
+		return
 	}
 
 	name := pkix.Name{
@@ -78,47 +94,49 @@ func apiNewCert(w http.ResponseWriter, r *http.Request) {
 		Value: userInfo.Id},
 	)
 
-	cert, err := apiPKI.CreateCertificate(r.Context(), request.PublicKey.Key, name,
-		pki.WithDuration(30*24*time.Hour), pki.ClientCert, pki.WithEmail(userInfo.Email))
+	certOpts = append(certOpts, issuerOptions()...)
+
+	cert, err := apiPKI.CreateCertificate(r.Context(), pubKey, name, profile, certOpts...)
 	if err != nil {
-		api.ErrorResponse(w, http.StatusInternalServerError, err, "Error creating certificate")
+		api.CertificateErrorResponse(w, err, "Error creating certificate")
 		return
 	}
 
-	event := api.J{
-		"event": "cert_signed",
-		"cert":  cert,
-	}
+	// The oldest certificates are only revoked once the new one is issued, a refused request changes nothing
+	nonRevoked := 0
+	for _, cCert := range currentCerts {
+		if cCert.Revoked == nil && cCert.RevokeAt == nil {
+			nonRevoked += 1
 
-	if err := awsservices.PublishEvent(apiSNS, r.Context(), event); err != nil {
-		log.WithError(err).Error("Error publishing event")
+			if nonRevoked >= kMaxClientCerts {
+				if _, err := apiPKI.RevokeCert(r.Context(), cCert.SerialBytes, pki.Revocation{
+					Reason:    pki.ReasonSuperseded,
+					RevokedBy: userInfo.Email,
+					Comment:   "Certificate limit reached",
+				}); err != nil {
+					log.WithError(err).Error("Error revoking certificate")
+					// Don't fail
+				}
+			}
+		}
 	}
 
-	configData := ovpn.ConfigData{
-		Certificate: cert.Certificate,
-
-		CACert:     apiPKI.GetCACert(r.Context()),
-		PrevCACert: apiPKI.GetPrevCACert(r.Context()),
-		CrossCert:  apiPKI.GetCrossCert(r.Context()),
-
-		StaticKey: apiPKI.GetStaticKey(r.Context()),
+	event := api.J{
+		"event":   "cert_signed",
+		"profile": profile.Name,
+		"cert":    cert,
 	}
 
-	var buf bytes.Buffer
-	if err := ovpn.GetClientConfig(&buf, configData); err != nil {
-		api.ErrorResponse(w, http.StatusInternalServerError, err, "Error writing OpenVPN profile")
-		return
+	if err := awsservices.PublishEvent(apiSNS, r.Context(), event); err != nil {
+		log.WithError(err).Error("Error publishing event")
 	}
 
-	fname := "OpenVPN"
-	if caName := os.Getenv("PKI_CLIENT_CERT_NAME"); caName != "" {
-		fname = caName
-	}
+	writeCertificate(w, r, cert, format, request.Password)
+}
 
-	w.Header().Set("X-VPN-Filename", fmt.Sprintf("%s.ovpn", fname))
-	w.Header().Set("Content-Type", "application/x-openvpn-profile")
-	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write(buf.Bytes()); err != nil {
-		log.WithError(err).Error("Error writing binary response")
+func issuerOptions() []pki.CertOptions {
+	if ocspURL := os.Getenv("PKI_OCSP_URL"); ocspURL != "" {
+		return []pki.CertOptions{pki.WithOCSPServer(ocspURL)}
 	}
+	return nil
 }
@@ -0,0 +1,141 @@
+package clientapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/awslabs/aws-lambda-go-api-proxy/core"
+	"github.com/empathybroker/aws-vpn/pkg/api"
+	"github.com/empathybroker/aws-vpn/pkg/gsuite"
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	mempki "github.com/empathybroker/aws-vpn/pkg/pki/memory"
+	"github.com/google/uuid"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const kTestEmail = "user@example.com"
+
+// useTestPKI replaces the PKI of the API with one in memory holding n active certificates of the test user
+func useTestPKI(t *testing.T, n int) []*pki.CertificateInfo {
+	ctx := context.Background()
+	s := mempki.NewMemStorage()
+
+	caData, err := pki.NewCAKey("Test CA", uuid.New().String(), pki.KeyAlgorithmP256, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.PutCAData(ctx, caData); err != nil {
+		t.Fatal(err)
+	}
+
+	prev := apiPKI
+	apiPKI = pki.NewPKI(s)
+	t.Cleanup(func() { apiPKI = prev })
+
+	var certs []*pki.CertificateInfo
+	for i := 0; i < n; i++ {
+		key, err := pki.NewPrivateKey(pki.KeyAlgorithmP256)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := apiPKI.CreateCertificate(ctx, pki.GetPublicKey(key), pkix.Name{CommonName: kTestEmail}, pki.NewDefaultProfiles()[pki.ProfileLaptop])
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, info)
+	}
+
+	return certs
+}
+
+func newUserRequest(t *testing.T, method string, target string, body interface{}) *http.Request {
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userInfo, err := json.Marshal(gsuite.UserInfo{Id: "1", Email: kTestEmail})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apiGwContext, err := json.Marshal(map[string]interface{}{
+		"authorizer": map[string]interface{}{
+			"principalId": kTestEmail,
+			"google":      string(userInfo),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(method, target, bytes.NewReader(data))
+	r.Header.Set(core.APIGwContextHeader, string(apiGwContext))
+	return r
+}
+
+func TestNewCertRejectedKeyRevokesNothing(t *testing.T) {
+	certs := useTestPKI(t, 2)
+
+	weakKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	apiNewCert(w, newUserRequest(t, http.MethodPut, "/certificates", api.KeyRequest{
+		PublicKey: &jose.JSONWebKey{Key: &weakKey.PublicKey},
+	}))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusBadRequest, w.Code, w.Body)
+	}
+
+	checkNotRevoked(t, certs)
+}
+
+func TestNewCertOutsideNameConstraintsRevokesNothing(t *testing.T) {
+	certs := useTestPKI(t, 2)
+	apiPKI.SetNameConstraints(pki.ParseNameConstraints("other.example.com", ""))
+
+	key, err := pki.NewPrivateKey(pki.KeyAlgorithmP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	apiNewCert(w, newUserRequest(t, http.MethodPut, "/certificates", api.KeyRequest{
+		PublicKey: &jose.JSONWebKey{Key: pki.GetPublicKey(key)},
+	}))
+
+	if w.Code != http.StatusBadRequest || !bytes.Contains(w.Body.Bytes(), []byte(pki.NameRejectedConstraints)) {
+		t.Fatalf("expected the name to be rejected, got %d: %s", w.Code, w.Body)
+	}
+
+	checkNotRevoked(t, certs)
+}
+
+func checkNotRevoked(t *testing.T, certs []*pki.CertificateInfo) {
+	t.Helper()
+
+	for _, cert := range certs {
+		info, err := apiPKI.GetCertBySerial(context.Background(), cert.SerialBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Revoked != nil {
+			t.Errorf("certificate %s revoked for a rejected request", info.Serial)
+		}
+	}
+}
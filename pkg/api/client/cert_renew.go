@@ -0,0 +1,101 @@
+package clientapi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/api"
+	awsservices "github.com/empathybroker/aws-vpn/pkg/aws"
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	kRenewalGracePeriod = 24 * time.Hour
+)
+
+func apiRenewCert(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	serial, err := pki.DecodeSerial(vars["serial"])
+	if err != nil {
+		api.ErrorResponse(w, http.StatusBadRequest, err, "Invalid serial")
+		return
+	}
+
+	_, userInfo, err := api.GetAPIGWPrincipal(r)
+	if err != nil {
+		api.ErrorResponse(w, http.StatusInternalServerError, err, "Error obtaining principal")
+		return
+	}
+
+	prev, err := apiPKI.GetCertBySerial(r.Context(), serial)
+	if err != nil {
+		api.ErrorResponse(w, http.StatusInternalServerError, err, "Error obtaining certificate")
+		return
+	}
+
+	if prev == nil || (!userInfo.IsAdmin && prev.Subject != userInfo.Email) {
+		api.ErrorResponse(w, http.StatusNotFound, nil, "Not Found")
+		return
+	}
+
+	if prev.CertType != pki.CertTypeClient {
+		api.ErrorResponse(w, http.StatusBadRequest, nil, "Only client certificates can be renewed")
+		return
+	}
+
+	profileName := prev.Profile
+	if profileName == "" {
+		profileName = pki.DefaultProfileName
+	}
+
+	if !userInfo.IsAdmin && !profileAllowed(userInfo, profileName) {
+		api.ErrorResponse(w, http.StatusForbidden, nil, "Profile not allowed")
+		return
+	}
+
+	request, err := api.DecodeKeyRequest(w, r)
+	if err != nil {
+		api.ErrorResponse(w, http.StatusBadRequest, err, "Invalid input")
+		return
+	}
+
+	format, ok := checkExport(w, r)
+	if !ok {
+		return
+	}
+
+	// Names are copied from the predecessor, so none are taken from the request
+	pubKey, certOpts, err := request.Parse(pki.CSRPolicy{})
+	if err != nil {
+		api.ErrorResponse(w, http.StatusBadRequest, err, "Invalid public key")
+		return
+	}
+
+	certOpts = append(certOpts, issuerOptions()...)
+
+	cert, err := apiPKI.RenewCertificate(r.Context(), prev, pubKey, kRenewalGracePeriod, certOpts...)
+	if errors.Cause(err) == pki.ErrNotRenewable {
+		api.ErrorResponse(w, http.StatusConflict, err, "Certificate is revoked or already renewed")
+		return
+	} else if err != nil {
+		api.CertificateErrorResponse(w, err, "Error renewing certificate")
+		return
+	}
+
+	event := api.J{
+		"event":       "cert_renewed",
+		"renewed_by":  userInfo.Email,
+		"predecessor": prev.Serial,
+		"cert":        cert,
+	}
+
+	if err := awsservices.PublishEvent(apiSNS, r.Context(), event); err != nil {
+		log.WithError(err).Error("Error publishing event")
+	}
+
+	writeCertificate(w, r, cert, format, request.Password)
+}
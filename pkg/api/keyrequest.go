@@ -0,0 +1,87 @@
+package api
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/pkg/errors"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const (
+	kPKCS10ContentType = "application/pkcs10"
+	kMaxCSRSize        = 16 * 1024
+
+	// PKCS12PasswordHeader carries the optional PKCS#12 password when the body is a bare CSR
+	PKCS12PasswordHeader = "X-VPN-PKCS12-Password"
+)
+
+// KeyRequest carries the key to be certified, either as a bare JWK or inside a PKCS#10 CSR
+type KeyRequest struct {
+	PublicKey *jose.JSONWebKey `json:"publicKey,omitempty"`
+	CSR       string           `json:"csr,omitempty"`
+	Profile   string           `json:"profile,omitempty"`
+
+	// Password encrypts and MACs the PKCS#12 file for the keychains which need it. The file only holds
+	// certificates, so it's optional.
+	Password string `json:"password,omitempty"`
+}
+
+// DecodeKeyRequest reads a KeyRequest from a JSON body, or from a PEM/DER CSR sent as application/pkcs10
+// with the profile in the query string and the PKCS#12 password in a header
+func DecodeKeyRequest(w http.ResponseWriter, r *http.Request) (*KeyRequest, error) {
+	if r.Header.Get("Content-Type") == kPKCS10ContentType {
+		data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, kMaxCSRSize))
+		if err != nil {
+			return nil, errors.Wrap(err, "reading CSR")
+		}
+
+		request := &KeyRequest{
+			CSR:      string(data),
+			Profile:  r.URL.Query().Get("profile"),
+			Password: r.Header.Get(PKCS12PasswordHeader),
+		}
+		if !strings.HasPrefix(strings.TrimSpace(request.CSR), "-----BEGIN") {
+			request.CSR = base64.StdEncoding.EncodeToString(data)
+		}
+		return request, nil
+	}
+
+	var request KeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, errors.Wrap(err, "decoding JSON")
+	}
+
+	return &request, nil
+}
+
+// Parse returns the requested public key along with the options for the names allowed by policy
+func (r KeyRequest) Parse(policy pki.CSRPolicy) (crypto.PublicKey, []pki.CertOptions, error) {
+	if r.CSR != "" {
+		data := []byte(r.CSR)
+		if !strings.HasPrefix(strings.TrimSpace(r.CSR), "-----BEGIN") {
+			var err error
+			if data, err = base64.StdEncoding.DecodeString(r.CSR); err != nil {
+				return nil, nil, errors.Wrap(err, "decoding CSR")
+			}
+		}
+
+		csr, err := pki.ParseCSR(data)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return csr.PublicKey, policy.Options(csr), nil
+	}
+
+	if r.PublicKey == nil || !r.PublicKey.Valid() {
+		return nil, nil, errors.New("invalid public key")
+	}
+
+	return pki.JSONWebKeyKey(*r.PublicKey), policy.Options(nil), nil
+}
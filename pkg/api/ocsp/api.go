@@ -0,0 +1,36 @@
+package ocspapi
+
+import (
+	"net/http"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	awsservices "github.com/empathybroker/aws-vpn/pkg/aws"
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	awspki "github.com/empathybroker/aws-vpn/pkg/pki/aws"
+	"github.com/empathybroker/aws-vpn/pkg/pki/storage"
+	"github.com/gorilla/mux"
+)
+
+var (
+	pkiStorage, _ = storage.FromEnv()
+	apiPKI        = pki.NewPKI(pkiStorage)
+)
+
+func init() {
+	pki.RegisterSignerBackend(awspki.NewKMSBackend(awsservices.NewKMSClient()))
+}
+
+func NewRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.Use(func(handler http.Handler) http.Handler {
+		return xray.Handler(xray.NewFixedSegmentNamer("vpn-api-ocsp"), handler)
+	})
+
+	// Base64 encoded GET requests may contain slashes, which must not be cleaned up
+	r.SkipClean(true)
+
+	r.HandleFunc("/", apiOCSPPost).Methods(http.MethodPost)
+	r.PathPrefix("/").HandlerFunc(apiOCSPGet).Methods(http.MethodGet)
+
+	return r
+}
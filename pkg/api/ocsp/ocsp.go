@@ -0,0 +1,95 @@
+package ocspapi
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	kOCSPRequestContentType  = "application/ocsp-request"
+	kOCSPResponseContentType = "application/ocsp-response"
+
+	kOCSPValidity       = 1 * time.Hour
+	kOCSPMaxRequestSize = 4 * 1024
+)
+
+func apiOCSPGet(w http.ResponseWriter, r *http.Request) {
+	encoded, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/"))
+	if err != nil {
+		ocspResponse(w, r, ocsp.MalformedRequestErrorResponse, err)
+		return
+	}
+
+	reqData, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		ocspResponse(w, r, ocsp.MalformedRequestErrorResponse, err)
+		return
+	}
+
+	ocspHandle(w, r, reqData)
+}
+
+func apiOCSPPost(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != kOCSPRequestContentType {
+		ocspResponse(w, r, ocsp.MalformedRequestErrorResponse, errors.New("unexpected content type"))
+		return
+	}
+
+	reqData, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, kOCSPMaxRequestSize))
+	if err != nil {
+		ocspResponse(w, r, ocsp.MalformedRequestErrorResponse, err)
+		return
+	}
+
+	ocspHandle(w, r, reqData)
+}
+
+func ocspHandle(w http.ResponseWriter, r *http.Request, reqData []byte) {
+	req, err := ocsp.ParseRequest(reqData)
+	if err != nil {
+		ocspResponse(w, r, ocsp.MalformedRequestErrorResponse, err)
+		return
+	}
+
+	res, err := apiPKI.CreateOCSPResponse(r.Context(), req, kOCSPValidity)
+	if err == pki.ErrUnknownIssuer {
+		ocspResponse(w, r, ocsp.UnauthorizedErrorResponse, err)
+		return
+	} else if err != nil {
+		ocspResponse(w, r, ocsp.InternalErrorErrorResponse, err)
+		return
+	}
+
+	// Successful GET responses can be cached by intermediaries (RFC 5019 section 6)
+	if r.Method == http.MethodGet {
+		now := time.Now().UTC()
+		w.Header().Set("Last-Modified", now.Format(http.TimeFormat))
+		w.Header().Set("Expires", now.Add(kOCSPValidity).Format(http.TimeFormat))
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, public, no-transform, must-revalidate", int(kOCSPValidity.Seconds())))
+	}
+
+	log.Debugf("OCSP response for serial %x", req.SerialNumber)
+	ocspResponse(w, r, res, nil)
+}
+
+func ocspResponse(w http.ResponseWriter, r *http.Request, res []byte, err error) {
+	if err != nil {
+		log.WithError(err).Errorf("Error answering OCSP request")
+	}
+
+	w.Header().Set("Content-Type", kOCSPResponseContentType)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(res); err != nil {
+		log.WithError(err).Error("Error writing binary response")
+	}
+}
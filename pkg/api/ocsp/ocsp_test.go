@@ -0,0 +1,263 @@
+package ocspapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	mempki "github.com/empathybroker/aws-vpn/pkg/pki/memory"
+	"github.com/empathybroker/aws-vpn/pkg/pki/storage"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ocsp"
+)
+
+type testCerts struct {
+	prevCA, ca, foreignCA        *x509.Certificate
+	prevGood, prevRevoked        *pki.CertificateInfo
+	good, revoked, held, foreign *pki.CertificateInfo
+}
+
+func issueTestCert(t *testing.T, p *pki.PKI) *pki.CertificateInfo {
+	t.Helper()
+
+	key, err := pki.NewPrivateKey(pki.KeyAlgorithmP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := p.CreateCertificate(context.Background(), pki.GetPublicKey(key), pkix.Name{CommonName: uuid.New().String()}, pki.NewDefaultProfiles()[pki.ProfileLaptop])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func newTestStorage(t *testing.T) (storage.CAStore, pki.CAData) {
+	t.Helper()
+
+	caData, err := pki.NewCAKey("Test CA", uuid.New().String(), pki.KeyAlgorithmP256, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := mempki.NewMemStorage()
+	if err := s.PutCAData(context.Background(), caData); err != nil {
+		t.Fatal(err)
+	}
+	return s, caData
+}
+
+// useTestPKI replaces the PKI of the API with one in memory which went through a CA rotation, holding
+// certificates of both CAs in every state
+func useTestPKI(t *testing.T) testCerts {
+	ctx := context.Background()
+	s, caData := newTestStorage(t)
+
+	prev := apiPKI
+	apiPKI = pki.NewPKI(s)
+	t.Cleanup(func() { apiPKI = prev })
+
+	certs := testCerts{prevCA: caData.CACert}
+	certs.prevGood = issueTestCert(t, apiPKI)
+	certs.prevRevoked = issueTestCert(t, apiPKI)
+
+	renewed, err := caData.Renew("Test CA", uuid.New().String(), pki.KeyAlgorithmP256, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.PutCAData(ctx, renewed); err != nil {
+		t.Fatal(err)
+	}
+	certs.ca = renewed.CACert
+
+	certs.good = issueTestCert(t, apiPKI)
+	certs.revoked = issueTestCert(t, apiPKI)
+	certs.held = issueTestCert(t, apiPKI)
+
+	for _, cert := range []*pki.CertificateInfo{certs.prevRevoked, certs.revoked} {
+		if _, err := apiPKI.RevokeCert(ctx, cert.SerialBytes, pki.Revocation{Reason: pki.ReasonKeyCompromise}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := apiPKI.HoldCert(ctx, certs.held.SerialBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	// Issued by a CA the responder doesn't know
+	foreign, foreignCA := newTestStorage(t)
+	certs.foreign = issueTestCert(t, pki.NewPKI(foreign))
+	certs.foreignCA = foreignCA.CACert
+
+	return certs
+}
+
+func newOCSPRequest(t *testing.T, cert *pki.CertificateInfo, issuer *x509.Certificate) []byte {
+	t.Helper()
+
+	req, err := ocsp.CreateRequest(cert.Certificate, issuer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func postOCSP(t *testing.T, reqData []byte, contentType string) []byte {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(reqData))
+	r.Header.Set("Content-Type", contentType)
+
+	w := httptest.NewRecorder()
+	NewRouter().ServeHTTP(w, r)
+	return checkOCSPResponse(t, w)
+}
+
+func getOCSP(t *testing.T, reqData []byte) []byte {
+	t.Helper()
+	return getOCSPPath(t, "/"+base64.StdEncoding.EncodeToString(reqData))
+}
+
+func getOCSPPath(t *testing.T, path string) []byte {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+
+	w := httptest.NewRecorder()
+	NewRouter().ServeHTTP(w, r)
+	return checkOCSPResponse(t, w)
+}
+
+func checkOCSPResponse(t *testing.T, w *httptest.ResponseRecorder) []byte {
+	t.Helper()
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+
+	if contentType := w.Header().Get("Content-Type"); contentType != kOCSPResponseContentType {
+		t.Fatalf("unexpected content type %q", contentType)
+	}
+
+	return w.Body.Bytes()
+}
+
+func TestOCSPStatus(t *testing.T) {
+	certs := useTestPKI(t)
+
+	for _, test := range []struct {
+		name   string
+		cert   *pki.CertificateInfo
+		issuer *x509.Certificate
+		status int
+		reason int
+	}{
+		{"good", certs.good, certs.ca, ocsp.Good, ocsp.Unspecified},
+		{"revoked", certs.revoked, certs.ca, ocsp.Revoked, ocsp.KeyCompromise},
+		{"hold", certs.held, certs.ca, ocsp.Revoked, ocsp.CertificateHold},
+		{"previous CA good", certs.prevGood, certs.prevCA, ocsp.Good, ocsp.Unspecified},
+		{"previous CA revoked", certs.prevRevoked, certs.prevCA, ocsp.Revoked, ocsp.KeyCompromise},
+	} {
+		reqData := newOCSPRequest(t, test.cert, test.issuer)
+
+		for method, resData := range map[string][]byte{
+			http.MethodGet:  getOCSP(t, reqData),
+			http.MethodPost: postOCSP(t, reqData, kOCSPRequestContentType),
+		} {
+			// The responses for the previous CA are only valid if delegated through PrevOCSPCert
+			res, err := ocsp.ParseResponseForCert(resData, test.cert.Certificate, test.issuer)
+			if err != nil {
+				t.Fatalf("%s %s: %v", test.name, method, err)
+			}
+
+			if test.issuer == certs.prevCA && (res.Certificate == nil || !bytes.Equal(res.Certificate.RawSubjectPublicKeyInfo, certs.ca.RawSubjectPublicKeyInfo)) {
+				t.Errorf("%s %s: response not delegated to the current CA key", test.name, method)
+			}
+
+			if res.Status != test.status {
+				t.Errorf("%s %s: expected status %d, got %d", test.name, method, test.status, res.Status)
+			}
+
+			if res.Status == ocsp.Revoked && res.RevocationReason != test.reason {
+				t.Errorf("%s %s: expected reason %d, got %d", test.name, method, test.reason, res.RevocationReason)
+			}
+		}
+	}
+}
+
+// A certificate of the previous CA asked for as if the current CA had issued it is unknown
+func TestOCSPWrongIssuer(t *testing.T) {
+	certs := useTestPKI(t)
+
+	reqData := newOCSPRequest(t, certs.prevGood, certs.ca)
+	res, err := ocsp.ParseResponse(postOCSP(t, reqData, kOCSPRequestContentType), certs.ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Status != ocsp.Unknown {
+		t.Errorf("expected status %d, got %d", ocsp.Unknown, res.Status)
+	}
+}
+
+func TestOCSPUnknownSerial(t *testing.T) {
+	certs := useTestPKI(t)
+
+	req, err := ocsp.ParseRequest(newOCSPRequest(t, certs.good, certs.ca))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Look for a serial whose GET request has a double slash, which the router must not clean up
+	var reqData []byte
+	for serial := int64(1); reqData == nil; serial++ {
+		req.SerialNumber = big.NewInt(serial)
+		data, err := req.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Contains(base64.StdEncoding.EncodeToString(data), "//") {
+			reqData = data
+		}
+	}
+
+	res, err := ocsp.ParseResponse(getOCSP(t, reqData), certs.ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Status != ocsp.Unknown || res.SerialNumber.Cmp(req.SerialNumber) != 0 {
+		t.Errorf("expected status %d for serial %s, got %d for %s", ocsp.Unknown, req.SerialNumber, res.Status, res.SerialNumber)
+	}
+}
+
+func TestOCSPErrors(t *testing.T) {
+	certs := useTestPKI(t)
+
+	for _, test := range []struct {
+		name   string
+		res    []byte
+		status ocsp.ResponseStatus
+	}{
+		{"content type", postOCSP(t, newOCSPRequest(t, certs.good, certs.ca), "application/octet-stream"), ocsp.Malformed},
+		{"malformed", postOCSP(t, []byte("not a request"), kOCSPRequestContentType), ocsp.Malformed},
+		{"base64", getOCSPPath(t, "/not*base64"), ocsp.Malformed},
+		{"unknown issuer", postOCSP(t, newOCSPRequest(t, certs.foreign, certs.foreignCA), kOCSPRequestContentType), ocsp.Unauthorized},
+	} {
+		_, err := ocsp.ParseResponse(test.res, nil)
+		if resErr, ok := err.(ocsp.ResponseError); !ok || resErr.Status != test.status {
+			t.Errorf("%s: expected status %s, got %v", test.name, test.status, err)
+		}
+	}
+}
@@ -8,19 +8,28 @@ import (
 	"github.com/empathybroker/aws-vpn/pkg/gsuite"
 	"github.com/empathybroker/aws-vpn/pkg/pki"
 	awspki "github.com/empathybroker/aws-vpn/pkg/pki/aws"
+	"github.com/empathybroker/aws-vpn/pkg/pki/storage"
 	"github.com/gorilla/mux"
 )
 
 var (
-	pkiStorage = awspki.NewAWSStorage(awsservices.NewSecretsManagerClient(), awsservices.NewDynamoDBClient())
-	apiPKI     = pki.NewPKI(pkiStorage)
-	apiSNS     = awsservices.NewSNSClient()
-	apiEC2     = awsservices.NewEC2Client()
+	pkiStorage, pkiLog = storage.FromEnv()
+	apiPKI             = pki.NewPKI(pkiStorage)
+	apiSNS             = awsservices.NewSNSClient()
+	apiEC2             = awsservices.NewEC2Client()
 
 	apiSecretsManager = awsservices.NewSecretsManagerClient()
 	apiDirectory      = gsuite.NewGoogleDirectory(awsservices.NewAWSServiceAccountProvider(apiSecretsManager, "VPN/GoogleServiceAccount"))
 )
 
+func init() {
+	pki.RegisterSignerBackend(awspki.NewKMSBackend(awsservices.NewKMSClient()))
+
+	if pkiLog != nil {
+		apiPKI.SetIssuanceLog(pkiLog)
+	}
+}
+
 func NewRouter() *mux.Router {
 	r := mux.NewRouter()
 	r.Use(func(handler http.Handler) http.Handler {
@@ -31,6 +40,7 @@ func NewRouter() *mux.Router {
 	r.HandleFunc("/verify", apiServerVerify).Methods(http.MethodPost)
 	r.HandleFunc("/connect", apiServerConnect).Methods(http.MethodPost)
 	r.HandleFunc("/disconnect", apiServerDisconnect).Methods(http.MethodPost)
+	r.HandleFunc("/crl", apiServerCRL).Methods(http.MethodGet)
 
 	return r
 }
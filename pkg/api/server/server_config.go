@@ -3,7 +3,6 @@ package serverapi
 import (
 	"bytes"
 	"crypto/x509/pkix"
-	"encoding/json"
 	"net/http"
 	"os"
 	"time"
@@ -13,36 +12,43 @@ import (
 	"github.com/empathybroker/aws-vpn/pkg/ovpn"
 	"github.com/empathybroker/aws-vpn/pkg/pki"
 	log "github.com/sirupsen/logrus"
-	jose "gopkg.in/square/go-jose.v2"
 )
 
-type configRequest struct {
-	PublicKey jose.JSONWebKey `json:"publicKey"`
-}
-
 func apiServerConfig(w http.ResponseWriter, r *http.Request) {
-	var request configRequest
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	request, err := api.DecodeKeyRequest(w, r)
+	if err != nil {
 		api.ErrorResponse(w, http.StatusBadRequest, err, "Invalid input")
 		return
 	}
 
-	if !request.PublicKey.Valid() {
-		api.ErrorResponse(w, http.StatusBadRequest, nil, "Invalid public key")
-		return
-	}
-
 	dnsName := os.Getenv("PKI_DOMAIN")
 	if dnsName == "" {
 		api.ErrorResponse(w, http.StatusInternalServerError, nil, "Missing domain name")
 		return
 	}
 
-	cert, err := apiPKI.CreateCertificate(r.Context(), request.PublicKey.Key,
-		pkix.Name{CommonName: dnsName}, pki.ServerCert,
-		pki.WithDuration(30*24*time.Hour), pki.WithDNS(dnsName))
+	profile, err := pki.Profiles.Get(pki.ProfileServer)
+	if err != nil {
+		api.ErrorResponse(w, http.StatusInternalServerError, err, "Missing server profile")
+		return
+	}
+
+	policy := profile.CSRPolicy("")
+	policy.DNSNames = append([]string{dnsName}, policy.DNSNames...)
+
+	pubKey, certOpts, err := request.Parse(policy)
+	if err != nil {
+		api.ErrorResponse(w, http.StatusBadRequest, err, "Invalid public key")
+		return
+	}
+
+	if ocspURL := os.Getenv("PKI_OCSP_URL"); ocspURL != "" {
+		certOpts = append(certOpts, pki.WithOCSPServer(ocspURL))
+	}
+
+	cert, err := apiPKI.CreateCertificate(r.Context(), pubKey, pkix.Name{CommonName: dnsName}, profile, certOpts...)
 	if err != nil {
-		api.ErrorResponse(w, http.StatusInternalServerError, err, "Error signing certificate")
+		api.CertificateErrorResponse(w, err, "Error signing certificate")
 		return
 	}
 
@@ -52,8 +58,24 @@ func apiServerConfig(w http.ResponseWriter, r *http.Request) {
 		CACert:     apiPKI.GetCACert(r.Context()),
 		PrevCACert: apiPKI.GetPrevCACert(r.Context()),
 		CrossCert:  apiPKI.GetCrossCert(r.Context()),
+		Chain:      apiPKI.GetCAChain(r.Context()),
+
+		StaticKey:     apiPKI.GetStaticKey(r.Context()),
+		TLSCryptV2Key: apiPKI.GetTLSCryptV2Key(r.Context()),
+	}
 
-		StaticKey: apiPKI.GetStaticKey(r.Context()),
+	// During a static key rotation a second instance accepts the new key, which the new client profiles have. While
+	// switching to tls-crypt-v2 the main instance keeps tls-crypt for the profiles exported before, and the second
+	// one takes the tls-crypt-v2 profiles.
+	var overlapData *ovpn.ConfigData
+	if apiPKI.TLSCryptV2Legacy(r.Context(), time.Now()) {
+		overlap := configData
+		overlapData = &overlap
+		configData.TLSCryptV2Key = nil
+	} else if rotation := apiPKI.GetStaticKeyRotation(r.Context()); rotation != nil && configData.TLSCryptV2Key == nil {
+		overlap := configData
+		overlap.StaticKey = rotation.NextKey
+		overlapData = &overlap
 	}
 
 	var config bytes.Buffer
@@ -62,19 +84,35 @@ func apiServerConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var overlapConfig bytes.Buffer
+	if overlapData != nil {
+		overlapData.Overlap = true
+		if err := ovpn.GetServerConfig(&overlapConfig, *overlapData); err != nil {
+			api.ErrorResponse(w, http.StatusInternalServerError, err, "Error obtaining overlap config")
+			return
+		}
+	}
+
+	keyAlgorithm, _ := pki.GetKeyAlgorithm(pubKey)
 	event := api.J{
-		"event":   "server_cert",
-		"success": true,
-		"request": request,
-		"cert":    cert,
+		"event":        "server_cert",
+		"success":      true,
+		"request":      request,
+		"keyAlgorithm": keyAlgorithm,
+		"cert":         cert,
 	}
 
 	if err := awsservices.PublishEvent(apiSNS, r.Context(), event); err != nil {
 		log.WithError(err).Error("Error publishing event")
 	}
 
-	api.JsonResponse(w, http.StatusOK, api.J{
+	response := api.J{
 		"message": "OK",
 		"config":  config.Bytes(),
-	})
+	}
+	if overlapConfig.Len() > 0 {
+		response["overlapConfig"] = overlapConfig.Bytes()
+	}
+
+	api.JsonResponse(w, http.StatusOK, response)
 }
@@ -0,0 +1,27 @@
+package serverapi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/api"
+)
+
+const (
+	// Servers refresh the CRL every hour with deploy/systemd/ovpn-helper-getcrl.timer. OpenVPN rejects every client
+	// once it expires, so it outlasts an API outage of several days.
+	kCRLValidity = 7 * 24 * time.Hour
+)
+
+func apiServerCRL(w http.ResponseWriter, r *http.Request) {
+	crl, err := apiPKI.CreateCRL(r.Context(), kCRLValidity)
+	if err != nil {
+		api.ErrorResponse(w, http.StatusInternalServerError, err, "Error creating CRL")
+		return
+	}
+
+	api.JsonResponse(w, http.StatusOK, api.J{
+		"message": "OK",
+		"crl":     crl,
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net"
 	"net/http"
+	"time"
 
 	"github.com/empathybroker/aws-vpn/pkg/api"
 	awsservices "github.com/empathybroker/aws-vpn/pkg/aws"
@@ -16,6 +17,9 @@ type verifyRequest struct {
 	Serial  string `json:"serial"`
 	Digest  string `json:"digest"`
 	Client  net.IP `json:"client"`
+
+	// TLSCryptV2 is set when verifying the metadata of a tls-crypt-v2 client key, before the TLS handshake
+	TLSCryptV2 bool `json:"tlsCryptV2,omitempty"`
 }
 
 func apiServerVerify(w http.ResponseWriter, r *http.Request) {
@@ -69,11 +73,68 @@ func apiServerVerify(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if cert.OnHold != nil {
+		event := api.J{
+			"event":   "cert_verify",
+			"success": false,
+			"error":   "cert_on_hold",
+			"request": request,
+		}
+
+		if err := awsservices.PublishEvent(apiSNS, r.Context(), event); err != nil {
+			log.WithError(err).Error("Error publishing event")
+		}
+
+		api.ErrorResponse(w, http.StatusForbidden, err, "Certificate is on hold")
+		return
+	}
+
+	// OpenVPN checks the expiration of certificates but not of tls-crypt-v2 keys, which outlive them
+	if time.Now().After(cert.NotAfter) {
+		event := api.J{
+			"event":   "cert_verify",
+			"success": false,
+			"error":   "cert_expired",
+			"request": request,
+		}
+
+		if err := awsservices.PublishEvent(apiSNS, r.Context(), event); err != nil {
+			log.WithError(err).Error("Error publishing event")
+		}
+
+		api.ErrorResponse(w, http.StatusForbidden, err, "Certificate has expired")
+		return
+	}
+
+	// During a CA rotation the certificate may chain to either CA, any other issuer is an error
+	verification, err := apiPKI.GetVerifier(r.Context()).Verify(cert.Certificate, time.Now())
+	if err != nil {
+		event := api.J{
+			"event":   "cert_verify",
+			"success": false,
+			"error":   "cert_untrusted",
+			"request": request,
+		}
+
+		if err := awsservices.PublishEvent(apiSNS, r.Context(), event); err != nil {
+			log.WithError(err).Error("Error publishing event")
+		}
+
+		api.ErrorResponse(w, http.StatusForbidden, err, "Certificate is not trusted")
+		return
+	}
+
+	for _, warning := range verification.Warnings {
+		log.WithField("serial", cert.Serial).Warn(warning)
+	}
+
 	event := api.J{
-		"event":   "cert_verify",
-		"success": true,
-		"request": request,
-		"cert":    cert,
+		"event":    "cert_verify",
+		"success":  true,
+		"request":  request,
+		"cert":     cert,
+		"caPath":   verification.Path,
+		"warnings": verification.Warnings,
 	}
 
 	if err := awsservices.PublishEvent(apiSNS, r.Context(), event); err != nil {
@@ -7,6 +7,7 @@ import (
 
 	"github.com/awslabs/aws-lambda-go-api-proxy/core"
 	"github.com/empathybroker/aws-vpn/pkg/gsuite"
+	"github.com/empathybroker/aws-vpn/pkg/pki"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
@@ -60,3 +61,26 @@ func ErrorResponse(w http.ResponseWriter, statusCode int, err error, msg string)
 	log.WithError(err).Errorf("Error response with code %d: %s", statusCode, msg)
 	JsonResponse(w, statusCode, e)
 }
+
+// CertificateErrorResponse reports keys refused by the PKI policy and names outside the CA constraints as bad
+// requests with their reason
+func CertificateErrorResponse(w http.ResponseWriter, err error, msg string) {
+	switch cause := errors.Cause(err).(type) {
+	case *pki.KeyPolicyError:
+		log.WithError(err).Errorf("Key rejected by policy")
+		JsonResponse(w, http.StatusBadRequest, J{
+			"message": cause.Message,
+			"reason":  cause.Reason,
+		})
+		return
+	case *pki.NameConstraintError:
+		log.WithError(err).Errorf("Name rejected by constraints")
+		JsonResponse(w, http.StatusBadRequest, J{
+			"message": cause.Error(),
+			"reason":  pki.NameRejectedConstraints,
+		})
+		return
+	}
+
+	ErrorResponse(w, http.StatusInternalServerError, err, msg)
+}
@@ -109,3 +109,21 @@ func (d *GoogleDirectory) GetUserInfo(ctx context.Context, userKey string) (*Use
 		Schemas: schemas,
 	}, nil
 }
+
+// SchemaStrings returns the values of a custom schema field, whether it is single or multi-valued
+func (u *UserInfo) SchemaStrings(schema string, field string) []string {
+	switch v := u.Schemas[schema][field].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
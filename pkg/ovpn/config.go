@@ -0,0 +1,18 @@
+package ovpn
+
+import (
+	"github.com/kelseyhightower/envconfig"
+)
+
+const kConfigOverlapPrefix = "PKI_STATIC_KEY_OVERLAP"
+
+// While the static key is rotated, a second server instance accepts the next key on Port and gives its clients
+// addresses in Subnet
+var configOverlap struct {
+	Port   int    `default:"1195"`
+	Subnet string `default:"10.9.0.0"`
+}
+
+func init() {
+	envconfig.MustProcess(kConfigOverlapPrefix, &configOverlap)
+}
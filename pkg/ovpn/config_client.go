@@ -6,6 +6,9 @@ const kClientConfigTemplate = `# OpenVPN client settings
 
 dev tun
 client
+{{ if .Overlap -}}
+remote {{ env "PKI_DOMAIN" }} {{ overlapPort }}
+{{ end -}}
 remote {{ env "PKI_DOMAIN" }}
 remote-random-hostname
 push-peer-info
@@ -23,9 +26,9 @@ verb 3
 {{ printf "%s" (pemCert .Certificate) -}}
 </cert>
 
-{{ if .CrossCert -}}
+{{ with .ExtraCerts -}}
 <extra-certs>
-{{ printf "%s" (pemCert .CrossCert) -}}
+{{ range . }}{{ printf "%s" (pemCert .) }}{{ end -}}
 </extra-certs>
 {{- end }}
 
@@ -34,11 +37,16 @@ verb 3
 %PRIVATEKEY%</key>
 
 <ca>
-{{ printf "%s" (pemCert .CACert) -}}
-{{- if .PrevCACert -}}{{ printf "%s" (pemCert .PrevCACert) -}}{{- end -}}
+{{ range .TrustedCerts }}{{ printf "%s" (pemCert .) }}{{ end -}}
 </ca>
 
+{{ if .TLSCryptV2ClientKey -}}
+<tls-crypt-v2>
+{{ printf "%s" .TLSCryptV2ClientKey -}}
+</tls-crypt-v2>
+{{- else -}}
 <tls-crypt>
 {{ printf "%s" .StaticKey -}}
 </tls-crypt>
+{{- end }}
 `
@@ -5,7 +5,12 @@ const kServerConfigTemplate = `# OpenVPN server settings
 
 dev tun
 topology subnet
+{{ if .Overlap -}}
+port {{ overlapPort }}
+server {{ overlapSubnet }} 255.255.255.0
+{{- else -}}
 server 10.8.0.0 255.255.255.0
+{{- end }}
 keepalive 10 60
 client-to-client
 push-peer-info
@@ -25,6 +30,9 @@ setenv PKI_API_ENDPOINT https://{{ env "PKI_DOMAIN" }}/api
 tls-verify /usr/local/bin/ovpn-helper
 client-connect /usr/local/bin/ovpn-helper
 client-disconnect /usr/local/bin/ovpn-helper
+{{- if .TLSCryptV2Key }}
+tls-crypt-v2-verify /usr/local/bin/ovpn-helper
+{{- end }}
 
 # Authentication
 tls-server
@@ -34,15 +42,16 @@ remote-cert-tls client
 tls-cert-profile preferred
 tls-version-min 1.3 or-highest
 x509-username-field ext:subjectAltName
+crl-verify %CRLFILE%
 
 # Serial Number {{ .Certificate.SerialNumber.Text 16 }}
 <cert>
 {{ printf "%s" (pemCert .Certificate) -}}
 </cert>
 
-{{ if .CrossCert -}}
+{{ with .ExtraCerts -}}
 <extra-certs>
-{{ printf "%s" (pemCert .CrossCert) -}}
+{{ range . }}{{ printf "%s" (pemCert .) }}{{ end -}}
 </extra-certs>
 {{- end }}
 
@@ -51,13 +60,18 @@ x509-username-field ext:subjectAltName
 %PRIVATEKEY%</key>
 
 <ca>
-{{ printf "%s" (pemCert .CACert) -}}
-{{- if .PrevCACert -}}{{ printf "%s" (pemCert .PrevCACert) -}}{{- end -}}
+{{ range .TrustedCerts }}{{ printf "%s" (pemCert .) }}{{ end -}}
 </ca>
 
+{{ if .TLSCryptV2Key -}}
+<tls-crypt-v2>
+{{ printf "%s" .TLSCryptV2Key -}}
+</tls-crypt-v2>
+{{- else -}}
 <tls-crypt>
 {{ printf "%s" .StaticKey -}}
 </tls-crypt>
+{{- end }}
 
 dh none
 `
@@ -1,6 +1,7 @@
 package ovpn
 
 import (
+	"bytes"
 	"crypto/x509"
 	"io"
 	"os"
@@ -13,6 +14,9 @@ var (
 	funcs = template.FuncMap{
 		"pemCert": pki.EncodePEMCert,
 		"env":     os.Getenv,
+
+		"overlapPort":   func() int { return configOverlap.Port },
+		"overlapSubnet": func() string { return configOverlap.Subnet },
 	}
 	tplClientConfig = template.Must(template.New("client_config").Funcs(funcs).Parse(kClientConfigTemplate))
 	tplServerConfig = template.Must(template.New("server_config").Funcs(funcs).Parse(kServerConfigTemplate))
@@ -25,7 +29,55 @@ type ConfigData struct {
 	PrevCACert *x509.Certificate
 	CrossCert  *x509.Certificate
 
+	// Chain holds the issuers of CACert up to the root when CACert is an intermediate
+	Chain []*x509.Certificate
+
 	StaticKey pki.StaticKey
+
+	// Overlap is set while the StaticKey is rotated or the servers switch to tls-crypt-v2. The server config is then
+	// for the second instance accepting the next key, and the client config connects to that instance first.
+	Overlap bool
+
+	// With a tls-crypt-v2 key the server config replaces the StaticKey with it, and the client config with the
+	// client key wrapped by it
+	TLSCryptV2Key       pki.TLSCryptV2Key
+	TLSCryptV2ClientKey []byte
+}
+
+// ExtraCerts returns the certificates sent to the peer along with ours
+func (d ConfigData) ExtraCerts() []*x509.Certificate {
+	var certs []*x509.Certificate
+	if d.CrossCert != nil {
+		certs = append(certs, d.CrossCert)
+	}
+
+	if len(d.Chain) > 0 {
+		certs = append(certs, d.CACert)
+		certs = append(certs, d.Chain[:len(d.Chain)-1]...)
+	}
+
+	return certs
+}
+
+// TrustedCerts returns the trust anchors for the peer certificate. With an intermediate only the root is
+// trusted, so peers holding certificates from the previous intermediate keep working.
+func (d ConfigData) TrustedCerts() []*x509.Certificate {
+	if len(d.Chain) == 0 {
+		certs := []*x509.Certificate{d.CACert}
+		if d.PrevCACert != nil {
+			certs = append(certs, d.PrevCACert)
+		}
+		return certs
+	}
+
+	certs := []*x509.Certificate{d.Chain[len(d.Chain)-1]}
+
+	// The previous CA was self-signed when migrating from a single tier CA
+	if d.PrevCACert != nil && bytes.Equal(d.PrevCACert.RawIssuer, d.PrevCACert.RawSubject) {
+		certs = append(certs, d.PrevCACert)
+	}
+
+	return certs
 }
 
 func GetClientConfig(w io.Writer, data ConfigData) error {
@@ -9,9 +9,27 @@ import (
 	"github.com/empathybroker/aws-vpn/pkg/pki"
 )
 
+// Resources names the Secrets Manager secret with the CA data and the DynamoDB tables. The issuance log is
+// disabled without LogTableName.
+type Resources struct {
+	SecretName   string
+	TableName    string
+	LogTableName string
+}
+
+// ResourcesFromEnv returns the names in PKI_AWS_SECRET_NAME, PKI_AWS_TABLE_NAME and PKI_AWS_LOG_TABLE_NAME
+func ResourcesFromEnv() Resources {
+	return Resources{
+		SecretName:   configAWSPKI.SecretName,
+		TableName:    configAWSPKI.TableName,
+		LogTableName: configAWSPKI.LogTableName,
+	}
+}
+
 type awsStorage struct {
 	sm  secretsmanageriface.SecretsManagerAPI
 	ddb dynamodbiface.DynamoDBAPI
+	res Resources
 
 	data pki.CAData
 	mut  sync.Mutex
@@ -19,8 +37,13 @@ type awsStorage struct {
 }
 
 func NewAWSStorage(sm secretsmanageriface.SecretsManagerAPI, ddb dynamodbiface.DynamoDBAPI) *awsStorage {
+	return NewAWSStorageWithResources(sm, ddb, ResourcesFromEnv())
+}
+
+func NewAWSStorageWithResources(sm secretsmanageriface.SecretsManagerAPI, ddb dynamodbiface.DynamoDBAPI, res Resources) *awsStorage {
 	return &awsStorage{
 		sm:  sm,
 		ddb: ddb,
+		res: res,
 	}
 }
@@ -10,6 +10,9 @@ var configAWSPKI struct {
 	SecretName   string `split_words:"true" default:"VPN/CAPrivateKey"`
 	TableName    string `split_words:"true" default:"vpn_certificates"`
 	DurationDays int    `split_words:"true" default:"30"`
+
+	// LogTableName enables the issuance log when set
+	LogTableName string `split_words:"true"`
 }
 
 func init() {
@@ -2,21 +2,27 @@ package awspki
 
 import (
 	"context"
-	"crypto/x509"
-	"errors"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	A "github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	E "github.com/aws/aws-sdk-go/service/dynamodb/expression"
 	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	kReasonNone                   = "None"
+	kReasonConditionalCheckFailed = "ConditionalCheckFailed"
+)
+
 func (s *awsStorage) GetCertBySerial(ctx context.Context, serial []byte) (*pki.CertificateInfo, error) {
 	res, err := s.ddb.GetItemWithContext(ctx, &dynamodb.GetItemInput{
-		TableName: aws.String(configAWSPKI.TableName),
+		TableName: aws.String(s.res.TableName),
 		Key: map[string]*dynamodb.AttributeValue{
 			kAttrSerialNumber: {B: serial},
 		},
@@ -46,7 +52,7 @@ func (s *awsStorage) ListAllCerts(ctx context.Context) ([]*pki.CertificateInfo,
 	}
 
 	query := &dynamodb.ScanInput{
-		TableName: aws.String(configAWSPKI.TableName),
+		TableName: aws.String(s.res.TableName),
 
 		ExpressionAttributeNames:  exp.Names(),
 		ExpressionAttributeValues: exp.Values(),
@@ -71,7 +77,7 @@ func (s *awsStorage) ListAllCerts(ctx context.Context) ([]*pki.CertificateInfo,
 			certs = append(certs, info)
 		}
 
-		return b
+		return true
 	}); err != nil {
 		return nil, err
 	}
@@ -89,7 +95,7 @@ func (s *awsStorage) ListCertsBySubject(ctx context.Context, subjectName string)
 	}
 
 	query := dynamodb.QueryInput{
-		TableName: aws.String(configAWSPKI.TableName),
+		TableName: aws.String(s.res.TableName),
 		IndexName: aws.String(kIndexSubjectName),
 
 		ExpressionAttributeNames:  exp.Names(),
@@ -118,7 +124,7 @@ func (s *awsStorage) ListCertsBySubject(ctx context.Context, subjectName string)
 			certs = append(certs, info)
 		}
 
-		return b
+		return true
 	}); err != nil {
 		return nil, err
 	}
@@ -126,34 +132,97 @@ func (s *awsStorage) ListCertsBySubject(ctx context.Context, subjectName string)
 	return certs, nil
 }
 
-func (s *awsStorage) AddCert(ctx context.Context, cert *x509.Certificate) error {
-	if cert.Raw == nil {
-		return errors.New("missing cert raw data")
+func (s *awsStorage) ListRevokedCerts(ctx context.Context) ([]*pki.CertificateInfo, error) {
+	filter := E.GreaterThan(E.Key(kAttrValidUntil), E.Value(time.Now().UTC().Unix()))
+	revoked := E.GreaterThan(E.Key(kAttrRevocationTime), E.Value(0)).
+		And(E.LessThanEqual(E.Key(kAttrRevocationTime), E.Value(time.Now().UTC().Unix())))
+	filter = filter.And(revoked.Or(E.GreaterThan(E.Key(kAttrHoldTime), E.Value(0))))
+
+	exp, err := E.NewBuilder().
+		WithFilter(filter).
+		Build()
+	if err != nil {
+		return nil, err
 	}
 
-	if cert.AuthorityKeyId == nil {
-		return errors.New("missing Authority Key ID")
+	query := &dynamodb.ScanInput{
+		TableName: aws.String(s.res.TableName),
+
+		ExpressionAttributeNames:  exp.Names(),
+		ExpressionAttributeValues: exp.Values(),
+		FilterExpression:          exp.Filter(),
+
+		ConsistentRead: aws.Bool(true),
 	}
 
-	if cert.SubjectKeyId == nil {
-		return errors.New("missing Subject Key ID")
+	certs := make([]*pki.CertificateInfo, 0)
+	if err := s.ddb.ScanPagesWithContext(ctx, query, func(output *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range output.Items {
+			var certEntry dynamoCertEntry
+			if err := A.UnmarshalMap(item, &certEntry); err != nil {
+				log.WithError(err).Error("Error unmarshaling cert from Dynamo")
+				continue
+			}
+
+			info, err := certEntry.toCertificateInfo()
+			if err != nil {
+				log.WithError(err).Error("Error parsing certificate from Dynamo")
+				continue
+			}
+
+			certs = append(certs, info)
+		}
+
+		return true
+	}); err != nil {
+		return nil, err
+	}
+
+	return certs, nil
+}
+
+func (s *awsStorage) AddCert(ctx context.Context, info *pki.CertificateInfo) error {
+	entry, err := newCertEntry(info)
+	if err != nil {
+		return err
+	}
+
+	item, err := A.MarshalMap(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.ddb.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.res.TableName),
+		Item:      item,
+	})
+
+	return err
+}
+
+// ImportCert stores info with its revocation, hold and renewal state
+func (s *awsStorage) ImportCert(ctx context.Context, info *pki.CertificateInfo) error {
+	entry, err := newCertEntry(info)
+	if err != nil {
+		return err
+	}
+
+	entry.RevocationReason = int(info.RevocationReason)
+	entry.RevokedBy = info.RevokedBy
+	entry.RevocationComment = info.RevocationComment
+
+	if rt := info.RevocationTime(); rt != nil {
+		entry.RevocationTime = rt.UTC()
 	}
 
-	cType := pki.GetCertType(cert)
-	if cType == pki.CertTypeUnknown {
-		return errors.New("unknown certificate type")
+	if info.OnHold != nil {
+		entry.HoldTime = info.OnHold.UTC()
 	}
 
-	entry := dynamoCertEntry{
-		SerialNumber:   cert.SerialNumber.Bytes(),
-		AuthorityKeyId: cert.AuthorityKeyId,
-		SubjectKeyId:   cert.SubjectKeyId,
-		SubjectName:    cert.Subject.CommonName,
-		CertType:       string(cType),
-		IssuedAt:       cert.NotBefore.UTC(),
-		ValidUntil:     cert.NotAfter.UTC(),
-		RevocationTime: time.Unix(0, 0).UTC(),
-		Data:           cert.Raw,
+	if info.Successor != "" {
+		if entry.Successor, err = pki.DecodeSerial(info.Successor); err != nil {
+			return err
+		}
 	}
 
 	item, err := A.MarshalMap(entry)
@@ -162,24 +231,182 @@ func (s *awsStorage) AddCert(ctx context.Context, cert *x509.Certificate) error
 	}
 
 	_, err = s.ddb.PutItemWithContext(ctx, &dynamodb.PutItemInput{
-		TableName: aws.String(configAWSPKI.TableName),
+		TableName: aws.String(s.res.TableName),
 		Item:      item,
 	})
 
 	return err
 }
 
-func (s *awsStorage) RevokeCert(ctx context.Context, serial []byte) (*pki.CertificateInfo, error) {
+// AddRenewedCert stores info and, in the same transaction, links its predecessor to it and schedules the
+// predecessor's revocation. It fails with pki.ErrNotRenewable if the predecessor was revoked or renewed meanwhile.
+func (s *awsStorage) AddRenewedCert(ctx context.Context, info *pki.CertificateInfo, revokeAt time.Time) error {
+	entry, err := newCertEntry(info)
+	if err != nil {
+		return err
+	}
+
+	if entry.Predecessor == nil {
+		return errors.New("missing predecessor")
+	}
+
+	item, err := A.MarshalMap(entry)
+	if err != nil {
+		return err
+	}
+
+	cond := E.Equal(E.Name(kAttrRevocationTime), E.Value(0)).
+		And(E.AttributeNotExists(E.Name(kAttrSuccessor))).
+		And(notHeld())
+	update := E.Set(E.Name(kAttrSuccessor), E.Value(entry.SerialNumber)).
+		Set(E.Name(kAttrRevocationTime), E.Value(revokeAt.UTC().Unix())).
+		Set(E.Name(kAttrRevocationReason), E.Value(int(pki.ReasonSuperseded)))
+
+	expr, err := E.NewBuilder().
+		WithCondition(cond).
+		WithUpdate(update).
+		Build()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.ddb.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Put: &dynamodb.Put{
+					TableName: aws.String(s.res.TableName),
+					Item:      item,
+				},
+			},
+			{
+				Update: &dynamodb.Update{
+					TableName: aws.String(s.res.TableName),
+					Key: map[string]*dynamodb.AttributeValue{
+						kAttrSerialNumber: {B: entry.Predecessor},
+					},
+
+					ExpressionAttributeNames:  expr.Names(),
+					ExpressionAttributeValues: expr.Values(),
+					ConditionExpression:       expr.Condition(),
+					UpdateExpression:          expr.Update(),
+				},
+			},
+		},
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeTransactionCanceledException {
+		if conditionsFailed(cancellationReasons(awsErr)) {
+			return pki.ErrNotRenewable
+		}
+		return errors.Wrap(err, "renewing certificate")
+	}
+
+	return err
+}
+
+// cancellationReasons returns the code for each item of a cancelled transaction. This SDK only has them in the
+// message, as in "Transaction cancelled, please refer cancellation reasons for specific reasons [None, ConditionalCheckFailed]"
+func cancellationReasons(err awserr.Error) []string {
+	msg := err.Message()
+	start, end := strings.LastIndex(msg, "["), strings.LastIndex(msg, "]")
+	if start < 0 || end < start {
+		return nil
+	}
+
+	var reasons []string
+	for _, reason := range strings.Split(msg[start+1:end], ",") {
+		reasons = append(reasons, strings.TrimSpace(reason))
+	}
+	return reasons
+}
+
+// conditionsFailed tells whether a transaction was cancelled only because of its conditions, and not e.g. by
+// throttling or a conflicting transaction
+func conditionsFailed(reasons []string) bool {
+	failed := false
+	for _, reason := range reasons {
+		switch reason {
+		case kReasonConditionalCheckFailed:
+			failed = true
+		case kReasonNone:
+		default:
+			return false
+		}
+	}
+	return failed
+}
+
+func (s *awsStorage) RevokeCert(ctx context.Context, serial []byte, revocation pki.Revocation) (*pki.CertificateInfo, error) {
+	now := time.Now().UTC().Unix()
+
+	update := E.Set(E.Name(kAttrRevocationTime), E.Value(now)).
+		Set(E.Name(kAttrRevocationReason), E.Value(int(revocation.Reason)))
+	if revocation.RevokedBy != "" {
+		update = update.Set(E.Name(kAttrRevokedBy), E.Value(revocation.RevokedBy))
+	}
+	if revocation.Comment != "" {
+		update = update.Set(E.Name(kAttrRevocationComment), E.Value(revocation.Comment))
+	}
+
+	// Revoking a certificate scheduled for revocation after renewal brings the revocation forward
+	expr, err := E.NewBuilder().
+		WithCondition(notRevoked(now)).
+		WithUpdate(update).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.updateCert(ctx, serial, expr)
+}
+
+func notRevoked(now int64) E.ConditionBuilder {
+	return E.Equal(E.Name(kAttrRevocationTime), E.Value(0)).
+		Or(E.GreaterThan(E.Name(kAttrRevocationTime), E.Value(now)))
+}
+
+func notHeld() E.ConditionBuilder {
+	return E.AttributeNotExists(E.Name(kAttrHoldTime)).
+		Or(E.Equal(E.Name(kAttrHoldTime), E.Value(0)))
+}
+
+func (s *awsStorage) HoldCert(ctx context.Context, serial []byte) (*pki.CertificateInfo, error) {
+	now := time.Now().UTC().Unix()
+
 	expr, err := E.NewBuilder().
-		WithCondition(E.Equal(E.Name(kAttrRevocationTime), E.Value(0))).
-		WithUpdate(E.Set(E.Name(kAttrRevocationTime), E.Value(time.Now().UTC().Unix()))).
+		WithCondition(notRevoked(now).And(notHeld())).
+		WithUpdate(E.Set(E.Name(kAttrHoldTime), E.Value(now))).
 		Build()
 	if err != nil {
 		return nil, err
 	}
 
+	return s.updateCert(ctx, serial, expr)
+}
+
+func (s *awsStorage) ReinstateCert(ctx context.Context, serial []byte) (*pki.CertificateInfo, error) {
+	now := time.Now().UTC().Unix()
+
+	expr, err := E.NewBuilder().
+		WithCondition(notRevoked(now).And(E.GreaterThan(E.Name(kAttrHoldTime), E.Value(0)))).
+		WithUpdate(E.Set(E.Name(kAttrHoldTime), E.Value(0))).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.updateCert(ctx, serial, expr)
+}
+
+func isConditionFailed(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+// updateCert applies the conditional update. It returns nil if there is no such certificate, and
+// pki.ErrInvalidCertState if the condition didn't hold.
+func (s *awsStorage) updateCert(ctx context.Context, serial []byte, expr E.Expression) (*pki.CertificateInfo, error) {
 	res, err := s.ddb.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
-		TableName: aws.String(configAWSPKI.TableName),
+		TableName: aws.String(s.res.TableName),
 		Key: map[string]*dynamodb.AttributeValue{
 			kAttrSerialNumber: {B: serial},
 		},
@@ -191,7 +418,15 @@ func (s *awsStorage) RevokeCert(ctx context.Context, serial []byte) (*pki.Certif
 
 		ReturnValues: aws.String(dynamodb.ReturnValueAllNew),
 	})
-	if err != nil {
+	if isConditionFailed(err) {
+		// The conditions never hold for missing items, which are not created
+		info, err := s.GetCertBySerial(ctx, serial)
+		if err != nil || info == nil {
+			return nil, err
+		}
+
+		return nil, pki.ErrInvalidCertState
+	} else if err != nil {
 		return nil, err
 	}
 
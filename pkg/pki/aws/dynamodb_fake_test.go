@@ -0,0 +1,743 @@
+package awspki
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+type item = map[string]*dynamodb.AttributeValue
+
+// keySchema is the hash and the optional range key of a table or an index
+type keySchema struct {
+	hash, rng string
+}
+
+type fakeTable struct {
+	key     keySchema
+	indexes map[string]keySchema
+	items   map[string]item
+}
+
+// fakeDynamoDB implements the DynamoDB operations used by awsStorage in memory, evaluating the expressions
+// generated by the expression builder. Results are split in small pages to exercise pagination.
+type fakeDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+
+	mut      sync.Mutex
+	tables   map[string]*fakeTable
+	pageSize int
+
+	// cancelReason cancels the next transaction as DynamoDB does e.g. on a conflict or throttling
+	cancelReason string
+
+	// scanned counts the items read by ScanWithContext
+	scanned int
+}
+
+var kTestResources = Resources{
+	SecretName:   "VPN/CAPrivateKey",
+	TableName:    "vpn_certificates",
+	LogTableName: "vpn_issuance_log",
+}
+
+func newFakeDynamoDB() *fakeDynamoDB {
+	return &fakeDynamoDB{
+		tables: map[string]*fakeTable{
+			kTestResources.TableName: {
+				key: keySchema{hash: kAttrSerialNumber},
+				indexes: map[string]keySchema{
+					kIndexSubjectName:  {hash: kAttrSubjectName, rng: kAttrIssuedAt},
+					kIndexSubjectKeyId: {hash: kAttrSubjectKeyId},
+				},
+				items: make(map[string]item),
+			},
+			kTestResources.LogTableName: {
+				key:   keySchema{hash: kAttrChain, rng: kAttrIndex},
+				items: make(map[string]item),
+			},
+		},
+		pageSize: 2,
+	}
+}
+
+func attrKey(av *dynamodb.AttributeValue) string {
+	switch {
+	case av == nil:
+		return ""
+	case av.B != nil:
+		return "B" + string(av.B)
+	case av.S != nil:
+		return "S" + *av.S
+	case av.N != nil:
+		return "N" + *av.N
+	}
+	return ""
+}
+
+func (t *fakeTable) itemKey(it item) string {
+	return attrKey(it[t.key.hash]) + "\x00" + attrKey(it[t.key.rng])
+}
+
+func (f *fakeDynamoDB) table(name *string) (*fakeTable, error) {
+	t, ok := f.tables[aws.StringValue(name)]
+	if !ok {
+		return nil, awserr.New(dynamodb.ErrCodeResourceNotFoundException, "table not found", nil)
+	}
+	return t, nil
+}
+
+func copyItem(it item) item {
+	if it == nil {
+		return nil
+	}
+
+	res := make(item, len(it))
+	for k, v := range it {
+		res[k] = v
+	}
+	return res
+}
+
+// compareAttrs compares two values of the same type, ok is false for missing values or different types
+func compareAttrs(a, b *dynamodb.AttributeValue) (cmp int, ok bool) {
+	switch {
+	case a == nil || b == nil:
+		return 0, false
+	case a.N != nil && b.N != nil:
+		x, okX := new(big.Rat).SetString(*a.N)
+		y, okY := new(big.Rat).SetString(*b.N)
+		return x.Cmp(y), okX && okY
+	case a.S != nil && b.S != nil:
+		return strings.Compare(*a.S, *b.S), true
+	case a.B != nil && b.B != nil:
+		return bytes.Compare(a.B, b.B), true
+	}
+	return 0, false
+}
+
+type condition func(it item) bool
+
+type operand func(it item) *dynamodb.AttributeValue
+
+// exprParser parses conditions like ((#0 = :0) OR (#0 > :1)) AND (attribute_not_exists (#1)), with the
+// comparisons, the logical operators and the attribute_exists, attribute_not_exists and begins_with functions
+type exprParser struct {
+	tokens []string
+	pos    int
+	names  map[string]*string
+	values map[string]*dynamodb.AttributeValue
+}
+
+func tokenize(expr string) []string {
+	for _, sep := range []string{"(", ")", ","} {
+		expr = strings.Replace(expr, sep, " "+sep+" ", -1)
+	}
+	return strings.Fields(expr)
+}
+
+func parseCondition(expr *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) (cond condition, err error) {
+	if expr == nil {
+		return func(it item) bool { return true }, nil
+	}
+
+	p := &exprParser{tokens: tokenize(*expr), names: names, values: values}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid expression %q: %v", *expr, r)
+		}
+	}()
+
+	cond = p.or()
+	if p.pos != len(p.tokens) {
+		panic("trailing tokens")
+	}
+	return cond, nil
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) next() string {
+	tok := p.peek()
+	if tok == "" {
+		panic("unexpected end")
+	}
+	p.pos++
+	return tok
+}
+
+func (p *exprParser) expect(tok string) {
+	if got := p.next(); got != tok {
+		panic(fmt.Sprintf("expected %s, got %s", tok, got))
+	}
+}
+
+func (p *exprParser) or() condition {
+	left := p.and()
+	for strings.EqualFold(p.peek(), "OR") {
+		p.pos++
+		l, r := left, p.and()
+		left = func(it item) bool { return l(it) || r(it) }
+	}
+	return left
+}
+
+func (p *exprParser) and() condition {
+	left := p.not()
+	for strings.EqualFold(p.peek(), "AND") {
+		p.pos++
+		l, r := left, p.not()
+		left = func(it item) bool { return l(it) && r(it) }
+	}
+	return left
+}
+
+func (p *exprParser) not() condition {
+	if strings.EqualFold(p.peek(), "NOT") {
+		p.pos++
+		c := p.not()
+		return func(it item) bool { return !c(it) }
+	}
+	return p.primary()
+}
+
+func (p *exprParser) name() string {
+	tok := p.next()
+	name, ok := p.names[tok]
+	if !ok {
+		panic("unknown name " + tok)
+	}
+	return *name
+}
+
+func (p *exprParser) operand() operand {
+	tok := p.peek()
+	if strings.HasPrefix(tok, ":") {
+		p.pos++
+		value, ok := p.values[tok]
+		if !ok {
+			panic("unknown value " + tok)
+		}
+		return func(it item) *dynamodb.AttributeValue { return value }
+	}
+
+	name := p.name()
+	return func(it item) *dynamodb.AttributeValue { return it[name] }
+}
+
+func (p *exprParser) primary() condition {
+	switch tok := p.peek(); tok {
+	case "(":
+		p.pos++
+		c := p.or()
+		p.expect(")")
+		return c
+	case "attribute_exists", "attribute_not_exists":
+		p.pos++
+		p.expect("(")
+		name := p.name()
+		p.expect(")")
+		exists := tok == "attribute_exists"
+		return func(it item) bool { _, ok := it[name]; return ok == exists }
+	case "begins_with":
+		p.pos++
+		p.expect("(")
+		path := p.operand()
+		p.expect(",")
+		prefix := p.operand()
+		p.expect(")")
+		return func(it item) bool {
+			a, b := path(it), prefix(it)
+			switch {
+			case a == nil || b == nil:
+				return false
+			case a.S != nil && b.S != nil:
+				return strings.HasPrefix(*a.S, *b.S)
+			case a.B != nil && b.B != nil:
+				return bytes.HasPrefix(a.B, b.B)
+			}
+			return false
+		}
+	}
+
+	left := p.operand()
+	op := p.next()
+	right := p.operand()
+
+	return func(it item) bool {
+		cmp, ok := compareAttrs(left(it), right(it))
+		if !ok {
+			return op == "<>"
+		}
+
+		switch op {
+		case "=":
+			return cmp == 0
+		case "<>":
+			return cmp != 0
+		case "<":
+			return cmp < 0
+		case "<=":
+			return cmp <= 0
+		case ">":
+			return cmp > 0
+		case ">=":
+			return cmp >= 0
+		}
+		panic("unknown operator " + op)
+	}
+}
+
+// applyUpdate applies update expressions like SET #0 = :0, #1 = :1
+func applyUpdate(it item, expr *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) error {
+	if expr == nil {
+		return nil
+	}
+
+	update := strings.TrimSpace(*expr)
+	if !strings.HasPrefix(update, "SET ") {
+		return fmt.Errorf("unsupported update %q", update)
+	}
+
+	for _, assignment := range strings.Split(strings.TrimPrefix(update, "SET "), ",") {
+		parts := strings.Split(assignment, "=")
+		if len(parts) != 2 {
+			return fmt.Errorf("unsupported update %q", update)
+		}
+
+		name, ok := names[strings.TrimSpace(parts[0])]
+		value, okValue := values[strings.TrimSpace(parts[1])]
+		if !ok || !okValue {
+			return fmt.Errorf("unknown name or value in %q", update)
+		}
+
+		it[*name] = value
+	}
+
+	return nil
+}
+
+func conditionFailed() error {
+	return awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+}
+
+// conditionsCancelled is the error of a transaction of n items whose item failed its condition
+func conditionsCancelled(n int, failed int) error {
+	reasons := make([]string, n)
+	for i := range reasons {
+		reasons[i] = kReasonNone
+	}
+	reasons[failed] = kReasonConditionalCheckFailed
+	return transactionCancelled(reasons)
+}
+
+// transactionCancelled formats the reasons like DynamoDB does in the message
+func transactionCancelled(reasons []string) error {
+	msg := fmt.Sprintf("Transaction cancelled, please refer cancellation reasons for specific reasons [%s]", strings.Join(reasons, ", "))
+	return awserr.New(dynamodb.ErrCodeTransactionCanceledException, msg, nil)
+}
+
+func (f *fakeDynamoDB) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	t, err := f.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.GetItemOutput{Item: copyItem(t.items[t.itemKey(input.Key)])}, nil
+}
+
+// put checks the condition against the current item and stores it, the caller holds the lock
+func (f *fakeDynamoDB) put(t *fakeTable, it item, cond condition) error {
+	key := t.itemKey(it)
+	current, ok := t.items[key]
+	if !ok {
+		current = item{}
+	}
+
+	if !cond(current) {
+		return conditionFailed()
+	}
+
+	t.items[key] = copyItem(it)
+	return nil
+}
+
+func (f *fakeDynamoDB) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	t, err := f.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	cond, err := parseCondition(input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.PutItemOutput{}, f.put(t, input.Item, cond)
+}
+
+// update returns the updated item without storing it, the caller holds the lock
+func (f *fakeDynamoDB) update(t *fakeTable, key item, condExpr *string, updateExpr *string,
+	names map[string]*string, values map[string]*dynamodb.AttributeValue) (item, error) {
+	cond, err := parseCondition(condExpr, names, values)
+	if err != nil {
+		return nil, err
+	}
+
+	current, ok := t.items[t.itemKey(key)]
+	if !ok {
+		current = item{}
+	}
+
+	if !cond(current) {
+		return nil, conditionFailed()
+	}
+
+	updated := copyItem(current)
+	for k, v := range key {
+		updated[k] = v
+	}
+
+	return updated, applyUpdate(updated, updateExpr, names, values)
+}
+
+func (f *fakeDynamoDB) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	t, err := f.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := f.update(t, input.Key, input.ConditionExpression, input.UpdateExpression,
+		input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+
+	t.items[t.itemKey(updated)] = updated
+
+	output := &dynamodb.UpdateItemOutput{}
+	if aws.StringValue(input.ReturnValues) == dynamodb.ReturnValueAllNew {
+		output.Attributes = copyItem(updated)
+	}
+	return output, nil
+}
+
+// TransactWriteItemsWithContext checks all the conditions before writing anything
+func (f *fakeDynamoDB) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	type write struct {
+		table *fakeTable
+		item  item
+	}
+
+	if f.cancelReason != "" {
+		reasons := make([]string, len(input.TransactItems))
+		for i := range reasons {
+			reasons[i] = kReasonNone
+		}
+		reasons[0], f.cancelReason = f.cancelReason, ""
+		return nil, transactionCancelled(reasons)
+	}
+
+	var writes []write
+	for i, ti := range input.TransactItems {
+		switch {
+		case ti.Put != nil:
+			t, err := f.table(ti.Put.TableName)
+			if err != nil {
+				return nil, err
+			}
+
+			cond, err := parseCondition(ti.Put.ConditionExpression, ti.Put.ExpressionAttributeNames, ti.Put.ExpressionAttributeValues)
+			if err != nil {
+				return nil, err
+			}
+
+			current, ok := t.items[t.itemKey(ti.Put.Item)]
+			if !ok {
+				current = item{}
+			}
+			if !cond(current) {
+				return nil, conditionsCancelled(len(input.TransactItems), i)
+			}
+
+			writes = append(writes, write{t, copyItem(ti.Put.Item)})
+		case ti.Update != nil:
+			t, err := f.table(ti.Update.TableName)
+			if err != nil {
+				return nil, err
+			}
+
+			updated, err := f.update(t, ti.Update.Key, ti.Update.ConditionExpression, ti.Update.UpdateExpression,
+				ti.Update.ExpressionAttributeNames, ti.Update.ExpressionAttributeValues)
+			if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+				return nil, conditionsCancelled(len(input.TransactItems), i)
+			} else if err != nil {
+				return nil, err
+			}
+
+			writes = append(writes, write{t, updated})
+		default:
+			return nil, fmt.Errorf("unsupported transaction item %v", ti)
+		}
+	}
+
+	for _, w := range writes {
+		w.table.items[w.table.itemKey(w.item)] = w.item
+	}
+
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+// pages splits items and filters each page, like the filter expressions apply after reading
+func (f *fakeDynamoDB) pages(items []item, filter condition) [][]item {
+	var pages [][]item
+	for len(items) > 0 || len(pages) == 0 {
+		n := f.pageSize
+		if n > len(items) {
+			n = len(items)
+		}
+
+		page := make([]item, 0, n)
+		for _, it := range items[:n] {
+			if filter(it) {
+				page = append(page, copyItem(it))
+			}
+		}
+
+		pages = append(pages, page)
+		items = items[n:]
+	}
+	return pages
+}
+
+// sortedItems returns the items of t in a stable order, the one of the scans
+func (t *fakeTable) sortedItems() []item {
+	keys := make([]string, 0, len(t.items))
+	for k := range t.items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	items := make([]item, 0, len(keys))
+	for _, k := range keys {
+		items = append(items, t.items[k])
+	}
+	return items
+}
+
+// keyOf returns the key attributes of it, along the ones of the index schema if any
+func (t *fakeTable) keyOf(it item, index *keySchema) item {
+	key := item{}
+	for _, schema := range []*keySchema{&t.key, index} {
+		if schema == nil {
+			continue
+		}
+		for _, attr := range []string{schema.hash, schema.rng} {
+			if av, ok := it[attr]; ok && attr != "" {
+				key[attr] = av
+			}
+		}
+	}
+	return key
+}
+
+// startAfter drops the items up to the one with the table key of start
+func (t *fakeTable) startAfter(items []item, start item) []item {
+	if start == nil {
+		return items
+	}
+
+	for i, it := range items {
+		if t.itemKey(it) == t.itemKey(start) {
+			return items[i+1:]
+		}
+	}
+	return items
+}
+
+func (f *fakeDynamoDB) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	t, err := f.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := parseCondition(input.FilterExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+
+	// The page size stands for the 1 MB DynamoDB reads at most per request
+	items := t.startAfter(t.sortedItems(), input.ExclusiveStartKey)
+	n := f.pageSize
+	if input.Limit != nil && int(*input.Limit) < n {
+		n = int(*input.Limit)
+	}
+
+	output := &dynamodb.ScanOutput{Items: []item{}}
+	if n < len(items) {
+		items = items[:n]
+		output.LastEvaluatedKey = t.keyOf(items[n-1], nil)
+	}
+	f.scanned += len(items)
+	output.ScannedCount = aws.Int64(int64(len(items)))
+
+	for _, it := range items {
+		if filter(it) {
+			output.Items = append(output.Items, copyItem(it))
+		}
+	}
+
+	return output, nil
+}
+
+func (f *fakeDynamoDB) ScanPagesWithContext(ctx aws.Context, input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool, opts ...request.Option) error {
+	f.mut.Lock()
+
+	t, err := f.table(input.TableName)
+	if err != nil {
+		f.mut.Unlock()
+		return err
+	}
+
+	filter, err := parseCondition(input.FilterExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		f.mut.Unlock()
+		return err
+	}
+
+	pages := f.pages(t.sortedItems(), filter)
+	f.mut.Unlock()
+
+	for i, page := range pages {
+		if !fn(&dynamodb.ScanOutput{Items: page}, i == len(pages)-1) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// query returns the items matching the key condition, sorted by the range key of the table or index, with the key
+// of the last one when the limit leaves items out
+func (f *fakeDynamoDB) query(input *dynamodb.QueryInput) ([]item, condition, item, error) {
+	t, err := f.table(input.TableName)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	schema := t.key
+	var index *keySchema
+	if input.IndexName != nil {
+		var ok bool
+		if schema, ok = t.indexes[*input.IndexName]; !ok {
+			return nil, nil, nil, awserr.New(dynamodb.ErrCodeResourceNotFoundException, "index not found", nil)
+		}
+		index = &schema
+	}
+
+	keyCond, err := parseCondition(input.KeyConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	filter, err := parseCondition(input.FilterExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var items []item
+	for _, it := range t.items {
+		if _, ok := it[schema.hash]; ok && keyCond(it) {
+			items = append(items, it)
+		}
+	}
+
+	forward := input.ScanIndexForward == nil || *input.ScanIndexForward
+	sort.Slice(items, func(i, j int) bool {
+		cmp, _ := compareAttrs(items[i][schema.rng], items[j][schema.rng])
+		if cmp == 0 {
+			return t.itemKey(items[i]) < t.itemKey(items[j])
+		}
+		return (cmp < 0) == forward
+	})
+
+	items = t.startAfter(items, input.ExclusiveStartKey)
+
+	var last item
+	if input.Limit != nil && int(*input.Limit) < len(items) {
+		items = items[:*input.Limit]
+		if len(items) > 0 {
+			last = t.keyOf(items[len(items)-1], index)
+		}
+	}
+
+	return items, filter, last, nil
+}
+
+func (f *fakeDynamoDB) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	items, filter, last, err := f.query(input)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &dynamodb.QueryOutput{Items: []item{}, LastEvaluatedKey: last, ScannedCount: aws.Int64(int64(len(items)))}
+	for _, it := range items {
+		if filter(it) {
+			output.Items = append(output.Items, copyItem(it))
+		}
+	}
+
+	return output, nil
+}
+
+func (f *fakeDynamoDB) QueryPagesWithContext(ctx aws.Context, input *dynamodb.QueryInput, fn func(*dynamodb.QueryOutput, bool) bool, opts ...request.Option) error {
+	f.mut.Lock()
+	items, filter, _, err := f.query(input)
+	if err != nil {
+		f.mut.Unlock()
+		return err
+	}
+
+	pages := f.pages(items, filter)
+	f.mut.Unlock()
+
+	for i, page := range pages {
+		if !fn(&dynamodb.QueryOutput{Items: page}, i == len(pages)-1) {
+			break
+		}
+	}
+
+	return nil
+}
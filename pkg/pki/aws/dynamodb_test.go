@@ -0,0 +1,186 @@
+package awspki
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/empathybroker/aws-vpn/pkg/pki/pkitest"
+	"github.com/pkg/errors"
+)
+
+func TestAWSStorage(t *testing.T) {
+	pkitest.RunStorageTests(t, func(t *testing.T) pki.PKIStorage {
+		return NewAWSStorageWithResources(nil, newFakeDynamoDB(), kTestResources)
+	})
+
+	pkitest.RunLogStorageTests(t, func(t *testing.T) pki.LogStorage {
+		return NewAWSStorageWithResources(nil, newFakeDynamoDB(), kTestResources)
+	})
+}
+
+func issueTestCert(t *testing.T, caCert *x509.Certificate, caKey crypto.PrivateKey, subject string) *pki.CertificateInfo {
+	t.Helper()
+
+	privKey, err := pki.NewPrivateKey(pki.KeyAlgorithmP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := pki.CreateCertificate(caCert, caKey, pki.GetPublicKey(privKey), pkix.Name{CommonName: subject},
+		pki.ClientCert, pki.WithDuration(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pki.CertInfoFromX509Cert(cert)
+}
+
+func TestAddRenewedCertCancelled(t *testing.T) {
+	ctx := context.Background()
+	ddb := newFakeDynamoDB()
+	storage := NewAWSStorageWithResources(nil, ddb, kTestResources)
+
+	caKey, err := pki.NewPrivateKey(pki.KeyAlgorithmP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := pki.CreateCertificate(nil, caKey, pki.GetPublicKey(caKey), pkix.Name{CommonName: "Test CA"},
+		pki.CACert, pki.WithDuration(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := issueTestCert(t, caCert, caKey, "user@example.com")
+	if err := storage.AddCert(ctx, prev); err != nil {
+		t.Fatal(err)
+	}
+
+	// A conflicting transaction must not look like the predecessor was already renewed
+	ddb.cancelReason = "TransactionConflict"
+	renewed := issueTestCert(t, caCert, caKey, "user@example.com")
+	renewed.Predecessor = prev.Serial
+	err = storage.AddRenewedCert(ctx, renewed, time.Now().Add(time.Hour))
+	if err == nil || errors.Cause(err) == pki.ErrNotRenewable {
+		t.Fatalf("expected the cancellation error, got %v", err)
+	}
+
+	// It can be retried
+	if err := storage.AddRenewedCert(ctx, renewed, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	again := issueTestCert(t, caCert, caKey, "user@example.com")
+	again.Predecessor = prev.Serial
+	if err := storage.AddRenewedCert(ctx, again, time.Now().Add(time.Hour)); err != pki.ErrNotRenewable {
+		t.Fatalf("expected ErrNotRenewable renewing twice, got %v", err)
+	}
+}
+
+func TestListCertsPagesTable(t *testing.T) {
+	ctx := context.Background()
+	ddb := newFakeDynamoDB()
+	storage := NewAWSStorageWithResources(nil, ddb, kTestResources)
+
+	caKey, err := pki.NewPrivateKey(pki.KeyAlgorithmP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := pki.CreateCertificate(nil, caKey, pki.GetPublicKey(caKey), pkix.Name{CommonName: "Test CA"},
+		pki.CACert, pki.WithDuration(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 6
+	for i := 0; i < n; i++ {
+		if err := storage.AddCert(ctx, issueTestCert(t, caCert, caKey, "user@example.com")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each page continues the scan where the previous one stopped instead of reading the table again
+	seen := make(map[string]bool)
+	query := pki.CertQuery{Limit: 2}
+	for {
+		page, err := storage.ListCerts(ctx, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, info := range page.Certs {
+			seen[info.Serial] = true
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+
+	if len(seen) != n || ddb.scanned != n {
+		t.Errorf("listed %d certificates reading %d items", len(seen), ddb.scanned)
+	}
+}
+
+func TestListCertsScanLimit(t *testing.T) {
+	ctx := context.Background()
+	ddb := newFakeDynamoDB()
+	storage := NewAWSStorageWithResources(nil, ddb, kTestResources)
+
+	prev := listScanLimit
+	listScanLimit = 3
+	defer func() { listScanLimit = prev }()
+
+	caKey, err := pki.NewPrivateKey(pki.KeyAlgorithmP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := pki.CreateCertificate(nil, caKey, pki.GetPublicKey(caKey), pkix.Name{CommonName: "Test CA"},
+		pki.CACert, pki.WithDuration(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 8
+	for i := 0; i < n; i++ {
+		email := "user@example.com"
+		if i == n/2 {
+			email = "other@example.com"
+		}
+
+		if err := storage.AddCert(ctx, issueTestCert(t, caCert, caKey, email)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A selective filter returns short or empty pages instead of scanning the whole table in one request
+	var found, pages int
+	query := pki.CertQuery{SubjectPrefix: "other@"}
+	for {
+		scanned := ddb.scanned
+		page, err := storage.ListCerts(ctx, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+
+		if ddb.scanned-scanned > int(listScanLimit) {
+			t.Fatalf("page %d read %d items", pages, ddb.scanned-scanned)
+		}
+		found += len(page.Certs)
+
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+
+	if found != 1 || pages != 3 {
+		t.Errorf("found %d certificates in %d pages", found, pages)
+	}
+}
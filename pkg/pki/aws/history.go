@@ -0,0 +1,121 @@
+package awspki
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const kStagePending = "AWSPENDING"
+
+// ListCAVersions returns the versions of the secret which Secrets Manager still keeps, newest first
+func (s *awsStorage) ListCAVersions(ctx context.Context) ([]*pki.CAVersion, error) {
+	var entries []*secretsmanager.SecretVersionsListEntry
+	err := s.sm.ListSecretVersionIdsPagesWithContext(ctx, &secretsmanager.ListSecretVersionIdsInput{
+		SecretId:          aws.String(s.res.SecretName),
+		IncludeDeprecated: aws.Bool(true),
+	}, func(page *secretsmanager.ListSecretVersionIdsOutput, _ bool) bool {
+		entries = append(entries, page.Versions...)
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "listing CA secret versions")
+	}
+
+	versions := make([]*pki.CAVersion, 0, len(entries))
+	for _, entry := range entries {
+		v := &pki.CAVersion{
+			Id:      aws.StringValue(entry.VersionId),
+			Created: aws.TimeValue(entry.CreatedDate),
+			Stages:  aws.StringValueSlice(entry.VersionStages),
+		}
+		for _, stage := range v.Stages {
+			if stage == kStageCurrent {
+				v.Current = true
+			}
+		}
+
+		res, err := s.sm.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId:  aws.String(s.res.SecretName),
+			VersionId: entry.VersionId,
+		})
+		if err != nil {
+			v.Err = errors.Wrap(err, "fetching CA data")
+		} else {
+			var data pki.CAData
+			if err := data.UnmarshalJSON(res.SecretBinary); err != nil {
+				v.Err = errors.Wrap(err, "unmarshalling CA data")
+			} else {
+				v.Data = &data
+			}
+		}
+
+		versions = append(versions, v)
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].Created.After(versions[j].Created)
+	})
+
+	return versions, nil
+}
+
+// RollbackCA moves the AWSCURRENT stage to the version. It is refused during a rotation, which would move the
+// stage again when it finishes.
+func (s *awsStorage) RollbackCA(ctx context.Context, id string) error {
+	versions, err := s.ListCAVersions(ctx)
+	if err != nil {
+		return err
+	}
+
+	var target, current *pki.CAVersion
+	for _, v := range versions {
+		if v.Id == id {
+			target = v
+		}
+		if v.Current {
+			current = v
+		}
+		for _, stage := range v.Stages {
+			if stage == kStagePending && !v.Current {
+				return errors.Errorf("version %s is pending, finish or cancel the rotation first", v.Id)
+			}
+		}
+	}
+
+	switch {
+	case target == nil:
+		return pki.ErrCAVersionNotFound
+	case target.Data == nil:
+		return errors.Wrapf(target.Err, "version %s", id)
+	case target.Current:
+		return errors.Errorf("version %s is already current", id)
+	}
+
+	input := &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:        aws.String(s.res.SecretName),
+		VersionStage:    aws.String(kStageCurrent),
+		MoveToVersionId: aws.String(id),
+	}
+	if current != nil {
+		input.RemoveFromVersionId = aws.String(current.Id)
+	}
+
+	if _, err := s.sm.UpdateSecretVersionStageWithContext(ctx, input); err != nil {
+		return errors.Wrap(err, "moving current stage")
+	}
+
+	log.WithField("version", id).Warn("Rolled back CA data")
+
+	s.mut.Lock()
+	s.exp = time.Time{}
+	s.mut.Unlock()
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package awspki
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/pkg/errors"
+)
+
+const (
+	KMSScheme = "awskms"
+
+	kKMSSignTimeout = 10 * time.Second
+)
+
+var kmsKeySpecs = map[pki.KeyAlgorithm]types.KeySpec{
+	pki.KeyAlgorithmRSA2048: types.KeySpecRsa2048,
+	pki.KeyAlgorithmRSA3072: types.KeySpecRsa3072,
+	pki.KeyAlgorithmRSA4096: types.KeySpecRsa4096,
+	pki.KeyAlgorithmP256:    types.KeySpecEccNistP256,
+	pki.KeyAlgorithmP384:    types.KeySpecEccNistP384,
+}
+
+// KMSAPI is the part of the KMS client used by the signer backend and the sealer
+type KMSAPI interface {
+	CreateKey(ctx context.Context, params *kms.CreateKeyInput, optFns ...func(*kms.Options)) (*kms.CreateKeyOutput, error)
+	GetPublicKey(ctx context.Context, params *kms.GetPublicKeyInput, optFns ...func(*kms.Options)) (*kms.GetPublicKeyOutput, error)
+	Sign(ctx context.Context, params *kms.SignInput, optFns ...func(*kms.Options)) (*kms.SignOutput, error)
+	GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error)
+	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+type kmsBackend struct {
+	svc KMSAPI
+}
+
+// NewKMSBackend keeps CA keys in AWS KMS as asymmetric SIGN_VERIFY keys, referenced as awskms:<key ARN>
+func NewKMSBackend(svc KMSAPI) pki.SignerBackend {
+	return &kmsBackend{svc: svc}
+}
+
+func (b *kmsBackend) Scheme() string {
+	return KMSScheme
+}
+
+func (b *kmsBackend) GenerateKey(ctx context.Context, alg pki.KeyAlgorithm, label string) (string, error) {
+	spec, ok := kmsKeySpecs[alg]
+	if !ok {
+		return "", errors.Errorf("key algorithm %s not supported by KMS", alg)
+	}
+
+	res, err := b.svc.CreateKey(ctx, &kms.CreateKeyInput{
+		KeySpec:     spec,
+		Description: aws.String(label),
+		KeyUsage:    types.KeyUsageTypeSignVerify,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "creating KMS key")
+	}
+
+	return KMSScheme + ":" + aws.ToString(res.KeyMetadata.Arn), nil
+}
+
+func (b *kmsBackend) Signer(ctx context.Context, uri string, pub crypto.PublicKey) (crypto.Signer, error) {
+	keyId := strings.TrimPrefix(uri, KMSScheme+":")
+	if keyId == uri || keyId == "" {
+		return nil, errors.Errorf("invalid KMS key URI %q", uri)
+	}
+
+	if pub == nil {
+		res, err := b.svc.GetPublicKey(ctx, &kms.GetPublicKeyInput{KeyId: aws.String(keyId)})
+		if err != nil {
+			return nil, errors.Wrap(err, "obtaining KMS public key")
+		}
+
+		if pub, err = x509.ParsePKIXPublicKey(res.PublicKey); err != nil {
+			return nil, errors.Wrap(err, "parsing KMS public key")
+		}
+	}
+
+	return &kmsSigner{svc: b.svc, keyId: keyId, pub: pub}, nil
+}
+
+type kmsSigner struct {
+	svc   KMSAPI
+	keyId string
+	pub   crypto.PublicKey
+}
+
+func (s *kmsSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+func (s *kmsSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	alg, err := kmsSigningAlgorithm(s.pub, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), kKMSSignTimeout)
+	defer cancel()
+
+	res, err := s.svc.Sign(ctx, &kms.SignInput{
+		KeyId:            aws.String(s.keyId),
+		Message:          digest,
+		MessageType:      types.MessageTypeDigest,
+		SigningAlgorithm: alg,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "signing with KMS")
+	}
+
+	return res.Signature, nil
+}
+
+func kmsSigningAlgorithm(pub crypto.PublicKey, opts crypto.SignerOpts) (types.SigningAlgorithmSpec, error) {
+	var hashName string
+	switch opts.HashFunc() {
+	case crypto.SHA256:
+		hashName = "SHA_256"
+	case crypto.SHA384:
+		hashName = "SHA_384"
+	case crypto.SHA512:
+		hashName = "SHA_512"
+	default:
+		return "", errors.Errorf("hash %v not supported by KMS", opts.HashFunc())
+	}
+
+	switch pub.(type) {
+	case *ecdsa.PublicKey:
+		return types.SigningAlgorithmSpec("ECDSA_" + hashName), nil
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return types.SigningAlgorithmSpec("RSASSA_PSS_" + hashName), nil
+		}
+		return types.SigningAlgorithmSpec("RSASSA_PKCS1_V1_5_" + hashName), nil
+	default:
+		return "", errors.Errorf("unsupported KMS key type %T", pub)
+	}
+}
@@ -0,0 +1,154 @@
+package awspki
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+)
+
+// fakeKMS is a KMS stand-in speaking the JSON protocol for the asymmetric key operations
+type fakeKMS struct {
+	mut  sync.Mutex
+	keys map[string]crypto.Signer
+}
+
+func (f *fakeKMS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	var input struct {
+		KeyId            string
+		KeySpec          string
+		Message          []byte
+		MessageType      string
+		SigningAlgorithm string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var output interface{}
+	switch strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "TrentService.") {
+	case "CreateKey":
+		var key crypto.Signer
+		var err error
+		switch input.KeySpec {
+		case "ECC_NIST_P256":
+			key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		case "RSA_2048":
+			key, err = rsa.GenerateKey(rand.Reader, 2048)
+		default:
+			err = fmt.Errorf("unexpected spec %s", input.KeySpec)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		arn := fmt.Sprintf("arn:aws:kms:eu-west-1:123456789012:key/%d", len(f.keys))
+		f.keys[arn] = key
+		output = map[string]interface{}{"KeyMetadata": map[string]string{"Arn": arn, "KeyId": arn}}
+	case "GetPublicKey":
+		der, _ := x509.MarshalPKIXPublicKey(f.keys[input.KeyId].Public())
+		output = map[string]interface{}{"KeyId": input.KeyId, "PublicKey": der}
+	case "Sign":
+		var opts crypto.SignerOpts = crypto.SHA256
+		if strings.HasPrefix(input.SigningAlgorithm, "RSASSA_PSS") {
+			opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+		}
+
+		sig, err := f.keys[input.KeyId].Sign(rand.Reader, input.Message, opts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		output = map[string]interface{}{"KeyId": input.KeyId, "Signature": sig, "SigningAlgorithm": input.SigningAlgorithm}
+	default:
+		http.Error(w, "unknown operation", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	_ = json.NewEncoder(w).Encode(output)
+}
+
+func TestKMSBackend(t *testing.T) {
+	server := httptest.NewServer(&fakeKMS{keys: make(map[string]crypto.Signer)})
+	defer server.Close()
+
+	backend := NewKMSBackend(kms.New(kms.Options{
+		Region:       "eu-west-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("id", "secret", ""),
+	}))
+	pki.RegisterSignerBackend(backend)
+
+	for _, alg := range []pki.KeyAlgorithm{pki.KeyAlgorithmP256, pki.KeyAlgorithmRSA2048} {
+		uri, err := backend.GenerateKey(context.Background(), alg, "test CA")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signer, err := backend.Signer(context.Background(), uri, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		caCert, err := pki.CreateCertificate(nil, signer, signer.Public(), pkix.Name{CommonName: "test CA"}, pki.CACert, pki.WithDuration(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := caCert.CheckSignatureFrom(caCert); err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+
+		// The CA data only references the key, which is opened through the registered backend
+		data, err := pki.CAData{KeyURI: uri, CACert: caCert, PublicKey: signer.Public()}.MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ca pki.CAData
+		if err := ca.UnmarshalJSON(data); err != nil {
+			t.Fatal(err)
+		}
+
+		if ca.PrivateKey != nil || ca.KeyURI != uri {
+			t.Fatalf("unexpected key in CA data")
+		}
+
+		crl, err := ca.CreateCRL(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parsed, err := x509.ParseRevocationList(crl)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := parsed.CheckSignatureFrom(caCert); err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package awspki
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const (
+	kKMSDataKeyHeader = "KMS-Data-Key"
+
+	kNonceSize = 24
+)
+
+type kmsSealer struct {
+	svc   KMSAPI
+	keyId string
+}
+
+// NewKMSSealer seals data with a fresh data key, which is stored next to it wrapped by the KMS key keyId
+func NewKMSSealer(svc KMSAPI, keyId string) pki.Sealer {
+	return &kmsSealer{svc: svc, keyId: keyId}
+}
+
+func (s *kmsSealer) Seal(blockType string, data []byte) ([]byte, error) {
+	res, err := s.svc.GenerateDataKey(context.Background(), &kms.GenerateDataKeyInput{
+		KeyId:   aws.String(s.keyId),
+		KeySpec: types.DataKeySpecAes256,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "generating data key")
+	}
+
+	var key [32]byte
+	copy(key[:], res.Plaintext)
+
+	var nonce [kNonceSize]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, errors.Wrap(err, "generating nonce")
+	}
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:    blockType,
+		Headers: map[string]string{kKMSDataKeyHeader: base64.StdEncoding.EncodeToString(res.CiphertextBlob)},
+		Bytes:   secretbox.Seal(nonce[:], data, &nonce, &key),
+	}), nil
+}
+
+func (s *kmsSealer) Open(blockType string, data []byte) ([]byte, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, errors.Errorf("expected PEM block %s", blockType)
+	}
+
+	wrappedKey, err := base64.StdEncoding.DecodeString(block.Headers[kKMSDataKeyHeader])
+	if err != nil || len(wrappedKey) == 0 {
+		return nil, errors.New("missing data key")
+	}
+
+	if len(block.Bytes) < kNonceSize+secretbox.Overhead {
+		return nil, errors.New("encrypted data too short")
+	}
+
+	res, err := s.svc.Decrypt(context.Background(), &kms.DecryptInput{
+		CiphertextBlob: wrappedKey,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypting data key")
+	}
+
+	var key [32]byte
+	copy(key[:], res.Plaintext)
+
+	var nonce [kNonceSize]byte
+	copy(nonce[:], block.Bytes[:kNonceSize])
+
+	plain, ok := secretbox.Open(nil, block.Bytes[kNonceSize:], &nonce, &key)
+	if !ok {
+		return nil, errors.New("wrong data key or corrupted data")
+	}
+
+	return plain, nil
+}
@@ -0,0 +1,235 @@
+package awspki
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	A "github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	E "github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	log "github.com/sirupsen/logrus"
+)
+
+func queryFilter(query pki.CertQuery) []E.ConditionBuilder {
+	now := time.Now().UTC().Unix()
+
+	var conds []E.ConditionBuilder
+	if query.Type != "" {
+		conds = append(conds, E.Equal(E.Name(kAttrCertType), E.Value(string(query.Type))))
+	}
+
+	switch query.Status {
+	case pki.CertStatusActive:
+		conds = append(conds, E.GreaterThan(E.Name(kAttrValidUntil), E.Value(now)), notRevoked(now), notHeld())
+	case pki.CertStatusRevoked:
+		revoked := E.GreaterThan(E.Name(kAttrRevocationTime), E.Value(0)).
+			And(E.LessThanEqual(E.Name(kAttrRevocationTime), E.Value(now)))
+		conds = append(conds, E.GreaterThan(E.Name(kAttrValidUntil), E.Value(now)),
+			revoked.Or(E.GreaterThan(E.Name(kAttrHoldTime), E.Value(0))))
+	case pki.CertStatusExpired:
+		conds = append(conds, E.LessThanEqual(E.Name(kAttrValidUntil), E.Value(now)))
+	}
+
+	if query.SubjectPrefix != "" {
+		conds = append(conds, E.BeginsWith(E.Name(kAttrSubjectName), query.SubjectPrefix))
+	}
+
+	if !query.IssuedAfter.IsZero() {
+		conds = append(conds, E.GreaterThanEqual(E.Name(kAttrIssuedAt), E.Value(query.IssuedAfter.Unix())))
+	}
+	if !query.IssuedBefore.IsZero() {
+		conds = append(conds, E.LessThan(E.Name(kAttrIssuedAt), E.Value(query.IssuedBefore.Unix())))
+	}
+	if !query.ExpiresAfter.IsZero() {
+		conds = append(conds, E.GreaterThanEqual(E.Name(kAttrValidUntil), E.Value(query.ExpiresAfter.Unix())))
+	}
+	if !query.ExpiresBefore.IsZero() {
+		conds = append(conds, E.LessThan(E.Name(kAttrValidUntil), E.Value(query.ExpiresBefore.Unix())))
+	}
+
+	return conds
+}
+
+// dynamoCursor is the key to continue a listing from, the SubjectKeyId is part of it when querying SubjectKeyIdIdx
+type dynamoCursor struct {
+	SerialNumber []byte `json:"s"`
+	SubjectKeyId []byte `json:"k,omitempty"`
+}
+
+func decodeCursor(cursor string) (map[string]*dynamodb.AttributeValue, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, pki.ErrInvalidCursor
+	}
+
+	var c dynamoCursor
+	if err := json.Unmarshal(data, &c); err != nil || len(c.SerialNumber) == 0 {
+		return nil, pki.ErrInvalidCursor
+	}
+
+	key := map[string]*dynamodb.AttributeValue{
+		kAttrSerialNumber: {B: c.SerialNumber},
+	}
+	if len(c.SubjectKeyId) > 0 {
+		key[kAttrSubjectKeyId] = &dynamodb.AttributeValue{B: c.SubjectKeyId}
+	}
+	return key, nil
+}
+
+func encodeCursor(key map[string]*dynamodb.AttributeValue) (string, error) {
+	c := dynamoCursor{SerialNumber: key[kAttrSerialNumber].B}
+	if keyId, ok := key[kAttrSubjectKeyId]; ok {
+		c.SubjectKeyId = keyId.B
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// listScanLimit caps the items DynamoDB evaluates for a page, so a selective filter doesn't scan the whole table
+// in one request
+var listScanLimit int64 = 5000
+
+// UnsortedPages tells ListCerts pages in table order
+func (s *awsStorage) UnsortedPages() {}
+
+// ListCerts filters in DynamoDB, querying SubjectKeyIdIdx when filtering by key ID, and pages in table order with
+// the key of the last certificate as the cursor. A page stops after listScanLimit items evaluated, with fewer
+// certificates than the limit or none. The table has no index for the query orders, only the certificates of each
+// page are sorted.
+func (s *awsStorage) ListCerts(ctx context.Context, query pki.CertQuery) (*pki.CertPage, error) {
+	query, err := query.Normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := decodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	builder := E.NewBuilder()
+	empty := true
+	if conds := queryFilter(query); len(conds) > 0 {
+		filter := conds[0]
+		for _, c := range conds[1:] {
+			filter = filter.And(c)
+		}
+		builder, empty = builder.WithFilter(filter), false
+	}
+
+	if query.KeyId != "" {
+		keyId, _ := hex.DecodeString(query.KeyId)
+		builder, empty = builder.WithKeyCondition(E.KeyEqual(E.Key(kAttrSubjectKeyId), E.Value(keyId))), false
+	}
+
+	var exp E.Expression
+	if !empty {
+		if exp, err = builder.Build(); err != nil {
+			return nil, err
+		}
+	}
+
+	certs := make([]*pki.CertificateInfo, 0)
+	var next map[string]*dynamodb.AttributeValue
+	var scanned int64
+	for {
+		var items []map[string]*dynamodb.AttributeValue
+		var last map[string]*dynamodb.AttributeValue
+		if query.KeyId != "" {
+			output, err := s.ddb.QueryWithContext(ctx, &dynamodb.QueryInput{
+				TableName: aws.String(s.res.TableName),
+				IndexName: aws.String(kIndexSubjectKeyId),
+
+				ExpressionAttributeNames:  exp.Names(),
+				ExpressionAttributeValues: exp.Values(),
+				KeyConditionExpression:    exp.KeyCondition(),
+				FilterExpression:          exp.Filter(),
+				ExclusiveStartKey:         start,
+				Limit:                     aws.Int64(listScanLimit - scanned),
+			})
+			if err != nil {
+				return nil, err
+			}
+			items, last = output.Items, output.LastEvaluatedKey
+			scanned += aws.Int64Value(output.ScannedCount)
+		} else {
+			output, err := s.ddb.ScanWithContext(ctx, &dynamodb.ScanInput{
+				TableName: aws.String(s.res.TableName),
+
+				ExpressionAttributeNames:  exp.Names(),
+				ExpressionAttributeValues: exp.Values(),
+				FilterExpression:          exp.Filter(),
+				ExclusiveStartKey:         start,
+				Limit:                     aws.Int64(listScanLimit - scanned),
+			})
+			if err != nil {
+				return nil, err
+			}
+			items, last = output.Items, output.LastEvaluatedKey
+			scanned += aws.Int64Value(output.ScannedCount)
+		}
+
+		for i, item := range items {
+			var certEntry dynamoCertEntry
+			if err := A.UnmarshalMap(item, &certEntry); err != nil {
+				log.WithError(err).Error("Error unmarshaling cert from Dynamo")
+				continue
+			}
+
+			info, err := certEntry.toCertificateInfo()
+			if err != nil || info == nil {
+				log.WithError(err).Error("Error parsing certificate from Dynamo")
+				continue
+			}
+
+			certs = append(certs, info)
+			if len(certs) < query.Limit {
+				continue
+			}
+
+			// The page ends before the DynamoDB one, it's continued after its last item
+			if i < len(items)-1 {
+				last = map[string]*dynamodb.AttributeValue{kAttrSerialNumber: item[kAttrSerialNumber]}
+				if query.KeyId != "" {
+					last[kAttrSubjectKeyId] = item[kAttrSubjectKeyId]
+				}
+			}
+			break
+		}
+
+		if len(last) == 0 {
+			break
+		} else if len(certs) == query.Limit || scanned >= listScanLimit {
+			next = last
+			break
+		}
+		start = last
+	}
+
+	sort.Slice(certs, func(i, j int) bool {
+		return query.Before(query.SortKey(certs[i]), query.SortKey(certs[j]))
+	})
+
+	page := &pki.CertPage{Certs: certs}
+	if next != nil {
+		if page.NextCursor, err = encodeCursor(next); err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
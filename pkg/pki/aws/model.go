@@ -12,15 +12,22 @@ import (
 )
 
 const (
-	kAttrSerialNumber   = "SerialNumber"
-	kAttrAuthorityKeyId = "AuthorityKeyId"
-	kAttrSubjectKeyId   = "SubjectKeyId"
-	kAttrSubjectName    = "SubjectName"
-	kAttrCertType       = "CertType"
-	kAttrIssuedAt       = "IssuedAt"
-	kAttrValidUntil     = "ValidUntil"
-	kAttrRevocationTime = "RevocationTime"
-	kAttrData           = "Data"
+	kAttrSerialNumber      = "SerialNumber"
+	kAttrAuthorityKeyId    = "AuthorityKeyId"
+	kAttrSubjectKeyId      = "SubjectKeyId"
+	kAttrSubjectName       = "SubjectName"
+	kAttrCertType          = "CertType"
+	kAttrIssuedAt          = "IssuedAt"
+	kAttrValidUntil        = "ValidUntil"
+	kAttrRevocationTime    = "RevocationTime"
+	kAttrData              = "Data"
+	kAttrProfile           = "Profile"
+	kAttrPredecessor       = "Predecessor"
+	kAttrSuccessor         = "Successor"
+	kAttrHoldTime          = "HoldTime"
+	kAttrRevocationReason  = "RevocationReason"
+	kAttrRevokedBy         = "RevokedBy"
+	kAttrRevocationComment = "RevocationComment"
 
 	kIndexSubjectKeyId = kAttrSubjectKeyId + "Idx"
 	kIndexSubjectName  = kAttrSubjectName + "Idx"
@@ -35,7 +42,15 @@ type dynamoCertEntry struct {
 	IssuedAt       time.Time `dynamodbav:",unixtime"`
 	ValidUntil     time.Time `dynamodbav:",unixtime"`
 	RevocationTime time.Time `dynamodbav:",unixtime"`
+	HoldTime       time.Time `dynamodbav:",unixtime"`
 	Data           []byte    `dynamodbav:",binary"`
+	Profile        string    `dynamodbav:",omitempty"`
+	Predecessor    []byte    `dynamodbav:",omitempty"`
+	Successor      []byte    `dynamodbav:",omitempty"`
+
+	RevocationReason  int    `dynamodbav:",omitempty"`
+	RevokedBy         string `dynamodbav:",omitempty"`
+	RevocationComment string `dynamodbav:",omitempty"`
 }
 
 func (e *dynamoCertEntry) toCertificateInfo() (*pki.CertificateInfo, error) {
@@ -58,11 +73,35 @@ func (e *dynamoCertEntry) toCertificateInfo() (*pki.CertificateInfo, error) {
 		Subject:   e.SubjectName,
 		NotBefore: e.IssuedAt.UTC(),
 		NotAfter:  e.ValidUntil.UTC(),
+
+		Profile: e.Profile,
+
+		RevocationReason:  pki.RevocationReason(e.RevocationReason),
+		RevokedBy:         e.RevokedBy,
+		RevocationComment: e.RevocationComment,
+	}
+
+	if e.Predecessor != nil {
+		info.Predecessor = hex.EncodeToString(e.Predecessor)
+	}
+
+	if e.Successor != nil {
+		info.Successor = hex.EncodeToString(e.Successor)
 	}
 
+	// A revocation time in the future is a revocation scheduled after renewal
 	if e.RevocationTime.Unix() > 0 {
 		rt := e.RevocationTime.UTC()
-		info.Revoked = &rt
+		if rt.After(time.Now()) {
+			info.RevokeAt = &rt
+		} else {
+			info.Revoked = &rt
+		}
+	}
+
+	if e.HoldTime.Unix() > 0 && info.Revoked == nil {
+		ht := e.HoldTime.UTC()
+		info.OnHold = &ht
 	}
 
 	return info, err
@@ -78,6 +117,12 @@ func (e dynamoCertEntry) String() string {
 		fmt.Sprintf("IssuedAt:%s", e.IssuedAt),
 		fmt.Sprintf("ValidUntil:%s", e.ValidUntil),
 		fmt.Sprintf("RevocationTime:%s", e.RevocationTime),
+		fmt.Sprintf("HoldTime:%s", e.HoldTime),
+		fmt.Sprintf("RevocationReason:%d", e.RevocationReason),
+		fmt.Sprintf("RevokedBy:%s", e.RevokedBy),
+		fmt.Sprintf("Profile:%s", e.Profile),
+		fmt.Sprintf("Predecessor:%s", hex.EncodeToString(e.Predecessor)),
+		fmt.Sprintf("Successor:%s", hex.EncodeToString(e.Successor)),
 	}
 
 	return fmt.Sprintf("{%s}", strings.Join(vals, ", "))
@@ -87,3 +132,47 @@ type RevokedCert struct {
 	SerialNumber   []byte
 	RevocationTime time.Time
 }
+
+func newCertEntry(info *pki.CertificateInfo) (*dynamoCertEntry, error) {
+	cert := info.Certificate
+	if cert == nil || cert.Raw == nil {
+		return nil, errors.New("missing cert raw data")
+	}
+
+	if cert.AuthorityKeyId == nil {
+		return nil, errors.New("missing Authority Key ID")
+	}
+
+	if cert.SubjectKeyId == nil {
+		return nil, errors.New("missing Subject Key ID")
+	}
+
+	cType := pki.GetCertType(cert)
+	if cType == pki.CertTypeUnknown {
+		return nil, errors.New("unknown certificate type")
+	}
+
+	entry := &dynamoCertEntry{
+		SerialNumber:   cert.SerialNumber.Bytes(),
+		AuthorityKeyId: cert.AuthorityKeyId,
+		SubjectKeyId:   cert.SubjectKeyId,
+		SubjectName:    cert.Subject.CommonName,
+		CertType:       string(cType),
+		IssuedAt:       cert.NotBefore.UTC(),
+		ValidUntil:     cert.NotAfter.UTC(),
+		RevocationTime: time.Unix(0, 0).UTC(),
+		HoldTime:       time.Unix(0, 0).UTC(),
+		Data:           cert.Raw,
+		Profile:        info.Profile,
+	}
+
+	if info.Predecessor != "" {
+		predecessor, err := pki.DecodeSerial(info.Predecessor)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding predecessor serial")
+		}
+		entry.Predecessor = predecessor
+	}
+
+	return entry, nil
+}
@@ -0,0 +1,7 @@
+//go:build pkcs11 && cgo
+// +build pkcs11,cgo
+
+package awspki
+
+// Build with -tags pkcs11 to keep CA keys in a PKCS#11 token configured with PKI_PKCS11_MODULE
+import _ "github.com/empathybroker/aws-vpn/pkg/pki/pkcs11"
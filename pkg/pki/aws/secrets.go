@@ -7,16 +7,25 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
 	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	kStageCurrent = "AWSCURRENT"
+
+	// kStageUpdate labels the version written by UpdateCAData before AWSCURRENT is moved to it
+	kStageUpdate = "VPNUPDATE"
+)
+
 func (s *awsStorage) maybeUpdate(ctx context.Context) {
 	if time.Now().After(s.exp) {
 		log.Debug("Updating CA secrets")
 		res, err := s.sm.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
-			SecretId: aws.String(configAWSPKI.SecretName),
+			SecretId: aws.String(s.res.SecretName),
 		})
 		if err != nil {
 			log.WithError(err).Error("Fetching CA Key")
@@ -32,6 +41,123 @@ func (s *awsStorage) maybeUpdate(ctx context.Context) {
 	}
 }
 
+// GetCAData returns the current version of the CA data, or nil if the secret has no value yet
+func (s *awsStorage) GetCAData(ctx context.Context) (*pki.CAData, error) {
+	res, err := s.sm.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(s.res.SecretName),
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "fetching CA data")
+	}
+
+	var data pki.CAData
+	if err := data.UnmarshalJSON(res.SecretBinary); err != nil {
+		return nil, errors.Wrap(err, "unmarshalling CA data")
+	}
+
+	return &data, nil
+}
+
+// PutCAData stores data as the current version of the secret, which must exist
+func (s *awsStorage) PutCAData(ctx context.Context, data pki.CAData) error {
+	secret, err := data.MarshalJSON()
+	if err != nil {
+		return errors.Wrap(err, "marshalling CA data")
+	}
+
+	_, err = s.sm.PutSecretValueWithContext(ctx, &secretsmanager.PutSecretValueInput{
+		SecretId:      aws.String(s.res.SecretName),
+		SecretBinary:  secret,
+		VersionStages: aws.StringSlice([]string{kStageCurrent}),
+	})
+	if err != nil {
+		return errors.Wrap(err, "writing CA data")
+	}
+
+	s.mut.Lock()
+	s.exp = time.Time{}
+	s.mut.Unlock()
+
+	return nil
+}
+
+// GetCADataVersion returns the current version of the CA data and its version id
+func (s *awsStorage) GetCADataVersion(ctx context.Context) (*pki.CAData, string, error) {
+	res, err := s.sm.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId:     aws.String(s.res.SecretName),
+		VersionStage: aws.String(kStageCurrent),
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+		return nil, "", nil
+	} else if err != nil {
+		return nil, "", errors.Wrap(err, "fetching CA data")
+	}
+
+	var data pki.CAData
+	if err := data.UnmarshalJSON(res.SecretBinary); err != nil {
+		return nil, "", errors.Wrap(err, "unmarshalling CA data")
+	}
+
+	return &data, aws.StringValue(res.VersionId), nil
+}
+
+// UpdateCAData writes data as a new version and moves AWSCURRENT to it from version. Secrets Manager refuses the
+// move if AWSCURRENT isn't on version anymore, so a concurrent writer isn't overwritten.
+func (s *awsStorage) UpdateCAData(ctx context.Context, data pki.CAData, version string) error {
+	secret, err := data.MarshalJSON()
+	if err != nil {
+		return errors.Wrap(err, "marshalling CA data")
+	}
+
+	desc, err := s.sm.DescribeSecretWithContext(ctx, &secretsmanager.DescribeSecretInput{
+		SecretId: aws.String(s.res.SecretName),
+	})
+	if err != nil {
+		return errors.Wrap(err, "describing CA secret")
+	}
+
+	for id, stages := range desc.VersionIdsToStages {
+		current, pending := false, false
+		for _, stage := range aws.StringValueSlice(stages) {
+			current = current || stage == kStageCurrent
+			pending = pending || stage == kStagePending
+		}
+
+		if pending && !current {
+			return pki.ErrCARotationPending
+		} else if current && id != version {
+			return pki.ErrCADataChanged
+		}
+	}
+
+	put, err := s.sm.PutSecretValueWithContext(ctx, &secretsmanager.PutSecretValueInput{
+		SecretId:      aws.String(s.res.SecretName),
+		SecretBinary:  secret,
+		VersionStages: aws.StringSlice([]string{kStageUpdate}),
+	})
+	if err != nil {
+		return errors.Wrap(err, "writing CA data")
+	}
+
+	_, err = s.sm.UpdateSecretVersionStageWithContext(ctx, &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:            aws.String(s.res.SecretName),
+		VersionStage:        aws.String(kStageCurrent),
+		MoveToVersionId:     put.VersionId,
+		RemoveFromVersionId: aws.String(version),
+	})
+	if err != nil {
+		return errors.Wrap(err, "moving current stage")
+	}
+
+	s.mut.Lock()
+	s.exp = time.Time{}
+	s.mut.Unlock()
+
+	return nil
+}
+
 func (s *awsStorage) GetCACert(ctx context.Context) *x509.Certificate {
 	s.mut.Lock()
 	defer s.mut.Unlock()
@@ -56,12 +182,20 @@ func (s *awsStorage) GetCrossCert(ctx context.Context) *x509.Certificate {
 	return s.data.CrossCert
 }
 
-func (s *awsStorage) GetPrivateKey(ctx context.Context) crypto.PrivateKey {
+func (s *awsStorage) GetCAChain(ctx context.Context) []*x509.Certificate {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.Chain
+}
+
+func (s *awsStorage) GetSigner(ctx context.Context) (crypto.Signer, error) {
 	s.mut.Lock()
 	defer s.mut.Unlock()
 	s.maybeUpdate(ctx)
 
-	return s.data.PrivateKey
+	return s.data.Signer(ctx)
 }
 
 func (s *awsStorage) GetPublicKey(ctx context.Context) crypto.PublicKey {
@@ -79,3 +213,51 @@ func (s *awsStorage) GetStaticKey(ctx context.Context) pki.StaticKey {
 
 	return s.data.StaticKey
 }
+
+func (s *awsStorage) GetStaticKeyRotation(ctx context.Context) *pki.StaticKeyRotation {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.StaticKeyRotation
+}
+
+func (s *awsStorage) GetTLSCryptV2Key(ctx context.Context) pki.TLSCryptV2Key {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.TLSCryptV2Key
+}
+
+func (s *awsStorage) GetTLSCryptV2Switch(ctx context.Context) *pki.TLSCryptV2Switch {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.TLSCryptV2Switch
+}
+
+func (s *awsStorage) GetPrevOCSPCert(ctx context.Context) *x509.Certificate {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.PrevOCSPCert
+}
+
+func (s *awsStorage) GetPrevCRL(ctx context.Context) []byte {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.PrevCRL
+}
+
+func (s *awsStorage) GetPrevSigner(ctx context.Context) (crypto.Signer, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.PrevSigner(ctx)
+}
@@ -0,0 +1,197 @@
+package awspki
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	A "github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	E "github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+)
+
+const (
+	kAttrChain = "Chain"
+	kAttrIndex = "Index"
+
+	// The log entries and the tree heads are kept in separate partitions of the log table
+	kChainEntries   = "issuance"
+	kChainTreeHeads = "treehead"
+)
+
+type dynamoLogEntry struct {
+	Chain       string
+	Index       uint64
+	Timestamp   int64
+	Type        string
+	Serial      string
+	CertHash    []byte `dynamodbav:",omitempty"`
+	Predecessor string `dynamodbav:",omitempty"`
+	Reason      int    `dynamodbav:",omitempty"`
+	Actor       string `dynamodbav:",omitempty"`
+	PrevHash    []byte
+	Hash        []byte
+}
+
+type dynamoTreeHead struct {
+	Chain     string
+	Index     int64
+	Size      uint64
+	Hash      []byte
+	KeyId     []byte
+	Signature []byte
+}
+
+// LogEnabled tells whether a table for the issuance log has been configured
+func LogEnabled() bool {
+	return configAWSPKI.LogTableName != ""
+}
+
+func (s *awsStorage) AppendLogEntry(ctx context.Context, entry *pki.LogEntry) error {
+	item, err := A.MarshalMap(dynamoLogEntry{
+		Chain:       kChainEntries,
+		Index:       entry.Index,
+		Timestamp:   entry.Timestamp.UnixNano(),
+		Type:        string(entry.Type),
+		Serial:      entry.Serial,
+		CertHash:    entry.CertHash,
+		Predecessor: entry.Predecessor,
+		Reason:      int(entry.Reason),
+		Actor:       entry.Actor,
+		PrevHash:    entry.PrevHash,
+		Hash:        entry.Hash,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Entries are never overwritten, a concurrent writer got this index first
+	expr, err := E.NewBuilder().
+		WithCondition(E.AttributeNotExists(E.Name(kAttrIndex))).
+		Build()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.ddb.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.res.LogTableName),
+		Item:      item,
+
+		ExpressionAttributeNames: expr.Names(),
+		ConditionExpression:      expr.Condition(),
+	})
+	if isConditionFailed(err) {
+		return pki.ErrLogConflict
+	}
+
+	return err
+}
+
+func (e dynamoLogEntry) toLogEntry() *pki.LogEntry {
+	return &pki.LogEntry{
+		Index:       e.Index,
+		Timestamp:   time.Unix(0, e.Timestamp).UTC(),
+		Type:        pki.LogEntryType(e.Type),
+		Serial:      e.Serial,
+		CertHash:    e.CertHash,
+		Predecessor: e.Predecessor,
+		Reason:      pki.RevocationReason(e.Reason),
+		Actor:       e.Actor,
+		PrevHash:    e.PrevHash,
+		Hash:        e.Hash,
+	}
+}
+
+func (s *awsStorage) queryLog(ctx context.Context, chain string, keyCond E.KeyConditionBuilder, forward bool, limit int, out interface{}) error {
+	exp, err := E.NewBuilder().
+		WithKeyCondition(E.KeyEqual(E.Key(kAttrChain), E.Value(chain)).And(keyCond)).
+		Build()
+	if err != nil {
+		return err
+	}
+
+	res, err := s.ddb.QueryWithContext(ctx, &dynamodb.QueryInput{
+		TableName: aws.String(s.res.LogTableName),
+
+		ExpressionAttributeNames:  exp.Names(),
+		ExpressionAttributeValues: exp.Values(),
+		KeyConditionExpression:    exp.KeyCondition(),
+
+		ScanIndexForward: aws.Bool(forward),
+		Limit:            aws.Int64(int64(limit)),
+		ConsistentRead:   aws.Bool(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	return A.UnmarshalListOfMaps(res.Items, out)
+}
+
+func (s *awsStorage) GetLogHead(ctx context.Context) (*pki.LogEntry, error) {
+	var entries []dynamoLogEntry
+	if err := s.queryLog(ctx, kChainEntries, E.KeyGreaterThanEqual(E.Key(kAttrIndex), E.Value(0)), false, 1, &entries); err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	return entries[0].toLogEntry(), nil
+}
+
+func (s *awsStorage) ListLogEntries(ctx context.Context, from uint64, limit int) ([]*pki.LogEntry, error) {
+	var entries []dynamoLogEntry
+	if err := s.queryLog(ctx, kChainEntries, E.KeyGreaterThanEqual(E.Key(kAttrIndex), E.Value(from)), true, limit, &entries); err != nil {
+		return nil, err
+	}
+
+	res := make([]*pki.LogEntry, 0, len(entries))
+	for _, e := range entries {
+		res = append(res, e.toLogEntry())
+	}
+
+	return res, nil
+}
+
+func (s *awsStorage) PutTreeHead(ctx context.Context, th *pki.TreeHead) error {
+	item, err := A.MarshalMap(dynamoTreeHead{
+		Chain:     kChainTreeHeads,
+		Index:     th.Timestamp.UnixNano(),
+		Size:      th.Size,
+		Hash:      th.Hash,
+		KeyId:     th.KeyId,
+		Signature: th.Signature,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.ddb.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.res.LogTableName),
+		Item:      item,
+	})
+
+	return err
+}
+
+func (s *awsStorage) GetTreeHead(ctx context.Context) (*pki.TreeHead, error) {
+	var heads []dynamoTreeHead
+	if err := s.queryLog(ctx, kChainTreeHeads, E.KeyGreaterThanEqual(E.Key(kAttrIndex), E.Value(0)), false, 1, &heads); err != nil {
+		return nil, err
+	}
+
+	if len(heads) == 0 {
+		return nil, nil
+	}
+
+	return &pki.TreeHead{
+		Size:      heads[0].Size,
+		Hash:      heads[0].Hash,
+		Timestamp: time.Unix(0, heads[0].Index).UTC(),
+		KeyId:     heads[0].KeyId,
+		Signature: heads[0].Signature,
+	}, nil
+}
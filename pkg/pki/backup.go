@@ -0,0 +1,111 @@
+package pki
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	kBackupPEMType = "ENCRYPTED PKI BACKUP"
+	kBackupVersion = 1
+)
+
+// Backup is the complete state of a PKI: its CA data, every certificate whatever its state and the issuance log.
+// The CA key is only included when it is kept in the CA data, otherwise the backup references it by KeyURI.
+type Backup struct {
+	CreatedAt time.Time          `json:"createdAt"`
+	CAData    *CAData            `json:"caData,omitempty"`
+	Certs     []*CertificateInfo `json:"-"`
+	Log       []*LogEntry        `json:"log,omitempty"`
+	TreeHead  *TreeHead          `json:"treeHead,omitempty"`
+}
+
+// backupCert adds the certificate itself, which CertificateInfo doesn't encode
+type backupCert struct {
+	*CertificateInfo
+	Raw []byte `json:"der"`
+}
+
+// backupPlain is Backup without its JSON methods
+type backupPlain Backup
+
+type storedBackup struct {
+	Version int `json:"version"`
+	*backupPlain
+	Certs []backupCert `json:"certs"`
+}
+
+func (b *Backup) MarshalJSON() ([]byte, error) {
+	stored := storedBackup{Version: kBackupVersion, backupPlain: (*backupPlain)(b), Certs: make([]backupCert, len(b.Certs))}
+	for i, info := range b.Certs {
+		if info.Certificate == nil {
+			return nil, errors.Errorf("certificate %s missing", info.Serial)
+		}
+		stored.Certs[i] = backupCert{CertificateInfo: info, Raw: info.Certificate.Raw}
+	}
+
+	return json.Marshal(stored)
+}
+
+func (b *Backup) UnmarshalJSON(data []byte) error {
+	stored := storedBackup{backupPlain: (*backupPlain)(b)}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return errors.Wrap(err, "unmarshal backup")
+	}
+
+	if stored.Version != kBackupVersion {
+		return errors.Errorf("unsupported backup version %d", stored.Version)
+	}
+
+	b.Certs = make([]*CertificateInfo, len(stored.Certs))
+	for i, c := range stored.Certs {
+		if c.CertificateInfo == nil {
+			return errors.New("empty certificate record")
+		}
+
+		cert, err := x509.ParseCertificate(c.Raw)
+		if err != nil {
+			return errors.Wrapf(err, "parsing certificate %s", c.Serial)
+		}
+
+		// The record must describe the certificate it comes with
+		info := CertInfoFromX509Cert(cert)
+		if info.Serial != c.Serial {
+			return errors.Errorf("certificate record %s holds certificate %s", c.Serial, info.Serial)
+		}
+
+		c.Certificate = cert
+		c.SerialBytes = info.SerialBytes
+		b.Certs[i] = c.CertificateInfo
+	}
+
+	return nil
+}
+
+// Seal encodes the backup and encrypts it with sealer, which also protects it from tampering
+func (b *Backup) Seal(sealer Sealer) ([]byte, error) {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding backup")
+	}
+
+	return sealer.Seal(kBackupPEMType, data)
+}
+
+// OpenBackup decrypts a backup sealed with Backup.Seal
+func OpenBackup(data []byte, sealer Sealer) (*Backup, error) {
+	plain, err := sealer.Open(kBackupPEMType, data)
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypting backup")
+	}
+
+	var b Backup
+	if err := json.Unmarshal(plain, &b); err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
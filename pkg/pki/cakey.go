@@ -1,6 +1,7 @@
 package pki
 
 import (
+	"context"
 	"crypto"
 	"crypto/x509"
 	"crypto/x509/pkix"
@@ -11,24 +12,64 @@ import (
 	jose "gopkg.in/square/go-jose.v2"
 )
 
+// ErrIntermediateRenewal is returned renewing an intermediate as a self-signed CA, which only its root can replace
+var ErrIntermediateRenewal = errors.New("CA is an intermediate of an offline root and must be renewed with pki-root")
+
 type CAData struct {
+	// PrivateKey is only set for keys kept in the CA data. Otherwise KeyURI references it in a SignerBackend.
 	PrivateKey crypto.PrivateKey
+	KeyURI     string
 	PublicKey  crypto.PublicKey
 
 	CACert     *x509.Certificate
 	PrevCACert *x509.Certificate
 	CrossCert  *x509.Certificate
 
+	// PrevOCSPCert delegates OCSP responses for the previous CA to the current key
+	PrevOCSPCert *x509.Certificate
+
+	// PrevPrivateKey or PrevKeyURI keep the previous CA key until its certificate expires, so the CRL for the
+	// certificates it issued can be signed again after the rotation
+	PrevPrivateKey crypto.PrivateKey
+	PrevKeyURI     string
+
+	// PrevCRL is the last CRL signed by the previous CA before it was rotated, served when its key isn't kept
+	PrevCRL []byte
+
+	// Chain holds the issuers of CACert up to the root when CACert is an intermediate
+	Chain []*x509.Certificate
+
 	StaticKey StaticKey
+
+	// StaticKeyCreated is when the StaticKey was put in place, zero if it was before rotations
+	StaticKeyCreated  time.Time
+	StaticKeyRotation *StaticKeyRotation
+
+	// TLSCryptV2Key is missing in CA data created before tls-crypt-v2, until the CA is renewed
+	TLSCryptV2Key    TLSCryptV2Key
+	TLSCryptV2Switch *TLSCryptV2Switch
 }
 
 type storedCAData struct {
-	PrivateKey jose.JSONWebKey `json:"key"`
+	PrivateKey *jose.JSONWebKey `json:"key,omitempty"`
+	KeyURI     string           `json:"keyUri,omitempty"`
 
 	CACert     []byte `json:"ca"`
 	PrevCACert []byte `json:"pca,omitempty"`
 	CrossCert  []byte `json:"xca,omitempty"`
-	StaticKey  []byte `json:"ovpn"`
+
+	PrevOCSPCert   []byte           `json:"pocsp,omitempty"`
+	PrevPrivateKey *jose.JSONWebKey `json:"pkey,omitempty"`
+	PrevKeyURI     string           `json:"pkeyUri,omitempty"`
+	PrevCRL        []byte           `json:"pcrl,omitempty"`
+
+	Chain [][]byte `json:"chain,omitempty"`
+
+	StaticKey         []byte             `json:"ovpn"`
+	StaticKeyCreated  *time.Time         `json:"ovpnCreated,omitempty"`
+	StaticKeyRotation *StaticKeyRotation `json:"ovpnRotation,omitempty"`
+	TLSCryptV2Key     []byte             `json:"tcv2,omitempty"`
+	TLSCryptV2Switch  *TLSCryptV2Switch  `json:"tcv2Switch,omitempty"`
 }
 
 func (k *CAData) UnmarshalJSON(data []byte) error {
@@ -57,25 +98,85 @@ func (k *CAData) UnmarshalJSON(data []byte) error {
 		}
 	}
 
-	var ok bool
-	if k.PrivateKey, ok = stored.PrivateKey.Key.(crypto.PrivateKey); !ok {
-		return errors.New("unexpected privateKey type")
+	if len(stored.PrevOCSPCert) > 0 {
+		k.PrevOCSPCert, err = x509.ParseCertificate(stored.PrevOCSPCert)
+		if err != nil {
+			return errors.Wrap(err, "parsing OCSP responder certificate")
+		}
+	}
+
+	k.Chain = nil
+	for _, der := range stored.Chain {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return errors.Wrap(err, "parsing CA chain")
+		}
+		k.Chain = append(k.Chain, cert)
+	}
+
+	k.KeyURI = stored.KeyURI
+	k.PrivateKey = nil
+	k.PublicKey = k.CACert.PublicKey
+
+	if stored.PrivateKey != nil {
+		var ok bool
+		if k.PrivateKey, ok = JSONWebKeyKey(*stored.PrivateKey).(crypto.PrivateKey); !ok {
+			return errors.New("unexpected privateKey type")
+		}
+
+		if k.PublicKey, ok = JSONWebKeyKey(stored.PrivateKey.Public()).(crypto.PublicKey); !ok {
+			return errors.New("unexpected publicKey type")
+		}
+	} else if k.KeyURI == "" {
+		return errors.New("CA data has neither a key nor a key URI")
 	}
 
-	if k.PublicKey, ok = stored.PrivateKey.Public().Key.(crypto.PublicKey); !ok {
-		return errors.New("unexpected publicKey type")
+	k.PrevKeyURI = stored.PrevKeyURI
+	k.PrevPrivateKey = nil
+	if stored.PrevPrivateKey != nil {
+		var ok bool
+		if k.PrevPrivateKey, ok = JSONWebKeyKey(*stored.PrevPrivateKey).(crypto.PrivateKey); !ok {
+			return errors.New("unexpected previous privateKey type")
+		}
 	}
 
+	k.PrevCRL = stored.PrevCRL
 	k.StaticKey = stored.StaticKey
+	k.StaticKeyCreated = time.Time{}
+	if stored.StaticKeyCreated != nil {
+		k.StaticKeyCreated = *stored.StaticKeyCreated
+	}
+	k.StaticKeyRotation = stored.StaticKeyRotation
+	k.TLSCryptV2Key = stored.TLSCryptV2Key
+	k.TLSCryptV2Switch = stored.TLSCryptV2Switch
 
 	return nil
 }
 
 func (k CAData) MarshalJSON() ([]byte, error) {
 	s := storedCAData{
-		PrivateKey: jose.JSONWebKey{Key: k.PrivateKey},
-		CACert:     k.CACert.Raw,
-		StaticKey:  k.StaticKey,
+		KeyURI:            k.KeyURI,
+		CACert:            k.CACert.Raw,
+		PrevKeyURI:        k.PrevKeyURI,
+		PrevCRL:           k.PrevCRL,
+		StaticKey:         k.StaticKey,
+		StaticKeyRotation: k.StaticKeyRotation,
+		TLSCryptV2Key:     k.TLSCryptV2Key,
+		TLSCryptV2Switch:  k.TLSCryptV2Switch,
+	}
+
+	if !k.StaticKeyCreated.IsZero() {
+		s.StaticKeyCreated = &k.StaticKeyCreated
+	}
+
+	if k.PrivateKey != nil {
+		jwk := NewJSONWebKey(k.PrivateKey)
+		s.PrivateKey = &jwk
+	}
+
+	if k.PrevPrivateKey != nil {
+		jwk := NewJSONWebKey(k.PrevPrivateKey)
+		s.PrevPrivateKey = &jwk
 	}
 
 	if k.PrevCACert != nil {
@@ -86,54 +187,136 @@ func (k CAData) MarshalJSON() ([]byte, error) {
 		s.CrossCert = k.CrossCert.Raw
 	}
 
+	if k.PrevOCSPCert != nil {
+		s.PrevOCSPCert = k.PrevOCSPCert.Raw
+	}
+
+	for _, cert := range k.Chain {
+		s.Chain = append(s.Chain, cert.Raw)
+	}
+
 	return json.Marshal(s)
 }
 
-func NewCAKey(caName string, serialNumber string, duration time.Duration) (CAData, error) {
-	privKey, err := NewPrivateKey()
+// Signer returns the CA key, opening it in its SignerBackend when it isn't kept in the CA data
+func (k CAData) Signer(ctx context.Context) (crypto.Signer, error) {
+	if k.KeyURI != "" {
+		return OpenSigner(ctx, k.KeyURI, k.PublicKey)
+	}
+
+	signer, ok := k.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported CA key type")
+	}
+	return signer, nil
+}
+
+// PrevSigner returns the previous CA key, or nil if it isn't kept
+func (k CAData) PrevSigner(ctx context.Context) (crypto.Signer, error) {
+	if k.PrevCACert == nil {
+		return nil, nil
+	}
+
+	if k.PrevKeyURI != "" {
+		return OpenSigner(ctx, k.PrevKeyURI, k.PrevCACert.PublicKey)
+	}
+
+	if k.PrevPrivateKey == nil {
+		return nil, nil
+	}
+
+	signer, ok := k.PrevPrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported previous CA key type")
+	}
+	return signer, nil
+}
+
+// NewCAKey creates a self-signed CA. caOpts are applied to its certificate, e.g. WithNameConstraints.
+func NewCAKey(caName string, serialNumber string, alg KeyAlgorithm, duration time.Duration, caOpts ...CertOptions) (CAData, error) {
+	privKey, keyURI, signer, err := newCAPrivateKey(alg, caName+" "+serialNumber)
 	if err != nil {
-		return CAData{}, errors.Wrap(err, "error generating key")
+		return CAData{}, err
 	}
 
 	pkiName := pkix.Name{CommonName: caName, SerialNumber: serialNumber}
-	caCert, err := CreateCertificate(nil, privKey, GetPublicKey(privKey), pkiName, CACert, WithDuration(duration), WithMaxPathLen(1))
+	opts := append([]CertOptions{CACert, WithDuration(duration), WithMaxPathLen(1)}, caOpts...)
+	caCert, err := CreateCertificate(nil, signer, signer.Public(), pkiName, opts...)
 	if err != nil {
 		return CAData{}, errors.Wrap(err, "error signing certificate key")
 	}
 
 	return CAData{
-		PrivateKey: privKey,
-		PublicKey:  GetPublicKey(privKey),
-		CACert:     caCert,
-		PrevCACert: nil,
-		CrossCert:  nil,
-		StaticKey:  NewStaticKey(),
+		PrivateKey:       privKey,
+		KeyURI:           keyURI,
+		PublicKey:        signer.Public(),
+		CACert:           caCert,
+		PrevCACert:       nil,
+		CrossCert:        nil,
+		StaticKey:        NewStaticKey(),
+		StaticKeyCreated: time.Now().UTC(),
+		TLSCryptV2Key:    NewTLSCryptV2Key(),
 	}, nil
 }
 
-func (k CAData) Renew(caName string, serialNumber string, duration time.Duration) (CAData, error) {
-	privKey, err := NewPrivateKey()
+// Renew creates a new self-signed CA cross-signed by k. caOpts are applied to both the new and the cross-signed
+// certificates.
+func (k CAData) Renew(caName string, serialNumber string, alg KeyAlgorithm, duration time.Duration, caOpts ...CertOptions) (CAData, error) {
+	if len(k.Chain) > 0 {
+		return CAData{}, ErrIntermediateRenewal
+	}
+
+	oldSigner, err := k.Signer(context.Background())
+	if err != nil {
+		return CAData{}, err
+	}
+
+	privKey, keyURI, signer, err := newCAPrivateKey(alg, caName+" "+serialNumber)
 	if err != nil {
-		return CAData{}, errors.Wrap(err, "error generating key")
+		return CAData{}, err
 	}
 
 	pkiName := pkix.Name{CommonName: caName, SerialNumber: serialNumber}
-	caCert, err := CreateCertificate(nil, privKey, GetPublicKey(privKey), pkiName, CACert, WithDuration(duration), WithMaxPathLen(1))
+	opts := append([]CertOptions{CACert, WithDuration(duration), WithMaxPathLen(1)}, caOpts...)
+	caCert, err := CreateCertificate(nil, signer, signer.Public(), pkiName, opts...)
 	if err != nil {
 		return CAData{}, errors.Wrap(err, "error signing CA certificate")
 	}
 
-	crossCert, err := CreateCertificate(k.CACert, k.PrivateKey, GetPublicKey(privKey), pkiName, CACert, WithExpiration(k.CACert.NotAfter), WithMaxPathLen(0))
+	crossOpts := append([]CertOptions{CACert, WithExpiration(k.CACert.NotAfter), WithMaxPathLen(0)}, caOpts...)
+	crossCert, err := CreateCertificate(k.CACert, oldSigner, signer.Public(), pkiName, crossOpts...)
 	if err != nil {
 		return CAData{}, errors.Wrap(err, "error cross-signing CA certificate")
 	}
 
+	ocspName := pkix.Name{CommonName: caName + " OCSP Responder", SerialNumber: serialNumber}
+	ocspCert, err := CreateCertificate(k.CACert, oldSigner, signer.Public(), ocspName, OCSPSigningCert, WithExpiration(k.CACert.NotAfter))
+	if err != nil {
+		return CAData{}, errors.Wrap(err, "error signing OCSP responder certificate")
+	}
+
 	return CAData{
-		PrivateKey: privKey,
-		PublicKey:  GetPublicKey(privKey),
-		CACert:     caCert,
-		PrevCACert: k.CACert,
-		CrossCert:  crossCert,
-		StaticKey:  k.StaticKey,
+		PrivateKey:        privKey,
+		KeyURI:            keyURI,
+		PublicKey:         signer.Public(),
+		CACert:            caCert,
+		PrevCACert:        k.CACert,
+		CrossCert:         crossCert,
+		PrevOCSPCert:      ocspCert,
+		PrevPrivateKey:    k.PrivateKey,
+		PrevKeyURI:        k.KeyURI,
+		StaticKey:         k.StaticKey,
+		StaticKeyCreated:  k.StaticKeyCreated,
+		StaticKeyRotation: k.StaticKeyRotation,
+		TLSCryptV2Key:     k.tlsCryptV2Key(),
+		TLSCryptV2Switch:  k.TLSCryptV2Switch,
 	}, nil
 }
+
+// tlsCryptV2Key keeps the tls-crypt-v2 key across renewals, creating it for CA data which has none yet
+func (k CAData) tlsCryptV2Key() TLSCryptV2Key {
+	if len(k.TLSCryptV2Key) == 0 {
+		return NewTLSCryptV2Key()
+	}
+	return k.TLSCryptV2Key
+}
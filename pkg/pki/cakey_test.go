@@ -1,8 +1,8 @@
 package pki
 
 import (
+	"crypto/x509/pkix"
 	"encoding/json"
-	"os"
 	"testing"
 	"time"
 
@@ -12,10 +12,6 @@ import (
 
 func init() {
 	logrus.SetLevel(logrus.DebugLevel)
-
-	if err := os.Setenv("PKI_KEY_TYPE", "RSA"); err != nil {
-		panic(err)
-	}
 }
 
 const (
@@ -24,16 +20,12 @@ const (
 )
 
 func TestNewCAKey(t *testing.T) {
-	if err := os.Setenv("PKI_KEY_TYPE", "RSA"); err != nil {
-		t.Fatal(err)
-	}
-
-	caKey, err := NewCAKey(kCAName, uuid.New().String(), kDuration)
+	caKey, err := NewCAKey(kCAName, uuid.New().String(), KeyAlgorithmRSA2048, kDuration)
 	if err != nil {
 		t.Fatalf("%+v", err)
 	}
 
-	rCaKey, err := caKey.Renew(kCAName, uuid.New().String(), kDuration)
+	rCaKey, err := caKey.Renew(kCAName, uuid.New().String(), KeyAlgorithmRSA2048, kDuration)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -53,13 +45,99 @@ func TestNewCAKey(t *testing.T) {
 	t.Log(len(rCaData), string(rCaData))
 }
 
+func TestKeyAlgorithms(t *testing.T) {
+	algs := []KeyAlgorithm{KeyAlgorithmRSA3072, KeyAlgorithmP256, KeyAlgorithmP384}
+
+	for i, alg := range algs {
+		caKey, err := NewCAKey(kCAName, uuid.New().String(), alg, kDuration)
+		if err != nil {
+			t.Fatalf("%s: %+v", alg, err)
+		}
+
+		// The renewed CA uses a different algorithm, which must still be cross-signed
+		rCaKey, err := caKey.Renew(kCAName, uuid.New().String(), algs[(i+1)%len(algs)], kDuration)
+		if err != nil {
+			t.Fatalf("%s: %+v", alg, err)
+		}
+
+		caData, err := json.Marshal(rCaKey)
+		if err != nil {
+			t.Fatalf("%s: %+v", alg, err)
+		}
+
+		var parsed CAData
+		if err := json.Unmarshal(caData, &parsed); err != nil {
+			t.Fatalf("%s: %+v", alg, err)
+		}
+
+		leafKey, err := NewPrivateKey(alg)
+		if err != nil {
+			t.Fatalf("%s: %+v", alg, err)
+		}
+
+		if _, err := EncodePEMPrivateKey(leafKey); err != nil {
+			t.Fatalf("%s: %+v", alg, err)
+		}
+
+		if leafAlg, err := GetKeyAlgorithm(GetPublicKey(leafKey)); err != nil || leafAlg != alg {
+			t.Fatalf("%s: unexpected algorithm %s (%v)", alg, leafAlg, err)
+		}
+
+		leaf, err := CreateCertificate(parsed.CACert, parsed.PrivateKey, GetPublicKey(leafKey), pkix.Name{CommonName: "test"}, ClientCert, WithDuration(time.Hour))
+		if err != nil {
+			t.Fatalf("%s: %+v", alg, err)
+		}
+
+		if err := leaf.CheckSignatureFrom(parsed.CACert); err != nil {
+			t.Fatalf("%s: %+v", alg, err)
+		}
+	}
+}
+
+func TestCAKeyAlgorithmEd25519(t *testing.T) {
+	if _, err := NewCAKey(kCAName, uuid.New().String(), KeyAlgorithmEd25519, kDuration); err == nil {
+		t.Fatal("expected an Ed25519 CA to be refused")
+	}
+
+	if _, err := ParseCAKeyAlgorithm(string(KeyAlgorithmEd25519)); err == nil {
+		t.Fatal("expected Ed25519 to be refused for the CA")
+	}
+
+	// The client key type doesn't choose the CA algorithm
+	t.Setenv("PKI_KEY_TYPE", string(KeyAlgorithmEd25519))
+	t.Setenv("PKI_CA_KEY_ALGORITHM", "")
+	if alg, err := CAKeyAlgorithmFromEnv(); err != nil || alg != KeyAlgorithmP256 {
+		t.Fatalf("expected the P-256 default, got %s (%v)", alg, err)
+	}
+
+	caKey, err := NewCAKey(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leafKey, err := NewPrivateKey(KeyAlgorithmEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := CreateCertificate(caKey.CACert, caKey.PrivateKey, GetPublicKey(leafKey), pkix.Name{CommonName: "test"}, ClientCert, WithDuration(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := leaf.CheckSignatureFrom(caKey.CACert); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestRenewSize(t *testing.T) {
-	maxSize := 7 * 1024
+	// Renewed CA data keeps the previous key to sign its CRL
+	maxSize := 10 * 1024
 
 	max := []int{0, 0, 0}
 
 	for i := 0; i < 10; i++ {
-		caKey, err := NewCAKey(kCAName, uuid.New().String(), kDuration)
+		caKey, err := NewCAKey(kCAName, uuid.New().String(), KeyAlgorithmRSA2048, kDuration)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -74,7 +152,7 @@ func TestRenewSize(t *testing.T) {
 		}
 		// t.Log(0, len(caData))
 
-		caKey, err = caKey.Renew(kCAName, uuid.New().String(), kDuration)
+		caKey, err = caKey.Renew(kCAName, uuid.New().String(), KeyAlgorithmRSA2048, kDuration)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -0,0 +1,133 @@
+package pki
+
+import (
+	"crypto/elliptic"
+	"crypto/x509"
+	"os"
+
+	"github.com/kelseyhightower/envconfig"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	kConfigPrefix         = "PKI_KEY_POLICY"
+	kConfigProfilesPrefix = "PKI_PROFILES"
+	kConfigNCPrefix       = "PKI_NAME_CONSTRAINTS"
+	kConfigSignerPrefix   = "PKI_CA_KEY"
+	kConfigTLSCryptPrefix = "PKI_TLS_CRYPT"
+)
+
+var configKeyPolicy struct {
+	Algorithms     []string `default:"RSA,ECDSA,Ed25519"`
+	Curves         []string `default:"P-256,P-384"`
+	MinRSABits     int      `split_words:"true" default:"2048"`
+	DebianWeakKeys []string `split_words:"true"`
+}
+
+var configProfiles struct {
+	File    string
+	Default string `default:"laptop"`
+}
+
+// Name constraints default to the domains allowed by the authorizer and the VPN domain. AUTH_HOSTED_DOMAINS is
+// read here too, so it must be set on the CA and API Lambdas as well as on the authorizer.
+var configNameConstraints struct {
+	EmailDomains []string `split_words:"true"`
+	DNSDomains   []string `envconfig:"DNS_DOMAINS"`
+}
+
+// Backend is the scheme of the SignerBackend new CA keys are generated in, empty to keep them in the CA data
+var configSigner struct {
+	Backend string
+}
+
+// V2 switches servers and clients to tls-crypt-v2. Clients need a new profile, the servers reject the old ones.
+var configTLSCrypt struct {
+	V2 bool
+}
+
+var (
+	DefaultKeyPolicy       KeyPolicy
+	DefaultNameConstraints NameConstraints
+
+	// Profiles holds the built-in profiles plus the ones loaded from PKI_PROFILES_FILE
+	Profiles           = NewDefaultProfiles()
+	DefaultProfileName string
+)
+
+func init() {
+	envconfig.MustProcess(kConfigPrefix, &configKeyPolicy)
+
+	DefaultKeyPolicy.MinRSABits = configKeyPolicy.MinRSABits
+	DefaultKeyPolicy.DebianWeakKeys = make(map[string]struct{})
+
+	for _, name := range configKeyPolicy.Algorithms {
+		switch name {
+		case x509.RSA.String():
+			DefaultKeyPolicy.AllowedAlgorithms = append(DefaultKeyPolicy.AllowedAlgorithms, x509.RSA)
+		case x509.ECDSA.String():
+			DefaultKeyPolicy.AllowedAlgorithms = append(DefaultKeyPolicy.AllowedAlgorithms, x509.ECDSA)
+		case x509.Ed25519.String():
+			DefaultKeyPolicy.AllowedAlgorithms = append(DefaultKeyPolicy.AllowedAlgorithms, x509.Ed25519)
+		default:
+			log.Fatalf("Unknown key algorithm in policy: %s", name)
+		}
+	}
+
+	for _, name := range configKeyPolicy.Curves {
+		switch name {
+		case "P-256":
+			DefaultKeyPolicy.AllowedCurves = append(DefaultKeyPolicy.AllowedCurves, elliptic.P256())
+		case "P-384":
+			DefaultKeyPolicy.AllowedCurves = append(DefaultKeyPolicy.AllowedCurves, elliptic.P384())
+		case "P-521":
+			DefaultKeyPolicy.AllowedCurves = append(DefaultKeyPolicy.AllowedCurves, elliptic.P521())
+		default:
+			log.Fatalf("Unknown curve in policy: %s", name)
+		}
+	}
+
+	for _, fileName := range configKeyPolicy.DebianWeakKeys {
+		f, err := os.Open(fileName)
+		if err != nil {
+			log.WithError(err).Fatalf("Error opening weak keys file %s", fileName)
+		}
+
+		if err := LoadDebianWeakKeys(f, DefaultKeyPolicy.DebianWeakKeys); err != nil {
+			log.WithError(err).Fatalf("Error loading weak keys file %s", fileName)
+		}
+		f.Close()
+	}
+
+	envconfig.MustProcess(kConfigProfilesPrefix, &configProfiles)
+
+	if configProfiles.File != "" {
+		f, err := os.Open(configProfiles.File)
+		if err != nil {
+			log.WithError(err).Fatalf("Error opening profiles file %s", configProfiles.File)
+		}
+
+		if err := Profiles.Load(f); err != nil {
+			log.WithError(err).Fatalf("Error loading profiles file %s", configProfiles.File)
+		}
+		f.Close()
+	}
+
+	if _, err := Profiles.Get(configProfiles.Default); err != nil {
+		log.WithError(err).Fatal("Invalid default profile")
+	}
+	DefaultProfileName = configProfiles.Default
+
+	envconfig.MustProcess(kConfigNCPrefix, &configNameConstraints)
+
+	DefaultNameConstraints = ParseNameConstraints(os.Getenv("AUTH_HOSTED_DOMAINS"), os.Getenv("PKI_DOMAIN"))
+	if len(configNameConstraints.EmailDomains) > 0 {
+		DefaultNameConstraints.PermittedEmailDomains = configNameConstraints.EmailDomains
+	}
+	if len(configNameConstraints.DNSDomains) > 0 {
+		DefaultNameConstraints.PermittedDNSDomains = configNameConstraints.DNSDomains
+	}
+
+	envconfig.MustProcess(kConfigSignerPrefix, &configSigner)
+	envconfig.MustProcess(kConfigTLSCryptPrefix, &configTLSCrypt)
+}
@@ -0,0 +1,139 @@
+package pki
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// NameConstraints limits the names CA certificates can vouch for, so a leaked CA key can't be used to
+// impersonate anything outside our own domains. Empty lists leave that name type unconstrained.
+type NameConstraints struct {
+	PermittedEmailDomains []string
+	PermittedDNSDomains   []string
+}
+
+const (
+	NameRejectedConstraints = "name_not_permitted"
+)
+
+// NameConstraintError is returned when a certificate would carry a name outside the constraints
+type NameConstraintError struct {
+	Name string
+}
+
+func (e *NameConstraintError) Error() string {
+	return fmt.Sprintf("name %q is not permitted by the CA name constraints", e.Name)
+}
+
+// ParseNameConstraints builds constraints from comma separated lists of domains
+func ParseNameConstraints(emailDomains string, dnsDomains string) NameConstraints {
+	return NameConstraints{
+		PermittedEmailDomains: splitList(emailDomains),
+		PermittedDNSDomains:   splitList(dnsDomains),
+	}
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (nc NameConstraints) IsEmpty() bool {
+	return len(nc.PermittedEmailDomains) == 0 && len(nc.PermittedDNSDomains) == 0
+}
+
+// WithNameConstraints adds the constraints to a CA certificate, marked critical as RFC 5280 requires
+func WithNameConstraints(nc NameConstraints) CertOptions {
+	return func(cert *x509.Certificate) {
+		cert.PermittedEmailAddresses = nc.PermittedEmailDomains
+		cert.PermittedDNSDomains = nc.PermittedDNSDomains
+		cert.PermittedDNSDomainsCritical = !nc.IsEmpty()
+	}
+}
+
+// NameConstraintsFromCert returns the permitted names of a CA certificate
+func NameConstraintsFromCert(ca *x509.Certificate) NameConstraints {
+	return NameConstraints{
+		PermittedEmailDomains: ca.PermittedEmailAddresses,
+		PermittedDNSDomains:   ca.PermittedDNSDomains,
+	}
+}
+
+// Check verifies every name in cert is permitted. A subject common name is checked as well, as an email
+// address if it has an @ and as a DNS name otherwise.
+func (nc NameConstraints) Check(cert *x509.Certificate) error {
+	emails := cert.EmailAddresses
+	dnsNames := cert.DNSNames
+
+	if cn := cert.Subject.CommonName; strings.Contains(cn, "@") {
+		emails = append([]string{cn}, emails...)
+	} else if cn != "" {
+		dnsNames = append([]string{cn}, dnsNames...)
+	}
+
+	if len(nc.PermittedEmailDomains) > 0 {
+		for _, email := range emails {
+			if !matchesAny(nc.PermittedEmailDomains, email, matchEmailConstraint) {
+				return &NameConstraintError{email}
+			}
+		}
+	}
+
+	if len(nc.PermittedDNSDomains) > 0 {
+		for _, dnsName := range dnsNames {
+			if !matchesAny(nc.PermittedDNSDomains, dnsName, matchDomainConstraint) {
+				return &NameConstraintError{dnsName}
+			}
+		}
+	}
+
+	return nil
+}
+
+func matchesAny(constraints []string, name string, match func(name string, constraint string) bool) bool {
+	for _, constraint := range constraints {
+		if match(name, constraint) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchEmailConstraint follows RFC 5280 4.2.1.10: a constraint with an @ is a whole mailbox, one with a leading
+// dot covers subdomains and anything else is a host
+func matchEmailConstraint(email string, constraint string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+
+	if strings.Contains(constraint, "@") {
+		return strings.EqualFold(email, constraint)
+	}
+
+	host := strings.ToLower(email[at+1:])
+	constraint = strings.ToLower(constraint)
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(host, constraint)
+	}
+
+	return host == constraint
+}
+
+// matchDomainConstraint accepts the domain itself and its subdomains, or only the latter with a leading dot
+func matchDomainConstraint(domain string, constraint string) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	constraint = strings.ToLower(constraint)
+
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(domain, constraint)
+	}
+
+	return domain == constraint || strings.HasSuffix(domain, "."+constraint)
+}
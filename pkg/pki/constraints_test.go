@@ -0,0 +1,69 @@
+package pki
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestNameConstraints(t *testing.T) {
+	nc := ParseNameConstraints("example.com", "vpn.example.com")
+
+	caKey, err := NewCAKey(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration, WithNameConstraints(nc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !caKey.CACert.PermittedDNSDomainsCritical || len(caKey.CACert.PermittedEmailAddresses) != 1 {
+		t.Fatal("expected critical name constraints in the CA certificate")
+	}
+
+	newCA, err := caKey.Renew(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration, WithNameConstraints(nc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(newCA.CrossCert.PermittedDNSDomains) != 1 {
+		t.Fatal("expected name constraints in the cross-signed certificate")
+	}
+
+	tests := []struct {
+		cn      string
+		opts    []CertOptions
+		allowed bool
+	}{
+		{"user@example.com", []CertOptions{ClientCert, WithEmail("user@example.com")}, true},
+		{"user@EXAMPLE.com", []CertOptions{ClientCert}, true},
+		{"user@sub.example.com", []CertOptions{ClientCert}, false},
+		{"user@example.com", []CertOptions{ClientCert, WithEmail("user@evil.com")}, false},
+		{"vpn.example.com", []CertOptions{ServerCert, WithDNS("vpn.example.com", "eu.vpn.example.com")}, true},
+		{"vpn.example.com", []CertOptions{ServerCert, WithDNS("www.example.com")}, false},
+		{"notvpn.example.com", []CertOptions{ServerCert}, false},
+	}
+
+	for _, test := range tests {
+		opts := append(test.opts, WithDuration(time.Hour))
+		cert, err := CreateCertificate(newCA.CACert, newCA.PrivateKey, newCA.PublicKey, pkix.Name{CommonName: test.cn}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = NameConstraintsFromCert(newCA.CACert).Check(cert)
+		if test.allowed && err != nil {
+			t.Errorf("%s: unexpected error %v", test.cn, err)
+		} else if !test.allowed && err == nil {
+			t.Errorf("%s: expected constraint violation", test.cn)
+		}
+
+		// Our check must never be more lenient than standard path validation of the SANs
+		roots := x509.NewCertPool()
+		roots.AddCert(newCA.CACert)
+		_, verifyErr := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+		if err == nil && verifyErr != nil {
+			t.Errorf("%s: accepted by the check but not by x509: %v", test.cn, verifyErr)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package pki
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RevocationReason is the CRLReason enumeration from RFC 5280 section 5.3.1
+type RevocationReason int
+
+const (
+	ReasonUnspecified          RevocationReason = 0
+	ReasonKeyCompromise        RevocationReason = 1
+	ReasonCACompromise         RevocationReason = 2
+	ReasonAffiliationChanged   RevocationReason = 3
+	ReasonSuperseded           RevocationReason = 4
+	ReasonCessationOfOperation RevocationReason = 5
+	ReasonCertificateHold      RevocationReason = 6
+	ReasonRemoveFromCRL        RevocationReason = 8
+	ReasonPrivilegeWithdrawn   RevocationReason = 9
+	ReasonAACompromise         RevocationReason = 10
+)
+
+var revocationReasonNames = map[RevocationReason]string{
+	ReasonUnspecified:          "unspecified",
+	ReasonKeyCompromise:        "keyCompromise",
+	ReasonCACompromise:         "cACompromise",
+	ReasonAffiliationChanged:   "affiliationChanged",
+	ReasonSuperseded:           "superseded",
+	ReasonCessationOfOperation: "cessationOfOperation",
+	ReasonCertificateHold:      "certificateHold",
+	ReasonRemoveFromCRL:        "removeFromCRL",
+	ReasonPrivilegeWithdrawn:   "privilegeWithdrawn",
+	ReasonAACompromise:         "aACompromise",
+}
+
+func (r RevocationReason) String() string {
+	if name, ok := revocationReasonNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("RevocationReason(%d)", int(r))
+}
+
+// ParseRevocationReason accepts the RFC 5280 name of a reason a certificate can be revoked for. Holds
+// and CRL removals have their own operations and are rejected.
+func ParseRevocationReason(name string) (RevocationReason, error) {
+	if name == "" {
+		return ReasonUnspecified, nil
+	}
+
+	for r, n := range revocationReasonNames {
+		if n == name && r != ReasonCertificateHold && r != ReasonRemoveFromCRL {
+			return r, nil
+		}
+	}
+
+	return ReasonUnspecified, errors.Errorf("invalid revocation reason %s", name)
+}
+
+// Revocation records why and by whom a certificate was revoked
+type Revocation struct {
+	Reason    RevocationReason
+	RevokedBy string
+	Comment   string
+}
+
+// CreateCRL signs a CRL for issuer containing the revoked and held certificates it has issued. Certificates
+// issued by a different CA are skipped, as they must be listed on a CRL signed by their own issuer.
+func CreateCRL(issuer *x509.Certificate, privKey crypto.PrivateKey, revoked []*CertificateInfo, validity time.Duration) ([]byte, error) {
+	signer, ok := privKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported key type")
+	}
+
+	now := time.Now().UTC()
+	template := &x509.RevocationList{
+		Number:     big.NewInt(now.Unix()),
+		ThisUpdate: now,
+		NextUpdate: now.Add(validity),
+	}
+
+	for _, cert := range revoked {
+		if cert.Certificate != nil && !bytes.Equal(cert.Certificate.AuthorityKeyId, issuer.SubjectKeyId) {
+			continue
+		}
+
+		entry := x509.RevocationListEntry{
+			SerialNumber: new(big.Int).SetBytes(cert.SerialBytes),
+		}
+
+		switch {
+		case cert.Revoked != nil:
+			entry.RevocationTime = cert.Revoked.UTC()
+			entry.ReasonCode = int(cert.RevocationReason)
+		case cert.OnHold != nil:
+			entry.RevocationTime = cert.OnHold.UTC()
+			entry.ReasonCode = int(ReasonCertificateHold)
+		default:
+			continue
+		}
+
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, entry)
+	}
+
+	return x509.CreateRevocationList(rand.Reader, template, issuer, signer)
+}
+
+// CreateCRL signs a CRL with the CA key, valid until the CA certificate expires. It is used to
+// leave a final CRL behind for the certificates issued by this CA before it is rotated.
+func (k CAData) CreateCRL(revoked []*CertificateInfo) ([]byte, error) {
+	signer, err := k.Signer(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return CreateCRL(k.CACert, signer, revoked, time.Until(k.CACert.NotAfter))
+}
+
+func EncodePEMCRL(crl []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl})
+}
+
+// DecodePEMCRLs parses every CRL in a PEM bundle
+func DecodePEMCRLs(data []byte) ([]*x509.RevocationList, error) {
+	var crls []*x509.RevocationList
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "X509 CRL" {
+			return nil, errors.Errorf("unexpected PEM block %s", block.Type)
+		}
+
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing CRL")
+		}
+		crls = append(crls, crl)
+	}
+
+	if len(crls) == 0 {
+		return nil, errors.New("no CRL found")
+	}
+
+	return crls, nil
+}
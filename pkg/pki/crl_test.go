@@ -0,0 +1,77 @@
+package pki
+
+import (
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCreateCRL(t *testing.T) {
+	caKey, err := NewCAKey(kCAName, uuid.New().String(), KeyAlgorithmRSA2048, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherCAKey, err := NewCAKey(kCAName, uuid.New().String(), KeyAlgorithmRSA2048, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var revoked []*CertificateInfo
+	for _, ca := range []CAData{caKey, otherCAKey} {
+		cert, err := CreateCertificate(ca.CACert, ca.PrivateKey, ca.PublicKey, pkix.Name{CommonName: "test"}, ClientCert, WithDuration(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info := CertInfoFromX509Cert(cert)
+		rt := time.Now().UTC().Truncate(time.Second)
+		info.Revoked = &rt
+		info.RevocationReason = ReasonKeyCompromise
+		revoked = append(revoked, info)
+	}
+
+	heldCert, err := CreateCertificate(caKey.CACert, caKey.PrivateKey, caKey.PublicKey, pkix.Name{CommonName: "held"}, ClientCert, WithDuration(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	held := CertInfoFromX509Cert(heldCert)
+	ht := time.Now().UTC().Truncate(time.Second)
+	held.OnHold = &ht
+	revoked = append(revoked, held)
+
+	der, err := CreateCRL(caKey.CACert, caKey.PrivateKey, revoked, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crls, err := DecodePEMCRLs(EncodePEMCRL(der))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crl := crls[0]
+	if err := crl.CheckSignatureFrom(caKey.CACert); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(crl.RevokedCertificateEntries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(crl.RevokedCertificateEntries))
+	}
+
+	entry := crl.RevokedCertificateEntries[0]
+	if entry.SerialNumber.Cmp(revoked[0].Certificate.SerialNumber) != 0 {
+		t.Fatalf("unexpected serial %x", entry.SerialNumber)
+	}
+
+	if entry.ReasonCode != int(ReasonKeyCompromise) {
+		t.Fatalf("unexpected reason %d", entry.ReasonCode)
+	}
+
+	if entry := crl.RevokedCertificateEntries[1]; entry.ReasonCode != int(ReasonCertificateHold) {
+		t.Fatalf("unexpected reason %d for held certificate", entry.ReasonCode)
+	}
+}
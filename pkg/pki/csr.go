@@ -0,0 +1,74 @@
+package pki
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/pkg/errors"
+)
+
+// CSRPolicy lists the subject alternative names a requester is allowed to ask for. Anything else
+// in a CSR, including its subject and extensions, is never copied into the certificate.
+type CSRPolicy struct {
+	Emails   []string
+	DNSNames []string
+}
+
+// ParseCSR parses a PEM or DER encoded PKCS#10 request and verifies its signature as proof of
+// possession of the private key
+func ParseCSR(data []byte) (*x509.CertificateRequest, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+			return nil, errors.Errorf("unexpected PEM block %s", block.Type)
+		}
+		data = block.Bytes
+	}
+
+	csr, err := x509.ParseCertificateRequest(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing CSR")
+	}
+
+	if err := csr.CheckSignature(); err != nil {
+		return nil, errors.Wrap(err, "verifying CSR signature")
+	}
+
+	return csr, nil
+}
+
+// Options returns the options for the names requested in csr that the policy allows. When csr is
+// nil or doesn't request any allowed name, every name in the policy is used.
+func (p CSRPolicy) Options(csr *x509.CertificateRequest) []CertOptions {
+	emails, dnsNames := p.Emails, p.DNSNames
+	if csr != nil {
+		if requested := intersect(csr.EmailAddresses, p.Emails); len(requested) > 0 {
+			emails = requested
+		}
+		if requested := intersect(csr.DNSNames, p.DNSNames); len(requested) > 0 {
+			dnsNames = requested
+		}
+	}
+
+	var opts []CertOptions
+	if len(emails) > 0 {
+		opts = append(opts, WithEmail(emails...))
+	}
+	if len(dnsNames) > 0 {
+		opts = append(opts, WithDNS(dnsNames...))
+	}
+
+	return opts
+}
+
+func intersect(requested []string, allowed []string) []string {
+	var res []string
+	for _, r := range requested {
+		for _, a := range allowed {
+			if r == a {
+				res = append(res, r)
+				break
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,58 @@
+package pki
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestParseCSR(t *testing.T) {
+	privKey, err := NewPrivateKey(KeyAlgorithmP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:        pkix.Name{CommonName: "someone-else@example.com"},
+		EmailAddresses: []string{"user@example.com", "someone-else@example.com"},
+		DNSNames:       []string{"vpn.example.com"},
+	}, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csr, err := ParseCSR(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caKey, err := NewCAKey(kCAName, uuid.New().String(), KeyAlgorithmRSA2048, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := CSRPolicy{Emails: []string{"user@example.com"}}
+	opts := append(policy.Options(csr), ClientCert, WithDuration(time.Hour))
+	cert, err := CreateCertificate(caKey.CACert, caKey.PrivateKey, csr.PublicKey, pkix.Name{CommonName: "user@example.com"}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cert.EmailAddresses) != 1 || cert.EmailAddresses[0] != "user@example.com" {
+		t.Fatalf("unexpected emails %v", cert.EmailAddresses)
+	}
+
+	if len(cert.DNSNames) != 0 {
+		t.Fatalf("unexpected DNS names %v", cert.DNSNames)
+	}
+
+	der[len(der)-1] ^= 0xff
+	if _, err := ParseCSR(der); err == nil {
+		t.Fatal("expected invalid signature error")
+	}
+}
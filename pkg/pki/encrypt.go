@@ -0,0 +1,101 @@
+package pki
+
+import (
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	kSaltSize  = 32
+	kNonceSize = 24
+
+	kScryptN = 1 << 15
+	kScryptR = 8
+	kScryptP = 1
+)
+
+func deriveKey(passphrase []byte, salt []byte) (*[32]byte, error) {
+	derived, err := scrypt.Key(passphrase, salt, kScryptN, kScryptR, kScryptP, 32)
+	if err != nil {
+		return nil, errors.Wrap(err, "deriving key")
+	}
+
+	var key [32]byte
+	copy(key[:], derived)
+	return &key, nil
+}
+
+// EncryptPEM seals data with a key derived from passphrase and wraps it in a PEM block of the given type
+func EncryptPEM(blockType string, data []byte, passphrase []byte) ([]byte, error) {
+	var salt [kSaltSize]byte
+	var nonce [kNonceSize]byte
+	if _, err := io.ReadFull(rand.Reader, salt[:]); err != nil {
+		return nil, errors.Wrap(err, "generating salt")
+	}
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, errors.Wrap(err, "generating nonce")
+	}
+
+	key, err := deriveKey(passphrase, salt[:])
+	if err != nil {
+		return nil, err
+	}
+
+	out := append(salt[:], nonce[:]...)
+	out = secretbox.Seal(out, data, &nonce, key)
+
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: out}), nil
+}
+
+// DecryptPEM opens a PEM block created by EncryptPEM
+func DecryptPEM(blockType string, data []byte, passphrase []byte) ([]byte, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, errors.Errorf("expected PEM block %s", blockType)
+	}
+
+	if len(block.Bytes) < kSaltSize+kNonceSize+secretbox.Overhead {
+		return nil, errors.New("encrypted data too short")
+	}
+
+	var nonce [kNonceSize]byte
+	copy(nonce[:], block.Bytes[kSaltSize:kSaltSize+kNonceSize])
+
+	key, err := deriveKey(passphrase, block.Bytes[:kSaltSize])
+	if err != nil {
+		return nil, err
+	}
+
+	plain, ok := secretbox.Open(nil, block.Bytes[kSaltSize+kNonceSize:], &nonce, key)
+	if !ok {
+		return nil, errors.New("wrong passphrase or corrupted data")
+	}
+
+	return plain, nil
+}
+
+// Sealer encrypts data at rest, such as the CA data of storages without a secrets service
+type Sealer interface {
+	Seal(blockType string, data []byte) ([]byte, error)
+	Open(blockType string, data []byte) ([]byte, error)
+}
+
+type passphraseSealer []byte
+
+// NewPassphraseSealer seals with EncryptPEM
+func NewPassphraseSealer(passphrase []byte) Sealer {
+	return passphraseSealer(passphrase)
+}
+
+func (s passphraseSealer) Seal(blockType string, data []byte) ([]byte, error) {
+	return EncryptPEM(blockType, data, s)
+}
+
+func (s passphraseSealer) Open(blockType string, data []byte) ([]byte, error) {
+	return DecryptPEM(blockType, data, s)
+}
@@ -0,0 +1,213 @@
+package fspki
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const kCADataPEMType = "VPN CA DATA"
+
+// maybeUpdate reloads the CA data when the file has been replaced
+func (s *fsStorage) maybeUpdate(ctx context.Context) {
+	info, err := os.Stat(s.path(kCADataFile))
+	if err != nil {
+		log.WithError(err).Error("Reading CA data")
+		return
+	}
+
+	if info.ModTime().Equal(s.modTime) {
+		return
+	}
+
+	log.Debug("Updating CA data")
+	data, err := s.readCAData()
+	if err != nil {
+		log.WithError(err).Error("Reading CA data")
+		return
+	}
+
+	s.data = *data
+	s.modTime = info.ModTime()
+}
+
+func (s *fsStorage) readCAData() (*pki.CAData, error) {
+	sealed, err := ioutil.ReadFile(s.path(kCADataFile))
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := s.sealer.Open(kCADataPEMType, sealed)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening CA data")
+	}
+
+	var data pki.CAData
+	if err := data.UnmarshalJSON(plain); err != nil {
+		return nil, errors.Wrap(err, "unmarshalling CA data")
+	}
+
+	return &data, nil
+}
+
+// GetCAData returns the stored CA data, or nil if there is none yet
+func (s *fsStorage) GetCAData(ctx context.Context) (*pki.CAData, error) {
+	data, err := s.readCAData()
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	return data, err
+}
+
+// PutCAData seals data and replaces the CA data file with it
+func (s *fsStorage) PutCAData(ctx context.Context, data pki.CAData) error {
+	plain, err := data.MarshalJSON()
+	if err != nil {
+		return errors.Wrap(err, "marshalling CA data")
+	}
+
+	sealed, err := s.sealer.Seal(kCADataPEMType, plain)
+	if err != nil {
+		return errors.Wrap(err, "sealing CA data")
+	}
+
+	unlock, err := s.lockCAData()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Readers never see a partially written file
+	tmp, err := ioutil.TempFile(s.dir, kCADataFile+".*")
+	if err != nil {
+		return errors.Wrap(err, "writing CA data")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(sealed); err != nil {
+		_ = tmp.Close()
+		return errors.Wrap(err, "writing CA data")
+	}
+
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return errors.Wrap(err, "writing CA data")
+	}
+
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "writing CA data")
+	}
+
+	return errors.Wrap(os.Rename(tmp.Name(), s.path(kCADataFile)), "writing CA data")
+}
+
+func (s *fsStorage) GetCACert(ctx context.Context) *x509.Certificate {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.CACert
+}
+
+func (s *fsStorage) GetPrevCACert(ctx context.Context) *x509.Certificate {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.PrevCACert
+}
+
+func (s *fsStorage) GetCrossCert(ctx context.Context) *x509.Certificate {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.CrossCert
+}
+
+func (s *fsStorage) GetCAChain(ctx context.Context) []*x509.Certificate {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.Chain
+}
+
+func (s *fsStorage) GetSigner(ctx context.Context) (crypto.Signer, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.Signer(ctx)
+}
+
+func (s *fsStorage) GetPublicKey(ctx context.Context) crypto.PublicKey {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.PublicKey
+}
+
+func (s *fsStorage) GetStaticKey(ctx context.Context) pki.StaticKey {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.StaticKey
+}
+
+func (s *fsStorage) GetStaticKeyRotation(ctx context.Context) *pki.StaticKeyRotation {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.StaticKeyRotation
+}
+
+func (s *fsStorage) GetTLSCryptV2Key(ctx context.Context) pki.TLSCryptV2Key {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.TLSCryptV2Key
+}
+
+func (s *fsStorage) GetTLSCryptV2Switch(ctx context.Context) *pki.TLSCryptV2Switch {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.TLSCryptV2Switch
+}
+
+func (s *fsStorage) GetPrevOCSPCert(ctx context.Context) *x509.Certificate {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.PrevOCSPCert
+}
+
+func (s *fsStorage) GetPrevCRL(ctx context.Context) []byte {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.PrevCRL
+}
+
+func (s *fsStorage) GetPrevSigner(ctx context.Context) (crypto.Signer, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.PrevSigner(ctx)
+}
@@ -0,0 +1,432 @@
+package fspki
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// certEntry follows the DynamoDB table, a revocation time in the future is a revocation scheduled after renewal
+type certEntry struct {
+	SerialNumber   []byte     `json:"serialNumber"`
+	AuthorityKeyId []byte     `json:"authorityKeyId"`
+	SubjectKeyId   []byte     `json:"subjectKeyId"`
+	SubjectName    string     `json:"subjectName"`
+	CertType       string     `json:"certType"`
+	IssuedAt       time.Time  `json:"issuedAt"`
+	ValidUntil     time.Time  `json:"validUntil"`
+	RevocationTime *time.Time `json:"revocationTime,omitempty"`
+	HoldTime       *time.Time `json:"holdTime,omitempty"`
+	Data           []byte     `json:"data"`
+	Profile        string     `json:"profile,omitempty"`
+	Predecessor    []byte     `json:"predecessor,omitempty"`
+	Successor      []byte     `json:"successor,omitempty"`
+
+	RevocationReason  int    `json:"revocationReason,omitempty"`
+	RevokedBy         string `json:"revokedBy,omitempty"`
+	RevocationComment string `json:"revocationComment,omitempty"`
+}
+
+func newCertEntry(info *pki.CertificateInfo) (*certEntry, error) {
+	cert := info.Certificate
+	if cert == nil || cert.Raw == nil {
+		return nil, errors.New("missing cert raw data")
+	}
+
+	if cert.AuthorityKeyId == nil {
+		return nil, errors.New("missing Authority Key ID")
+	}
+
+	if cert.SubjectKeyId == nil {
+		return nil, errors.New("missing Subject Key ID")
+	}
+
+	cType := pki.GetCertType(cert)
+	if cType == pki.CertTypeUnknown {
+		return nil, errors.New("unknown certificate type")
+	}
+
+	entry := &certEntry{
+		SerialNumber:   cert.SerialNumber.Bytes(),
+		AuthorityKeyId: cert.AuthorityKeyId,
+		SubjectKeyId:   cert.SubjectKeyId,
+		SubjectName:    cert.Subject.CommonName,
+		CertType:       string(cType),
+		IssuedAt:       cert.NotBefore.UTC(),
+		ValidUntil:     cert.NotAfter.UTC(),
+		Data:           cert.Raw,
+		Profile:        info.Profile,
+	}
+
+	if info.Predecessor != "" {
+		predecessor, err := pki.DecodeSerial(info.Predecessor)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding predecessor serial")
+		}
+		entry.Predecessor = predecessor
+	}
+
+	return entry, nil
+}
+
+func (e *certEntry) toCertificateInfo() (*pki.CertificateInfo, error) {
+	cert, err := x509.ParseCertificate(e.Data)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing certificate")
+	}
+
+	info := &pki.CertificateInfo{
+		Certificate: cert,
+		SerialBytes: e.SerialNumber,
+
+		CertType:  pki.CertType(e.CertType),
+		Serial:    hex.EncodeToString(e.SerialNumber),
+		KeyId:     hex.EncodeToString(e.SubjectKeyId),
+		Subject:   e.SubjectName,
+		NotBefore: e.IssuedAt.UTC(),
+		NotAfter:  e.ValidUntil.UTC(),
+
+		Profile: e.Profile,
+
+		RevocationReason:  pki.RevocationReason(e.RevocationReason),
+		RevokedBy:         e.RevokedBy,
+		RevocationComment: e.RevocationComment,
+	}
+
+	if e.Predecessor != nil {
+		info.Predecessor = hex.EncodeToString(e.Predecessor)
+	}
+
+	if e.Successor != nil {
+		info.Successor = hex.EncodeToString(e.Successor)
+	}
+
+	if e.RevocationTime != nil {
+		rt := e.RevocationTime.UTC()
+		if rt.After(time.Now()) {
+			info.RevokeAt = &rt
+		} else {
+			info.Revoked = &rt
+		}
+	}
+
+	if e.HoldTime != nil && info.Revoked == nil {
+		ht := e.HoldTime.UTC()
+		info.OnHold = &ht
+	}
+
+	return info, nil
+}
+
+func (e *certEntry) revoked(now time.Time) bool {
+	return e.RevocationTime != nil && !e.RevocationTime.After(now)
+}
+
+// subjectKey orders the certificates of a subject by issuance time
+func subjectKey(e *certEntry) []byte {
+	key := append([]byte(e.SubjectName), 0)
+	key = append(key, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(key[len(key)-8:], uint64(e.IssuedAt.Unix()))
+	return append(key, e.SerialNumber...)
+}
+
+func getEntry(tx *bolt.Tx, serial []byte) (*certEntry, error) {
+	data := tx.Bucket(kBucketCerts).Get(serial)
+	if data == nil {
+		return nil, nil
+	}
+
+	var entry certEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, errors.Wrap(err, "unmarshalling certificate entry")
+	}
+
+	return &entry, nil
+}
+
+func putEntry(tx *bolt.Tx, entry *certEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Bucket(kBucketSubjects).Put(subjectKey(entry), nil); err != nil {
+		return err
+	}
+
+	return tx.Bucket(kBucketCerts).Put(entry.SerialNumber, data)
+}
+
+func (s *fsStorage) GetCertBySerial(ctx context.Context, serial []byte) (*pki.CertificateInfo, error) {
+	var info *pki.CertificateInfo
+	err := s.view(func(tx *bolt.Tx) error {
+		entry, err := getEntry(tx, serial)
+		if err != nil || entry == nil {
+			return err
+		}
+
+		info, err = entry.toCertificateInfo()
+		return err
+	})
+
+	return info, err
+}
+
+// listCerts returns the certificates matching filter, skipping the unreadable ones like the DynamoDB scans
+func (s *fsStorage) listCerts(filter func(e *certEntry) bool) ([]*pki.CertificateInfo, error) {
+	certs := make([]*pki.CertificateInfo, 0)
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(kBucketCerts).ForEach(func(k, v []byte) error {
+			var entry certEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				log.WithError(err).Error("Error unmarshalling certificate entry")
+				return nil
+			}
+
+			if !filter(&entry) {
+				return nil
+			}
+
+			info, err := entry.toCertificateInfo()
+			if err != nil {
+				log.WithError(err).Error("Error parsing certificate")
+				return nil
+			}
+
+			certs = append(certs, info)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return certs, nil
+}
+
+func (s *fsStorage) ListAllCerts(ctx context.Context) ([]*pki.CertificateInfo, error) {
+	now := time.Now()
+	return s.listCerts(func(e *certEntry) bool {
+		return e.ValidUntil.After(now) && e.CertType == pki.CertTypeClient
+	})
+}
+
+// ListCerts reads all the certificates, the database has no indexes for the query filters and orders
+func (s *fsStorage) ListCerts(ctx context.Context, query pki.CertQuery) (*pki.CertPage, error) {
+	certs, err := s.listCerts(func(e *certEntry) bool {
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pki.PageCerts(certs, query)
+}
+
+func (s *fsStorage) ListRevokedCerts(ctx context.Context) ([]*pki.CertificateInfo, error) {
+	now := time.Now()
+	return s.listCerts(func(e *certEntry) bool {
+		return e.ValidUntil.After(now) && (e.revoked(now) || e.HoldTime != nil)
+	})
+}
+
+// ListCertsBySubject returns the valid certificates of subjectName, newest first
+func (s *fsStorage) ListCertsBySubject(ctx context.Context, subjectName string) ([]*pki.CertificateInfo, error) {
+	now := time.Now()
+	prefix := append([]byte(subjectName), 0)
+
+	certs := make([]*pki.CertificateInfo, 0)
+	err := s.view(func(tx *bolt.Tx) error {
+		c := tx.Bucket(kBucketSubjects).Cursor()
+
+		// Start after the last key with the prefix and walk backwards
+		k, _ := c.Seek(append([]byte(subjectName), 1))
+		if k == nil {
+			k, _ = c.Last()
+		} else {
+			k, _ = c.Prev()
+		}
+
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Prev() {
+			entry, err := getEntry(tx, k[len(prefix)+8:])
+			if err != nil {
+				log.WithError(err).Error("Error reading certificate entry")
+				continue
+			}
+
+			if entry == nil || !entry.ValidUntil.After(now) {
+				continue
+			}
+
+			info, err := entry.toCertificateInfo()
+			if err != nil {
+				log.WithError(err).Error("Error parsing certificate")
+				continue
+			}
+
+			certs = append(certs, info)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return certs, nil
+}
+
+func (s *fsStorage) AddCert(ctx context.Context, info *pki.CertificateInfo) error {
+	entry, err := newCertEntry(info)
+	if err != nil {
+		return err
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		return putEntry(tx, entry)
+	})
+}
+
+// ImportCert stores info with its revocation, hold and renewal state
+func (s *fsStorage) ImportCert(ctx context.Context, info *pki.CertificateInfo) error {
+	entry, err := newCertEntry(info)
+	if err != nil {
+		return err
+	}
+
+	entry.RevocationReason = int(info.RevocationReason)
+	entry.RevokedBy = info.RevokedBy
+	entry.RevocationComment = info.RevocationComment
+
+	if rt := info.RevocationTime(); rt != nil {
+		t := rt.UTC()
+		entry.RevocationTime = &t
+	}
+
+	if info.OnHold != nil {
+		t := info.OnHold.UTC()
+		entry.HoldTime = &t
+	}
+
+	if info.Successor != "" {
+		if entry.Successor, err = pki.DecodeSerial(info.Successor); err != nil {
+			return errors.Wrap(err, "decoding successor serial")
+		}
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		return putEntry(tx, entry)
+	})
+}
+
+// AddRenewedCert stores info and, in the same transaction, links its predecessor to it and schedules the
+// predecessor's revocation. It fails with pki.ErrNotRenewable if the predecessor was revoked or renewed meanwhile.
+func (s *fsStorage) AddRenewedCert(ctx context.Context, info *pki.CertificateInfo, revokeAt time.Time) error {
+	entry, err := newCertEntry(info)
+	if err != nil {
+		return err
+	}
+
+	if entry.Predecessor == nil {
+		return errors.New("missing predecessor")
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		prev, err := getEntry(tx, entry.Predecessor)
+		if err != nil {
+			return err
+		}
+
+		if prev == nil || prev.RevocationTime != nil || prev.Successor != nil || prev.HoldTime != nil {
+			return pki.ErrNotRenewable
+		}
+
+		rt := revokeAt.UTC()
+		prev.Successor = entry.SerialNumber
+		prev.RevocationTime = &rt
+		prev.RevocationReason = int(pki.ReasonSuperseded)
+
+		if err := putEntry(tx, prev); err != nil {
+			return err
+		}
+
+		return putEntry(tx, entry)
+	})
+}
+
+// updateCert applies change to the certificate in a transaction. It returns nil if there is no such certificate.
+func (s *fsStorage) updateCert(serial []byte, change func(e *certEntry, now time.Time) error) (*pki.CertificateInfo, error) {
+	var info *pki.CertificateInfo
+	err := s.update(func(tx *bolt.Tx) error {
+		entry, err := getEntry(tx, serial)
+		if err != nil || entry == nil {
+			return err
+		}
+
+		if err := change(entry, time.Now().UTC()); err != nil {
+			return err
+		}
+
+		if err := putEntry(tx, entry); err != nil {
+			return err
+		}
+
+		info, err = entry.toCertificateInfo()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+func (s *fsStorage) RevokeCert(ctx context.Context, serial []byte, revocation pki.Revocation) (*pki.CertificateInfo, error) {
+	return s.updateCert(serial, func(e *certEntry, now time.Time) error {
+		// Revoking a certificate scheduled for revocation after renewal brings the revocation forward
+		if e.revoked(now) {
+			return pki.ErrInvalidCertState
+		}
+
+		e.RevocationTime = &now
+		e.RevocationReason = int(revocation.Reason)
+		if revocation.RevokedBy != "" {
+			e.RevokedBy = revocation.RevokedBy
+		}
+		if revocation.Comment != "" {
+			e.RevocationComment = revocation.Comment
+		}
+		return nil
+	})
+}
+
+func (s *fsStorage) HoldCert(ctx context.Context, serial []byte) (*pki.CertificateInfo, error) {
+	return s.updateCert(serial, func(e *certEntry, now time.Time) error {
+		if e.revoked(now) || e.HoldTime != nil {
+			return pki.ErrInvalidCertState
+		}
+
+		e.HoldTime = &now
+		return nil
+	})
+}
+
+func (s *fsStorage) ReinstateCert(ctx context.Context, serial []byte) (*pki.CertificateInfo, error) {
+	return s.updateCert(serial, func(e *certEntry, now time.Time) error {
+		if e.revoked(now) || e.HoldTime == nil {
+			return pki.ErrInvalidCertState
+		}
+
+		e.HoldTime = nil
+		return nil
+	})
+}
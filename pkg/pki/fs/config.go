@@ -0,0 +1,28 @@
+package fspki
+
+import (
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+const kConfigPrefix = "PKI_FS"
+
+var configFSPKI struct {
+	Dir string `default:"/var/lib/aws-vpn/pki"`
+
+	// LockTimeout is how long to wait for other processes using the certificate database
+	LockTimeout time.Duration `split_words:"true" default:"10s"`
+
+	// Log enables the issuance log
+	Log bool
+}
+
+func init() {
+	envconfig.MustProcess(kConfigPrefix, &configFSPKI)
+}
+
+// LogEnabled tells whether the issuance log has been enabled
+func LogEnabled() bool {
+	return configFSPKI.Log
+}
@@ -0,0 +1,141 @@
+package fspki
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	kCADataFile = "ca.pem"
+	kCALockFile = "ca.lock"
+	kDBFile     = "certs.db"
+)
+
+var (
+	kBucketCerts     = []byte("certs")
+	kBucketSubjects  = []byte("subjects")
+	kBucketLog       = []byte("log")
+	kBucketTreeHeads = []byte("treeheads")
+	kBuckets         = [][]byte{kBucketCerts, kBucketSubjects, kBucketLog, kBucketTreeHeads}
+)
+
+// fsStorage keeps the sealed CA data in a file and the certificates in a bbolt database in the same directory.
+// The database is only open during each operation, so the API and the command line tools can share it.
+type fsStorage struct {
+	dir    string
+	sealer pki.Sealer
+
+	data    pki.CAData
+	mut     sync.Mutex
+	modTime time.Time
+}
+
+func NewFSStorage(dir string, sealer pki.Sealer) *fsStorage {
+	return &fsStorage{
+		dir:    dir,
+		sealer: sealer,
+	}
+}
+
+// NewFSStorageFromEnv uses the directory in PKI_FS_DIR
+func NewFSStorageFromEnv(sealer pki.Sealer) *fsStorage {
+	return NewFSStorage(configFSPKI.Dir, sealer)
+}
+
+func (s *fsStorage) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// openDB opens the database for writing, or shared with other readers. Either waits for the lock up to
+// PKI_FS_LOCK_TIMEOUT.
+func (s *fsStorage) openDB(readOnly bool) (*bolt.DB, error) {
+	path := s.path(kDBFile)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		readOnly = false
+	}
+
+	if !readOnly {
+		if err := os.MkdirAll(s.dir, 0700); err != nil {
+			return nil, errors.Wrap(err, "creating storage directory")
+		}
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: configFSPKI.LockTimeout, ReadOnly: readOnly})
+	if err != nil {
+		return nil, errors.Wrap(err, "opening certificate database")
+	}
+
+	if !readOnly {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			for _, name := range kBuckets {
+				if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			_ = db.Close()
+			return nil, errors.Wrap(err, "initializing certificate database")
+		}
+	}
+
+	return db, nil
+}
+
+func (s *fsStorage) view(fn func(tx *bolt.Tx) error) error {
+	db, err := s.openDB(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.View(fn)
+}
+
+func (s *fsStorage) update(fn func(tx *bolt.Tx) error) error {
+	db, err := s.openDB(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(fn)
+}
+
+// lockCAData takes an exclusive lock for writing the CA data
+func (s *fsStorage) lockCAData() (func(), error) {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "creating storage directory")
+	}
+
+	f, err := os.OpenFile(s.path(kCALockFile), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening lock file")
+	}
+
+	deadline := time.Now().Add(configFSPKI.LockTimeout)
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+
+		if err != syscall.EWOULDBLOCK || time.Now().After(deadline) {
+			_ = f.Close()
+			return nil, errors.Wrap(err, "locking CA data")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		_ = f.Close()
+	}, nil
+}
@@ -0,0 +1,154 @@
+package fspki
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/empathybroker/aws-vpn/pkg/pki/pkitest"
+	"github.com/google/uuid"
+)
+
+func TestFSStorage(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	storage := NewFSStorage(dir, pki.NewPassphraseSealer([]byte("test")))
+	if data, err := storage.GetCAData(ctx); err != nil || data != nil {
+		t.Fatalf("expected empty storage, got %v %v", data, err)
+	}
+
+	caKey, err := pki.NewCAKey("Test CA", uuid.New().String(), pki.KeyAlgorithmP256, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.PutCAData(ctx, caKey); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another instance, e.g. another process, reads the same data with the passphrase only
+	if _, err := NewFSStorage(dir, pki.NewPassphraseSealer([]byte("wrong"))).GetCAData(ctx); err == nil {
+		t.Fatal("expected error with the wrong passphrase")
+	}
+
+	storage = NewFSStorage(dir, pki.NewPassphraseSealer([]byte("test")))
+	if !storage.GetCACert(ctx).Equal(caKey.CACert) {
+		t.Fatal("unexpected CA certificate")
+	}
+
+	fsPKI := pki.NewPKI(storage)
+	fsPKI.SetIssuanceLog(storage)
+
+	profile, err := pki.Profiles.Get(pki.ProfileLaptop)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var issued []*pki.CertificateInfo
+	for i := 0; i < 3; i++ {
+		privKey, err := pki.NewPrivateKey(pki.KeyAlgorithmP256)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		notBefore := time.Now().Add(time.Duration(i-3) * time.Second)
+		info, err := fsPKI.CreateCertificate(ctx, pki.GetPublicKey(privKey), pkix.Name{CommonName: "user@example.com"}, profile,
+			pki.WithTimespan(notBefore, notBefore.Add(time.Hour)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		issued = append(issued, info)
+	}
+
+	certs, err := storage.ListCertsBySubject(ctx, "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(certs) != 3 || certs[0].Serial != issued[2].Serial || certs[2].Serial != issued[0].Serial {
+		t.Fatal("expected the certificates of the subject newest first")
+	}
+
+	if certs, err := storage.ListCertsBySubject(ctx, "user@example"); err != nil || len(certs) != 0 {
+		t.Fatal("subject lookup must not match prefixes")
+	}
+
+	if _, err := fsPKI.HoldCert(ctx, issued[0].SerialBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fsPKI.HoldCert(ctx, issued[0].SerialBytes); err != pki.ErrInvalidCertState {
+		t.Fatalf("expected ErrInvalidCertState holding twice, got %v", err)
+	}
+
+	if _, err := fsPKI.RevokeCert(ctx, issued[1].SerialBytes, pki.Revocation{Reason: pki.ReasonKeyCompromise, RevokedBy: "admin@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	revoked, err := storage.ListRevokedCerts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(revoked) != 2 {
+		t.Fatalf("expected a held and a revoked certificate, got %d", len(revoked))
+	}
+
+	info, err := fsPKI.ReinstateCert(ctx, issued[0].SerialBytes)
+	if err != nil || info.OnHold != nil {
+		t.Fatalf("unexpected reinstate result %v %v", info, err)
+	}
+
+	privKey, err := pki.NewPrivateKey(pki.KeyAlgorithmP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renewed, err := fsPKI.RenewCertificate(ctx, issued[2], pki.GetPublicKey(privKey), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev, err := storage.GetCertBySerial(ctx, issued[2].SerialBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if prev.Successor != renewed.Serial || prev.RevokeAt == nil || prev.Revoked != nil {
+		t.Fatal("expected the predecessor to be scheduled for revocation")
+	}
+
+	if err := storage.AddRenewedCert(ctx, renewed, time.Now()); err == nil {
+		t.Fatal("expected error renewing twice")
+	}
+
+	if missing, err := storage.RevokeCert(ctx, []byte{1, 2, 3}, pki.Revocation{}); err != nil || missing != nil {
+		t.Fatal("expected nil revoking an unknown certificate")
+	}
+
+	if _, err := fsPKI.SignTreeHead(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := fsPKI.VerifyIssuanceLog(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(problems) > 0 {
+		t.Fatalf("issuance log problems: %v", problems)
+	}
+}
+
+func TestFSStorageConformance(t *testing.T) {
+	pkitest.RunStorageTests(t, func(t *testing.T) pki.PKIStorage {
+		return NewFSStorage(t.TempDir(), pki.NewPassphraseSealer([]byte("test")))
+	})
+
+	pkitest.RunLogStorageTests(t, func(t *testing.T) pki.LogStorage {
+		return NewFSStorage(t.TempDir(), pki.NewPassphraseSealer([]byte("test")))
+	})
+}
@@ -0,0 +1,95 @@
+package fspki
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	bolt "go.etcd.io/bbolt"
+)
+
+func indexKey(index uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, index)
+	return key
+}
+
+func (s *fsStorage) AppendLogEntry(ctx context.Context, entry *pki.LogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(kBucketLog)
+
+		// Entries are never overwritten, a concurrent writer got this index first
+		if b.Get(indexKey(entry.Index)) != nil {
+			return pki.ErrLogConflict
+		}
+
+		return b.Put(indexKey(entry.Index), data)
+	})
+}
+
+func (s *fsStorage) GetLogHead(ctx context.Context) (*pki.LogEntry, error) {
+	var head *pki.LogEntry
+	err := s.view(func(tx *bolt.Tx) error {
+		_, data := tx.Bucket(kBucketLog).Cursor().Last()
+		if data == nil {
+			return nil
+		}
+
+		head = &pki.LogEntry{}
+		return json.Unmarshal(data, head)
+	})
+
+	return head, err
+}
+
+func (s *fsStorage) ListLogEntries(ctx context.Context, from uint64, limit int) ([]*pki.LogEntry, error) {
+	entries := make([]*pki.LogEntry, 0)
+	err := s.view(func(tx *bolt.Tx) error {
+		c := tx.Bucket(kBucketLog).Cursor()
+		for k, data := c.Seek(indexKey(from)); k != nil && len(entries) < limit; k, data = c.Next() {
+			var entry pki.LogEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return err
+			}
+			entries = append(entries, &entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (s *fsStorage) PutTreeHead(ctx context.Context, th *pki.TreeHead) error {
+	data, err := json.Marshal(th)
+	if err != nil {
+		return err
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(kBucketTreeHeads).Put(indexKey(uint64(th.Timestamp.UnixNano())), data)
+	})
+}
+
+func (s *fsStorage) GetTreeHead(ctx context.Context) (*pki.TreeHead, error) {
+	var head *pki.TreeHead
+	err := s.view(func(tx *bolt.Tx) error {
+		_, data := tx.Bucket(kBucketTreeHeads).Cursor().Last()
+		if data == nil {
+			return nil
+		}
+
+		head = &pki.TreeHead{}
+		return json.Unmarshal(data, head)
+	})
+
+	return head, err
+}
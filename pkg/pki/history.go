@@ -0,0 +1,119 @@
+package pki
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrCAVersionNotFound = errors.New("CA version not found")
+	ErrCADataChanged     = errors.New("CA data changed since it was read")
+	ErrCARotationPending = errors.New("CA rotation pending")
+)
+
+// CAVersion is a stored version of the CA data
+type CAVersion struct {
+	Id      string
+	Created time.Time
+	Current bool
+
+	// Stages are the labels of the version in storages which have them, like Secrets Manager
+	Stages []string
+
+	// Data is nil when the version can't be decoded, Err tells why
+	Data *CAData
+	Err  error
+}
+
+// CAHistory is implemented by the storages which keep the previous versions of the CA data
+type CAHistory interface {
+	// ListCAVersions returns the versions, newest first
+	ListCAVersions(ctx context.Context) ([]*CAVersion, error)
+	// RollbackCA makes the version current again
+	RollbackCA(ctx context.Context, id string) error
+}
+
+// CAUpdater is implemented by the storages which can write the CA data only over the version it was read from, so
+// jobs changing part of it don't undo a concurrent write
+type CAUpdater interface {
+	// GetCADataVersion returns the current CA data and its version, or nil if there is none yet
+	GetCADataVersion(ctx context.Context) (*CAData, string, error)
+	// UpdateCAData writes data if version is still the current one, otherwise it returns ErrCADataChanged. It
+	// returns ErrCARotationPending while a CA rotation would overwrite data when it finishes.
+	UpdateCAData(ctx context.Context, data CAData, version string) error
+}
+
+// CAIssuer is a CA certificate with the versions of the CA data holding it and the certificates it issued
+type CAIssuer struct {
+	CACert   *x509.Certificate
+	Versions []*CAVersion
+	Certs    []*CertificateInfo
+}
+
+// GroupByIssuer matches the certificates to the CA certificates of the versions by their AuthorityKeyId. The
+// certificates issued by none of them are returned apart.
+func GroupByIssuer(versions []*CAVersion, certs []*CertificateInfo) ([]*CAIssuer, []*CertificateInfo) {
+	var issuers []*CAIssuer
+	for _, v := range versions {
+		if v.Data == nil {
+			continue
+		}
+
+		var issuer *CAIssuer
+		for _, i := range issuers {
+			if i.CACert.Equal(v.Data.CACert) {
+				issuer = i
+				break
+			}
+		}
+
+		if issuer == nil {
+			issuer = &CAIssuer{CACert: v.Data.CACert}
+			issuers = append(issuers, issuer)
+		}
+		issuer.Versions = append(issuer.Versions, v)
+	}
+
+	var unknown []*CertificateInfo
+	for _, info := range certs {
+		found := false
+		for _, issuer := range issuers {
+			if info.Certificate != nil && len(info.Certificate.AuthorityKeyId) > 0 &&
+				bytes.Equal(info.Certificate.AuthorityKeyId, issuer.CACert.SubjectKeyId) {
+				issuer.Certs = append(issuer.Certs, info)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			unknown = append(unknown, info)
+		}
+	}
+
+	return issuers, unknown
+}
+
+// ControlKeysDiff lists the control channel keys which differ between a and b. Clients only connect with the keys
+// in their profiles, so replacing them disconnects the clients which got the other ones.
+func ControlKeysDiff(a CAData, b CAData) []string {
+	var diff []string
+	if !bytes.Equal(a.StaticKey, b.StaticKey) {
+		diff = append(diff, "static key")
+	}
+
+	if (a.StaticKeyRotation == nil) != (b.StaticKeyRotation == nil) ||
+		a.StaticKeyRotation != nil && !bytes.Equal(a.StaticKeyRotation.NextKey, b.StaticKeyRotation.NextKey) {
+		diff = append(diff, "next static key")
+	}
+
+	if !bytes.Equal(a.TLSCryptV2Key, b.TLSCryptV2Key) {
+		diff = append(diff, "tls-crypt-v2 key")
+	}
+
+	return diff
+}
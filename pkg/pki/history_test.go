@@ -0,0 +1,96 @@
+package pki
+
+import (
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestGroupByIssuer(t *testing.T) {
+	caKey, err := NewCAKey(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renewed, err := caKey.Renew(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := NewCAKey(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issue := func(ca CAData) *CertificateInfo {
+		cert, err := CreateCertificate(ca.CACert, ca.PrivateKey, ca.PublicKey, pkix.Name{CommonName: "test"}, ClientCert, WithDuration(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return CertInfoFromX509Cert(cert)
+	}
+
+	oldCert, newCert, otherCert := issue(caKey), issue(renewed), issue(other)
+
+	// The current data was stored twice, e.g. after rotating the static key
+	versions := []*CAVersion{
+		{Id: "3", Current: true, Data: &renewed},
+		{Id: "2", Data: &renewed},
+		{Id: "1", Data: &caKey},
+		{Id: "0"},
+	}
+
+	issuers, unknown := GroupByIssuer(versions, []*CertificateInfo{oldCert, newCert, otherCert})
+	if len(issuers) != 2 {
+		t.Fatalf("expected 2 issuers, got %d", len(issuers))
+	}
+
+	if len(issuers[0].Versions) != 2 || len(issuers[0].Certs) != 1 || issuers[0].Certs[0] != newCert {
+		t.Errorf("unexpected current issuer %+v", issuers[0])
+	}
+
+	if len(issuers[1].Versions) != 1 || len(issuers[1].Certs) != 1 || issuers[1].Certs[0] != oldCert {
+		t.Errorf("unexpected previous issuer %+v", issuers[1])
+	}
+
+	if len(unknown) != 1 || unknown[0] != otherCert {
+		t.Errorf("unexpected unknown certificates %v", unknown)
+	}
+}
+
+func TestControlKeysDiff(t *testing.T) {
+	caKey, err := NewCAKey(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renewed, err := caKey.Renew(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := ControlKeysDiff(caKey, renewed); len(diff) != 0 {
+		t.Errorf("renewal changed the control channel keys: %v", diff)
+	}
+
+	rotating, err := renewed.StartStaticKeyRotation(time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := ControlKeysDiff(caKey, rotating); len(diff) != 1 || diff[0] != "next static key" {
+		t.Errorf("expected the next static key to differ, got %v", diff)
+	}
+
+	retired, err := rotating.RetireStaticKey(rotating.StaticKeyRotation.OverlapEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retired.TLSCryptV2Key = NewTLSCryptV2Key()
+
+	if diff := ControlKeysDiff(caKey, retired); len(diff) != 2 {
+		t.Errorf("expected the static and tls-crypt-v2 keys to differ, got %v", diff)
+	}
+}
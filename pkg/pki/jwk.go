@@ -0,0 +1,33 @@
+package pki
+
+import (
+	"crypto/ed25519"
+
+	xed25519 "golang.org/x/crypto/ed25519"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// NewJSONWebKey wraps key in a JWK. go-jose only understands the golang.org/x/crypto Ed25519 types,
+// so standard library Ed25519 keys are converted.
+func NewJSONWebKey(key interface{}) jose.JSONWebKey {
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		key = xed25519.PrivateKey(k)
+	case ed25519.PublicKey:
+		key = xed25519.PublicKey(k)
+	}
+
+	return jose.JSONWebKey{Key: key}
+}
+
+// JSONWebKeyKey returns the key in jwk, converting Ed25519 keys back to the standard library types
+func JSONWebKeyKey(jwk jose.JSONWebKey) interface{} {
+	switch k := jwk.Key.(type) {
+	case xed25519.PrivateKey:
+		return ed25519.PrivateKey(k)
+	case xed25519.PublicKey:
+		return ed25519.PublicKey(k)
+	}
+
+	return jwk.Key
+}
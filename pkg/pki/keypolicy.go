@@ -0,0 +1,166 @@
+package pki
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+)
+
+const (
+	KeyRejectedAlgorithm   = "algorithm_not_allowed"
+	KeyRejectedRSASize     = "rsa_modulus_too_small"
+	KeyRejectedRSAExponent = "rsa_exponent_invalid"
+	KeyRejectedCurve       = "curve_not_allowed"
+	KeyRejectedDebianWeak  = "debian_weak_key"
+	KeyRejectedROCA        = "roca_vulnerable_key"
+	KeyRejectedUnsupported = "unsupported_key_type"
+)
+
+const (
+	kDebianFingerprintChars = 20
+)
+
+// KeyPolicyError explains why a public key was refused. Reason is meant to be machine readable.
+type KeyPolicyError struct {
+	Reason  string
+	Message string
+}
+
+func (e *KeyPolicyError) Error() string {
+	return fmt.Sprintf("key rejected by policy (%s): %s", e.Reason, e.Message)
+}
+
+// KeyPolicy decides which public keys the CA accepts to sign
+type KeyPolicy struct {
+	AllowedAlgorithms []x509.PublicKeyAlgorithm
+	AllowedCurves     []elliptic.Curve
+	MinRSABits        int
+
+	// DebianWeakKeys holds fingerprints in the openssl-blacklist format
+	DebianWeakKeys map[string]struct{}
+}
+
+func (p KeyPolicy) Check(key crypto.PublicKey) error {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if !p.allowsAlgorithm(x509.RSA) {
+			return &KeyPolicyError{KeyRejectedAlgorithm, "RSA keys are not allowed"}
+		}
+
+		if key.N.BitLen() < p.MinRSABits {
+			return &KeyPolicyError{KeyRejectedRSASize, fmt.Sprintf("RSA modulus must be at least %d bits, got %d", p.MinRSABits, key.N.BitLen())}
+		}
+
+		if key.E < 3 || key.E%2 == 0 {
+			return &KeyPolicyError{KeyRejectedRSAExponent, fmt.Sprintf("invalid public exponent %d", key.E)}
+		}
+
+		if _, ok := p.DebianWeakKeys[DebianWeakKeyFingerprint(key)]; ok {
+			return &KeyPolicyError{KeyRejectedDebianWeak, "key was generated by a vulnerable Debian OpenSSL"}
+		}
+
+		if IsROCAVulnerable(key) {
+			return &KeyPolicyError{KeyRejectedROCA, "key is vulnerable to ROCA (CVE-2017-15361)"}
+		}
+	case *ecdsa.PublicKey:
+		if !p.allowsAlgorithm(x509.ECDSA) {
+			return &KeyPolicyError{KeyRejectedAlgorithm, "ECDSA keys are not allowed"}
+		}
+
+		if !p.allowsCurve(key.Curve) {
+			return &KeyPolicyError{KeyRejectedCurve, fmt.Sprintf("curve %s is not allowed", key.Curve.Params().Name)}
+		}
+	case ed25519.PublicKey:
+		if !p.allowsAlgorithm(x509.Ed25519) {
+			return &KeyPolicyError{KeyRejectedAlgorithm, "Ed25519 keys are not allowed"}
+		}
+	default:
+		return &KeyPolicyError{KeyRejectedUnsupported, fmt.Sprintf("unsupported key type %T", key)}
+	}
+
+	return nil
+}
+
+func (p KeyPolicy) allowsAlgorithm(alg x509.PublicKeyAlgorithm) bool {
+	for _, a := range p.AllowedAlgorithms {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+func (p KeyPolicy) allowsCurve(curve elliptic.Curve) bool {
+	for _, c := range p.AllowedCurves {
+		if c.Params().Name == curve.Params().Name {
+			return true
+		}
+	}
+	return false
+}
+
+// DebianWeakKeyFingerprint computes the fingerprint used by openssl-vulnkey: the last 80 bits of the
+// SHA-1 of the "Modulus=" line printed by openssl
+func DebianWeakKeyFingerprint(key *rsa.PublicKey) string {
+	h := sha1.Sum([]byte(fmt.Sprintf("Modulus=%X\n", key.N)))
+	fp := hex.EncodeToString(h[:])
+	return fp[len(fp)-kDebianFingerprintChars:]
+}
+
+// LoadDebianWeakKeys reads an openssl-blacklist file, ignoring comments
+func LoadDebianWeakKeys(r io.Reader, keys map[string]struct{}) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if len(line) != kDebianFingerprintChars {
+			return fmt.Errorf("invalid fingerprint %q", line)
+		}
+		keys[strings.ToLower(line)] = struct{}{}
+	}
+
+	return scanner.Err()
+}
+
+// Small primes used by the ROCA fingerprint. Moduli generated by the vulnerable Infineon library are
+// always in the subgroup generated by 65537 modulo each of them.
+var kROCAPrimes = []int64{
+	3, 5, 7, 11, 13, 17, 19, 23, 29, 31, 37, 41, 43, 47, 53, 59, 61, 67, 71, 73, 79, 83, 89, 97,
+	101, 103, 107, 109, 113, 127, 131, 137, 139, 149, 151, 157, 163, 167,
+}
+
+// IsROCAVulnerable implements the fingerprint test from "The Return of Coppersmith's Attack"
+func IsROCAVulnerable(key *rsa.PublicKey) bool {
+	mod := new(big.Int)
+	for _, p := range kROCAPrimes {
+		r := mod.Mod(key.N, big.NewInt(p)).Int64()
+
+		found := false
+		for g, i := int64(1), int64(0); i < p; i++ {
+			if g == r {
+				found = true
+				break
+			}
+			g = (g * 65537) % p
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
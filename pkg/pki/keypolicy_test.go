@@ -0,0 +1,86 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"strings"
+	"testing"
+)
+
+func TestKeyPolicy(t *testing.T) {
+	policy := KeyPolicy{
+		AllowedAlgorithms: []x509.PublicKeyAlgorithm{x509.RSA, x509.ECDSA},
+		AllowedCurves:     []elliptic.Curve{elliptic.P256()},
+		MinRSABits:        2048,
+		DebianWeakKeys:    make(map[string]struct{}),
+	}
+
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	good, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ed, err := NewPrivateKey(KeyAlgorithmEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	evenExp := good.PublicKey
+	evenExp.E = 65536
+
+	if err := LoadDebianWeakKeys(strings.NewReader("# test\n"+DebianWeakKeyFingerprint(&good.PublicKey)+"\n"), policy.DebianWeakKeys); err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		key    interface{}
+		reason string
+	}{
+		{"rsa-1024", &small.PublicKey, KeyRejectedRSASize},
+		{"rsa-even-exponent", &evenExp, KeyRejectedRSAExponent},
+		{"rsa-debian-weak", &good.PublicKey, KeyRejectedDebianWeak},
+		{"p384", &p384.PublicKey, KeyRejectedCurve},
+		{"ed25519", GetPublicKey(ed), KeyRejectedAlgorithm},
+		{"rsa-2048", &other.PublicKey, ""},
+		{"p256", &p256.PublicKey, ""},
+	}
+
+	for _, test := range tests {
+		err := policy.Check(test.key)
+		if test.reason == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", test.name, err)
+			}
+			continue
+		}
+
+		policyErr, ok := err.(*KeyPolicyError)
+		if !ok || policyErr.Reason != test.reason {
+			t.Errorf("%s: expected %s, got %v", test.name, test.reason, err)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package pki
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type CertStatus string
+
+const (
+	CertStatusActive  CertStatus = "active"
+	CertStatusRevoked CertStatus = "revoked"
+	CertStatusExpired CertStatus = "expired"
+)
+
+type CertSortField string
+
+const (
+	SortByIssuedAt  CertSortField = "issuedAt"
+	SortByExpiresAt CertSortField = "expiresAt"
+
+	DefaultCertQueryLimit = 100
+	MaxCertQueryLimit     = 1000
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// CertQuery selects a page of certificates. Zero values don't filter. The statuses are exclusive: expired
+// certificates are never active nor revoked, and certificates on hold count as revoked.
+//
+// The memory, fs and sql storages sort across pages. The aws storage pages in table order, see UnsortedPager.
+type CertQuery struct {
+	Type          CertType
+	Status        CertStatus
+	SubjectPrefix string
+	KeyId         string
+
+	// The ranges include the start and exclude the end
+	IssuedAfter   time.Time
+	IssuedBefore  time.Time
+	ExpiresAfter  time.Time
+	ExpiresBefore time.Time
+
+	SortBy     CertSortField
+	Descending bool
+	Limit      int
+
+	// Cursor is the NextCursor of the previous page, for the same query. It's opaque, each storage decodes its own.
+	Cursor string
+}
+
+// UnsortedPager is implemented by the storages whose ListCerts walks the certificates in an order of their own and
+// only sorts the certificates within each page
+type UnsortedPager interface {
+	UnsortedPages()
+}
+
+// CertPage holds up to the limit of certificates. Storages which cap the items read per page may return fewer, or
+// none, with a NextCursor to continue from.
+type CertPage struct {
+	Certs      []*CertificateInfo `json:"certs"`
+	NextCursor string             `json:"nextCursor,omitempty"`
+}
+
+// CertCursor is the sort key of the last certificate of a page. Ties in the sort field are ordered by serial.
+type CertCursor struct {
+	Time   time.Time
+	Serial string
+}
+
+// Normalize validates q and fills in the default sort and limit
+func (q CertQuery) Normalize() (CertQuery, error) {
+	switch q.Type {
+	case "", CertTypeClient, CertTypeServer, CertTypeCA:
+	default:
+		return q, errors.Errorf("invalid certificate type %q", q.Type)
+	}
+
+	switch q.Status {
+	case "", CertStatusActive, CertStatusRevoked, CertStatusExpired:
+	default:
+		return q, errors.Errorf("invalid status %q", q.Status)
+	}
+
+	switch q.SortBy {
+	case "":
+		q.SortBy = SortByIssuedAt
+	case SortByIssuedAt, SortByExpiresAt:
+	default:
+		return q, errors.Errorf("invalid sort field %q", q.SortBy)
+	}
+
+	if q.KeyId != "" {
+		if _, err := hex.DecodeString(q.KeyId); err != nil {
+			return q, errors.Wrap(err, "invalid key ID")
+		}
+		q.KeyId = strings.ToLower(q.KeyId)
+	}
+
+	if q.Limit == 0 {
+		q.Limit = DefaultCertQueryLimit
+	} else if q.Limit < 0 || q.Limit > MaxCertQueryLimit {
+		return q, errors.Errorf("limit must be between 1 and %d", MaxCertQueryLimit)
+	}
+
+	return q, nil
+}
+
+// Status tells whether info is active, revoked or expired at now
+func (info *CertificateInfo) Status(now time.Time) CertStatus {
+	switch {
+	case !info.NotAfter.After(now):
+		return CertStatusExpired
+	case info.Revoked != nil || info.OnHold != nil:
+		return CertStatusRevoked
+	default:
+		return CertStatusActive
+	}
+}
+
+// Matches applies the filters of q to info
+func (q CertQuery) Matches(info *CertificateInfo, now time.Time) bool {
+	switch {
+	case q.Type != "" && info.CertType != q.Type:
+		return false
+	case q.Status != "" && info.Status(now) != q.Status:
+		return false
+	case !strings.HasPrefix(info.Subject, q.SubjectPrefix):
+		return false
+	case q.KeyId != "" && info.KeyId != q.KeyId:
+		return false
+	case !q.IssuedAfter.IsZero() && info.NotBefore.Before(q.IssuedAfter):
+		return false
+	case !q.IssuedBefore.IsZero() && !info.NotBefore.Before(q.IssuedBefore):
+		return false
+	case !q.ExpiresAfter.IsZero() && info.NotAfter.Before(q.ExpiresAfter):
+		return false
+	case !q.ExpiresBefore.IsZero() && !info.NotAfter.Before(q.ExpiresBefore):
+		return false
+	}
+
+	return true
+}
+
+// SortKey returns the position of info in the order of q
+func (q CertQuery) SortKey(info *CertificateInfo) CertCursor {
+	t := info.NotBefore
+	if q.SortBy == SortByExpiresAt {
+		t = info.NotAfter
+	}
+
+	return CertCursor{Time: t.UTC().Truncate(time.Second), Serial: info.Serial}
+}
+
+// Before tells whether a comes before b in the order of q
+func (q CertQuery) Before(a, b CertCursor) bool {
+	less := a.Time.Before(b.Time) || (a.Time.Equal(b.Time) && a.Serial < b.Serial)
+	if q.Descending {
+		return !less && a != b
+	}
+	return less
+}
+
+// After decodes the cursor of q for the storages which sort across pages, it is nil for the first page
+func (q CertQuery) After() (*CertCursor, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	parts := strings.SplitN(string(data), ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+
+	unix, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	if _, err := hex.DecodeString(parts[1]); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &CertCursor{Time: time.Unix(unix, 0).UTC(), Serial: parts[1]}, nil
+}
+
+// Encode returns the opaque cursor passed in CertQuery.Cursor
+func (c CertCursor) Encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.Time.Unix(), 10) + "." + c.Serial))
+}
+
+// PageCerts returns the page of certs selected by q, for storages which can't filter and sort themselves
+func PageCerts(certs []*CertificateInfo, q CertQuery) (*CertPage, error) {
+	q, err := q.Normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := q.After()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	selected := make([]*CertificateInfo, 0)
+	for _, info := range certs {
+		if !q.Matches(info, now) {
+			continue
+		}
+
+		if after != nil && !q.Before(*after, q.SortKey(info)) {
+			continue
+		}
+
+		selected = append(selected, info)
+	}
+
+	sort.Slice(selected, func(i, j int) bool {
+		return q.Before(q.SortKey(selected[i]), q.SortKey(selected[j]))
+	})
+
+	return NewCertPage(selected, q), nil
+}
+
+// NewCertPage returns the first q.Limit certificates, sorted and past the cursor, with the cursor for the next
+// page if there are more
+func NewCertPage(certs []*CertificateInfo, q CertQuery) *CertPage {
+	if len(certs) <= q.Limit {
+		return &CertPage{Certs: certs}
+	}
+
+	certs = certs[:q.Limit]
+	return &CertPage{
+		Certs:      certs,
+		NextCursor: q.SortKey(certs[len(certs)-1]).Encode(),
+	}
+}
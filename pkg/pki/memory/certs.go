@@ -0,0 +1,289 @@
+package mempki
+
+import (
+	"context"
+	"encoding/hex"
+	"sort"
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/pkg/errors"
+)
+
+// certEntry follows the DynamoDB table, a revocation time in the future is a revocation scheduled after renewal
+type certEntry struct {
+	info pki.CertificateInfo
+
+	revocationTime *time.Time
+	holdTime       *time.Time
+	successor      string
+}
+
+func (e *certEntry) revoked(now time.Time) bool {
+	return e.revocationTime != nil && !e.revocationTime.After(now)
+}
+
+// toCertificateInfo returns a copy, callers can't change the stored entry
+func (e *certEntry) toCertificateInfo() *pki.CertificateInfo {
+	info := e.info
+	info.Successor = e.successor
+	info.Revoked, info.RevokeAt, info.OnHold = nil, nil, nil
+
+	if e.revocationTime != nil {
+		rt := *e.revocationTime
+		if rt.After(time.Now()) {
+			info.RevokeAt = &rt
+		} else {
+			info.Revoked = &rt
+		}
+	}
+
+	if e.holdTime != nil && info.Revoked == nil {
+		ht := *e.holdTime
+		info.OnHold = &ht
+	}
+
+	return &info
+}
+
+func newCertEntry(info *pki.CertificateInfo) (*certEntry, error) {
+	cert := info.Certificate
+	if cert == nil || cert.Raw == nil {
+		return nil, errors.New("missing cert raw data")
+	}
+
+	if cert.AuthorityKeyId == nil {
+		return nil, errors.New("missing Authority Key ID")
+	}
+
+	if cert.SubjectKeyId == nil {
+		return nil, errors.New("missing Subject Key ID")
+	}
+
+	if cType := pki.GetCertType(cert); cType == pki.CertTypeUnknown {
+		return nil, errors.New("unknown certificate type")
+	}
+
+	entry := &certEntry{
+		info: pki.CertificateInfo{
+			Certificate: cert,
+			SerialBytes: cert.SerialNumber.Bytes(),
+
+			CertType:  pki.GetCertType(cert),
+			Serial:    hex.EncodeToString(cert.SerialNumber.Bytes()),
+			KeyId:     hex.EncodeToString(cert.SubjectKeyId),
+			Subject:   cert.Subject.CommonName,
+			NotBefore: cert.NotBefore.UTC(),
+			NotAfter:  cert.NotAfter.UTC(),
+
+			Profile: info.Profile,
+		},
+	}
+
+	if info.Predecessor != "" {
+		predecessor, err := pki.DecodeSerial(info.Predecessor)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding predecessor serial")
+		}
+		entry.info.Predecessor = hex.EncodeToString(predecessor)
+	}
+
+	return entry, nil
+}
+
+func (s *memStorage) GetCertBySerial(ctx context.Context, serial []byte) (*pki.CertificateInfo, error) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	entry, ok := s.certs[hex.EncodeToString(serial)]
+	if !ok {
+		return nil, nil
+	}
+
+	return entry.toCertificateInfo(), nil
+}
+
+func (s *memStorage) listCerts(filter func(e *certEntry, now time.Time) bool) []*pki.CertificateInfo {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	now := time.Now()
+	certs := make([]*pki.CertificateInfo, 0)
+	for _, entry := range s.certs {
+		if filter(entry, now) {
+			certs = append(certs, entry.toCertificateInfo())
+		}
+	}
+
+	return certs
+}
+
+func (s *memStorage) ListAllCerts(ctx context.Context) ([]*pki.CertificateInfo, error) {
+	return s.listCerts(func(e *certEntry, now time.Time) bool {
+		return e.info.NotAfter.After(now) && e.info.CertType == pki.CertTypeClient
+	}), nil
+}
+
+func (s *memStorage) ListCerts(ctx context.Context, query pki.CertQuery) (*pki.CertPage, error) {
+	return pki.PageCerts(s.listCerts(func(e *certEntry, now time.Time) bool {
+		return true
+	}), query)
+}
+
+func (s *memStorage) ListRevokedCerts(ctx context.Context) ([]*pki.CertificateInfo, error) {
+	return s.listCerts(func(e *certEntry, now time.Time) bool {
+		return e.info.NotAfter.After(now) && (e.revoked(now) || e.holdTime != nil)
+	}), nil
+}
+
+// ListCertsBySubject returns the valid certificates of subjectName, newest first
+func (s *memStorage) ListCertsBySubject(ctx context.Context, subjectName string) ([]*pki.CertificateInfo, error) {
+	certs := s.listCerts(func(e *certEntry, now time.Time) bool {
+		return e.info.NotAfter.After(now) && e.info.Subject == subjectName
+	})
+
+	sort.Slice(certs, func(i, j int) bool {
+		if !certs[i].NotBefore.Equal(certs[j].NotBefore) {
+			return certs[i].NotBefore.After(certs[j].NotBefore)
+		}
+		return certs[i].Serial > certs[j].Serial
+	})
+
+	return certs, nil
+}
+
+func (s *memStorage) AddCert(ctx context.Context, info *pki.CertificateInfo) error {
+	entry, err := newCertEntry(info)
+	if err != nil {
+		return err
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.certs[entry.info.Serial] = entry
+	return nil
+}
+
+// ImportCert stores info with its revocation, hold and renewal state
+func (s *memStorage) ImportCert(ctx context.Context, info *pki.CertificateInfo) error {
+	entry, err := newCertEntry(info)
+	if err != nil {
+		return err
+	}
+
+	entry.info.RevocationReason = info.RevocationReason
+	entry.info.RevokedBy = info.RevokedBy
+	entry.info.RevocationComment = info.RevocationComment
+
+	if rt := info.RevocationTime(); rt != nil {
+		t := rt.UTC()
+		entry.revocationTime = &t
+	}
+
+	if info.OnHold != nil {
+		t := info.OnHold.UTC()
+		entry.holdTime = &t
+	}
+
+	if info.Successor != "" {
+		successor, err := pki.DecodeSerial(info.Successor)
+		if err != nil {
+			return errors.Wrap(err, "decoding successor serial")
+		}
+		entry.successor = hex.EncodeToString(successor)
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.certs[entry.info.Serial] = entry
+	return nil
+}
+
+// AddRenewedCert stores info, links its predecessor to it and schedules the predecessor's revocation. It fails
+// with pki.ErrNotRenewable if the predecessor was revoked, held or renewed meanwhile.
+func (s *memStorage) AddRenewedCert(ctx context.Context, info *pki.CertificateInfo, revokeAt time.Time) error {
+	entry, err := newCertEntry(info)
+	if err != nil {
+		return err
+	}
+
+	if entry.info.Predecessor == "" {
+		return errors.New("missing predecessor")
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	prev, ok := s.certs[entry.info.Predecessor]
+	if !ok || prev.revocationTime != nil || prev.successor != "" || prev.holdTime != nil {
+		return pki.ErrNotRenewable
+	}
+
+	rt := revokeAt.UTC()
+	prev.successor = entry.info.Serial
+	prev.revocationTime = &rt
+	prev.info.RevocationReason = pki.ReasonSuperseded
+
+	s.certs[entry.info.Serial] = entry
+	return nil
+}
+
+// updateCert applies change under the lock. It returns nil if there is no such certificate.
+func (s *memStorage) updateCert(serial []byte, change func(e *certEntry, now time.Time) error) (*pki.CertificateInfo, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	entry, ok := s.certs[hex.EncodeToString(serial)]
+	if !ok {
+		return nil, nil
+	}
+
+	if err := change(entry, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+
+	return entry.toCertificateInfo(), nil
+}
+
+func (s *memStorage) RevokeCert(ctx context.Context, serial []byte, revocation pki.Revocation) (*pki.CertificateInfo, error) {
+	return s.updateCert(serial, func(e *certEntry, now time.Time) error {
+		// Revoking a certificate scheduled for revocation after renewal brings the revocation forward
+		if e.revoked(now) {
+			return pki.ErrInvalidCertState
+		}
+
+		e.revocationTime = &now
+		e.info.RevocationReason = revocation.Reason
+		if revocation.RevokedBy != "" {
+			e.info.RevokedBy = revocation.RevokedBy
+		}
+		if revocation.Comment != "" {
+			e.info.RevocationComment = revocation.Comment
+		}
+		return nil
+	})
+}
+
+func (s *memStorage) HoldCert(ctx context.Context, serial []byte) (*pki.CertificateInfo, error) {
+	return s.updateCert(serial, func(e *certEntry, now time.Time) error {
+		if e.revoked(now) || e.holdTime != nil {
+			return pki.ErrInvalidCertState
+		}
+
+		e.holdTime = &now
+		return nil
+	})
+}
+
+func (s *memStorage) ReinstateCert(ctx context.Context, serial []byte) (*pki.CertificateInfo, error) {
+	return s.updateCert(serial, func(e *certEntry, now time.Time) error {
+		if e.revoked(now) || e.holdTime == nil {
+			return pki.ErrInvalidCertState
+		}
+
+		e.holdTime = nil
+		return nil
+	})
+}
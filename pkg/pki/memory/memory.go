@@ -0,0 +1,143 @@
+// Package mempki keeps the PKI in memory, for tests and local development
+package mempki
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"sync"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+)
+
+// memStorage implements the PKIStorage and the LogStorage. It is safe for concurrent use.
+type memStorage struct {
+	mut sync.RWMutex
+
+	data      pki.CAData
+	certs     map[string]*certEntry
+	log       map[uint64]*pki.LogEntry
+	head      *pki.LogEntry
+	treeHeads []*pki.TreeHead
+}
+
+func NewMemStorage() *memStorage {
+	return &memStorage{
+		certs: make(map[string]*certEntry),
+		log:   make(map[uint64]*pki.LogEntry),
+	}
+}
+
+// GetCAData returns the CA data, or nil if there is none yet
+func (s *memStorage) GetCAData(ctx context.Context) (*pki.CAData, error) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	if s.data.CACert == nil {
+		return nil, nil
+	}
+
+	data := s.data
+	return &data, nil
+}
+
+func (s *memStorage) PutCAData(ctx context.Context, data pki.CAData) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.data = data
+	return nil
+}
+
+func (s *memStorage) GetCACert(ctx context.Context) *x509.Certificate {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	return s.data.CACert
+}
+
+func (s *memStorage) GetPrevCACert(ctx context.Context) *x509.Certificate {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	return s.data.PrevCACert
+}
+
+func (s *memStorage) GetCrossCert(ctx context.Context) *x509.Certificate {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	return s.data.CrossCert
+}
+
+func (s *memStorage) GetCAChain(ctx context.Context) []*x509.Certificate {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	return s.data.Chain
+}
+
+func (s *memStorage) GetSigner(ctx context.Context) (crypto.Signer, error) {
+	s.mut.RLock()
+	data := s.data
+	s.mut.RUnlock()
+
+	// Opening a key in a signer backend may take a while, it's done without holding the lock
+	return data.Signer(ctx)
+}
+
+func (s *memStorage) GetPublicKey(ctx context.Context) crypto.PublicKey {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	return s.data.PublicKey
+}
+
+func (s *memStorage) GetStaticKey(ctx context.Context) pki.StaticKey {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	return s.data.StaticKey
+}
+
+func (s *memStorage) GetStaticKeyRotation(ctx context.Context) *pki.StaticKeyRotation {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	return s.data.StaticKeyRotation
+}
+
+func (s *memStorage) GetTLSCryptV2Key(ctx context.Context) pki.TLSCryptV2Key {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	return s.data.TLSCryptV2Key
+}
+
+func (s *memStorage) GetTLSCryptV2Switch(ctx context.Context) *pki.TLSCryptV2Switch {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	return s.data.TLSCryptV2Switch
+}
+
+func (s *memStorage) GetPrevOCSPCert(ctx context.Context) *x509.Certificate {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	return s.data.PrevOCSPCert
+}
+
+func (s *memStorage) GetPrevCRL(ctx context.Context) []byte {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	return s.data.PrevCRL
+}
+
+func (s *memStorage) GetPrevSigner(ctx context.Context) (crypto.Signer, error) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	return s.data.PrevSigner(ctx)
+}
@@ -0,0 +1,293 @@
+package mempki
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/empathybroker/aws-vpn/pkg/pki/pkitest"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+func TestMemStorage(t *testing.T) {
+	pkitest.RunStorageTests(t, func(t *testing.T) pki.PKIStorage {
+		return NewMemStorage()
+	})
+}
+
+func TestMemLogStorage(t *testing.T) {
+	pkitest.RunLogStorageTests(t, func(t *testing.T) pki.LogStorage {
+		return NewMemStorage()
+	})
+}
+
+// Revocations made after a rotation must reach the CRL of the previous CA
+func TestPrevCACRL(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage()
+
+	caData, err := pki.NewCAKey("Test CA", uuid.New().String(), pki.KeyAlgorithmP256, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.PutCAData(ctx, caData); err != nil {
+		t.Fatal(err)
+	}
+
+	p := pki.NewPKI(s)
+	profile := pki.NewDefaultProfiles()[pki.ProfileLaptop]
+
+	var certs []*pki.CertificateInfo
+	for i := 0; i < 3; i++ {
+		key, err := pki.NewPrivateKey(pki.KeyAlgorithmP256)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := p.CreateCertificate(ctx, pki.GetPublicKey(key), pkix.Name{CommonName: uuid.New().String()}, profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, info)
+	}
+
+	// The reinstated certificate is on hold in the CRL signed at the rotation
+	if _, err := p.HoldCert(ctx, certs[2].SerialBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	revoked, err := s.ListRevokedCerts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renewed, err := caData.Renew("Test CA", uuid.New().String(), pki.KeyAlgorithmP256, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if renewed.PrevCRL, err = caData.CreateCRL(revoked); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.PutCAData(ctx, renewed); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.RevokeCert(ctx, certs[0].SerialBytes, pki.Revocation{Reason: pki.ReasonKeyCompromise}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.HoldCert(ctx, certs[1].SerialBytes); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.ReinstateCert(ctx, certs[2].SerialBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := p.CreateCRL(ctx, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crls, err := pki.DecodePEMCRLs(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(crls) != 2 {
+		t.Fatalf("expected the CRLs of both CAs, got %d", len(crls))
+	}
+
+	prevCRL := crls[1]
+	if err := prevCRL.CheckSignatureFrom(caData.CACert); err != nil {
+		t.Fatalf("previous CRL not signed by the previous CA: %v", err)
+	}
+
+	listed := map[string]bool{}
+	for _, entry := range prevCRL.RevokedCertificateEntries {
+		listed[hex.EncodeToString(entry.SerialNumber.Bytes())] = true
+	}
+
+	if !listed[certs[0].Serial] || !listed[certs[1].Serial] || listed[certs[2].Serial] {
+		t.Errorf("previous CRL doesn't reflect the changes after the rotation: %v", listed)
+	}
+}
+
+// Failed changes are logged as aborted, and certificates the log doesn't know are found whatever their state
+func TestIssuanceLogAborted(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage()
+
+	caData, err := pki.NewCAKey("Test CA", uuid.New().String(), pki.KeyAlgorithmP256, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.PutCAData(ctx, caData); err != nil {
+		t.Fatal(err)
+	}
+
+	p := pki.NewPKI(s)
+	p.SetIssuanceLog(s)
+
+	key, err := pki.NewPrivateKey(pki.KeyAlgorithmP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := p.CreateCertificate(ctx, pki.GetPublicKey(key), pkix.Name{CommonName: "user@example.com"}, pki.NewDefaultProfiles()[pki.ProfileLaptop])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.RenewCertificate(ctx, info, pki.GetPublicKey(key), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// info is stale, the storage refuses to renew it again
+	if _, err := p.RenewCertificate(ctx, info, pki.GetPublicKey(key), time.Hour); errors.Cause(err) != pki.ErrNotRenewable {
+		t.Fatalf("expected ErrNotRenewable, got %v", err)
+	}
+
+	if _, err := p.ReinstateCert(ctx, info.SerialBytes); err == nil {
+		t.Fatal("expected error reinstating a certificate which isn't on hold")
+	}
+
+	entries, err := s.ListLogEntries(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var types []pki.LogEntryType
+	for _, entry := range entries {
+		types = append(types, entry.Type)
+	}
+	expected := []pki.LogEntryType{pki.LogEntryIssued, pki.LogEntryIssued, pki.LogEntryIssued, pki.LogEntryAborted,
+		pki.LogEntryReinstated, pki.LogEntryAborted}
+	if !reflect.DeepEqual(types, expected) {
+		t.Fatalf("expected the entries %v, got %v", expected, types)
+	}
+
+	if _, err := p.SignTreeHead(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if problems, err := p.VerifyIssuanceLog(ctx); err != nil || len(problems) > 0 {
+		t.Fatalf("unexpected issuance log problems %v %v", problems, err)
+	}
+
+	notBefore := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+	cert, err := pki.CreateCertificate(caData.CACert, caData.PrivateKey, pki.GetPublicKey(key), pkix.Name{CommonName: "vpn.example.com"},
+		pki.ServerCert, pki.WithTimespan(notBefore, notBefore.Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unlogged := pki.CertInfoFromX509Cert(cert)
+	if err := s.ImportCert(ctx, unlogged); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := p.VerifyIssuanceLog(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(problems) != 1 {
+		t.Fatalf("expected the expired server certificate to be reported, got %v", problems)
+	}
+}
+
+// Certificates stored before the log was enabled are imported into it, later unlogged ones are still reported
+func TestImportIssuanceLog(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage()
+
+	caData, err := pki.NewCAKey("Test CA", uuid.New().String(), pki.KeyAlgorithmP256, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.PutCAData(ctx, caData); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := pki.NewPrivateKey(pki.KeyAlgorithmP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := pki.NewPKI(s)
+	profile := pki.NewDefaultProfiles()[pki.ProfileLaptop]
+
+	var before []string
+	var revoked []byte
+	for i := 0; i < 2; i++ {
+		info, err := p.CreateCertificate(ctx, pki.GetPublicKey(key), pkix.Name{CommonName: uuid.New().String()}, profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before = append(before, info.Serial)
+		revoked = info.SerialBytes
+	}
+	sort.Strings(before)
+
+	if _, err := p.RevokeCert(ctx, revoked, pki.Revocation{Reason: pki.ReasonSuperseded}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first entry of the log comes after the certificates above
+	time.Sleep(time.Second)
+	p.SetIssuanceLog(s)
+	if _, err := p.CreateCertificate(ctx, pki.GetPublicKey(key), pkix.Name{CommonName: uuid.New().String()}, profile); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.SignTreeHead(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if problems, err := p.VerifyIssuanceLog(ctx); err != nil || len(problems) != 3 {
+		t.Fatalf("expected the unlogged certificates and revocation to be reported, got %v %v", problems, err)
+	}
+
+	imported, err := p.ImportIssuanceLog(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(imported)
+	if !reflect.DeepEqual(imported, before) {
+		t.Fatalf("expected %v imported, got %v", before, imported)
+	}
+
+	if problems, err := p.VerifyIssuanceLog(ctx); err != nil || len(problems) > 0 {
+		t.Fatalf("unexpected issuance log problems %v %v", problems, err)
+	}
+
+	// Certificates stored behind the back of the log after it started aren't imported
+	cert, err := pki.CreateCertificate(caData.CACert, caData.PrivateKey, pki.GetPublicKey(key), pkix.Name{CommonName: uuid.New().String()},
+		pki.ClientCert, pki.WithDuration(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ImportCert(ctx, pki.CertInfoFromX509Cert(cert)); err != nil {
+		t.Fatal(err)
+	}
+
+	if imported, err := p.ImportIssuanceLog(ctx); err != nil || len(imported) > 0 {
+		t.Fatalf("expected nothing imported, got %v %v", imported, err)
+	}
+
+	if problems, err := p.VerifyIssuanceLog(ctx); err != nil || len(problems) != 1 {
+		t.Fatalf("expected the unlogged certificate to be reported, got %v %v", problems, err)
+	}
+}
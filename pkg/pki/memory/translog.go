@@ -0,0 +1,85 @@
+package mempki
+
+import (
+	"context"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+)
+
+func (s *memStorage) AppendLogEntry(ctx context.Context, entry *pki.LogEntry) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	// Entries are never overwritten, a concurrent writer got this index first
+	if _, ok := s.log[entry.Index]; ok {
+		return pki.ErrLogConflict
+	}
+
+	stored := *entry
+	s.log[entry.Index] = &stored
+	if s.head == nil || entry.Index > s.head.Index {
+		s.head = &stored
+	}
+
+	return nil
+}
+
+func (s *memStorage) GetLogHead(ctx context.Context) (*pki.LogEntry, error) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	if s.head == nil {
+		return nil, nil
+	}
+
+	head := *s.head
+	return &head, nil
+}
+
+// ListLogEntries returns the entries from the index on, the log has no gaps
+func (s *memStorage) ListLogEntries(ctx context.Context, from uint64, limit int) ([]*pki.LogEntry, error) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	entries := make([]*pki.LogEntry, 0)
+	for index := from; len(entries) < limit; index++ {
+		entry, ok := s.log[index]
+		if !ok {
+			break
+		}
+
+		stored := *entry
+		entries = append(entries, &stored)
+	}
+
+	return entries, nil
+}
+
+func (s *memStorage) PutTreeHead(ctx context.Context, th *pki.TreeHead) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	stored := *th
+	s.treeHeads = append(s.treeHeads, &stored)
+	return nil
+}
+
+// GetTreeHead returns the tree head with the latest timestamp
+func (s *memStorage) GetTreeHead(ctx context.Context) (*pki.TreeHead, error) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	var latest *pki.TreeHead
+	for _, th := range s.treeHeads {
+		if latest == nil || th.Timestamp.After(latest.Timestamp) {
+			latest = th
+		}
+	}
+
+	if latest == nil {
+		return nil, nil
+	}
+
+	th := *latest
+	return &th, nil
+}
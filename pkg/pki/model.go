@@ -19,6 +19,20 @@ type CertificateInfo struct {
 	NotBefore time.Time  `json:"notBefore"`
 	NotAfter  time.Time  `json:"notAfter"`
 	Revoked   *time.Time `json:"revoked,omitempty"`
+
+	RevocationReason  RevocationReason `json:"revocationReason,omitempty"`
+	RevokedBy         string           `json:"revokedBy,omitempty"`
+	RevocationComment string           `json:"revocationComment,omitempty"`
+
+	// OnHold is set while the certificate is suspended. It can be reinstated until it is revoked.
+	OnHold *time.Time `json:"onHold,omitempty"`
+
+	Profile string `json:"profile,omitempty"`
+
+	// A renewed certificate links to its successor and stays valid until RevokeAt
+	Predecessor string     `json:"predecessor,omitempty"`
+	Successor   string     `json:"successor,omitempty"`
+	RevokeAt    *time.Time `json:"revokeAt,omitempty"`
 }
 
 func CertInfoFromX509Cert(cert *x509.Certificate) *CertificateInfo {
@@ -36,17 +50,79 @@ func CertInfoFromX509Cert(cert *x509.Certificate) *CertificateInfo {
 	}
 }
 
+// RevocationTime returns when the certificate was or is scheduled to be revoked, or nil
+func (info *CertificateInfo) RevocationTime() *time.Time {
+	if info.Revoked != nil {
+		return info.Revoked
+	}
+	return info.RevokeAt
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Unix() == b.Unix()
+}
+
+// Diff lists the fields in which other differs from info, comparing the times to the second like the storages
+func (info *CertificateInfo) Diff(other *CertificateInfo) []string {
+	var diff []string
+	check := func(field string, same bool) {
+		if !same {
+			diff = append(diff, field)
+		}
+	}
+
+	check("certificate", info.Certificate != nil && other.Certificate != nil && info.Certificate.Equal(other.Certificate))
+	check("type", info.CertType == other.CertType)
+	check("serial", info.Serial == other.Serial)
+	check("keyId", info.KeyId == other.KeyId)
+	check("subject", info.Subject == other.Subject)
+	check("notBefore", info.NotBefore.Unix() == other.NotBefore.Unix())
+	check("notAfter", info.NotAfter.Unix() == other.NotAfter.Unix())
+	check("revoked", sameTime(info.Revoked, other.Revoked))
+	check("revocationReason", info.RevocationReason == other.RevocationReason)
+	check("revokedBy", info.RevokedBy == other.RevokedBy)
+	check("revocationComment", info.RevocationComment == other.RevocationComment)
+	check("onHold", sameTime(info.OnHold, other.OnHold))
+	check("profile", info.Profile == other.Profile)
+	check("predecessor", info.Predecessor == other.Predecessor)
+	check("successor", info.Successor == other.Successor)
+	check("revokeAt", sameTime(info.RevokeAt, other.RevokeAt))
+
+	return diff
+}
+
+// CertImporter is implemented by the storages which can store certificates with all their state, e.g. when
+// copying them from another storage. Importing a certificate again replaces it.
+type CertImporter interface {
+	ImportCert(ctx context.Context, info *CertificateInfo) error
+}
+
 type PKIStorage interface {
 	GetCACert(ctx context.Context) *x509.Certificate
 	GetPrevCACert(ctx context.Context) *x509.Certificate
 	GetCrossCert(ctx context.Context) *x509.Certificate
-	GetPrivateKey(ctx context.Context) crypto.PrivateKey
+	GetCAChain(ctx context.Context) []*x509.Certificate
+	GetSigner(ctx context.Context) (crypto.Signer, error)
 	GetPublicKey(ctx context.Context) crypto.PublicKey
 	GetStaticKey(ctx context.Context) StaticKey
+	GetStaticKeyRotation(ctx context.Context) *StaticKeyRotation
+	GetTLSCryptV2Key(ctx context.Context) TLSCryptV2Key
+	GetTLSCryptV2Switch(ctx context.Context) *TLSCryptV2Switch
+	GetPrevOCSPCert(ctx context.Context) *x509.Certificate
+	GetPrevCRL(ctx context.Context) []byte
+	GetPrevSigner(ctx context.Context) (crypto.Signer, error)
 
-	AddCert(ctx context.Context, cert *x509.Certificate) error
+	AddCert(ctx context.Context, info *CertificateInfo) error
+	AddRenewedCert(ctx context.Context, info *CertificateInfo, revokeAt time.Time) error
 	ListAllCerts(ctx context.Context) ([]*CertificateInfo, error)
+	ListCerts(ctx context.Context, query CertQuery) (*CertPage, error)
 	ListCertsBySubject(context.Context, string) ([]*CertificateInfo, error)
+	ListRevokedCerts(ctx context.Context) ([]*CertificateInfo, error)
 	GetCertBySerial(context.Context, []byte) (*CertificateInfo, error)
-	RevokeCert(context.Context, []byte) (*CertificateInfo, error)
+	RevokeCert(context.Context, []byte, Revocation) (*CertificateInfo, error)
+	HoldCert(context.Context, []byte) (*CertificateInfo, error)
+	ReinstateCert(context.Context, []byte) (*CertificateInfo, error)
 }
@@ -0,0 +1,112 @@
+package pki
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ocsp"
+)
+
+var (
+	ErrUnknownIssuer = errors.New("unknown issuer")
+
+	oidOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+)
+
+// OCSPSigningCert makes a delegated OCSP responder certificate (RFC 6960 section 4.2.2.2)
+func OCSPSigningCert(cert *x509.Certificate) {
+	cert.IsCA = false
+	cert.BasicConstraintsValid = true
+	cert.KeyUsage = x509.KeyUsageDigitalSignature
+	cert.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning}
+	cert.ExtraExtensions = append(cert.ExtraExtensions, pkix.Extension{Id: oidOCSPNoCheck, Value: asn1.NullBytes})
+}
+
+func WithOCSPServer(url ...string) CertOptions {
+	return func(cert *x509.Certificate) {
+		cert.OCSPServer = url
+	}
+}
+
+// issuerMatches checks whether the issuer hashes in an OCSP request identify the CA certificate
+func issuerMatches(req *ocsp.Request, ca *x509.Certificate) bool {
+	if ca == nil || !req.HashAlgorithm.Available() {
+		return false
+	}
+
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(ca.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return false
+	}
+
+	h := req.HashAlgorithm.New()
+	h.Write(ca.RawSubject)
+	nameHash := h.Sum(nil)
+
+	h.Reset()
+	h.Write(spki.PublicKey.RightAlign())
+	keyHash := h.Sum(nil)
+
+	return bytes.Equal(nameHash, req.IssuerNameHash) && bytes.Equal(keyHash, req.IssuerKeyHash)
+}
+
+func (pki *PKI) CreateOCSPResponse(ctx context.Context, req *ocsp.Request, validity time.Duration) ([]byte, error) {
+	signer, err := pki.storage.GetSigner(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "obtaining CA key")
+	}
+
+	// Responses for the current CA are signed directly with its key. Certificates issued by the
+	// previous CA are answered with the current key, delegated by the previous CA on rotation.
+	issuer := pki.storage.GetCACert(ctx)
+	responder := issuer
+	if !issuerMatches(req, issuer) {
+		issuer = pki.storage.GetPrevCACert(ctx)
+		responder = pki.storage.GetPrevOCSPCert(ctx)
+		if responder == nil || !issuerMatches(req, issuer) {
+			return nil, ErrUnknownIssuer
+		}
+	}
+
+	now := time.Now().UTC()
+	template := ocsp.Response{
+		Status:       ocsp.Unknown,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(validity),
+		IssuerHash:   req.HashAlgorithm,
+	}
+
+	if responder != issuer {
+		template.Certificate = responder
+	}
+
+	cert, err := pki.storage.GetCertBySerial(ctx, req.SerialNumber.Bytes())
+	if err != nil {
+		return nil, errors.Wrap(err, "obtaining certificate")
+	}
+
+	if cert != nil && bytes.Equal(cert.Certificate.AuthorityKeyId, issuer.SubjectKeyId) {
+		if cert.Revoked != nil {
+			template.Status = ocsp.Revoked
+			template.RevokedAt = cert.Revoked.UTC()
+			template.RevocationReason = int(cert.RevocationReason)
+		} else if cert.OnHold != nil {
+			template.Status = ocsp.Revoked
+			template.RevokedAt = cert.OnHold.UTC()
+			template.RevocationReason = ocsp.CertificateHold
+		} else {
+			template.Status = ocsp.Good
+		}
+	}
+
+	return ocsp.CreateResponse(issuer, responder, template, signer)
+}
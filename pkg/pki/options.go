@@ -62,3 +62,10 @@ func WithDNS(dnsName ...string) CertOptions {
 		cert.DNSNames = dnsName
 	}
 }
+
+// WithRawSubject copies a subject verbatim, keeping attributes pkix.Name can't marshal back
+func WithRawSubject(rawSubject []byte) CertOptions {
+	return func(cert *x509.Certificate) {
+		cert.RawSubject = rawSubject
+	}
+}
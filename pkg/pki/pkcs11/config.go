@@ -0,0 +1,26 @@
+//go:build cgo
+// +build cgo
+
+package pkcs11pki
+
+import (
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/kelseyhightower/envconfig"
+)
+
+const kConfigPrefix = "PKI_PKCS11"
+
+var configPKCS11 struct {
+	// Module is the path of the PKCS#11 library, the backend is only registered when set
+	Module     string
+	TokenLabel string `split_words:"true"`
+	Pin        string
+}
+
+func init() {
+	envconfig.MustProcess(kConfigPrefix, &configPKCS11)
+
+	if configPKCS11.Module != "" {
+		pki.RegisterSignerBackend(NewBackend(configPKCS11.Module, configPKCS11.TokenLabel, configPKCS11.Pin))
+	}
+}
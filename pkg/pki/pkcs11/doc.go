@@ -0,0 +1,3 @@
+// Package pkcs11pki keeps CA keys in a PKCS#11 token, such as an HSM or SoftHSM. It needs cgo, and registers
+// itself as the "pkcs11" signer backend when PKI_PKCS11_MODULE is set.
+package pkcs11pki
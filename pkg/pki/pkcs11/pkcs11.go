@@ -0,0 +1,470 @@
+//go:build cgo
+// +build cgo
+
+package pkcs11pki
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+)
+
+const Scheme = "pkcs11"
+
+var (
+	oidNamedCurveP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidNamedCurveP384 = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+)
+
+// DigestInfo prefixes for RSA PKCS#1 v1.5 signatures, CKM_RSA_PKCS only pads the data it is given
+var kDigestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+var kPSSMechanisms = map[crypto.Hash][2]uint{
+	crypto.SHA256: {pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256},
+	crypto.SHA384: {pkcs11.CKM_SHA384, pkcs11.CKG_MGF1_SHA384},
+	crypto.SHA512: {pkcs11.CKM_SHA512, pkcs11.CKG_MGF1_SHA512},
+}
+
+// Backend uses a single logged in session on the token, PKCS#11 sessions can't be used concurrently
+type Backend struct {
+	module     string
+	tokenLabel string
+	pin        string
+
+	mut     sync.Mutex
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+}
+
+// NewBackend opens keys in the token labeled tokenLabel. The module is loaded on first use.
+func NewBackend(module, tokenLabel, pin string) *Backend {
+	return &Backend{module: module, tokenLabel: tokenLabel, pin: pin}
+}
+
+func (b *Backend) Scheme() string {
+	return Scheme
+}
+
+// Close logs out and unloads the module
+func (b *Backend) Close() {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if b.ctx == nil {
+		return
+	}
+
+	_ = b.ctx.Logout(b.session)
+	_ = b.ctx.CloseSession(b.session)
+	_ = b.ctx.Finalize()
+	b.ctx.Destroy()
+	b.ctx = nil
+}
+
+// open must be called with the lock held
+func (b *Backend) open() error {
+	if b.ctx != nil {
+		return nil
+	}
+
+	ctx := pkcs11.New(b.module)
+	if ctx == nil {
+		return errors.Errorf("loading PKCS#11 module %s", b.module)
+	}
+
+	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return errors.Wrap(err, "initializing PKCS#11 module")
+	}
+
+	session, err := b.login(ctx)
+	if err != nil {
+		_ = ctx.Finalize()
+		ctx.Destroy()
+		return err
+	}
+
+	b.ctx = ctx
+	b.session = session
+	return nil
+}
+
+func (b *Backend) login(ctx *pkcs11.Ctx) (pkcs11.SessionHandle, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, errors.Wrap(err, "listing PKCS#11 slots")
+	}
+
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			return 0, errors.Wrap(err, "obtaining PKCS#11 token info")
+		}
+
+		if strings.TrimRight(info.Label, " \x00") != b.tokenLabel {
+			continue
+		}
+
+		session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+		if err != nil {
+			return 0, errors.Wrap(err, "opening PKCS#11 session")
+		}
+
+		if err := ctx.Login(session, pkcs11.CKU_USER, b.pin); err != nil && err != pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+			_ = ctx.CloseSession(session)
+			return 0, errors.Wrap(err, "logging in to PKCS#11 token")
+		}
+
+		return session, nil
+	}
+
+	return 0, errors.Errorf("PKCS#11 token %q not found", b.tokenLabel)
+}
+
+func (b *Backend) GenerateKey(_ context.Context, alg pki.KeyAlgorithm, label string) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	pubTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	privTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+
+	var mech uint
+	switch alg {
+	case pki.KeyAlgorithmP256, pki.KeyAlgorithmP384:
+		oid := oidNamedCurveP256
+		if alg == pki.KeyAlgorithmP384 {
+			oid = oidNamedCurveP384
+		}
+
+		params, err := asn1.Marshal(oid)
+		if err != nil {
+			return "", err
+		}
+
+		mech = pkcs11.CKM_EC_KEY_PAIR_GEN
+		pubTemplate = append(pubTemplate, pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params))
+	case pki.KeyAlgorithmRSA2048, pki.KeyAlgorithmRSA3072, pki.KeyAlgorithmRSA4096:
+		var bits int
+		_, _ = fmt.Sscanf(string(alg), "RSA-%d", &bits)
+
+		mech = pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN
+		pubTemplate = append(pubTemplate,
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, bits),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}))
+	default:
+		return "", errors.Errorf("key algorithm %s not supported by PKCS#11", alg)
+	}
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if err := b.open(); err != nil {
+		return "", err
+	}
+
+	_, _, err := b.ctx.GenerateKeyPair(b.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(mech, nil)}, pubTemplate, privTemplate)
+	if err != nil {
+		return "", errors.Wrap(err, "generating PKCS#11 key pair")
+	}
+
+	return b.keyURI(id, label), nil
+}
+
+// keyURI follows RFC 7512, the id is what identifies the key and the rest is informative
+func (b *Backend) keyURI(id []byte, label string) string {
+	var encodedId strings.Builder
+	for _, c := range id {
+		_, _ = fmt.Fprintf(&encodedId, "%%%02x", c)
+	}
+
+	return fmt.Sprintf("%s:token=%s;object=%s;id=%s", Scheme,
+		url.PathEscape(b.tokenLabel), url.PathEscape(label), encodedId.String())
+}
+
+func parseKeyURI(uri string) (map[string]string, error) {
+	path := strings.TrimPrefix(uri, Scheme+":")
+	if path == uri {
+		return nil, errors.Errorf("invalid PKCS#11 key URI %q", uri)
+	}
+
+	// Query attributes such as pin-source are not used, the PIN comes from the configuration
+	path = strings.SplitN(path, "?", 2)[0]
+
+	attrs := make(map[string]string)
+	for _, attr := range strings.Split(path, ";") {
+		parts := strings.SplitN(attr, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid PKCS#11 key URI %q", uri)
+		}
+
+		value, err := url.PathUnescape(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid PKCS#11 key URI %q", uri)
+		}
+		attrs[parts[0]] = value
+	}
+
+	if attrs["id"] == "" && attrs["object"] == "" {
+		return nil, errors.Errorf("PKCS#11 key URI %q has no id nor object", uri)
+	}
+
+	return attrs, nil
+}
+
+// findObject must be called with the lock held
+func (b *Backend) findObject(class uint, attrs map[string]string) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, class)}
+	if id := attrs["id"]; id != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, []byte(id)))
+	}
+	if label := attrs["object"]; label != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, label))
+	}
+
+	if err := b.ctx.FindObjectsInit(b.session, template); err != nil {
+		return 0, errors.Wrap(err, "searching PKCS#11 objects")
+	}
+	defer func() { _ = b.ctx.FindObjectsFinal(b.session) }()
+
+	objects, _, err := b.ctx.FindObjects(b.session, 2)
+	if err != nil {
+		return 0, errors.Wrap(err, "searching PKCS#11 objects")
+	}
+
+	switch len(objects) {
+	case 0:
+		return 0, errors.New("PKCS#11 key not found")
+	case 1:
+		return objects[0], nil
+	default:
+		return 0, errors.New("PKCS#11 key URI matches several keys")
+	}
+}
+
+// publicKey must be called with the lock held
+func (b *Backend) publicKey(attrs map[string]string) (crypto.PublicKey, error) {
+	obj, err := b.findObject(pkcs11.CKO_PUBLIC_KEY, attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := b.ctx.GetAttributeValue(b.session, obj, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "obtaining PKCS#11 key type")
+	}
+
+	switch new(big.Int).SetBytes(reverse(values[0].Value)).Uint64() {
+	case pkcs11.CKK_EC:
+		return b.ecPublicKey(obj)
+	case pkcs11.CKK_RSA:
+		values, err := b.ctx.GetAttributeValue(b.session, obj, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "obtaining PKCS#11 public key")
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(values[0].Value),
+			E: int(new(big.Int).SetBytes(values[1].Value).Int64()),
+		}, nil
+	default:
+		return nil, errors.New("unsupported PKCS#11 key type")
+	}
+}
+
+func (b *Backend) ecPublicKey(obj pkcs11.ObjectHandle) (crypto.PublicKey, error) {
+	values, err := b.ctx.GetAttributeValue(b.session, obj, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "obtaining PKCS#11 public key")
+	}
+
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(values[0].Value, &oid); err != nil {
+		return nil, errors.Wrap(err, "parsing PKCS#11 EC parameters")
+	}
+
+	var curve elliptic.Curve
+	switch {
+	case oid.Equal(oidNamedCurveP256):
+		curve = elliptic.P256()
+	case oid.Equal(oidNamedCurveP384):
+		curve = elliptic.P384()
+	default:
+		return nil, errors.Errorf("unsupported PKCS#11 curve %s", oid)
+	}
+
+	// The point should be DER encoded as an OCTET STRING, but some modules return it raw
+	point := values[1].Value
+	var encoded []byte
+	if rest, err := asn1.Unmarshal(point, &encoded); err == nil && len(rest) == 0 {
+		point = encoded
+	}
+
+	x, y := elliptic.Unmarshal(curve, point)
+	if x == nil {
+		return nil, errors.New("invalid PKCS#11 EC point")
+	}
+
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+func (b *Backend) Signer(_ context.Context, uri string, pub crypto.PublicKey) (crypto.Signer, error) {
+	attrs, err := parseKeyURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if err := b.open(); err != nil {
+		return nil, err
+	}
+
+	// Make sure the key exists now instead of failing on the first signature
+	if _, err := b.findObject(pkcs11.CKO_PRIVATE_KEY, attrs); err != nil {
+		return nil, err
+	}
+
+	if pub == nil {
+		if pub, err = b.publicKey(attrs); err != nil {
+			return nil, err
+		}
+	}
+
+	return &signer{backend: b, attrs: attrs, pub: pub}, nil
+}
+
+type signer struct {
+	backend *Backend
+	attrs   map[string]string
+	pub     crypto.PublicKey
+}
+
+func (s *signer) Public() crypto.PublicKey {
+	return s.pub
+}
+
+func (s *signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var mech *pkcs11.Mechanism
+	data := digest
+
+	switch s.pub.(type) {
+	case *ecdsa.PublicKey:
+		mech = pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)
+	case *rsa.PublicKey:
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			params, ok := kPSSMechanisms[pss.HashFunc()]
+			if !ok {
+				return nil, errors.Errorf("hash %v not supported by PKCS#11", pss.HashFunc())
+			}
+
+			saltLength := pss.SaltLength
+			if saltLength == rsa.PSSSaltLengthEqualsHash || saltLength == rsa.PSSSaltLengthAuto {
+				saltLength = pss.HashFunc().Size()
+			}
+
+			mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, pkcs11.NewPSSParams(params[0], params[1], uint(saltLength)))
+		} else {
+			prefix, ok := kDigestInfoPrefixes[opts.HashFunc()]
+			if !ok {
+				return nil, errors.Errorf("hash %v not supported by PKCS#11", opts.HashFunc())
+			}
+
+			mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)
+			data = append(append([]byte{}, prefix...), digest...)
+		}
+	default:
+		return nil, errors.Errorf("unsupported PKCS#11 key type %T", s.pub)
+	}
+
+	sig, err := s.backend.sign(s.attrs, mech, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := s.pub.(*ecdsa.PublicKey); ok {
+		// PKCS#11 returns r || s, x509 expects the ASN.1 structure
+		half := len(sig) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			R: new(big.Int).SetBytes(sig[:half]),
+			S: new(big.Int).SetBytes(sig[half:]),
+		})
+	}
+
+	return sig, nil
+}
+
+func (b *Backend) sign(attrs map[string]string, mech *pkcs11.Mechanism, data []byte) ([]byte, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if err := b.open(); err != nil {
+		return nil, err
+	}
+
+	obj, err := b.findObject(pkcs11.CKO_PRIVATE_KEY, attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.ctx.SignInit(b.session, []*pkcs11.Mechanism{mech}, obj); err != nil {
+		return nil, errors.Wrap(err, "signing with PKCS#11")
+	}
+
+	sig, err := b.ctx.Sign(b.session, data)
+	if err != nil {
+		return nil, errors.Wrap(err, "signing with PKCS#11")
+	}
+
+	return sig, nil
+}
+
+// reverse turns the native little endian CK_ULONG attribute values into big endian
+func reverse(b []byte) []byte {
+	res := make([]byte, len(b))
+	for i := range b {
+		res[len(b)-1-i] = b[i]
+	}
+	return res
+}
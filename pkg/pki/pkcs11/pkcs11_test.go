@@ -0,0 +1,70 @@
+//go:build cgo
+// +build cgo
+
+package pkcs11pki
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+)
+
+func TestKeyURI(t *testing.T) {
+	b := NewBackend("", "VPN CA", "")
+	uri := b.keyURI([]byte{0x01, 0xab}, "vpn-ca 1;2")
+
+	attrs, err := parseKeyURI(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if attrs["token"] != "VPN CA" || attrs["object"] != "vpn-ca 1;2" || attrs["id"] != "\x01\xab" {
+		t.Fatalf("unexpected attributes %v from %s", attrs, uri)
+	}
+
+	for _, invalid := range []string{"awskms:arn", "pkcs11:token=x", "pkcs11:id"} {
+		if _, err := parseKeyURI(invalid); err == nil {
+			t.Errorf("%s: expected error", invalid)
+		}
+	}
+}
+
+// TestSoftHSM runs against an initialized token, e.g. softhsm2-util --init-token --free --label test --pin 1234 --so-pin 1234
+func TestSoftHSM(t *testing.T) {
+	module := os.Getenv("PKI_PKCS11_TEST_MODULE")
+	if module == "" {
+		t.Skip("PKI_PKCS11_TEST_MODULE not set")
+	}
+
+	b := NewBackend(module, os.Getenv("PKI_PKCS11_TEST_TOKEN_LABEL"), os.Getenv("PKI_PKCS11_TEST_PIN"))
+	defer b.Close()
+
+	for _, alg := range []pki.KeyAlgorithm{pki.KeyAlgorithmP256, pki.KeyAlgorithmP384, pki.KeyAlgorithmRSA2048} {
+		uri, err := b.GenerateKey(context.Background(), alg, "test CA "+string(alg))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signer, err := b.Signer(context.Background(), uri, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if keyAlg, err := pki.GetKeyAlgorithm(signer.Public()); err != nil || keyAlg != alg {
+			t.Fatalf("%s: unexpected public key algorithm %s", alg, keyAlg)
+		}
+
+		caCert, err := pki.CreateCertificate(nil, signer, signer.Public(), pkix.Name{CommonName: "test CA"}, pki.CACert, pki.WithDuration(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := caCert.CheckSignatureFrom(caCert); err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+	}
+}
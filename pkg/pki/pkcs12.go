@@ -0,0 +1,42 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/x509"
+
+	"github.com/pkg/errors"
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+// EncodePKCS12 creates a PKCS#12 file with a certificate and its chain. The private key never leaves the client,
+// so the file holds nothing secret and without a password it's neither encrypted nor MACed. Some keychains refuse
+// files without a MAC, with a password they get pbeWithSHAAnd3-KeyTripleDES-CBC and HMAC-SHA1, the combination
+// understood by every keychain and smartcard tool we have to support.
+func EncodePKCS12(cert *x509.Certificate, chain []*x509.Certificate, password string) ([]byte, error) {
+	entries := []pkcs12.TrustStoreEntry{{Cert: cert, FriendlyName: cert.Subject.CommonName}}
+	for _, c := range chain {
+		entries = append(entries, pkcs12.TrustStoreEntry{Cert: c, FriendlyName: c.Subject.CommonName})
+	}
+
+	encoder := pkcs12.Passwordless
+	if password != "" {
+		encoder = pkcs12.LegacyDES
+	}
+
+	pfx, err := encoder.EncodeTrustStoreEntries(entries, password)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding PKCS#12")
+	}
+
+	return pfx, nil
+}
+
+// EncodePEMBundle concatenates a certificate and its chain in PEM format
+func EncodePEMBundle(cert *x509.Certificate, chain []*x509.Certificate) []byte {
+	var buf bytes.Buffer
+	buf.Write(EncodePEMCert(cert))
+	for _, c := range chain {
+		buf.Write(EncodePEMCert(c))
+	}
+	return buf.Bytes()
+}
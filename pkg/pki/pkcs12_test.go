@@ -0,0 +1,59 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+func TestEncodePKCS12(t *testing.T) {
+	caKey, err := NewCAKey(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privKey, err := NewPrivateKey(KeyAlgorithmP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := CreateCertificate(caKey.CACert, caKey.PrivateKey, GetPublicKey(privKey), pkix.Name{CommonName: "user@example.com"}, ClientCert, WithDuration(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pfx, err := EncodePKCS12(cert, []*x509.Certificate{caKey.CACert}, "sécret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pkcs12.DecodeTrustStore(pfx, "wrong"); err != pkcs12.ErrIncorrectPassword {
+		t.Fatalf("expected incorrect password, got %v", err)
+	}
+
+	// Without a password the file is neither encrypted nor MACed
+	plain, err := EncodePKCS12(cert, []*x509.Certificate{caKey.CACert}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for password, pfx := range map[string][]byte{"sécret": pfx, "": plain} {
+		certs, err := pkcs12.DecodeTrustStore(pfx, password)
+		if err != nil {
+			t.Fatalf("password %q: %v", password, err)
+		}
+
+		if len(certs) != 2 {
+			t.Fatalf("password %q: expected 2 certificates, got %d", password, len(certs))
+		}
+
+		if !bytes.Equal(certs[0].Raw, cert.Raw) || !bytes.Equal(certs[1].Raw, caKey.CACert.Raw) {
+			t.Fatalf("password %q: unexpected certificates", password)
+		}
+	}
+}
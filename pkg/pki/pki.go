@@ -1,24 +1,47 @@
 package pki
 
 import (
+	"bytes"
 	"context"
 	"crypto"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/hex"
+	"time"
 
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrNotRenewable     = errors.New("certificate is revoked, on hold or already renewed")
+	ErrInvalidCertState = errors.New("certificate is not in a valid state for this operation")
 )
 
 type PKI struct {
-	storage PKIStorage
+	storage     PKIStorage
+	keyPolicy   KeyPolicy
+	constraints NameConstraints
+	log         LogStorage
 }
 
 func NewPKI(s PKIStorage) *PKI {
 	return &PKI{
-		storage: s,
+		storage:     s,
+		keyPolicy:   DefaultKeyPolicy,
+		constraints: DefaultNameConstraints,
 	}
 }
 
+func (pki *PKI) SetKeyPolicy(p KeyPolicy) {
+	pki.keyPolicy = p
+}
+
+// SetNameConstraints sets the names the PKI may issue for, on top of those embedded in the CA certificates
+func (pki *PKI) SetNameConstraints(nc NameConstraints) {
+	pki.constraints = nc
+}
+
 func (pki *PKI) GetCACert(ctx context.Context) *x509.Certificate {
 	return pki.storage.GetCACert(ctx)
 }
@@ -31,21 +54,142 @@ func (pki *PKI) GetCrossCert(ctx context.Context) *x509.Certificate {
 	return pki.storage.GetCrossCert(ctx)
 }
 
+func (pki *PKI) GetCAChain(ctx context.Context) []*x509.Certificate {
+	return pki.storage.GetCAChain(ctx)
+}
+
 func (pki *PKI) GetStaticKey(ctx context.Context) StaticKey {
 	return pki.storage.GetStaticKey(ctx)
 }
 
-func (pki *PKI) CreateCertificate(ctx context.Context, pubKey crypto.PublicKey, subject pkix.Name, certOpts ...CertOptions) (*CertificateInfo, error) {
-	cert, err := CreateCertificate(pki.storage.GetCACert(ctx), pki.storage.GetPrivateKey(ctx), pubKey, subject, certOpts...)
+// GetStaticKeyRotation returns the StaticKey rotation in progress, or nil
+func (pki *PKI) GetStaticKeyRotation(ctx context.Context) *StaticKeyRotation {
+	return pki.storage.GetStaticKeyRotation(ctx)
+}
+
+// GetClientStaticKey returns the static key for new client profiles, the next one during a rotation
+func (pki *PKI) GetClientStaticKey(ctx context.Context) StaticKey {
+	if rotation := pki.storage.GetStaticKeyRotation(ctx); rotation != nil {
+		return rotation.NextKey
+	}
+	return pki.storage.GetStaticKey(ctx)
+}
+
+// GetTLSCryptV2Key returns the tls-crypt-v2 server key once PKI_TLS_CRYPT_V2 is enabled and the switch to it has
+// started, otherwise the configs keep the shared StaticKey
+func (pki *PKI) GetTLSCryptV2Key(ctx context.Context) TLSCryptV2Key {
+	if !configTLSCrypt.V2 || pki.storage.GetTLSCryptV2Switch(ctx) == nil {
+		return nil
+	}
+
+	key := pki.storage.GetTLSCryptV2Key(ctx)
+	if len(key) == 0 {
+		log.Error("The switch to tls-crypt-v2 started but the CA data has no tls-crypt-v2 key, the configs keep tls-crypt")
+	}
+	return key
+}
+
+// TLSCryptV2Legacy tells whether the servers still accept the tls-crypt profiles exported before the switch to
+// tls-crypt-v2, on the main instance while the overlap instance takes tls-crypt-v2
+func (pki *PKI) TLSCryptV2Legacy(ctx context.Context, now time.Time) bool {
+	return pki.GetTLSCryptV2Key(ctx) != nil && pki.storage.GetTLSCryptV2Switch(ctx).Legacy(now)
+}
+
+// TLSCryptV2Enabled tells whether PKI_TLS_CRYPT_V2 is enabled
+func TLSCryptV2Enabled() bool {
+	return configTLSCrypt.V2
+}
+
+func (pki *PKI) signCertificate(ctx context.Context, pubKey crypto.PublicKey, subject pkix.Name, profile Profile, certOpts ...CertOptions) (*CertificateInfo, error) {
+	if err := pki.keyPolicy.Check(pubKey); err != nil {
+		return nil, err
+	}
+
+	certOpts = append([]CertOptions{WithProfile(profile)}, certOpts...)
+	signer, err := pki.storage.GetSigner(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "obtaining CA key")
+	}
+
+	caCert := pki.storage.GetCACert(ctx)
+	cert, err := CreateCertificate(caCert, signer, pubKey, subject, certOpts...)
 	if err != nil {
 		return nil, errors.Wrap(err, "creating certificate")
 	}
 
-	if err := pki.storage.AddCert(ctx, cert); err != nil {
-		return nil, errors.Wrap(err, "storing certificate")
+	// The certificate is discarded before being stored or logged if any name is out of bounds
+	if err := pki.constraints.Check(cert); err != nil {
+		return nil, err
+	}
+
+	for _, ca := range append([]*x509.Certificate{caCert}, pki.storage.GetCAChain(ctx)...) {
+		if err := NameConstraintsFromCert(ca).Check(cert); err != nil {
+			return nil, err
+		}
 	}
 
-	return CertInfoFromX509Cert(cert), nil
+	info := CertInfoFromX509Cert(cert)
+	info.Profile = profile.Name
+
+	return info, nil
+}
+
+func (pki *PKI) CreateCertificate(ctx context.Context, pubKey crypto.PublicKey, subject pkix.Name, profile Profile, certOpts ...CertOptions) (*CertificateInfo, error) {
+	info, err := pki.signCertificate(ctx, pubKey, subject, profile, certOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return pki.logged(ctx, LogEntryIssued, info, func() (*CertificateInfo, error) {
+		if err := pki.storage.AddCert(ctx, info); err != nil {
+			return nil, errors.Wrap(err, "storing certificate")
+		}
+		return info, nil
+	})
+}
+
+// RenewCertificate issues a successor of prev with the same subject, names and profile. prev stays valid for
+// the grace period so the holder can switch over without losing the connection.
+func (pki *PKI) RenewCertificate(ctx context.Context, prev *CertificateInfo, pubKey crypto.PublicKey, grace time.Duration, certOpts ...CertOptions) (*CertificateInfo, error) {
+	if prev.Revoked != nil || prev.OnHold != nil || prev.Successor != "" {
+		return nil, ErrNotRenewable
+	}
+
+	profileName := prev.Profile
+	if profileName == "" {
+		profileName = DefaultProfileName
+	}
+
+	profile, err := Profiles.Get(profileName)
+	if err != nil {
+		return nil, err
+	}
+
+	certOpts = append(certOpts, WithRawSubject(prev.Certificate.RawSubject))
+	if len(prev.Certificate.EmailAddresses) > 0 {
+		certOpts = append(certOpts, WithEmail(prev.Certificate.EmailAddresses...))
+	}
+	if len(prev.Certificate.DNSNames) > 0 {
+		certOpts = append(certOpts, WithDNS(prev.Certificate.DNSNames...))
+	}
+
+	info, err := pki.signCertificate(ctx, pubKey, prev.Certificate.Subject, profile, certOpts...)
+	if err != nil {
+		return nil, err
+	}
+	info.Predecessor = prev.Serial
+
+	revokeAt := time.Now().Add(grace).UTC()
+	if revokeAt.After(prev.NotAfter) {
+		revokeAt = prev.NotAfter
+	}
+
+	return pki.logged(ctx, LogEntryIssued, info, func() (*CertificateInfo, error) {
+		if err := pki.storage.AddRenewedCert(ctx, info, revokeAt); err != nil {
+			return nil, errors.Wrap(err, "storing renewed certificate")
+		}
+		return info, nil
+	})
 }
 
 func (pki *PKI) GetCertBySerial(ctx context.Context, serial []byte) (*CertificateInfo, error) {
@@ -59,6 +203,106 @@ func (pki *PKI) ListCerts(ctx context.Context, subject string) ([]*CertificateIn
 	return pki.storage.ListCertsBySubject(ctx, subject)
 }
 
-func (pki *PKI) RevokeCert(ctx context.Context, serial []byte) (*CertificateInfo, error) {
-	return pki.storage.RevokeCert(ctx, serial)
+// QueryCerts returns a page of the certificates selected by query
+// SortsAcrossPages tells whether QueryCerts applies the sort of the query across pages, see UnsortedPager
+func (pki *PKI) SortsAcrossPages() bool {
+	_, unsorted := pki.storage.(UnsortedPager)
+	return !unsorted
+}
+
+func (pki *PKI) QueryCerts(ctx context.Context, query CertQuery) (*CertPage, error) {
+	query, err := query.Normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	return pki.storage.ListCerts(ctx, query)
+}
+
+func (pki *PKI) RevokeCert(ctx context.Context, serial []byte, revocation Revocation) (*CertificateInfo, error) {
+	change := &CertificateInfo{
+		Serial:           hex.EncodeToString(serial),
+		RevocationReason: revocation.Reason,
+		RevokedBy:        revocation.RevokedBy,
+	}
+	return pki.logChange(ctx, LogEntryRevoked, change, func() (*CertificateInfo, error) {
+		return pki.storage.RevokeCert(ctx, serial, revocation)
+	})
+}
+
+func (pki *PKI) HoldCert(ctx context.Context, serial []byte) (*CertificateInfo, error) {
+	change := &CertificateInfo{Serial: hex.EncodeToString(serial)}
+	return pki.logChange(ctx, LogEntryHeld, change, func() (*CertificateInfo, error) {
+		return pki.storage.HoldCert(ctx, serial)
+	})
+}
+
+func (pki *PKI) ReinstateCert(ctx context.Context, serial []byte) (*CertificateInfo, error) {
+	change := &CertificateInfo{Serial: hex.EncodeToString(serial)}
+	return pki.logChange(ctx, LogEntryReinstated, change, func() (*CertificateInfo, error) {
+		return pki.storage.ReinstateCert(ctx, serial)
+	})
+}
+
+// logChange logs and writes a state change of a stored certificate. Unknown serials aren't logged.
+func (pki *PKI) logChange(ctx context.Context, entryType LogEntryType, change *CertificateInfo, write func() (*CertificateInfo, error)) (*CertificateInfo, error) {
+	if pki.log != nil {
+		serial, _ := hex.DecodeString(change.Serial)
+		if info, err := pki.storage.GetCertBySerial(ctx, serial); err != nil || info == nil {
+			return nil, err
+		}
+	}
+
+	return pki.logged(ctx, entryType, change, write)
+}
+
+func (pki *PKI) CreateCRL(ctx context.Context, validity time.Duration) ([]byte, error) {
+	revoked, err := pki.storage.ListRevokedCerts(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing revoked certificates")
+	}
+
+	signer, err := pki.storage.GetSigner(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "obtaining CA key")
+	}
+
+	crl, err := CreateCRL(pki.storage.GetCACert(ctx), signer, revoked, validity)
+	if err != nil {
+		return nil, errors.Wrap(err, "signing CRL")
+	}
+
+	var buf bytes.Buffer
+	buf.Write(EncodePEMCRL(crl))
+
+	// Certificates issued by the previous CA are listed on a CRL signed again with its key. CA data rotated
+	// before the key was kept only has the CRL signed at the rotation.
+	if prevCA := pki.storage.GetPrevCACert(ctx); prevCA != nil && time.Now().Before(prevCA.NotAfter) {
+		prevCRL, err := pki.createPrevCRL(ctx, prevCA, revoked, validity)
+		if err != nil {
+			// The current CA's CRL must still be served, the one from the rotation is the best left
+			log.WithError(err).Error("Error signing previous CA CRL")
+			prevCRL = pki.storage.GetPrevCRL(ctx)
+		}
+
+		if prevCRL != nil {
+			buf.Write(EncodePEMCRL(prevCRL))
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// createPrevCRL signs the CRL of the previous CA, or returns the one signed at the rotation if its key isn't kept
+func (pki *PKI) createPrevCRL(ctx context.Context, prevCA *x509.Certificate, revoked []*CertificateInfo, validity time.Duration) ([]byte, error) {
+	signer, err := pki.storage.GetPrevSigner(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "obtaining previous CA key")
+	}
+
+	if signer == nil {
+		return pki.storage.GetPrevCRL(ctx), nil
+	}
+
+	return CreateCRL(prevCA, signer, revoked, validity)
 }
@@ -0,0 +1,545 @@
+// Package pkitest has conformance tests for the PKIStorage and LogStorage implementations
+package pkitest
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/pkg/errors"
+)
+
+// issuer signs test certificates without a PKIStorage, they are added to the storage under test directly
+type issuer struct {
+	t      *testing.T
+	caCert *x509.Certificate
+	caKey  crypto.PrivateKey
+}
+
+func newIssuer(t *testing.T) *issuer {
+	caKey, err := pki.NewPrivateKey(pki.KeyAlgorithmP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caCert, err := pki.CreateCertificate(nil, caKey, pki.GetPublicKey(caKey), pkix.Name{CommonName: "Test CA"},
+		pki.CACert, pki.WithDuration(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &issuer{t: t, caCert: caCert, caKey: caKey}
+}
+
+// issue creates a certificate valid from notBefore for validity. Storages keep times with second precision.
+func (i *issuer) issue(subject string, certType pki.CertOptions, notBefore time.Time, validity time.Duration) *pki.CertificateInfo {
+	i.t.Helper()
+
+	privKey, err := pki.NewPrivateKey(pki.KeyAlgorithmP256)
+	if err != nil {
+		i.t.Fatal(err)
+	}
+
+	notBefore = notBefore.Truncate(time.Second)
+	cert, err := pki.CreateCertificate(i.caCert, i.caKey, pki.GetPublicKey(privKey), pkix.Name{CommonName: subject},
+		certType, pki.WithTimespan(notBefore, notBefore.Add(validity)))
+	if err != nil {
+		i.t.Fatal(err)
+	}
+
+	info := pki.CertInfoFromX509Cert(cert)
+	info.Profile = pki.ProfileLaptop
+	return info
+}
+
+func (i *issuer) add(ctx context.Context, storage pki.PKIStorage, subject string, notBefore time.Time, validity time.Duration) *pki.CertificateInfo {
+	i.t.Helper()
+
+	info := i.issue(subject, pki.ClientCert, notBefore, validity)
+	if err := storage.AddCert(ctx, info); err != nil {
+		i.t.Fatal(err)
+	}
+
+	return info
+}
+
+func serials(certs []*pki.CertificateInfo) map[string]bool {
+	res := make(map[string]bool)
+	for _, c := range certs {
+		res[c.Serial] = true
+	}
+	return res
+}
+
+func isErr(err error, target error) bool {
+	return errors.Cause(err) == target
+}
+
+// RunStorageTests checks the certificate operations of the storages returned by newStorage, which must be empty.
+// The CA data getters are left to each implementation.
+func RunStorageTests(t *testing.T, newStorage func(t *testing.T) pki.PKIStorage) {
+	ctx := context.Background()
+
+	t.Run("GetCertBySerial", func(t *testing.T) {
+		storage := newStorage(t)
+		is := newIssuer(t)
+
+		if info, err := storage.GetCertBySerial(ctx, []byte{1, 2, 3}); err != nil || info != nil {
+			t.Fatalf("expected nil for an unknown serial, got %v %v", info, err)
+		}
+
+		added := is.add(ctx, storage, "user@example.com", time.Now(), time.Hour)
+		info, err := storage.GetCertBySerial(ctx, added.SerialBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info == nil || info.Serial != added.Serial || info.Subject != added.Subject || info.KeyId != added.KeyId ||
+			info.CertType != pki.CertTypeClient || info.Profile != pki.ProfileLaptop {
+			t.Fatalf("unexpected certificate %+v", info)
+		}
+
+		if !info.NotBefore.Equal(added.NotBefore) || !info.NotAfter.Equal(added.NotAfter) || !info.Certificate.Equal(added.Certificate) {
+			t.Fatal("unexpected certificate validity or data")
+		}
+
+		if info.Revoked != nil || info.OnHold != nil || info.RevokeAt != nil || info.Successor != "" {
+			t.Fatal("expected an active certificate")
+		}
+	})
+
+	t.Run("ListAllCerts", func(t *testing.T) {
+		storage := newStorage(t)
+		is := newIssuer(t)
+
+		valid := is.add(ctx, storage, "user@example.com", time.Now(), time.Hour)
+		expired := is.add(ctx, storage, "user@example.com", time.Now().Add(-2*time.Hour), time.Hour)
+
+		server := is.issue("vpn.example.com", pki.ServerCert, time.Now(), time.Hour)
+		if err := storage.AddCert(ctx, server); err != nil {
+			t.Fatal(err)
+		}
+
+		certs, err := storage.ListAllCerts(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found := serials(certs)
+		if !found[valid.Serial] || found[expired.Serial] || found[server.Serial] || len(certs) != 1 {
+			t.Fatal("expected only the valid client certificate")
+		}
+	})
+
+	t.Run("ListCertsBySubject", func(t *testing.T) {
+		storage := newStorage(t)
+		is := newIssuer(t)
+
+		var issued []*pki.CertificateInfo
+		for i := 0; i < 3; i++ {
+			issued = append(issued, is.add(ctx, storage, "user@example.com", time.Now().Add(time.Duration(i-3)*time.Minute), time.Hour))
+		}
+		is.add(ctx, storage, "user@example.com", time.Now().Add(-2*time.Hour), time.Hour)
+		is.add(ctx, storage, "user@example.com.evil", time.Now(), time.Hour)
+
+		certs, err := storage.ListCertsBySubject(ctx, "user@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(certs) != 3 || certs[0].Serial != issued[2].Serial || certs[1].Serial != issued[1].Serial || certs[2].Serial != issued[0].Serial {
+			t.Fatal("expected the valid certificates of the subject newest first")
+		}
+
+		if certs, err := storage.ListCertsBySubject(ctx, "user@example"); err != nil || len(certs) != 0 {
+			t.Fatal("subject lookup must not match prefixes")
+		}
+	})
+
+	t.Run("ListCerts", func(t *testing.T) {
+		storage := newStorage(t)
+		is := newIssuer(t)
+
+		now := time.Now().Truncate(time.Second)
+		var active []*pki.CertificateInfo
+		for i := 0; i < 3; i++ {
+			active = append(active, is.add(ctx, storage, "user@example.com", now.Add(time.Duration(i-10)*time.Minute), time.Hour))
+		}
+		other := is.add(ctx, storage, "other@example.com", now.Add(-5*time.Minute), 2*time.Hour)
+		expired := is.add(ctx, storage, "user@example.com", now.Add(-2*time.Hour), time.Hour)
+		revoked := is.add(ctx, storage, "user@example.com", now.Add(-4*time.Minute), time.Hour)
+		held := is.add(ctx, storage, "user@example.com", now.Add(-3*time.Minute), time.Hour)
+
+		server := is.issue("vpn.example.com", pki.ServerCert, now.Add(-time.Minute), time.Hour)
+		if err := storage.AddCert(ctx, server); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := storage.RevokeCert(ctx, revoked.SerialBytes, pki.Revocation{Reason: pki.ReasonKeyCompromise}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := storage.HoldCert(ctx, held.SerialBytes); err != nil {
+			t.Fatal(err)
+		}
+
+		list := func(q pki.CertQuery) []string {
+			t.Helper()
+
+			q, err := q.Normalize()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var res []string
+			for {
+				page, err := storage.ListCerts(ctx, q)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if len(page.Certs) > q.Limit {
+					t.Fatalf("page of %d certificates over the limit of %d", len(page.Certs), q.Limit)
+				}
+
+				for i := 1; i < len(page.Certs); i++ {
+					if q.Before(q.SortKey(page.Certs[i]), q.SortKey(page.Certs[i-1])) {
+						t.Fatal("page not sorted")
+					}
+				}
+
+				for _, c := range page.Certs {
+					res = append(res, c.Serial)
+				}
+
+				if page.NextCursor == "" {
+					return res
+				}
+				q.Cursor = page.NextCursor
+			}
+		}
+
+		expect := func(name string, got []string, certs ...*pki.CertificateInfo) {
+			t.Helper()
+
+			if len(got) != len(certs) {
+				t.Fatalf("%s: expected %d certificates, got %d", name, len(certs), len(got))
+			}
+
+			// Storages sorting only within pages return the certificates in any order across them
+			if _, ok := storage.(pki.UnsortedPager); ok {
+				want := serials(certs)
+				for _, serial := range got {
+					if !want[serial] {
+						t.Fatalf("%s: unexpected or repeated certificate %s", name, serial)
+					}
+					delete(want, serial)
+				}
+				return
+			}
+
+			for i, c := range certs {
+				if got[i] != c.Serial {
+					t.Fatalf("%s: unexpected certificate at %d", name, i)
+				}
+			}
+		}
+
+		// All of them in issuance order, across pages
+		all := []*pki.CertificateInfo{expired, active[0], active[1], active[2], other, revoked, held, server}
+		expect("all", list(pki.CertQuery{Limit: 3}), all...)
+		expect("descending", list(pki.CertQuery{Limit: 2, Descending: true}), server, held, revoked, other, active[2], active[1], active[0], expired)
+		expect("by expiration", list(pki.CertQuery{SortBy: pki.SortByExpiresAt, Limit: 5}), expired, active[0], active[1], active[2], revoked, held, server, other)
+
+		expect("active", list(pki.CertQuery{Status: pki.CertStatusActive}), active[0], active[1], active[2], other, server)
+		expect("revoked", list(pki.CertQuery{Status: pki.CertStatusRevoked}), revoked, held)
+		expect("expired", list(pki.CertQuery{Status: pki.CertStatusExpired}), expired)
+		expect("server", list(pki.CertQuery{Type: pki.CertTypeServer}), server)
+		expect("active clients", list(pki.CertQuery{Type: pki.CertTypeClient, Status: pki.CertStatusActive, Limit: 1}), active[0], active[1], active[2], other)
+
+		expect("subject prefix", list(pki.CertQuery{SubjectPrefix: "other@"}), other)
+		expect("key ID", list(pki.CertQuery{KeyId: strings.ToUpper(active[1].KeyId)}), active[1])
+		expect("issued range", list(pki.CertQuery{IssuedAfter: active[1].NotBefore, IssuedBefore: revoked.NotBefore}), active[1], active[2], other)
+		expect("expiring range", list(pki.CertQuery{ExpiresAfter: now, ExpiresBefore: active[2].NotAfter.Add(time.Second)}), active[0], active[1], active[2])
+
+		if _, err := storage.ListCerts(ctx, pki.CertQuery{Cursor: "not a cursor"}); !isErr(err, pki.ErrInvalidCursor) {
+			t.Fatalf("expected ErrInvalidCursor, got %v", err)
+		}
+	})
+
+	t.Run("ImportCert", func(t *testing.T) {
+		storage := newStorage(t)
+		importer, ok := storage.(pki.CertImporter)
+		if !ok {
+			t.Skip("storage doesn't import certificates")
+		}
+		is := newIssuer(t)
+
+		now := time.Now().UTC().Truncate(time.Second)
+		revokedAt, heldAt, revokeAt := now.Add(-time.Minute), now.Add(-2*time.Minute), now.Add(time.Hour)
+
+		active := is.issue("user@example.com", pki.ClientCert, now.Add(-time.Hour), 2*time.Hour)
+		expired := is.issue("user@example.com", pki.ClientCert, now.Add(-2*time.Hour), time.Hour)
+
+		revoked := is.issue("user@example.com", pki.ClientCert, now.Add(-time.Hour), 2*time.Hour)
+		revoked.Revoked = &revokedAt
+		revoked.RevocationReason = pki.ReasonKeyCompromise
+		revoked.RevokedBy = "admin@example.com"
+		revoked.RevocationComment = "lost laptop"
+
+		held := is.issue("user@example.com", pki.ClientCert, now.Add(-time.Hour), 2*time.Hour)
+		held.OnHold = &heldAt
+
+		renewed := is.issue("user@example.com", pki.ClientCert, now, time.Hour)
+		prev := is.issue("user@example.com", pki.ClientCert, now.Add(-time.Hour), 2*time.Hour)
+		prev.Successor = renewed.Serial
+		prev.RevokeAt = &revokeAt
+		prev.RevocationReason = pki.ReasonSuperseded
+		renewed.Predecessor = prev.Serial
+
+		for _, info := range []*pki.CertificateInfo{active, expired, revoked, held, prev, renewed} {
+			if err := importer.ImportCert(ctx, info); err != nil {
+				t.Fatal(err)
+			}
+
+			stored, err := storage.GetCertBySerial(ctx, info.SerialBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if stored == nil {
+				t.Fatal("expected the imported certificate")
+			}
+
+			if diff := info.Diff(stored); len(diff) > 0 {
+				t.Fatalf("imported certificate differs in %v", diff)
+			}
+		}
+
+		// Importing again replaces the state
+		active.Revoked = &revokedAt
+		if err := importer.ImportCert(ctx, active); err != nil {
+			t.Fatal(err)
+		}
+
+		if stored, err := storage.GetCertBySerial(ctx, active.SerialBytes); err != nil || len(active.Diff(stored)) > 0 {
+			t.Fatal("expected the imported certificate to be replaced")
+		}
+
+		if _, err := storage.RevokeCert(ctx, revoked.SerialBytes, pki.Revocation{}); !isErr(err, pki.ErrInvalidCertState) {
+			t.Fatalf("expected ErrInvalidCertState revoking an imported revoked certificate, got %v", err)
+		}
+
+		if _, err := storage.ReinstateCert(ctx, held.SerialBytes); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("RevokeCert", func(t *testing.T) {
+		storage := newStorage(t)
+		is := newIssuer(t)
+
+		added := is.add(ctx, storage, "user@example.com", time.Now(), time.Hour)
+		active := is.add(ctx, storage, "user@example.com", time.Now(), time.Hour)
+
+		revocation := pki.Revocation{Reason: pki.ReasonKeyCompromise, RevokedBy: "admin@example.com", Comment: "lost laptop"}
+		info, err := storage.RevokeCert(ctx, added.SerialBytes, revocation)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info == nil || info.Revoked == nil || info.RevocationReason != pki.ReasonKeyCompromise ||
+			info.RevokedBy != "admin@example.com" || info.RevocationComment != "lost laptop" {
+			t.Fatalf("unexpected revoked certificate %+v", info)
+		}
+
+		if _, err := storage.RevokeCert(ctx, added.SerialBytes, revocation); !isErr(err, pki.ErrInvalidCertState) {
+			t.Fatalf("expected ErrInvalidCertState revoking twice, got %v", err)
+		}
+
+		if info, err := storage.RevokeCert(ctx, []byte{1, 2, 3}, revocation); err != nil || info != nil {
+			t.Fatalf("expected nil revoking an unknown certificate, got %v %v", info, err)
+		}
+
+		revoked, err := storage.ListRevokedCerts(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if found := serials(revoked); !found[added.Serial] || found[active.Serial] {
+			t.Fatal("expected only the revoked certificate in the revocation list")
+		}
+
+		if info, err := storage.GetCertBySerial(ctx, added.SerialBytes); err != nil || info.Revoked == nil {
+			t.Fatal("expected the revocation to be stored")
+		}
+	})
+
+	t.Run("RevokeOnce", func(t *testing.T) {
+		storage := newStorage(t)
+		is := newIssuer(t)
+
+		added := is.add(ctx, storage, "user@example.com", time.Now(), time.Hour)
+
+		// Concurrent revocations of the same certificate succeed exactly once
+		const workers = 8
+		errs := make(chan error, workers)
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := storage.RevokeCert(ctx, added.SerialBytes, pki.Revocation{Reason: pki.ReasonUnspecified})
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		succeeded := 0
+		for err := range errs {
+			if err == nil {
+				succeeded++
+			} else if !isErr(err, pki.ErrInvalidCertState) {
+				t.Fatal(err)
+			}
+		}
+
+		if succeeded != 1 {
+			t.Fatalf("expected exactly one revocation, got %d", succeeded)
+		}
+	})
+
+	t.Run("HoldCert", func(t *testing.T) {
+		storage := newStorage(t)
+		is := newIssuer(t)
+
+		added := is.add(ctx, storage, "user@example.com", time.Now(), time.Hour)
+
+		info, err := storage.HoldCert(ctx, added.SerialBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info == nil || info.OnHold == nil || info.Revoked != nil {
+			t.Fatalf("unexpected held certificate %+v", info)
+		}
+
+		if _, err := storage.HoldCert(ctx, added.SerialBytes); !isErr(err, pki.ErrInvalidCertState) {
+			t.Fatalf("expected ErrInvalidCertState holding twice, got %v", err)
+		}
+
+		if info, err := storage.HoldCert(ctx, []byte{1, 2, 3}); err != nil || info != nil {
+			t.Fatalf("expected nil holding an unknown certificate, got %v %v", info, err)
+		}
+
+		revoked, err := storage.ListRevokedCerts(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !serials(revoked)[added.Serial] {
+			t.Fatal("expected the held certificate in the revocation list")
+		}
+
+		info, err = storage.ReinstateCert(ctx, added.SerialBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info == nil || info.OnHold != nil || info.Revoked != nil {
+			t.Fatalf("unexpected reinstated certificate %+v", info)
+		}
+
+		if _, err := storage.ReinstateCert(ctx, added.SerialBytes); !isErr(err, pki.ErrInvalidCertState) {
+			t.Fatalf("expected ErrInvalidCertState reinstating an active certificate, got %v", err)
+		}
+
+		if revoked, err := storage.ListRevokedCerts(ctx); err != nil || len(revoked) != 0 {
+			t.Fatal("expected an empty revocation list after reinstating")
+		}
+
+		// Held certificates can still be revoked, but revoked ones can't be held or reinstated
+		if _, err := storage.HoldCert(ctx, added.SerialBytes); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := storage.RevokeCert(ctx, added.SerialBytes, pki.Revocation{Reason: pki.ReasonKeyCompromise}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := storage.ReinstateCert(ctx, added.SerialBytes); !isErr(err, pki.ErrInvalidCertState) {
+			t.Fatalf("expected ErrInvalidCertState reinstating a revoked certificate, got %v", err)
+		}
+
+		if _, err := storage.HoldCert(ctx, added.SerialBytes); !isErr(err, pki.ErrInvalidCertState) {
+			t.Fatalf("expected ErrInvalidCertState holding a revoked certificate, got %v", err)
+		}
+	})
+
+	t.Run("AddRenewedCert", func(t *testing.T) {
+		storage := newStorage(t)
+		is := newIssuer(t)
+
+		prev := is.add(ctx, storage, "user@example.com", time.Now().Add(-time.Minute), time.Hour)
+
+		renewed := is.issue("user@example.com", pki.ClientCert, time.Now(), time.Hour)
+		renewed.Predecessor = prev.Serial
+		if err := storage.AddRenewedCert(ctx, renewed, time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := storage.GetCertBySerial(ctx, prev.SerialBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Successor != renewed.Serial || info.RevokeAt == nil || info.Revoked != nil || info.RevocationReason != pki.ReasonSuperseded {
+			t.Fatalf("expected the predecessor to be scheduled for revocation, got %+v", info)
+		}
+
+		if info, err := storage.GetCertBySerial(ctx, renewed.SerialBytes); err != nil || info == nil || info.Predecessor != prev.Serial {
+			t.Fatal("expected the renewed certificate to link to its predecessor")
+		}
+
+		if revoked, err := storage.ListRevokedCerts(ctx); err != nil || len(revoked) != 0 {
+			t.Fatal("certificates scheduled for revocation must not be in the revocation list")
+		}
+
+		again := is.issue("user@example.com", pki.ClientCert, time.Now(), time.Hour)
+		again.Predecessor = prev.Serial
+		if err := storage.AddRenewedCert(ctx, again, time.Now().Add(time.Hour)); !isErr(err, pki.ErrNotRenewable) {
+			t.Fatalf("expected ErrNotRenewable renewing twice, got %v", err)
+		}
+
+		if info, err := storage.GetCertBySerial(ctx, again.SerialBytes); err != nil || info != nil {
+			t.Fatal("a failed renewal must not store the certificate")
+		}
+
+		// Revoking a certificate scheduled for revocation brings the revocation forward
+		info, err = storage.RevokeCert(ctx, prev.SerialBytes, pki.Revocation{Reason: pki.ReasonKeyCompromise})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Revoked == nil || info.RevokeAt != nil {
+			t.Fatalf("expected the predecessor to be revoked, got %+v", info)
+		}
+
+		held := is.add(ctx, storage, "user@example.com", time.Now(), time.Hour)
+		if _, err := storage.HoldCert(ctx, held.SerialBytes); err != nil {
+			t.Fatal(err)
+		}
+
+		renewed = is.issue("user@example.com", pki.ClientCert, time.Now(), time.Hour)
+		renewed.Predecessor = held.Serial
+		if err := storage.AddRenewedCert(ctx, renewed, time.Now().Add(time.Hour)); !isErr(err, pki.ErrNotRenewable) {
+			t.Fatalf("expected ErrNotRenewable renewing a held certificate, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package pkitest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+)
+
+func logEntry(index uint64, prev *pki.LogEntry) *pki.LogEntry {
+	entry := &pki.LogEntry{
+		Index:     index,
+		Timestamp: time.Now().UTC(),
+		Type:      pki.LogEntryIssued,
+		Serial:    "0a0b0c",
+		Actor:     "admin@example.com",
+	}
+
+	if index%2 == 0 {
+		certHash := sha256.Sum256([]byte{byte(index)})
+		entry.CertHash = certHash[:]
+	} else {
+		entry.Type = pki.LogEntryRevoked
+		entry.Reason = pki.ReasonKeyCompromise
+	}
+
+	if prev != nil {
+		entry.PrevHash = prev.Hash
+	}
+	entry.Hash = entry.ComputeHash()
+
+	return entry
+}
+
+// RunLogStorageTests checks the issuance log operations of the storages returned by newStorage, which must be empty
+func RunLogStorageTests(t *testing.T, newStorage func(t *testing.T) pki.LogStorage) {
+	ctx := context.Background()
+
+	t.Run("LogEntries", func(t *testing.T) {
+		storage := newStorage(t)
+
+		if head, err := storage.GetLogHead(ctx); err != nil || head != nil {
+			t.Fatalf("expected an empty log, got %v %v", head, err)
+		}
+
+		var entries []*pki.LogEntry
+		var prev *pki.LogEntry
+		for i := uint64(0); i < 5; i++ {
+			prev = logEntry(i, prev)
+			if err := storage.AppendLogEntry(ctx, prev); err != nil {
+				t.Fatal(err)
+			}
+			entries = append(entries, prev)
+		}
+
+		if err := storage.AppendLogEntry(ctx, logEntry(4, entries[3])); err != pki.ErrLogConflict {
+			t.Fatalf("expected ErrLogConflict appending an existing index, got %v", err)
+		}
+
+		head, err := storage.GetLogHead(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if head == nil || head.Index != 4 || !bytes.Equal(head.Hash, entries[4].Hash) {
+			t.Fatalf("unexpected log head %+v", head)
+		}
+
+		listed, err := storage.ListLogEntries(ctx, 1, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(listed) != 3 {
+			t.Fatalf("expected 3 entries, got %d", len(listed))
+		}
+
+		// The stored entries must hash the same, including the timestamp nanoseconds
+		for i, entry := range listed {
+			expected := entries[i+1]
+			if entry.Index != expected.Index || !entry.Timestamp.Equal(expected.Timestamp) || entry.Type != expected.Type ||
+				entry.Reason != expected.Reason || !bytes.Equal(entry.ComputeHash(), expected.Hash) {
+				t.Fatalf("unexpected entry %+v, expected %+v", entry, expected)
+			}
+		}
+
+		if listed, err := storage.ListLogEntries(ctx, 5, 10); err != nil || len(listed) != 0 {
+			t.Fatal("expected no entries past the head")
+		}
+	})
+
+	t.Run("TreeHeads", func(t *testing.T) {
+		storage := newStorage(t)
+
+		if th, err := storage.GetTreeHead(ctx); err != nil || th != nil {
+			t.Fatalf("expected no tree head, got %v %v", th, err)
+		}
+
+		now := time.Now().UTC()
+		for i, ts := range []time.Time{now.Add(-time.Minute), now} {
+			th := &pki.TreeHead{
+				Size:      uint64(i + 1),
+				Hash:      []byte{byte(i)},
+				Timestamp: ts,
+				KeyId:     []byte{1},
+				Signature: []byte{2},
+			}
+			if err := storage.PutTreeHead(ctx, th); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		th, err := storage.GetTreeHead(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if th == nil || th.Size != 2 || !th.Timestamp.Equal(now) || !bytes.Equal(th.Hash, []byte{1}) {
+			t.Fatalf("expected the latest tree head, got %+v", th)
+		}
+	})
+}
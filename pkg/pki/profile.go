@@ -0,0 +1,218 @@
+package pki
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ProfileLaptop     = "laptop"
+	ProfileMobile     = "mobile"
+	ProfileContractor = "contractor"
+	ProfileService    = "service"
+	ProfileServer     = "server"
+)
+
+var (
+	ErrUnknownProfile = errors.New("unknown certificate profile")
+
+	keyUsageNames = map[string]x509.KeyUsage{
+		"digitalSignature":  x509.KeyUsageDigitalSignature,
+		"contentCommitment": x509.KeyUsageContentCommitment,
+		"keyEncipherment":   x509.KeyUsageKeyEncipherment,
+		"dataEncipherment":  x509.KeyUsageDataEncipherment,
+		"keyAgreement":      x509.KeyUsageKeyAgreement,
+	}
+
+	extKeyUsageNames = map[string]x509.ExtKeyUsage{
+		"clientAuth":      x509.ExtKeyUsageClientAuth,
+		"serverAuth":      x509.ExtKeyUsageServerAuth,
+		"codeSigning":     x509.ExtKeyUsageCodeSigning,
+		"emailProtection": x509.ExtKeyUsageEmailProtection,
+	}
+)
+
+// Profile describes the kind of certificate issued to a class of devices
+type Profile struct {
+	Name        string
+	KeyUsage    x509.KeyUsage
+	ExtKeyUsage []x509.ExtKeyUsage
+	Validity    time.Duration
+
+	// EmailSAN includes the requester's email, DNSNames are always included
+	EmailSAN bool
+	DNSNames []string
+
+	Extensions []pkix.Extension
+}
+
+type storedProfile struct {
+	KeyUsage     []string `json:"keyUsage"`
+	ExtKeyUsage  []string `json:"extKeyUsage"`
+	ValidityDays int      `json:"validityDays"`
+
+	SAN struct {
+		Email bool     `json:"email"`
+		DNS   []string `json:"dns"`
+	} `json:"san"`
+
+	Extensions []struct {
+		OID      string `json:"oid"`
+		Critical bool   `json:"critical"`
+		Value    []byte `json:"value"`
+	} `json:"extensions"`
+}
+
+func (p *Profile) UnmarshalJSON(data []byte) error {
+	var stored storedProfile
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return errors.Wrap(err, "unmarshal profile")
+	}
+
+	if stored.ValidityDays <= 0 {
+		return errors.New("profile validity must be positive")
+	}
+
+	p.KeyUsage = 0
+	for _, name := range stored.KeyUsage {
+		ku, ok := keyUsageNames[name]
+		if !ok {
+			return errors.Errorf("unknown key usage %s", name)
+		}
+		p.KeyUsage |= ku
+	}
+
+	p.ExtKeyUsage = nil
+	for _, name := range stored.ExtKeyUsage {
+		eku, ok := extKeyUsageNames[name]
+		if !ok {
+			return errors.Errorf("unknown extended key usage %s", name)
+		}
+		p.ExtKeyUsage = append(p.ExtKeyUsage, eku)
+	}
+
+	p.Validity = time.Duration(stored.ValidityDays) * 24 * time.Hour
+	p.EmailSAN = stored.SAN.Email
+	p.DNSNames = stored.SAN.DNS
+
+	p.Extensions = nil
+	for _, ext := range stored.Extensions {
+		oid, err := parseOID(ext.OID)
+		if err != nil {
+			return err
+		}
+		p.Extensions = append(p.Extensions, pkix.Extension{Id: oid, Critical: ext.Critical, Value: ext.Value})
+	}
+
+	return nil
+}
+
+func parseOID(s string) (asn1.ObjectIdentifier, error) {
+	var oid asn1.ObjectIdentifier
+	for _, part := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("invalid OID %s", s)
+		}
+		oid = append(oid, n)
+	}
+
+	if len(oid) < 2 {
+		return nil, errors.Errorf("invalid OID %s", s)
+	}
+
+	return oid, nil
+}
+
+// CSRPolicy returns the names a requester with the given email may get in this profile
+func (p Profile) CSRPolicy(email string) CSRPolicy {
+	policy := CSRPolicy{DNSNames: p.DNSNames}
+	if p.EmailSAN && email != "" {
+		policy.Emails = []string{email}
+	}
+	return policy
+}
+
+// WithProfile sets the usages, validity and extensions of the profile
+func WithProfile(p Profile) CertOptions {
+	notBefore := time.Now()
+	return func(cert *x509.Certificate) {
+		cert.IsCA = false
+		cert.BasicConstraintsValid = true
+		cert.KeyUsage = p.KeyUsage
+		cert.ExtKeyUsage = p.ExtKeyUsage
+		cert.NotBefore = notBefore
+		cert.NotAfter = notBefore.Add(p.Validity)
+		cert.ExtraExtensions = append(cert.ExtraExtensions, p.Extensions...)
+	}
+}
+
+type ProfileRegistry map[string]Profile
+
+func (r ProfileRegistry) Get(name string) (Profile, error) {
+	p, ok := r[name]
+	if !ok {
+		return Profile{}, errors.Wrap(ErrUnknownProfile, name)
+	}
+	return p, nil
+}
+
+// Load reads a JSON object of profiles by name, replacing any existing profile with the same name
+func (r ProfileRegistry) Load(reader io.Reader) error {
+	var loaded map[string]Profile
+	if err := json.NewDecoder(reader).Decode(&loaded); err != nil {
+		return errors.Wrap(err, "decoding profiles")
+	}
+
+	for name, p := range loaded {
+		p.Name = name
+		r[name] = p
+	}
+
+	return nil
+}
+
+// MaxValidity returns the longest validity of the profiles, how long a profile issued now may be used
+func (r ProfileRegistry) MaxValidity() time.Duration {
+	var max time.Duration
+	for _, p := range r {
+		if p.Validity > max {
+			max = p.Validity
+		}
+	}
+	return max
+}
+
+func clientProfile(name string, validity time.Duration) Profile {
+	return Profile{
+		Name:        name,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		Validity:    validity,
+		EmailSAN:    true,
+	}
+}
+
+// NewDefaultProfiles returns the built-in profiles, which match the certificates issued before profiles existed
+func NewDefaultProfiles() ProfileRegistry {
+	return ProfileRegistry{
+		ProfileLaptop:     clientProfile(ProfileLaptop, 30*24*time.Hour),
+		ProfileMobile:     clientProfile(ProfileMobile, 30*24*time.Hour),
+		ProfileContractor: clientProfile(ProfileContractor, 7*24*time.Hour),
+		ProfileService:    clientProfile(ProfileService, 365*24*time.Hour),
+		ProfileServer: {
+			Name:        ProfileServer,
+			KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			Validity:    30 * 24 * time.Hour,
+		},
+	}
+}
@@ -0,0 +1,78 @@
+package pki
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const kProfilesJSON = `{
+	"contractor": {
+		"keyUsage": ["digitalSignature"],
+		"extKeyUsage": ["clientAuth"],
+		"validityDays": 3,
+		"san": {"email": true},
+		"extensions": [{"oid": "1.3.6.1.4.1.99999.1", "value": "BQA="}]
+	}
+}`
+
+func TestProfiles(t *testing.T) {
+	profiles := NewDefaultProfiles()
+	if err := profiles.Load(strings.NewReader(kProfilesJSON)); err != nil {
+		t.Fatal(err)
+	}
+
+	if validity := profiles.MaxValidity(); validity != 365*24*time.Hour {
+		t.Fatalf("unexpected max validity %s", validity)
+	}
+
+	if _, err := profiles.Get("unknown"); err == nil {
+		t.Fatal("expected unknown profile error")
+	}
+
+	profile, err := profiles.Get(ProfileContractor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caKey, err := NewCAKey(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := append(profile.CSRPolicy("user@example.com").Options(nil), WithProfile(profile))
+	cert, err := CreateCertificate(caKey.CACert, caKey.PrivateKey, caKey.PublicKey, pkix.Name{CommonName: "user@example.com"}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if validity := cert.NotAfter.Sub(cert.NotBefore); validity != 3*24*time.Hour {
+		t.Fatalf("unexpected validity %s", validity)
+	}
+
+	if len(cert.EmailAddresses) != 1 || cert.EmailAddresses[0] != "user@example.com" {
+		t.Fatalf("unexpected emails %v", cert.EmailAddresses)
+	}
+
+	if len(cert.ExtKeyUsage) != 1 || cert.ExtKeyUsage[0] != x509.ExtKeyUsageClientAuth {
+		t.Fatalf("unexpected EKUs %v", cert.ExtKeyUsage)
+	}
+
+	found := false
+	for _, ext := range cert.Extensions {
+		if ext.Id.String() == "1.3.6.1.4.1.99999.1" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("custom extension not found")
+	}
+
+	if GetCertType(cert) != CertTypeClient {
+		t.Fatalf("unexpected cert type %s", GetCertType(cert))
+	}
+}
@@ -0,0 +1,150 @@
+package pki
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const kRootCAPEMType = "ENCRYPTED ROOT CA"
+
+// RootCA is an offline CA that only signs issuing intermediates. It is never stored in the online secret.
+type RootCA struct {
+	PrivateKey crypto.PrivateKey
+	CACert     *x509.Certificate
+}
+
+type storedRootCA struct {
+	PrivateKey jose.JSONWebKey `json:"key"`
+	CACert     []byte          `json:"ca"`
+}
+
+func NewRootCA(caName string, serialNumber string, alg KeyAlgorithm, duration time.Duration, caOpts ...CertOptions) (RootCA, error) {
+	privKey, err := NewPrivateKey(alg)
+	if err != nil {
+		return RootCA{}, errors.Wrap(err, "error generating key")
+	}
+
+	pkiName := pkix.Name{CommonName: caName, SerialNumber: serialNumber}
+	opts := append([]CertOptions{CACert, WithDuration(duration), WithMaxPathLen(1)}, caOpts...)
+	caCert, err := CreateCertificate(nil, privKey, GetPublicKey(privKey), pkiName, opts...)
+	if err != nil {
+		return RootCA{}, errors.Wrap(err, "error signing root certificate")
+	}
+
+	return RootCA{
+		PrivateKey: privKey,
+		CACert:     caCert,
+	}, nil
+}
+
+// Encrypt exports the root CA as a passphrase protected PEM block
+func (r RootCA) Encrypt(passphrase []byte) ([]byte, error) {
+	data, err := json.Marshal(storedRootCA{
+		PrivateKey: NewJSONWebKey(r.PrivateKey),
+		CACert:     r.CACert.Raw,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding root CA")
+	}
+
+	return EncryptPEM(kRootCAPEMType, data, passphrase)
+}
+
+func DecryptRootCA(data []byte, passphrase []byte) (RootCA, error) {
+	plain, err := DecryptPEM(kRootCAPEMType, data, passphrase)
+	if err != nil {
+		return RootCA{}, err
+	}
+
+	var stored storedRootCA
+	if err := json.Unmarshal(plain, &stored); err != nil {
+		return RootCA{}, errors.Wrap(err, "unmarshal root CA")
+	}
+
+	caCert, err := x509.ParseCertificate(stored.CACert)
+	if err != nil {
+		return RootCA{}, errors.Wrap(err, "parsing root certificate")
+	}
+
+	return RootCA{
+		PrivateKey: JSONWebKeyKey(stored.PrivateKey),
+		CACert:     caCert,
+	}, nil
+}
+
+// signIntermediate returns the key and certificate of a new intermediate, without anything else
+func (r RootCA) signIntermediate(caName string, serialNumber string, alg KeyAlgorithm, duration time.Duration, caOpts []CertOptions) (CAData, error) {
+	privKey, keyURI, signer, err := newCAPrivateKey(alg, caName+" "+serialNumber)
+	if err != nil {
+		return CAData{}, err
+	}
+
+	notAfter := time.Now().Add(duration)
+	if notAfter.After(r.CACert.NotAfter) {
+		notAfter = r.CACert.NotAfter
+	}
+
+	pkiName := pkix.Name{CommonName: caName, SerialNumber: serialNumber}
+	opts := append([]CertOptions{CACert, WithExpiration(notAfter), WithMaxPathLen(0)}, caOpts...)
+	caCert, err := CreateCertificate(r.CACert, r.PrivateKey, signer.Public(), pkiName, opts...)
+	if err != nil {
+		return CAData{}, errors.Wrap(err, "error signing intermediate certificate")
+	}
+
+	return CAData{
+		PrivateKey: privKey,
+		KeyURI:     keyURI,
+		PublicKey:  signer.Public(),
+		CACert:     caCert,
+		Chain:      []*x509.Certificate{r.CACert},
+	}, nil
+}
+
+// NewIntermediate creates the online issuing CA, signed by the root
+func (r RootCA) NewIntermediate(caName string, serialNumber string, alg KeyAlgorithm, duration time.Duration, caOpts ...CertOptions) (CAData, error) {
+	newCA, err := r.signIntermediate(caName, serialNumber, alg, duration, caOpts)
+	if err != nil {
+		return CAData{}, err
+	}
+
+	newCA.StaticKey = NewStaticKey()
+	newCA.StaticKeyCreated = time.Now().UTC()
+	newCA.TLSCryptV2Key = NewTLSCryptV2Key()
+	return newCA, nil
+}
+
+// RenewIntermediate replaces the issuing CA in k. Both intermediates chain to the root, so no cross-signing is needed.
+func (r RootCA) RenewIntermediate(k CAData, caName string, serialNumber string, alg KeyAlgorithm, duration time.Duration, caOpts ...CertOptions) (CAData, error) {
+	oldSigner, err := k.Signer(context.Background())
+	if err != nil {
+		return CAData{}, err
+	}
+
+	newCA, err := r.signIntermediate(caName, serialNumber, alg, duration, caOpts)
+	if err != nil {
+		return CAData{}, err
+	}
+
+	ocspName := pkix.Name{CommonName: caName + " OCSP Responder", SerialNumber: serialNumber}
+	newCA.PrevOCSPCert, err = CreateCertificate(k.CACert, oldSigner, newCA.PublicKey, ocspName, OCSPSigningCert, WithExpiration(k.CACert.NotAfter))
+	if err != nil {
+		return CAData{}, errors.Wrap(err, "error signing OCSP responder certificate")
+	}
+
+	newCA.PrevCACert = k.CACert
+	newCA.PrevPrivateKey = k.PrivateKey
+	newCA.PrevKeyURI = k.KeyURI
+	newCA.StaticKey = k.StaticKey
+	newCA.StaticKeyCreated = k.StaticKeyCreated
+	newCA.StaticKeyRotation = k.StaticKeyRotation
+	newCA.TLSCryptV2Key = k.tlsCryptV2Key()
+	newCA.TLSCryptV2Switch = k.TLSCryptV2Switch
+	return newCA, nil
+}
@@ -0,0 +1,84 @@
+package pki
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRootCA(t *testing.T) {
+	root, err := NewRootCA(kCAName+" Root", uuid.New().String(), KeyAlgorithmP384, 10*kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := root.Encrypt([]byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := DecryptRootCA(encrypted, []byte("wrong")); err == nil {
+		t.Fatal("expected error with wrong passphrase")
+	}
+
+	root, err = DecryptRootCA(encrypted, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caKey, err := root.NewIntermediate(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored CAData
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatal(err)
+	}
+
+	// The intermediate can't turn into a self-signed CA, which would lose the chain to the root
+	if _, err := stored.Renew(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration); err != ErrIntermediateRenewal {
+		t.Fatalf("expected ErrIntermediateRenewal renewing an intermediate, got %v", err)
+	}
+
+	rCaKey, err := root.RenewIntermediate(stored, kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.CACert)
+
+	// Certificates from both intermediates must validate against the root alone
+	for _, ca := range []CAData{stored, rCaKey} {
+		cert, err := CreateCertificate(ca.CACert, ca.PrivateKey, ca.PublicKey, pkix.Name{CommonName: "test"}, ClientCert, WithDuration(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		intermediates := x509.NewCertPool()
+		intermediates.AddCert(ca.CACert)
+
+		if _, err := cert.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Intermediates can't sign further CAs
+	if rCaKey.CACert.MaxPathLen != 0 || !rCaKey.CACert.MaxPathLenZero {
+		t.Fatalf("unexpected path length %d", rCaKey.CACert.MaxPathLen)
+	}
+}
@@ -0,0 +1,186 @@
+package pki
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const kRotationTestValidity = 5 * time.Minute
+
+// trustAnchor is the certificate clients trust for d: the root when the CA is an intermediate
+func (k CAData) trustAnchor() *x509.Certificate {
+	if len(k.Chain) > 0 {
+		return k.Chain[len(k.Chain)-1]
+	}
+	return k.CACert
+}
+
+// intermediates are the certificates between the leaves issued by d and its trust anchor
+func (k CAData) intermediates() []*x509.Certificate {
+	if len(k.Chain) == 0 {
+		return nil
+	}
+	return append([]*x509.Certificate{k.CACert}, k.Chain[:len(k.Chain)-1]...)
+}
+
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
+
+// checkCACert checks cert can issue certificates now
+func checkCACert(name string, cert *x509.Certificate, now time.Time) error {
+	switch {
+	case cert == nil:
+		return errors.Errorf("%s missing", name)
+	case !cert.BasicConstraintsValid || !cert.IsCA:
+		return errors.Errorf("%s is not a CA certificate", name)
+	case cert.KeyUsage&x509.KeyUsageCertSign == 0:
+		return errors.Errorf("%s can't sign certificates", name)
+	case now.Before(cert.NotBefore):
+		return errors.Errorf("%s is not valid until %s", name, cert.NotBefore.Format(time.RFC3339))
+	case !now.Before(cert.NotAfter):
+		return errors.Errorf("%s expired on %s", name, cert.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// CheckRotation validates the CA data next before it replaces prev, which is nil for the first CA: its key must
+// match its certificate, the certificates must chain to the previous CA as the clients expect, and a throwaway leaf
+// issued with it must validate against both the new and the previous trust anchors.
+func CheckRotation(ctx context.Context, prev *CAData, next CAData, now time.Time) error {
+	if err := checkCACert("CA certificate", next.CACert, now); err != nil {
+		return err
+	}
+
+	if next.CACert.MaxPathLen < 0 || next.CACert.MaxPathLen > 1 {
+		return errors.New("CA certificate path length is unconstrained")
+	}
+
+	signer, err := next.Signer(ctx)
+	if err != nil {
+		return errors.Wrap(err, "opening CA key")
+	}
+
+	if !publicKeyEqual(signer.Public(), next.CACert.PublicKey) || !publicKeyEqual(next.PublicKey, next.CACert.PublicKey) {
+		return errors.New("CA key doesn't match the CA certificate")
+	}
+
+	for i, cert := range next.Chain {
+		if err := checkCACert("CA chain certificate", cert, now); err != nil {
+			return err
+		}
+
+		child := next.CACert
+		if i > 0 {
+			child = next.Chain[i-1]
+		}
+		if err := child.CheckSignatureFrom(cert); err != nil {
+			return errors.Wrapf(err, "CA chain broken at %s", cert.Subject.CommonName)
+		}
+	}
+
+	if len(next.StaticKey) == 0 {
+		return errors.New("static key missing")
+	}
+
+	// The signature of the leaf proves the key signs as the CA certificate says
+	leafKey, err := NewPrivateKey(KeyAlgorithmP256)
+	if err != nil {
+		return err
+	}
+
+	leaf, err := CreateCertificate(next.CACert, signer, GetPublicKey(leafKey), pkix.Name{CommonName: "CA rotation test"},
+		ClientCert, WithTimespan(now.Add(-time.Minute), now.Add(kRotationTestValidity)))
+	if err != nil {
+		return errors.Wrap(err, "issuing test certificate")
+	}
+
+	if err := leaf.CheckSignatureFrom(next.CACert); err != nil {
+		return errors.Wrap(err, "CA key doesn't sign as the CA certificate")
+	}
+
+	verifier := NewVerifier(next)
+	if _, err := verifier.VerifyPath(leaf, VerifyPathCurrent, now); err != nil {
+		return errors.Wrap(err, "verifying test certificate against the new CA")
+	}
+
+	if prev == nil {
+		return nil
+	}
+
+	if !bytes.Equal(next.StaticKey, prev.StaticKey) {
+		return errors.New("static key changed, clients would lose the control channel")
+	}
+
+	if next.PrevCACert == nil || !next.PrevCACert.Equal(prev.CACert) {
+		return errors.New("previous CA certificate doesn't match the current CA")
+	}
+
+	prevSigner, err := next.PrevSigner(ctx)
+	if err != nil {
+		return errors.Wrap(err, "opening previous CA key")
+	}
+
+	if prevSigner != nil && !publicKeyEqual(prevSigner.Public(), prev.CACert.PublicKey) {
+		return errors.New("previous CA key doesn't match the previous CA certificate")
+	}
+
+	if next.CrossCert != nil {
+		cross := next.CrossCert
+		if err := checkCACert("cross certificate", cross, now); err != nil {
+			return err
+		}
+
+		if !publicKeyEqual(cross.PublicKey, next.CACert.PublicKey) || !bytes.Equal(cross.RawSubject, next.CACert.RawSubject) {
+			return errors.New("cross certificate is not for the new CA")
+		}
+
+		if err := cross.CheckSignatureFrom(prev.CACert); err != nil {
+			return errors.Wrap(err, "cross certificate is not signed by the previous CA")
+		}
+
+		if cross.NotAfter.After(prev.CACert.NotAfter) {
+			return errors.New("cross certificate outlives the previous CA")
+		}
+
+		if !cross.MaxPathLenZero || cross.MaxPathLen != 0 {
+			return errors.New("cross certificate path length is not zero")
+		}
+	} else if len(next.Chain) == 0 {
+		return errors.New("cross certificate missing, clients of the previous CA couldn't validate the new one")
+	}
+
+	if _, err := verifier.VerifyPath(leaf, VerifyPathCross, now); err != nil {
+		return errors.Wrap(err, "verifying test certificate against the previous CA")
+	}
+
+	if ocsp := next.PrevOCSPCert; ocsp != nil {
+		if err := ocsp.CheckSignatureFrom(prev.CACert); err != nil {
+			return errors.Wrap(err, "OCSP responder certificate is not signed by the previous CA")
+		}
+
+		if !publicKeyEqual(ocsp.PublicKey, next.CACert.PublicKey) {
+			return errors.New("OCSP responder certificate is not for the new CA key")
+		}
+	}
+
+	if len(next.PrevCRL) > 0 {
+		crl, err := x509.ParseRevocationList(next.PrevCRL)
+		if err != nil {
+			return errors.Wrap(err, "parsing previous CA CRL")
+		}
+
+		if err := crl.CheckSignatureFrom(prev.CACert); err != nil {
+			return errors.Wrap(err, "previous CA CRL is not signed by the previous CA")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package pki
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCheckRotation(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	caKey, err := NewCAKey(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CheckRotation(ctx, nil, caKey, now); err != nil {
+		t.Errorf("first CA rejected: %v", err)
+	}
+
+	renewed, err := caKey.Renew(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if renewed.PrevCRL, err = caKey.CreateCRL(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CheckRotation(ctx, &caKey, renewed, now); err != nil {
+		t.Errorf("renewed CA rejected: %v", err)
+	}
+
+	other, err := NewCAKey(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, broken := range map[string]func(k CAData) CAData{
+		"wrong key": func(k CAData) CAData {
+			k.PrivateKey = other.PrivateKey
+			return k
+		},
+		"foreign cross certificate": func(k CAData) CAData {
+			k.CrossCert = other.CACert
+			return k
+		},
+		"missing cross certificate": func(k CAData) CAData {
+			k.CrossCert = nil
+			return k
+		},
+		"new static key": func(k CAData) CAData {
+			k.StaticKey = NewStaticKey()
+			return k
+		},
+		"foreign previous key": func(k CAData) CAData {
+			k.PrevPrivateKey = other.PrivateKey
+			return k
+		},
+		"foreign CRL": func(k CAData) CAData {
+			k.PrevCRL, _ = other.CreateCRL(nil)
+			return k
+		},
+	} {
+		if err := CheckRotation(ctx, &caKey, broken(renewed), now); err == nil {
+			t.Errorf("CA with %s accepted", name)
+		}
+	}
+
+	if err := CheckRotation(ctx, &caKey, renewed, now.Add(2*kDuration)); err == nil {
+		t.Error("expired CA accepted")
+	}
+}
+
+func TestCheckIntermediateRotation(t *testing.T) {
+	ctx := context.Background()
+
+	root, err := NewRootCA(kCAName+" Root", uuid.New().String(), KeyAlgorithmP384, 10*kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caKey, err := root.NewIntermediate(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renewed, err := root.RenewIntermediate(caKey, kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CheckRotation(ctx, &caKey, renewed, time.Now()); err != nil {
+		t.Errorf("renewed intermediate rejected: %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package pki
+
+import (
+	"context"
+	"crypto"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// SignerBackend keeps CA keys outside the CA data, in a KMS or an HSM. Keys are referenced by a URI whose
+// scheme selects the backend, and the private part never leaves it.
+type SignerBackend interface {
+	// Scheme is the URI scheme of the keys handled by the backend
+	Scheme() string
+
+	// GenerateKey creates a signing key labeled with label and returns its URI
+	GenerateKey(ctx context.Context, alg KeyAlgorithm, label string) (string, error)
+
+	// Signer opens the key at uri. pub is the public key from the CA certificate, so it isn't fetched again.
+	Signer(ctx context.Context, uri string, pub crypto.PublicKey) (crypto.Signer, error)
+}
+
+var (
+	signerBackendsMut sync.RWMutex
+	signerBackends    = make(map[string]SignerBackend)
+)
+
+// RegisterSignerBackend makes a backend available for the keys with its scheme
+func RegisterSignerBackend(b SignerBackend) {
+	signerBackendsMut.Lock()
+	defer signerBackendsMut.Unlock()
+
+	signerBackends[b.Scheme()] = b
+}
+
+func getSignerBackend(scheme string) (SignerBackend, error) {
+	signerBackendsMut.RLock()
+	defer signerBackendsMut.RUnlock()
+
+	b, ok := signerBackends[scheme]
+	if !ok {
+		return nil, errors.Errorf("no signer backend for %q keys", scheme)
+	}
+	return b, nil
+}
+
+// OpenSigner opens the key at uri with the backend registered for its scheme
+func OpenSigner(ctx context.Context, uri string, pub crypto.PublicKey) (crypto.Signer, error) {
+	b, err := getSignerBackend(strings.SplitN(uri, ":", 2)[0])
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := b.Signer(ctx, uri, pub)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening key %s", uri)
+	}
+
+	return signer, nil
+}
+
+// newCAPrivateKey generates a CA key in the backend configured in PKI_CA_KEY_BACKEND, or in memory if there
+// is none. Exactly one of the returned key and URI is set.
+func newCAPrivateKey(alg KeyAlgorithm, label string) (crypto.PrivateKey, string, crypto.Signer, error) {
+	if err := CheckCAKeyAlgorithm(alg); err != nil {
+		return nil, "", nil, err
+	}
+
+	if configSigner.Backend == "" {
+		privKey, err := NewPrivateKey(alg)
+		if err != nil {
+			return nil, "", nil, errors.Wrap(err, "error generating key")
+		}
+		return privKey, "", privKey.(crypto.Signer), nil
+	}
+
+	b, err := getSignerBackend(configSigner.Backend)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	ctx := context.Background()
+	uri, err := b.GenerateKey(ctx, alg, label)
+	if err != nil {
+		return nil, "", nil, errors.Wrap(err, "error generating key")
+	}
+
+	signer, err := b.Signer(ctx, uri, nil)
+	if err != nil {
+		return nil, "", nil, errors.Wrapf(err, "opening key %s", uri)
+	}
+
+	return nil, uri, signer, nil
+}
@@ -0,0 +1,222 @@
+package sqlpki
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const kCADataPEMType = "VPN CA DATA"
+
+func (s *sqlStorage) maybeUpdate(ctx context.Context) {
+	if time.Now().After(s.exp) {
+		log.Debug("Updating CA data")
+		data, err := s.GetCAData(ctx)
+		if err != nil {
+			log.WithError(err).Error("Reading CA data")
+			return
+		}
+
+		if data == nil {
+			log.Error("No CA data stored")
+			return
+		}
+
+		s.data = *data
+		s.exp = time.Now().Add(1 * time.Minute)
+	}
+}
+
+// GetCAData returns the latest version of the CA data, or nil if there is none yet
+func (s *sqlStorage) GetCAData(ctx context.Context) (*pki.CAData, error) {
+	data, _, err := s.GetCADataVersion(ctx)
+	return data, err
+}
+
+// GetCADataVersion returns the latest version of the CA data and its number
+func (s *sqlStorage) GetCADataVersion(ctx context.Context) (*pki.CAData, string, error) {
+	var version int
+	var sealed []byte
+	err := s.db.QueryRowContext(ctx, "SELECT version, data FROM ca_data ORDER BY version DESC LIMIT 1").Scan(&version, &sealed)
+	if err == sql.ErrNoRows {
+		return nil, "", nil
+	} else if err != nil {
+		return nil, "", errors.Wrap(err, "reading CA data")
+	}
+
+	plain, err := s.sealer.Open(kCADataPEMType, sealed)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "opening CA data")
+	}
+
+	var data pki.CAData
+	if err := data.UnmarshalJSON(plain); err != nil {
+		return nil, "", errors.Wrap(err, "unmarshalling CA data")
+	}
+
+	return &data, strconv.Itoa(version), nil
+}
+
+// PutCAData seals data and stores it as a new version, the previous versions are kept
+func (s *sqlStorage) PutCAData(ctx context.Context, data pki.CAData) error {
+	return s.putCAData(ctx, data, "")
+}
+
+// UpdateCAData stores data as a new version if version is still the latest one
+func (s *sqlStorage) UpdateCAData(ctx context.Context, data pki.CAData, version string) error {
+	if version == "" {
+		return pki.ErrCADataChanged
+	}
+	return s.putCAData(ctx, data, version)
+}
+
+// putCAData stores data after version, or after the latest version if it's empty
+func (s *sqlStorage) putCAData(ctx context.Context, data pki.CAData, version string) error {
+	plain, err := data.MarshalJSON()
+	if err != nil {
+		return errors.Wrap(err, "marshalling CA data")
+	}
+
+	sealed, err := s.sealer.Seal(kCADataPEMType, plain)
+	if err != nil {
+		return errors.Wrap(err, "sealing CA data")
+	}
+
+	// A concurrent writer taking the same version makes the insert fail on the primary key
+	err = s.withTx(ctx, func(tx *sql.Tx) error {
+		var latest int
+		if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM ca_data").Scan(&latest); err != nil {
+			return err
+		}
+
+		if version != "" && version != strconv.Itoa(latest) {
+			return pki.ErrCADataChanged
+		}
+
+		_, err := tx.ExecContext(ctx, s.query("INSERT INTO ca_data (version, data, created_at) VALUES (?, ?, ?)"),
+			latest+1, sealed, dbTime(time.Now()))
+		return err
+	})
+	if err == pki.ErrCADataChanged {
+		return err
+	} else if err != nil {
+		return errors.Wrap(err, "writing CA data")
+	}
+
+	s.mut.Lock()
+	s.exp = time.Time{}
+	s.mut.Unlock()
+
+	return nil
+}
+
+func (s *sqlStorage) GetCACert(ctx context.Context) *x509.Certificate {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.CACert
+}
+
+func (s *sqlStorage) GetPrevCACert(ctx context.Context) *x509.Certificate {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.PrevCACert
+}
+
+func (s *sqlStorage) GetCrossCert(ctx context.Context) *x509.Certificate {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.CrossCert
+}
+
+func (s *sqlStorage) GetCAChain(ctx context.Context) []*x509.Certificate {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.Chain
+}
+
+func (s *sqlStorage) GetSigner(ctx context.Context) (crypto.Signer, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.Signer(ctx)
+}
+
+func (s *sqlStorage) GetPublicKey(ctx context.Context) crypto.PublicKey {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.PublicKey
+}
+
+func (s *sqlStorage) GetStaticKey(ctx context.Context) pki.StaticKey {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.StaticKey
+}
+
+func (s *sqlStorage) GetStaticKeyRotation(ctx context.Context) *pki.StaticKeyRotation {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.StaticKeyRotation
+}
+
+func (s *sqlStorage) GetTLSCryptV2Key(ctx context.Context) pki.TLSCryptV2Key {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.TLSCryptV2Key
+}
+
+func (s *sqlStorage) GetTLSCryptV2Switch(ctx context.Context) *pki.TLSCryptV2Switch {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.TLSCryptV2Switch
+}
+
+func (s *sqlStorage) GetPrevOCSPCert(ctx context.Context) *x509.Certificate {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.PrevOCSPCert
+}
+
+func (s *sqlStorage) GetPrevCRL(ctx context.Context) []byte {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.PrevCRL
+}
+
+func (s *sqlStorage) GetPrevSigner(ctx context.Context) (crypto.Signer, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.maybeUpdate(ctx)
+
+	return s.data.PrevSigner(ctx)
+}
@@ -0,0 +1,327 @@
+package sqlpki
+
+import (
+	"context"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const kCertColumns = `serial_number, subject_key_id, subject_name, cert_type, issued_at, valid_until, revocation_time,
+	hold_time, revocation_reason, revoked_by, revocation_comment, profile, predecessor, successor, data`
+
+// A revocation time in the future is a revocation scheduled after renewal, like in the DynamoDB table
+const kNotRevoked = "(revocation_time IS NULL OR revocation_time > ?)"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// queryer is either the database or a transaction
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func scanCert(row rowScanner) (*pki.CertificateInfo, error) {
+	var serial, keyId, predecessor, successor, data []byte
+	var subject, certType, revokedBy, comment, profile string
+	var issuedAt, validUntil time.Time
+	var revocationTime, holdTime sql.NullTime
+	var reason int
+
+	err := row.Scan(&serial, &keyId, &subject, &certType, &issuedAt, &validUntil, &revocationTime,
+		&holdTime, &reason, &revokedBy, &comment, &profile, &predecessor, &successor, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing certificate")
+	}
+
+	info := &pki.CertificateInfo{
+		Certificate: cert,
+		SerialBytes: serial,
+
+		CertType:  pki.CertType(certType),
+		Serial:    hex.EncodeToString(serial),
+		KeyId:     hex.EncodeToString(keyId),
+		Subject:   subject,
+		NotBefore: issuedAt.UTC(),
+		NotAfter:  validUntil.UTC(),
+
+		Profile: profile,
+
+		RevocationReason:  pki.RevocationReason(reason),
+		RevokedBy:         revokedBy,
+		RevocationComment: comment,
+	}
+
+	if len(predecessor) > 0 {
+		info.Predecessor = hex.EncodeToString(predecessor)
+	}
+
+	if len(successor) > 0 {
+		info.Successor = hex.EncodeToString(successor)
+	}
+
+	if revocationTime.Valid {
+		rt := revocationTime.Time.UTC()
+		if rt.After(time.Now()) {
+			info.RevokeAt = &rt
+		} else {
+			info.Revoked = &rt
+		}
+	}
+
+	if holdTime.Valid && info.Revoked == nil {
+		ht := holdTime.Time.UTC()
+		info.OnHold = &ht
+	}
+
+	return info, nil
+}
+
+// nullBytes makes empty serials NULL, some drivers store nil slices as empty blobs
+func nullBytes(b []byte) interface{} {
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
+func (s *sqlStorage) getCert(ctx context.Context, q queryer, serial []byte) (*pki.CertificateInfo, error) {
+	info, err := scanCert(q.QueryRowContext(ctx, s.query("SELECT "+kCertColumns+" FROM certificates WHERE serial_number = ?"), serial))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return info, err
+}
+
+func (s *sqlStorage) GetCertBySerial(ctx context.Context, serial []byte) (*pki.CertificateInfo, error) {
+	return s.getCert(ctx, s.db, serial)
+}
+
+// listCerts returns the certificates matching where, skipping the unreadable ones like the DynamoDB scans
+func (s *sqlStorage) listCerts(ctx context.Context, where string, args ...interface{}) ([]*pki.CertificateInfo, error) {
+	rows, err := s.db.QueryContext(ctx, s.query("SELECT "+kCertColumns+" FROM certificates WHERE "+where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	certs := make([]*pki.CertificateInfo, 0)
+	for rows.Next() {
+		info, err := scanCert(rows)
+		if err != nil {
+			log.WithError(err).Error("Error reading certificate")
+			continue
+		}
+
+		certs = append(certs, info)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return certs, nil
+}
+
+func (s *sqlStorage) ListAllCerts(ctx context.Context) ([]*pki.CertificateInfo, error) {
+	return s.listCerts(ctx, "valid_until > ? AND cert_type = ?", dbTime(time.Now()), string(pki.CertTypeClient))
+}
+
+func (s *sqlStorage) ListRevokedCerts(ctx context.Context) ([]*pki.CertificateInfo, error) {
+	now := dbTime(time.Now())
+	return s.listCerts(ctx, "valid_until > ? AND (revocation_time <= ? OR hold_time IS NOT NULL)", now, now)
+}
+
+// ListCertsBySubject returns the valid certificates of subjectName, newest first
+func (s *sqlStorage) ListCertsBySubject(ctx context.Context, subjectName string) ([]*pki.CertificateInfo, error) {
+	return s.listCerts(ctx, "subject_name = ? AND valid_until > ? ORDER BY issued_at DESC, serial_number DESC",
+		subjectName, dbTime(time.Now()))
+}
+
+// checkCert validates the certificate of info and returns its type and predecessor
+func checkCert(info *pki.CertificateInfo) (pki.CertType, []byte, error) {
+	cert := info.Certificate
+	if cert == nil || cert.Raw == nil {
+		return "", nil, errors.New("missing cert raw data")
+	}
+
+	if cert.AuthorityKeyId == nil {
+		return "", nil, errors.New("missing Authority Key ID")
+	}
+
+	if cert.SubjectKeyId == nil {
+		return "", nil, errors.New("missing Subject Key ID")
+	}
+
+	cType := pki.GetCertType(cert)
+	if cType == pki.CertTypeUnknown {
+		return "", nil, errors.New("unknown certificate type")
+	}
+
+	var predecessor []byte
+	if info.Predecessor != "" {
+		var err error
+		predecessor, err = pki.DecodeSerial(info.Predecessor)
+		if err != nil {
+			return "", nil, errors.Wrap(err, "decoding predecessor serial")
+		}
+	}
+
+	return cType, predecessor, nil
+}
+
+func (s *sqlStorage) insertCert(ctx context.Context, tx *sql.Tx, info *pki.CertificateInfo) error {
+	cType, predecessor, err := checkCert(info)
+	if err != nil {
+		return err
+	}
+
+	cert := info.Certificate
+	_, err = tx.ExecContext(ctx, s.query(`INSERT INTO certificates (serial_number, authority_key_id, subject_key_id,
+		subject_name, cert_type, issued_at, valid_until, profile, predecessor, data) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		cert.SerialNumber.Bytes(), cert.AuthorityKeyId, cert.SubjectKeyId, cert.Subject.CommonName, string(cType),
+		dbTime(cert.NotBefore), dbTime(cert.NotAfter), info.Profile, nullBytes(predecessor), cert.Raw)
+	return err
+}
+
+func nullTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return dbTime(*t)
+}
+
+// ImportCert stores info with its revocation, hold and renewal state
+func (s *sqlStorage) ImportCert(ctx context.Context, info *pki.CertificateInfo) error {
+	cType, predecessor, err := checkCert(info)
+	if err != nil {
+		return err
+	}
+
+	var successor []byte
+	if info.Successor != "" {
+		if successor, err = pki.DecodeSerial(info.Successor); err != nil {
+			return errors.Wrap(err, "decoding successor serial")
+		}
+	}
+
+	cert := info.Certificate
+	_, err = s.db.ExecContext(ctx, s.query(`INSERT INTO certificates (serial_number, authority_key_id, subject_key_id,
+		subject_name, cert_type, issued_at, valid_until, revocation_time, hold_time, revocation_reason, revoked_by,
+		revocation_comment, profile, predecessor, successor, data) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (serial_number) DO UPDATE SET revocation_time = excluded.revocation_time,
+		hold_time = excluded.hold_time, revocation_reason = excluded.revocation_reason, revoked_by = excluded.revoked_by,
+		revocation_comment = excluded.revocation_comment, profile = excluded.profile,
+		predecessor = excluded.predecessor, successor = excluded.successor`),
+		cert.SerialNumber.Bytes(), cert.AuthorityKeyId, cert.SubjectKeyId, cert.Subject.CommonName, string(cType),
+		dbTime(cert.NotBefore), dbTime(cert.NotAfter), nullTime(info.RevocationTime()), nullTime(info.OnHold),
+		int(info.RevocationReason), info.RevokedBy, info.RevocationComment, info.Profile, nullBytes(predecessor),
+		nullBytes(successor), cert.Raw)
+	return err
+}
+
+func (s *sqlStorage) AddCert(ctx context.Context, info *pki.CertificateInfo) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		return s.insertCert(ctx, tx, info)
+	})
+}
+
+// AddRenewedCert stores info and, in the same transaction, links its predecessor to it and schedules the
+// predecessor's revocation. It fails with pki.ErrNotRenewable if the predecessor was revoked or renewed meanwhile.
+func (s *sqlStorage) AddRenewedCert(ctx context.Context, info *pki.CertificateInfo, revokeAt time.Time) error {
+	if info.Predecessor == "" {
+		return errors.New("missing predecessor")
+	}
+
+	predecessor, err := pki.DecodeSerial(info.Predecessor)
+	if err != nil {
+		return errors.Wrap(err, "decoding predecessor serial")
+	}
+
+	if info.Certificate == nil {
+		return errors.New("missing cert raw data")
+	}
+
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, s.query(`UPDATE certificates SET successor = ?, revocation_time = ?, revocation_reason = ?
+			WHERE serial_number = ? AND revocation_time IS NULL AND successor IS NULL AND hold_time IS NULL`),
+			info.Certificate.SerialNumber.Bytes(), dbTime(revokeAt), int(pki.ReasonSuperseded), predecessor)
+		if err != nil {
+			return err
+		}
+
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return pki.ErrNotRenewable
+		}
+
+		return s.insertCert(ctx, tx, info)
+	})
+}
+
+// updateCert runs the conditional update in a transaction and returns the updated certificate. It returns nil if
+// there is no such certificate, and pki.ErrInvalidCertState if the condition didn't hold.
+func (s *sqlStorage) updateCert(ctx context.Context, serial []byte, update string, args ...interface{}) (*pki.CertificateInfo, error) {
+	var info *pki.CertificateInfo
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, s.query(update), args...)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		info, err = s.getCert(ctx, tx, serial)
+		if err != nil {
+			return err
+		}
+
+		if n == 0 && info != nil {
+			return pki.ErrInvalidCertState
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// RevokeCert revokes the certificate in a single conditional update, so concurrent revocations can't both succeed.
+// Revoking a certificate scheduled for revocation after renewal brings the revocation forward.
+func (s *sqlStorage) RevokeCert(ctx context.Context, serial []byte, revocation pki.Revocation) (*pki.CertificateInfo, error) {
+	now := dbTime(time.Now())
+	return s.updateCert(ctx, serial, `UPDATE certificates SET revocation_time = ?, revocation_reason = ?,
+		revoked_by = COALESCE(NULLIF(?, ''), revoked_by), revocation_comment = COALESCE(NULLIF(?, ''), revocation_comment)
+		WHERE serial_number = ? AND `+kNotRevoked,
+		now, int(revocation.Reason), revocation.RevokedBy, revocation.Comment, serial, now)
+}
+
+func (s *sqlStorage) HoldCert(ctx context.Context, serial []byte) (*pki.CertificateInfo, error) {
+	now := dbTime(time.Now())
+	return s.updateCert(ctx, serial, "UPDATE certificates SET hold_time = ? WHERE serial_number = ? AND hold_time IS NULL AND "+kNotRevoked,
+		now, serial, now)
+}
+
+func (s *sqlStorage) ReinstateCert(ctx context.Context, serial []byte) (*pki.CertificateInfo, error) {
+	return s.updateCert(ctx, serial, "UPDATE certificates SET hold_time = NULL WHERE serial_number = ? AND hold_time IS NOT NULL AND "+kNotRevoked,
+		serial, dbTime(time.Now()))
+}
@@ -0,0 +1,25 @@
+package sqlpki
+
+import (
+	"github.com/kelseyhightower/envconfig"
+)
+
+const kConfigPrefix = "PKI_SQL"
+
+var configSQLPKI struct {
+	// Driver is postgres or sqlite3
+	Driver string `default:"postgres"`
+	DSN    string `envconfig:"DSN"`
+
+	// Log enables the issuance log
+	Log bool
+}
+
+func init() {
+	envconfig.MustProcess(kConfigPrefix, &configSQLPKI)
+}
+
+// LogEnabled tells whether the issuance log has been enabled
+func LogEnabled() bool {
+	return configSQLPKI.Log
+}
@@ -0,0 +1,5 @@
+package sqlpki
+
+import (
+	_ "github.com/lib/pq"
+)
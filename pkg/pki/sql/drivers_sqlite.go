@@ -0,0 +1,9 @@
+//go:build cgo
+// +build cgo
+
+package sqlpki
+
+// The SQLite driver needs cgo, builds without it only support PostgreSQL
+import (
+	_ "github.com/mattn/go-sqlite3"
+)
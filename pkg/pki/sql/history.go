@@ -0,0 +1,104 @@
+package sqlpki
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ListCAVersions returns the stored versions of the CA data, newest first. The newest is the current one.
+func (s *sqlStorage) ListCAVersions(ctx context.Context) ([]*pki.CAVersion, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT version, data, created_at FROM ca_data ORDER BY version DESC")
+	if err != nil {
+		return nil, errors.Wrap(err, "listing CA data versions")
+	}
+	defer rows.Close()
+
+	var versions []*pki.CAVersion
+	for rows.Next() {
+		var version int
+		var sealed []byte
+		var created time.Time
+		if err := rows.Scan(&version, &sealed, &created); err != nil {
+			return nil, errors.Wrap(err, "reading CA data version")
+		}
+
+		v := &pki.CAVersion{
+			Id:      strconv.Itoa(version),
+			Created: created,
+			Current: len(versions) == 0,
+		}
+
+		if plain, err := s.sealer.Open(kCADataPEMType, sealed); err != nil {
+			v.Err = errors.Wrap(err, "opening CA data")
+		} else {
+			var data pki.CAData
+			if err := data.UnmarshalJSON(plain); err != nil {
+				v.Err = errors.Wrap(err, "unmarshalling CA data")
+			} else {
+				v.Data = &data
+			}
+		}
+
+		versions = append(versions, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "listing CA data versions")
+	}
+
+	return versions, nil
+}
+
+// RollbackCA stores the version again as the newest one, so the history keeps the rolled back versions
+func (s *sqlStorage) RollbackCA(ctx context.Context, id string) error {
+	target, err := strconv.Atoi(id)
+	if err != nil {
+		return pki.ErrCAVersionNotFound
+	}
+
+	err = s.withTx(ctx, func(tx *sql.Tx) error {
+		var sealed []byte
+		err := tx.QueryRowContext(ctx, s.query("SELECT data FROM ca_data WHERE version = ?"), target).Scan(&sealed)
+		if err == sql.ErrNoRows {
+			return pki.ErrCAVersionNotFound
+		} else if err != nil {
+			return err
+		}
+
+		var version int
+		if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM ca_data").Scan(&version); err != nil {
+			return err
+		}
+
+		if version == target {
+			return errors.Errorf("version %s is already current", id)
+		}
+
+		if _, err := s.sealer.Open(kCADataPEMType, sealed); err != nil {
+			return errors.Wrap(err, "opening CA data")
+		}
+
+		_, err = tx.ExecContext(ctx, s.query("INSERT INTO ca_data (version, data, created_at) VALUES (?, ?, ?)"),
+			version+1, sealed, dbTime(time.Now()))
+		return err
+	})
+	if err == pki.ErrCAVersionNotFound {
+		return err
+	} else if err != nil {
+		return errors.Wrap(err, "rolling back CA data")
+	}
+
+	log.WithField("version", id).Warn("Rolled back CA data")
+
+	s.mut.Lock()
+	s.exp = time.Time{}
+	s.mut.Unlock()
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package sqlpki
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+)
+
+// ListCerts filters, sorts and pages in the database, reading one more row to know if there is a next page
+func (s *sqlStorage) ListCerts(ctx context.Context, query pki.CertQuery) (*pki.CertPage, error) {
+	query, err := query.Normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := query.After()
+	if err != nil {
+		return nil, err
+	}
+
+	now := dbTime(time.Now())
+	var where []string
+	var args []interface{}
+	add := func(cond string, condArgs ...interface{}) {
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+
+	if query.Type != "" {
+		add("cert_type = ?", string(query.Type))
+	}
+
+	switch query.Status {
+	case pki.CertStatusActive:
+		add("valid_until > ? AND hold_time IS NULL AND "+kNotRevoked, now, now)
+	case pki.CertStatusRevoked:
+		add("valid_until > ? AND (revocation_time <= ? OR hold_time IS NOT NULL)", now, now)
+	case pki.CertStatusExpired:
+		add("valid_until <= ?", now)
+	}
+
+	// substr rather than LIKE, which is case insensitive in SQLite and needs escaping
+	if query.SubjectPrefix != "" {
+		add("substr(subject_name, 1, ?) = ?", utf8.RuneCountInString(query.SubjectPrefix), query.SubjectPrefix)
+	}
+
+	if query.KeyId != "" {
+		keyId, _ := hex.DecodeString(query.KeyId)
+		add("subject_key_id = ?", keyId)
+	}
+
+	if !query.IssuedAfter.IsZero() {
+		add("issued_at >= ?", dbTime(query.IssuedAfter))
+	}
+	if !query.IssuedBefore.IsZero() {
+		add("issued_at < ?", dbTime(query.IssuedBefore))
+	}
+	if !query.ExpiresAfter.IsZero() {
+		add("valid_until >= ?", dbTime(query.ExpiresAfter))
+	}
+	if !query.ExpiresBefore.IsZero() {
+		add("valid_until < ?", dbTime(query.ExpiresBefore))
+	}
+
+	column, op, order := "issued_at", ">", "ASC"
+	if query.SortBy == pki.SortByExpiresAt {
+		column = "valid_until"
+	}
+	if query.Descending {
+		op, order = "<", "DESC"
+	}
+
+	if after != nil {
+		serial, _ := hex.DecodeString(after.Serial)
+		add("("+column+" "+op+" ? OR ("+column+" = ? AND serial_number "+op+" ?))", dbTime(after.Time), dbTime(after.Time), serial)
+	}
+
+	q := "SELECT " + kCertColumns + " FROM certificates"
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	q += " ORDER BY " + column + " " + order + ", serial_number " + order + " LIMIT ?"
+
+	rows, err := s.db.QueryContext(ctx, s.query(q), append(args, query.Limit+1)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	certs := make([]*pki.CertificateInfo, 0)
+	for rows.Next() {
+		info, err := scanCert(rows)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, info)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return pki.NewCertPage(certs, query), nil
+}
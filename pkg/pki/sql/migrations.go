@@ -0,0 +1,113 @@
+package sqlpki
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// kMigrationsLock is the PostgreSQL advisory lock taken while migrating
+const kMigrationsLock = 0x76706e706b69
+
+// kMigrations are applied in order, each in its own transaction. Existing ones must never be changed.
+var kMigrations = [][]string{
+	{
+		`CREATE TABLE certificates (
+			serial_number BYTES PRIMARY KEY,
+			authority_key_id BYTES NOT NULL,
+			subject_key_id BYTES NOT NULL,
+			subject_name TEXT NOT NULL,
+			cert_type TEXT NOT NULL,
+			issued_at TIME NOT NULL,
+			valid_until TIME NOT NULL,
+			revocation_time TIME,
+			hold_time TIME,
+			revocation_reason INTEGER NOT NULL DEFAULT 0,
+			revoked_by TEXT NOT NULL DEFAULT '',
+			revocation_comment TEXT NOT NULL DEFAULT '',
+			profile TEXT NOT NULL DEFAULT '',
+			predecessor BYTES,
+			successor BYTES,
+			data BYTES NOT NULL
+		)`,
+		`CREATE INDEX certificates_subject_name_idx ON certificates (subject_name, issued_at)`,
+		`CREATE INDEX certificates_subject_key_id_idx ON certificates (subject_key_id)`,
+		`CREATE INDEX certificates_valid_until_idx ON certificates (valid_until)`,
+		`CREATE TABLE ca_data (
+			version INTEGER PRIMARY KEY,
+			data BYTES NOT NULL,
+			created_at TIME NOT NULL
+		)`,
+		`CREATE TABLE issuance_log (
+			log_index BIGINT PRIMARY KEY,
+			timestamp_ns BIGINT NOT NULL,
+			entry_type TEXT NOT NULL,
+			serial TEXT NOT NULL,
+			cert_hash BYTES,
+			predecessor TEXT NOT NULL DEFAULT '',
+			reason INTEGER NOT NULL DEFAULT 0,
+			actor TEXT NOT NULL DEFAULT '',
+			prev_hash BYTES,
+			hash BYTES NOT NULL
+		)`,
+		`CREATE TABLE tree_heads (
+			timestamp_ns BIGINT PRIMARY KEY,
+			size BIGINT NOT NULL,
+			hash BYTES NOT NULL,
+			key_id BYTES NOT NULL,
+			signature BYTES NOT NULL
+		)`,
+	},
+	{
+		`CREATE INDEX certificates_issued_at_idx ON certificates (issued_at)`,
+	},
+}
+
+// Migrate brings the schema up to date. Concurrent migrations wait for each other in PostgreSQL, and fail
+// with a busy error in SQLite.
+func (s *sqlStorage) Migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, s.dialect.types.Replace(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIME NOT NULL
+	)`)); err != nil {
+		return errors.Wrap(err, "creating migrations table")
+	}
+
+	for i, statements := range kMigrations {
+		version := i + 1
+		err := s.withTx(ctx, func(tx *sql.Tx) error {
+			if s.dialect.numbered {
+				if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", kMigrationsLock); err != nil {
+					return err
+				}
+			}
+
+			var applied int
+			if err := tx.QueryRowContext(ctx, s.query("SELECT COUNT(*) FROM schema_migrations WHERE version = ?"), version).Scan(&applied); err != nil {
+				return err
+			}
+
+			if applied > 0 {
+				return nil
+			}
+
+			log.Infof("Applying schema migration %d", version)
+			for _, statement := range statements {
+				if _, err := tx.ExecContext(ctx, s.dialect.types.Replace(statement)); err != nil {
+					return err
+				}
+			}
+
+			_, err := tx.ExecContext(ctx, s.query("INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)"), version, dbTime(time.Now()))
+			return err
+		})
+		if err != nil {
+			return errors.Wrapf(err, "applying schema migration %d", version)
+		}
+	}
+
+	return nil
+}
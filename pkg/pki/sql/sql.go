@@ -0,0 +1,123 @@
+package sqlpki
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/pkg/errors"
+)
+
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite3"
+)
+
+// dialect adapts the queries, which are written with ? placeholders and BYTES and TIME column types
+type dialect struct {
+	types    *strings.Replacer
+	numbered bool
+}
+
+var kDialects = map[string]dialect{
+	DriverPostgres: {types: strings.NewReplacer("BYTES", "BYTEA", "TIME", "TIMESTAMPTZ"), numbered: true},
+	DriverSQLite:   {types: strings.NewReplacer("BYTES", "BLOB", "TIME", "TIMESTAMP")},
+}
+
+type sqlStorage struct {
+	db      *sql.DB
+	dialect dialect
+	sealer  pki.Sealer
+
+	data pki.CAData
+	mut  sync.Mutex
+	exp  time.Time
+}
+
+// NewSQLStorage uses db, opened with one of the supported drivers. The CA data is sealed with sealer.
+func NewSQLStorage(db *sql.DB, driver string, sealer pki.Sealer) (*sqlStorage, error) {
+	d, ok := kDialects[driver]
+	if !ok {
+		return nil, errors.Errorf("unsupported SQL driver %q", driver)
+	}
+
+	// SQLite only allows one writer, concurrent transactions in the pool would fail with SQLITE_BUSY
+	if driver == DriverSQLite {
+		db.SetMaxOpenConns(1)
+	}
+
+	return &sqlStorage{
+		db:      db,
+		dialect: d,
+		sealer:  sealer,
+	}, nil
+}
+
+// NewSQLStorageFromEnv opens the database in PKI_SQL_DSN and migrates its schema
+func NewSQLStorageFromEnv(ctx context.Context, sealer pki.Sealer) (*sqlStorage, error) {
+	db, err := sql.Open(configSQLPKI.Driver, configSQLPKI.DSN)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening database")
+	}
+
+	s, err := NewSQLStorage(db, configSQLPKI.Driver, sealer)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	if err := s.Migrate(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *sqlStorage) Close() error {
+	return s.db.Close()
+}
+
+// query replaces the ? placeholders if the dialect uses numbered ones
+func (s *sqlStorage) query(q string) string {
+	if !s.dialect.numbered {
+		return q
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, c := range q {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
+
+// withTx runs fn in a transaction, which is committed if fn succeeds
+func (s *sqlStorage) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// dbTime stores times with second precision, like the DynamoDB table. SQLite compares them as strings.
+func dbTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Second)
+}
@@ -0,0 +1,164 @@
+package sqlpki
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/google/uuid"
+)
+
+func testSQLStorage(t *testing.T, driver string, dsn string) {
+	ctx := context.Background()
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	storage, err := NewSQLStorage(db, driver, pki.NewPassphraseSealer([]byte("test")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Migrating twice is a no-op
+	for i := 0; i < 2; i++ {
+		if err := storage.Migrate(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if data, err := storage.GetCAData(ctx); err != nil || data != nil {
+		t.Fatalf("expected empty storage, got %v %v", data, err)
+	}
+
+	caKey, err := pki.NewCAKey("Test CA", uuid.New().String(), pki.KeyAlgorithmP256, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.PutCAData(ctx, caKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if !storage.GetCACert(ctx).Equal(caKey.CACert) {
+		t.Fatal("unexpected CA certificate")
+	}
+
+	sqlPKI := pki.NewPKI(storage)
+	sqlPKI.SetIssuanceLog(storage)
+
+	profile, err := pki.Profiles.Get(pki.ProfileLaptop)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var issued []*pki.CertificateInfo
+	for i := 0; i < 3; i++ {
+		privKey, err := pki.NewPrivateKey(pki.KeyAlgorithmP256)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		notBefore := time.Now().Add(time.Duration(i-3) * time.Second)
+		info, err := sqlPKI.CreateCertificate(ctx, pki.GetPublicKey(privKey), pkix.Name{CommonName: "user@example.com"}, profile,
+			pki.WithTimespan(notBefore, notBefore.Add(time.Hour)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		issued = append(issued, info)
+	}
+
+	certs, err := storage.ListCertsBySubject(ctx, "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(certs) != 3 || certs[0].Serial != issued[2].Serial || certs[2].Serial != issued[0].Serial {
+		t.Fatal("expected the certificates of the subject newest first")
+	}
+
+	if certs, err := storage.ListCertsBySubject(ctx, "user@example"); err != nil || len(certs) != 0 {
+		t.Fatal("subject lookup must not match prefixes")
+	}
+
+	if _, err := sqlPKI.HoldCert(ctx, issued[0].SerialBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sqlPKI.HoldCert(ctx, issued[0].SerialBytes); err != pki.ErrInvalidCertState {
+		t.Fatalf("expected ErrInvalidCertState holding twice, got %v", err)
+	}
+
+	if _, err := sqlPKI.RevokeCert(ctx, issued[1].SerialBytes, pki.Revocation{Reason: pki.ReasonKeyCompromise, RevokedBy: "admin@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	revoked, err := storage.ListRevokedCerts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(revoked) != 2 {
+		t.Fatalf("expected a held and a revoked certificate, got %d", len(revoked))
+	}
+
+	info, err := sqlPKI.ReinstateCert(ctx, issued[0].SerialBytes)
+	if err != nil || info.OnHold != nil {
+		t.Fatalf("unexpected reinstate result %v %v", info, err)
+	}
+
+	privKey, err := pki.NewPrivateKey(pki.KeyAlgorithmP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renewed, err := sqlPKI.RenewCertificate(ctx, issued[2], pki.GetPublicKey(privKey), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev, err := storage.GetCertBySerial(ctx, issued[2].SerialBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if prev.Successor != renewed.Serial || prev.RevokeAt == nil || prev.Revoked != nil {
+		t.Fatal("expected the predecessor to be scheduled for revocation")
+	}
+
+	if err := storage.AddRenewedCert(ctx, renewed, time.Now()); err == nil {
+		t.Fatal("expected error renewing twice")
+	}
+
+	if missing, err := storage.RevokeCert(ctx, []byte{1, 2, 3}, pki.Revocation{}); err != nil || missing != nil {
+		t.Fatal("expected nil revoking an unknown certificate")
+	}
+
+	if _, err := sqlPKI.SignTreeHead(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := sqlPKI.VerifyIssuanceLog(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(problems) > 0 {
+		t.Fatalf("issuance log problems: %v", problems)
+	}
+}
+
+func TestPostgresStorage(t *testing.T) {
+	dsn := os.Getenv("PKI_SQL_TEST_DSN")
+	if dsn == "" {
+		t.Skip("PKI_SQL_TEST_DSN not set")
+	}
+
+	testSQLStorage(t, DriverPostgres, dsn)
+}
@@ -0,0 +1,144 @@
+//go:build cgo
+// +build cgo
+
+package sqlpki
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/empathybroker/aws-vpn/pkg/pki/pkitest"
+	"github.com/google/uuid"
+)
+
+func TestSQLiteStorage(t *testing.T) {
+	testSQLStorage(t, DriverSQLite, filepath.Join(t.TempDir(), "pki.db"))
+}
+
+func newSQLiteStorage(t *testing.T) *sqlStorage {
+	db, err := sql.Open(DriverSQLite, filepath.Join(t.TempDir(), "pki.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	storage, err := NewSQLStorage(db, DriverSQLite, pki.NewPassphraseSealer([]byte("test")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return storage
+}
+
+func TestSQLiteStorageConformance(t *testing.T) {
+	pkitest.RunStorageTests(t, func(t *testing.T) pki.PKIStorage {
+		return newSQLiteStorage(t)
+	})
+
+	pkitest.RunLogStorageTests(t, func(t *testing.T) pki.LogStorage {
+		return newSQLiteStorage(t)
+	})
+}
+
+func TestSQLiteCAHistory(t *testing.T) {
+	ctx := context.Background()
+	storage := newSQLiteStorage(t)
+
+	first, err := pki.NewCAKey("Test CA", uuid.New().String(), pki.KeyAlgorithmP256, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := first.Renew("Test CA", uuid.New().String(), pki.KeyAlgorithmP256, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range []pki.CAData{first, second} {
+		if err := storage.PutCAData(ctx, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions, err := storage.ListCAVersions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(versions) != 2 || !versions[0].Current || versions[1].Current || !versions[1].Data.CACert.Equal(first.CACert) {
+		t.Fatalf("unexpected versions %+v", versions)
+	}
+
+	if err := storage.RollbackCA(ctx, versions[0].Id); err == nil {
+		t.Error("rolled back to the current version")
+	}
+
+	if err := storage.RollbackCA(ctx, "42"); err != pki.ErrCAVersionNotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+
+	if err := storage.RollbackCA(ctx, versions[1].Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if !storage.GetCACert(ctx).Equal(first.CACert) {
+		t.Error("rollback didn't change the CA certificate")
+	}
+
+	if versions, err := storage.ListCAVersions(ctx); err != nil || len(versions) != 3 {
+		t.Errorf("expected the rollback as a new version, got %d %v", len(versions), err)
+	}
+}
+
+func TestSQLiteCAUpdate(t *testing.T) {
+	ctx := context.Background()
+	storage := newSQLiteStorage(t)
+
+	data, err := pki.NewCAKey("Test CA", uuid.New().String(), pki.KeyAlgorithmP256, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.PutCAData(ctx, data); err != nil {
+		t.Fatal(err)
+	}
+
+	read, version, err := storage.GetCADataVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another writer changes the data after it was read
+	if err := storage.PutCAData(ctx, data); err != nil {
+		t.Fatal(err)
+	}
+
+	rotating, err := read.StartStaticKeyRotation(time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.UpdateCAData(ctx, rotating, version); err != pki.ErrCADataChanged {
+		t.Fatalf("expected ErrCADataChanged, got %v", err)
+	}
+
+	if _, version, err = storage.GetCADataVersion(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.UpdateCAData(ctx, rotating, version); err != nil {
+		t.Fatal(err)
+	}
+
+	if storage.GetStaticKeyRotation(ctx) == nil {
+		t.Error("update not stored")
+	}
+}
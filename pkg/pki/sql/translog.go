@@ -0,0 +1,117 @@
+package sqlpki
+
+import (
+	"context"
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+)
+
+const kLogColumns = "log_index, timestamp_ns, entry_type, serial, cert_hash, predecessor, reason, actor, prev_hash, hash"
+
+func scanLogEntry(row rowScanner) (*pki.LogEntry, error) {
+	var entry pki.LogEntry
+	var index, timestamp int64
+	var entryType string
+	var reason int
+
+	err := row.Scan(&index, &timestamp, &entryType, &entry.Serial, &entry.CertHash, &entry.Predecessor, &reason,
+		&entry.Actor, &entry.PrevHash, &entry.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	// Timestamps are kept with nanoseconds, they are part of the entry hash
+	entry.Index = uint64(index)
+	entry.Timestamp = time.Unix(0, timestamp).UTC()
+	entry.Type = pki.LogEntryType(entryType)
+	entry.Reason = pki.RevocationReason(reason)
+
+	return &entry, nil
+}
+
+func (s *sqlStorage) AppendLogEntry(ctx context.Context, entry *pki.LogEntry) error {
+	res, err := s.db.ExecContext(ctx, s.query(`INSERT INTO issuance_log (`+kLogColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (log_index) DO NOTHING`),
+		int64(entry.Index), entry.Timestamp.UnixNano(), string(entry.Type), entry.Serial, nullBytes(entry.CertHash),
+		entry.Predecessor, int(entry.Reason), entry.Actor, nullBytes(entry.PrevHash), entry.Hash)
+	if err != nil {
+		return err
+	}
+
+	// Entries are never overwritten, a concurrent writer got this index first
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return pki.ErrLogConflict
+	}
+
+	return nil
+}
+
+func (s *sqlStorage) GetLogHead(ctx context.Context) (*pki.LogEntry, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+kLogColumns+" FROM issuance_log ORDER BY log_index DESC LIMIT 1")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	return scanLogEntry(rows)
+}
+
+func (s *sqlStorage) ListLogEntries(ctx context.Context, from uint64, limit int) ([]*pki.LogEntry, error) {
+	rows, err := s.db.QueryContext(ctx, s.query("SELECT "+kLogColumns+" FROM issuance_log WHERE log_index >= ? ORDER BY log_index LIMIT ?"),
+		int64(from), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]*pki.LogEntry, 0)
+	for rows.Next() {
+		entry, err := scanLogEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (s *sqlStorage) PutTreeHead(ctx context.Context, th *pki.TreeHead) error {
+	_, err := s.db.ExecContext(ctx, s.query("INSERT INTO tree_heads (timestamp_ns, size, hash, key_id, signature) VALUES (?, ?, ?, ?, ?)"),
+		th.Timestamp.UnixNano(), int64(th.Size), th.Hash, th.KeyId, th.Signature)
+	return err
+}
+
+func (s *sqlStorage) GetTreeHead(ctx context.Context) (*pki.TreeHead, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT timestamp_ns, size, hash, key_id, signature FROM tree_heads ORDER BY timestamp_ns DESC LIMIT 1")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	var th pki.TreeHead
+	var timestamp, size int64
+	if err := rows.Scan(&timestamp, &size, &th.Hash, &th.KeyId, &th.Signature); err != nil {
+		return nil, err
+	}
+
+	th.Timestamp = time.Unix(0, timestamp).UTC()
+	th.Size = uint64(size)
+
+	return &th, nil
+}
@@ -5,6 +5,9 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 const (
@@ -39,3 +42,66 @@ func (k StaticKey) String() string {
 
 	return buf.String()
 }
+
+// StaticKeyRotation is the replacement of the StaticKey in progress. Until OverlapEnd the servers accept NextKey
+// besides the StaticKey and new client profiles get NextKey, then NextKey replaces the StaticKey.
+type StaticKeyRotation struct {
+	NextKey    StaticKey `json:"next"`
+	Started    time.Time `json:"started"`
+	OverlapEnd time.Time `json:"overlapEnd"`
+}
+
+type StaticKeyPhase string
+
+const (
+	StaticKeyCurrent  StaticKeyPhase = "current"
+	StaticKeyOverlap  StaticKeyPhase = "overlap"
+	StaticKeyRetiring StaticKeyPhase = "retiring"
+)
+
+var (
+	ErrStaticKeyRotating    = errors.New("static key rotation already in progress")
+	ErrStaticKeyNotRotating = errors.New("no static key rotation in progress")
+	ErrStaticKeyOverlap     = errors.New("static key overlap not over yet")
+)
+
+// StaticKeyPhase tells whether the StaticKey is being rotated, and whether the old one can be retired
+func (k CAData) StaticKeyPhase(now time.Time) StaticKeyPhase {
+	switch {
+	case k.StaticKeyRotation == nil:
+		return StaticKeyCurrent
+	case now.Before(k.StaticKeyRotation.OverlapEnd):
+		return StaticKeyOverlap
+	default:
+		return StaticKeyRetiring
+	}
+}
+
+// StartStaticKeyRotation creates the next static key, accepted along the current one for overlap
+func (k CAData) StartStaticKeyRotation(now time.Time, overlap time.Duration) (CAData, error) {
+	if k.StaticKeyRotation != nil {
+		return CAData{}, ErrStaticKeyRotating
+	}
+
+	k.StaticKeyRotation = &StaticKeyRotation{
+		NextKey:    NewStaticKey(),
+		Started:    now.UTC(),
+		OverlapEnd: now.Add(overlap).UTC(),
+	}
+	return k, nil
+}
+
+// RetireStaticKey replaces the StaticKey with the next one once the overlap is over
+func (k CAData) RetireStaticKey(now time.Time) (CAData, error) {
+	switch k.StaticKeyPhase(now) {
+	case StaticKeyCurrent:
+		return CAData{}, ErrStaticKeyNotRotating
+	case StaticKeyOverlap:
+		return CAData{}, ErrStaticKeyOverlap
+	}
+
+	k.StaticKey = k.StaticKeyRotation.NextKey
+	k.StaticKeyCreated = now.UTC()
+	k.StaticKeyRotation = nil
+	return k, nil
+}
@@ -0,0 +1,77 @@
+package pki
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestStaticKeyRotation(t *testing.T) {
+	caKey, err := NewCAKey(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	if phase := caKey.StaticKeyPhase(now); phase != StaticKeyCurrent {
+		t.Errorf("unexpected phase %s", phase)
+	}
+
+	if _, err := caKey.RetireStaticKey(now); err != ErrStaticKeyNotRotating {
+		t.Errorf("unexpected error retiring without rotation: %v", err)
+	}
+
+	rotating, err := caKey.StartStaticKeyRotation(now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rotating.StartStaticKeyRotation(now, time.Hour); err != ErrStaticKeyRotating {
+		t.Errorf("unexpected error starting a second rotation: %v", err)
+	}
+
+	// The rotation survives storing and renewing the CA
+	data, err := json.Marshal(rotating)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored CAData
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err = stored.Renew(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.StaticKeyRotation == nil || !bytes.Equal(stored.StaticKeyRotation.NextKey, rotating.StaticKeyRotation.NextKey) {
+		t.Fatal("static key rotation lost")
+	}
+
+	if phase := stored.StaticKeyPhase(now.Add(time.Minute)); phase != StaticKeyOverlap {
+		t.Errorf("unexpected phase %s", phase)
+	}
+
+	if _, err := stored.RetireStaticKey(now.Add(time.Minute)); err != ErrStaticKeyOverlap {
+		t.Errorf("unexpected error retiring during the overlap: %v", err)
+	}
+
+	later := now.Add(2 * time.Hour)
+	if phase := stored.StaticKeyPhase(later); phase != StaticKeyRetiring {
+		t.Errorf("unexpected phase %s", phase)
+	}
+
+	retired, err := stored.RetireStaticKey(later)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(retired.StaticKey, rotating.StaticKeyRotation.NextKey) || retired.StaticKeyRotation != nil || !retired.StaticKeyCreated.Equal(later.UTC()) {
+		t.Error("static key not replaced")
+	}
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	mempki "github.com/empathybroker/aws-vpn/pkg/pki/memory"
+	"github.com/pkg/errors"
+)
+
+// NewBackup reads the complete PKI in s and its issuance log l, which may be nil
+func NewBackup(ctx context.Context, s CAStore, l pki.LogStorage) (*pki.Backup, error) {
+	b := &pki.Backup{CreatedAt: time.Now().UTC()}
+
+	var err error
+	if b.CAData, err = s.GetCAData(ctx); err != nil {
+		return nil, errors.Wrap(err, "reading CA data")
+	}
+
+	if b.Certs, err = listCerts(ctx, s, kMigrationBatchSize); err != nil {
+		return nil, errors.Wrap(err, "listing certificates")
+	}
+
+	if l == nil {
+		return b, nil
+	}
+
+	for {
+		entries, err := l.ListLogEntries(ctx, uint64(len(b.Log)), kMigrationBatchSize)
+		if err != nil {
+			return nil, errors.Wrap(err, "listing log entries")
+		} else if len(entries) == 0 {
+			break
+		}
+		b.Log = append(b.Log, entries...)
+	}
+
+	if b.TreeHead, err = l.GetTreeHead(ctx); err != nil {
+		return nil, errors.Wrap(err, "reading tree head")
+	}
+
+	return b, nil
+}
+
+// Restore writes the backup into s and its issuance log l, which may be nil, like a migration from the storage it
+// was taken from, and returns the differences left between them
+func Restore(ctx context.Context, b *pki.Backup, s Store, l pki.LogStorage) ([]string, error) {
+	src := mempki.NewMemStorage()
+	if b.CAData != nil {
+		if err := src.PutCAData(ctx, *b.CAData); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, info := range b.Certs {
+		if err := src.ImportCert(ctx, info); err != nil {
+			return nil, errors.Wrapf(err, "reading certificate %s", info.Serial)
+		}
+	}
+
+	for _, entry := range b.Log {
+		if err := src.AppendLogEntry(ctx, entry); err != nil {
+			return nil, errors.Wrapf(err, "reading log entry %d", entry.Index)
+		}
+	}
+
+	if b.TreeHead != nil {
+		if err := src.PutTreeHead(ctx, b.TreeHead); err != nil {
+			return nil, err
+		}
+	}
+
+	m := &Migration{From: src, To: s, ToLog: l}
+	if len(b.Log) > 0 {
+		m.FromLog = src
+	}
+
+	if _, err := m.Run(ctx); err != nil {
+		return nil, err
+	}
+
+	return m.Verify(ctx)
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	mempki "github.com/empathybroker/aws-vpn/pkg/pki/memory"
+)
+
+func TestBackup(t *testing.T) {
+	ctx := context.Background()
+	src, p := newSourcePKI(t)
+
+	certs := issueCerts(t, p, 3)
+	issueHistory(t, src, p)
+	if _, err := p.RevokeCert(ctx, certs[0].SerialBytes, pki.Revocation{Reason: pki.ReasonSuperseded}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.SignTreeHead(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewBackup(ctx, src, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealer := pki.NewPassphraseSealer([]byte("passphrase"))
+	data, err := b.Seal(sealer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pki.OpenBackup(data, pki.NewPassphraseSealer([]byte("wrong"))); err == nil {
+		t.Error("expected error with wrong passphrase")
+	}
+
+	tampered := bytes.Replace(data, data[100:104], []byte("AAAA"), 1)
+	if _, err := pki.OpenBackup(tampered, sealer); err == nil {
+		t.Error("expected error with tampered backup")
+	}
+
+	restored, err := pki.OpenBackup(data, sealer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(restored.Certs) != 5 || len(restored.Log) != 5 || restored.TreeHead == nil {
+		t.Fatalf("incomplete backup with %d certificates and %d log entries", len(restored.Certs), len(restored.Log))
+	}
+
+	dst := mempki.NewMemStorage()
+	problems, err := Restore(ctx, restored, dst, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, problem := range problems {
+		t.Error(problem)
+	}
+
+	// The restored storage is equal to the original
+	verifyMigration(t, &Migration{From: src, FromLog: src, To: dst, ToLog: dst})
+}
@@ -0,0 +1,408 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const kMigrationBatchSize = 100
+
+// Migration copies the CA data, every certificate whatever its state and the issuance log between two storages
+type Migration struct {
+	From    CAStore
+	FromLog pki.LogStorage
+	To      Store
+	ToLog   pki.LogStorage
+
+	// StateFile keeps the progress so an interrupted migration resumes where it stopped
+	StateFile string
+	// Id identifies the migration in the state file, e.g. with both storage URIs, to refuse resuming another one
+	Id        string
+	BatchSize int
+	// DryRun only counts what would be copied
+	DryRun bool
+}
+
+type MigrationResult struct {
+	CACopied   bool
+	Certs      int
+	LogEntries int
+}
+
+type migrationState struct {
+	Id     string `json:"id"`
+	CADone bool   `json:"caDone"`
+	Cursor string `json:"cursor,omitempty"`
+	Certs  int    `json:"certs"`
+}
+
+func (m *Migration) batchSize() int {
+	if m.BatchSize <= 0 {
+		return kMigrationBatchSize
+	}
+	return m.BatchSize
+}
+
+// listCerts pages through every certificate in s whatever its state, type or expiration
+func listCerts(ctx context.Context, s pki.PKIStorage, batchSize int) ([]*pki.CertificateInfo, error) {
+	var certs []*pki.CertificateInfo
+	query := pki.CertQuery{Limit: batchSize}
+	for {
+		page, err := s.ListCerts(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+
+		certs = append(certs, page.Certs...)
+		if page.NextCursor == "" {
+			return certs, nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+func (m *Migration) loadState() (*migrationState, error) {
+	state := &migrationState{Id: m.Id}
+	if m.StateFile == "" {
+		return state, nil
+	}
+
+	data, err := ioutil.ReadFile(m.StateFile)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "reading migration state")
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, errors.Wrap(err, "decoding migration state")
+	}
+
+	if state.Id != m.Id {
+		return nil, errors.Errorf("state file %s belongs to migration %q", m.StateFile, state.Id)
+	}
+
+	// Certificates issued since may be anywhere in the table order, so they are all copied again
+	if _, ok := m.From.(pki.UnsortedPager); ok && state.Cursor != "" {
+		log.Info("Source storage doesn't sort across pages, copying every certificate again")
+		state.Cursor = ""
+		return state, nil
+	}
+
+	// Certificates issued since in the same second may sort before the last one copied, so that second is copied again
+	after, err := pki.CertQuery{Cursor: state.Cursor}.After()
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding migration state")
+	} else if after != nil {
+		state.Cursor = pki.CertCursor{Time: after.Time}.Encode()
+	}
+
+	log.Infof("Resuming migration after %d certificates", state.Certs)
+	return state, nil
+}
+
+// saveState writes the state to a temporary file first, so an interruption never leaves it half written
+func (m *Migration) saveState(state *migrationState) error {
+	if m.StateFile == "" || m.DryRun {
+		return nil
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return errors.Wrap(err, "encoding migration state")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(m.StateFile), filepath.Base(m.StateFile)+".*")
+	if err != nil {
+		return errors.Wrap(err, "writing migration state")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return errors.Wrap(err, "writing migration state")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "writing migration state")
+	}
+
+	return errors.Wrap(os.Rename(tmp.Name(), m.StateFile), "writing migration state")
+}
+
+// Run copies everything not copied yet, so running it again after the source changed catches up with the new
+// certificates and log entries. Changes to certificates already copied need a new state file.
+func (m *Migration) Run(ctx context.Context) (*MigrationResult, error) {
+	state, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MigrationResult{}
+	if !state.CADone {
+		if result.CACopied, err = m.copyCAData(ctx); err != nil {
+			return nil, err
+		}
+
+		state.CADone = true
+		if err := m.saveState(state); err != nil {
+			return nil, err
+		}
+	}
+
+	if result.Certs, err = m.copyCerts(ctx, state); err != nil {
+		return nil, err
+	}
+
+	if result.LogEntries, err = m.copyLog(ctx); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func sameCAData(a, b *pki.CAData) (bool, error) {
+	if a == nil || b == nil {
+		return a == nil && b == nil, nil
+	}
+
+	aData, err := a.MarshalJSON()
+	if err != nil {
+		return false, errors.Wrap(err, "encoding CA data")
+	}
+
+	bData, err := b.MarshalJSON()
+	if err != nil {
+		return false, errors.Wrap(err, "encoding CA data")
+	}
+
+	return bytes.Equal(aData, bData), nil
+}
+
+// copyCAData refuses to replace a different CA in the destination
+func (m *Migration) copyCAData(ctx context.Context) (bool, error) {
+	data, err := m.From.GetCAData(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "reading source CA data")
+	} else if data == nil {
+		log.Warn("Source storage has no CA data")
+		return false, nil
+	}
+
+	current, err := m.To.GetCAData(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "reading destination CA data")
+	}
+
+	if current != nil {
+		same, err := sameCAData(data, current)
+		if err != nil {
+			return false, err
+		} else if !same {
+			return false, errors.New("destination storage holds a different CA")
+		}
+
+		log.Info("CA data already in the destination")
+		return false, nil
+	}
+
+	if m.DryRun {
+		log.Infof("Would copy CA %s", data.CACert.Subject.CommonName)
+		return true, nil
+	}
+
+	if err := m.To.PutCAData(ctx, *data); err != nil {
+		return false, errors.Wrap(err, "writing destination CA data")
+	}
+
+	log.Infof("Copied CA %s", data.CACert.Subject.CommonName)
+	return true, nil
+}
+
+func (m *Migration) copyCerts(ctx context.Context, state *migrationState) (int, error) {
+	copied := 0
+	query := pki.CertQuery{Limit: m.batchSize(), Cursor: state.Cursor}
+	for {
+		page, err := m.From.ListCerts(ctx, query)
+		if err != nil {
+			return copied, errors.Wrap(err, "listing source certificates")
+		}
+
+		if !m.DryRun {
+			for _, info := range page.Certs {
+				if err := m.To.ImportCert(ctx, info); err != nil {
+					return copied, errors.Wrapf(err, "importing certificate %s", info.Serial)
+				}
+			}
+		}
+
+		copied += len(page.Certs)
+		state.Certs += len(page.Certs)
+		if page.NextCursor != "" {
+			state.Cursor = page.NextCursor
+		}
+		if err := m.saveState(state); err != nil {
+			return copied, err
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+		log.Infof("%d certificates copied", state.Certs)
+	}
+
+	return copied, nil
+}
+
+// copyLog appends the source entries after the destination head, checking both logs agree up to it
+func (m *Migration) copyLog(ctx context.Context) (int, error) {
+	if m.FromLog == nil {
+		return 0, nil
+	} else if m.ToLog == nil {
+		log.Warn("Destination storage has no issuance log, not copied")
+		return 0, nil
+	}
+
+	var next uint64
+	head, err := m.ToLog.GetLogHead(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "reading destination log head")
+	}
+
+	if head != nil {
+		entries, err := m.FromLog.ListLogEntries(ctx, head.Index, 1)
+		if err != nil {
+			return 0, errors.Wrap(err, "listing source log entries")
+		}
+
+		if len(entries) == 0 || !bytes.Equal(entries[0].Hash, head.Hash) {
+			return 0, errors.Errorf("destination issuance log diverges from the source at entry %d", head.Index)
+		}
+		next = head.Index + 1
+	}
+
+	copied := 0
+	for {
+		entries, err := m.FromLog.ListLogEntries(ctx, next, m.batchSize())
+		if err != nil {
+			return copied, errors.Wrap(err, "listing source log entries")
+		} else if len(entries) == 0 {
+			break
+		}
+
+		for _, entry := range entries {
+			if !m.DryRun {
+				if err := m.ToLog.AppendLogEntry(ctx, entry); err != nil {
+					return copied, errors.Wrapf(err, "appending log entry %d", entry.Index)
+				}
+			}
+			next = entry.Index + 1
+			copied++
+		}
+	}
+
+	th, err := m.FromLog.GetTreeHead(ctx)
+	if err != nil {
+		return copied, errors.Wrap(err, "reading source tree head")
+	} else if th == nil || m.DryRun {
+		return copied, nil
+	}
+
+	current, err := m.ToLog.GetTreeHead(ctx)
+	if err != nil {
+		return copied, errors.Wrap(err, "reading destination tree head")
+	}
+
+	if current == nil || !current.Timestamp.Equal(th.Timestamp) {
+		if err := m.ToLog.PutTreeHead(ctx, th); err != nil {
+			return copied, errors.Wrap(err, "writing destination tree head")
+		}
+	}
+
+	return copied, nil
+}
+
+// Verify compares the CA data, every certificate and the issuance log head of both storages
+func (m *Migration) Verify(ctx context.Context) ([]string, error) {
+	var problems []string
+
+	data, err := m.From.GetCAData(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading source CA data")
+	}
+
+	current, err := m.To.GetCAData(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading destination CA data")
+	}
+
+	if same, err := sameCAData(data, current); err != nil {
+		return nil, err
+	} else if !same {
+		problems = append(problems, "CA data differs")
+	}
+
+	count := 0
+	query := pki.CertQuery{Limit: m.batchSize()}
+	for {
+		page, err := m.From.ListCerts(ctx, query)
+		if err != nil {
+			return nil, errors.Wrap(err, "listing source certificates")
+		}
+
+		for _, info := range page.Certs {
+			copied, err := m.To.GetCertBySerial(ctx, info.SerialBytes)
+			if err != nil {
+				return nil, errors.Wrapf(err, "reading certificate %s", info.Serial)
+			}
+
+			if copied == nil {
+				problems = append(problems, fmt.Sprintf("certificate %s missing", info.Serial))
+			} else if diff := info.Diff(copied); len(diff) > 0 {
+				problems = append(problems, fmt.Sprintf("certificate %s differs in %v", info.Serial, diff))
+			}
+		}
+
+		count += len(page.Certs)
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+
+	all, err := listCerts(ctx, m.To, m.batchSize())
+	if err != nil {
+		return nil, errors.Wrap(err, "listing destination certificates")
+	}
+
+	if len(all) != count {
+		problems = append(problems, fmt.Sprintf("source has %d certificates and destination %d", count, len(all)))
+	}
+
+	if m.FromLog != nil && m.ToLog != nil {
+		head, err := m.FromLog.GetLogHead(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading source log head")
+		}
+
+		copiedHead, err := m.ToLog.GetLogHead(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading destination log head")
+		}
+
+		if (head == nil) != (copiedHead == nil) || head != nil && (head.Index != copiedHead.Index || !bytes.Equal(head.Hash, copiedHead.Hash)) {
+			problems = append(problems, "issuance log head differs")
+		}
+	}
+
+	return problems, nil
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	mempki "github.com/empathybroker/aws-vpn/pkg/pki/memory"
+	"github.com/google/uuid"
+)
+
+type memStore interface {
+	Store
+	pki.LogStorage
+}
+
+func newSourcePKI(t *testing.T) (memStore, *pki.PKI) {
+	ctx := context.Background()
+	src := mempki.NewMemStorage()
+
+	caData, err := pki.NewCAKey("Test CA", uuid.New().String(), pki.KeyAlgorithmP256, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := src.PutCAData(ctx, caData); err != nil {
+		t.Fatal(err)
+	}
+
+	p := pki.NewPKI(src)
+	p.SetIssuanceLog(src)
+	return src, p
+}
+
+func issueCerts(t *testing.T, p *pki.PKI, n int) []*pki.CertificateInfo {
+	ctx := context.Background()
+	profile := pki.NewDefaultProfiles()[pki.ProfileLaptop]
+
+	var certs []*pki.CertificateInfo
+	for i := 0; i < n; i++ {
+		key, err := pki.NewPrivateKey(pki.KeyAlgorithmP256)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := p.CreateCertificate(ctx, pki.GetPublicKey(key), pkix.Name{CommonName: uuid.New().String()}, profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, info)
+	}
+
+	return certs
+}
+
+// issueHistory adds a server certificate and an expired one, which only the complete listing returns
+func issueHistory(t *testing.T, src memStore, p *pki.PKI) {
+	ctx := context.Background()
+
+	key, err := pki.NewPrivateKey(pki.KeyAlgorithmP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profile := pki.NewDefaultProfiles()[pki.ProfileServer]
+	if _, err := p.CreateCertificate(ctx, pki.GetPublicKey(key), pkix.Name{CommonName: "vpn.example.com"}, profile); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := src.GetCAData(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notBefore := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+	cert, err := pki.CreateCertificate(data.CACert, data.PrivateKey, pki.GetPublicKey(key), pkix.Name{CommonName: uuid.New().String()},
+		pki.ClientCert, pki.WithTimespan(notBefore, notBefore.Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := pki.CertInfoFromX509Cert(cert)
+	info.Profile = pki.ProfileLaptop
+	if err := src.ImportCert(ctx, info); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func verifyMigration(t *testing.T, m *Migration) {
+	problems, err := m.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, problem := range problems {
+		t.Error(problem)
+	}
+}
+
+func TestMigration(t *testing.T) {
+	ctx := context.Background()
+	src, p := newSourcePKI(t)
+	dst := mempki.NewMemStorage()
+
+	certs := issueCerts(t, p, 5)
+	issueHistory(t, src, p)
+	if _, err := p.RevokeCert(ctx, certs[0].SerialBytes, pki.Revocation{Reason: pki.ReasonKeyCompromise, RevokedBy: "admin"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.HoldCert(ctx, certs[1].SerialBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Migration{
+		From: src, FromLog: src, To: dst, ToLog: dst,
+		StateFile: filepath.Join(t.TempDir(), "state.json"),
+		Id:        "mem:src mem:dst",
+		BatchSize: 2,
+		DryRun:    true,
+	}
+
+	result, err := m.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.CACopied || result.Certs != 7 || result.LogEntries != 8 {
+		t.Errorf("unexpected dry run result %+v", result)
+	}
+	if data, _ := dst.GetCAData(ctx); data != nil {
+		t.Error("dry run copied the CA data")
+	}
+	if all, _ := dst.ListAllCerts(ctx); len(all) != 0 {
+		t.Errorf("dry run copied %d certificates", len(all))
+	}
+
+	m.DryRun = false
+	if _, err := m.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	verifyMigration(t, m)
+
+	// A second run resumes from the state file and catches up with the new certificates
+	issueCerts(t, p, 3)
+	result, err = m.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.CACopied || result.Certs < 3 || result.LogEntries != 3 {
+		t.Errorf("unexpected resumed result %+v", result)
+	}
+	verifyMigration(t, m)
+
+	other := *m
+	other.Id = "mem:src mem:other"
+	if _, err := other.Run(ctx); err == nil {
+		t.Error("expected error resuming another migration")
+	}
+}
+
+func TestMigrationDifferentCA(t *testing.T) {
+	ctx := context.Background()
+	src, _ := newSourcePKI(t)
+	dst, _ := newSourcePKI(t)
+
+	m := &Migration{From: src, To: dst}
+	if _, err := m.Run(ctx); err == nil {
+		t.Fatal("expected error migrating into a storage with another CA")
+	}
+
+	problems, err := m.Verify(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 {
+		t.Errorf("expected the CA data to differ, got %v", problems)
+	}
+}
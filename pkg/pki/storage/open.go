@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	awspki "github.com/empathybroker/aws-vpn/pkg/pki/aws"
+	fspki "github.com/empathybroker/aws-vpn/pkg/pki/fs"
+	sqlpki "github.com/empathybroker/aws-vpn/pkg/pki/sql"
+	"github.com/pkg/errors"
+)
+
+// Store is a storage keeping its CA data which can import certificates with all their state
+type Store interface {
+	CAStore
+	pki.CertImporter
+}
+
+// Open returns the storage at uri, and its issuance log if it has one. The URIs are like:
+//
+//	aws:?region=eu-west-1&profile=old-account&secret=VPN/CAPrivateKey&table=vpn_certificates&log-table=vpn_log
+//	fs:/var/lib/aws-vpn/pki
+//	postgres://user@host/pki
+//	sqlite3:/var/lib/aws-vpn/pki.db
+//
+// The aws names default to PKI_AWS_*, and the CA data of the other storages is sealed as configured with PKI_SEAL_*.
+func Open(ctx context.Context, uri string) (Store, pki.LogStorage, error) {
+	scheme := strings.SplitN(uri, ":", 2)[0]
+	switch scheme {
+	case "aws":
+		return openAWS(uri)
+	case "fs", sqlpki.DriverPostgres, "postgresql", sqlpki.DriverSQLite:
+	default:
+		return nil, nil, errors.Errorf("unknown storage %q", uri)
+	}
+
+	sealer, err := Sealer()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "configuring CA data sealing")
+	}
+
+	switch scheme {
+	case "fs":
+		s := fspki.NewFSStorage(strings.TrimPrefix(strings.TrimPrefix(uri, "fs:"), "//"), sealer)
+		return s, s, nil
+	default:
+		driver, dsn := sqlpki.DriverPostgres, uri
+		if scheme == sqlpki.DriverSQLite {
+			driver, dsn = sqlpki.DriverSQLite, strings.TrimPrefix(uri, scheme+":")
+		}
+
+		db, err := sql.Open(driver, dsn)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "opening database")
+		}
+
+		s, err := sqlpki.NewSQLStorage(db, driver, sealer)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err := s.Migrate(ctx); err != nil {
+			return nil, nil, err
+		}
+
+		return s, s, nil
+	}
+}
+
+func openAWS(uri string) (Store, pki.LogStorage, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "parsing storage URI")
+	}
+
+	params := u.Query()
+	res := awspki.ResourcesFromEnv()
+	for param, name := range map[string]*string{
+		"secret":    &res.SecretName,
+		"table":     &res.TableName,
+		"log-table": &res.LogTableName,
+	} {
+		if value := params.Get(param); value != "" {
+			*name = value
+		}
+	}
+
+	opts := session.Options{
+		Profile:           params.Get("profile"),
+		SharedConfigState: session.SharedConfigEnable,
+	}
+	if region := params.Get("region"); region != "" {
+		opts.Config.Region = aws.String(region)
+	}
+
+	sess, err := session.NewSessionWithOptions(opts)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "creating AWS session")
+	}
+
+	s := awspki.NewAWSStorageWithResources(secretsmanager.New(sess), dynamodb.New(sess), res)
+	if res.LogTableName == "" {
+		return s, nil, nil
+	}
+	return s, s, nil
+}
@@ -0,0 +1,116 @@
+// Package storage selects the PKIStorage of the API and tools from the environment
+package storage
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+
+	awsservices "github.com/empathybroker/aws-vpn/pkg/aws"
+	"github.com/empathybroker/aws-vpn/pkg/pki"
+	awspki "github.com/empathybroker/aws-vpn/pkg/pki/aws"
+	fspki "github.com/empathybroker/aws-vpn/pkg/pki/fs"
+	sqlpki "github.com/empathybroker/aws-vpn/pkg/pki/sql"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	kConfigPrefix = "PKI"
+
+	StorageAWS = "aws"
+	StorageFS  = "fs"
+	StorageSQL = "sql"
+)
+
+var configStorage struct {
+	Storage string `default:"aws"`
+
+	// The CA data of the fs and sql storages is sealed with KMS, with the passphrase, or with the first line of
+	// the passphrase file
+	SealKMSKeyId       string `envconfig:"SEAL_KMS_KEY_ID"`
+	SealPassphrase     string `split_words:"true"`
+	SealPassphraseFile string `split_words:"true"`
+}
+
+func init() {
+	envconfig.MustProcess(kConfigPrefix, &configStorage)
+}
+
+// CAStore is a storage which reads and writes its CA data
+type CAStore interface {
+	pki.PKIStorage
+	GetCAData(ctx context.Context) (*pki.CAData, error)
+	PutCAData(ctx context.Context, data pki.CAData) error
+}
+
+// Sealer returns the sealer for the CA data of the fs and sql storages
+func Sealer() (pki.Sealer, error) {
+	if configStorage.SealKMSKeyId != "" {
+		return awspki.NewKMSSealer(awsservices.NewKMSClient(), configStorage.SealKMSKeyId), nil
+	}
+
+	passphrase := configStorage.SealPassphrase
+	if configStorage.SealPassphraseFile != "" {
+		data, err := ioutil.ReadFile(configStorage.SealPassphraseFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading passphrase file")
+		}
+		passphrase = strings.SplitN(string(data), "\n", 2)[0]
+	}
+
+	if passphrase == "" {
+		return nil, errors.New("no passphrase configured for the CA data")
+	}
+
+	return pki.NewPassphraseSealer([]byte(passphrase)), nil
+}
+
+// CAStoreFromEnv returns the storage selected with PKI_STORAGE, or nil if it keeps the CA in Secrets Manager
+func CAStoreFromEnv() CAStore {
+	if configStorage.Storage == StorageAWS {
+		return nil
+	}
+
+	s, _ := FromEnv()
+	store, _ := s.(CAStore)
+	return store
+}
+
+// FromEnv returns the storage selected with PKI_STORAGE, and its issuance log if enabled
+func FromEnv() (pki.PKIStorage, pki.LogStorage) {
+	if configStorage.Storage == StorageAWS {
+		s := awspki.NewAWSStorage(awsservices.NewSecretsManagerClient(), awsservices.NewDynamoDBClient())
+		if awspki.LogEnabled() {
+			return s, s
+		}
+		return s, nil
+	}
+
+	sealer, err := Sealer()
+	if err != nil {
+		log.WithError(err).Fatal("Error configuring CA data sealing")
+	}
+
+	switch configStorage.Storage {
+	case StorageFS:
+		s := fspki.NewFSStorageFromEnv(sealer)
+		if fspki.LogEnabled() {
+			return s, s
+		}
+		return s, nil
+	case StorageSQL:
+		s, err := sqlpki.NewSQLStorageFromEnv(context.Background(), sealer)
+		if err != nil {
+			log.WithError(err).Fatal("Error opening SQL storage")
+		}
+		if sqlpki.LogEnabled() {
+			return s, s
+		}
+		return s, nil
+	default:
+		log.Fatalf("Unknown storage %q", configStorage.Storage)
+		return nil, nil
+	}
+}
@@ -0,0 +1,241 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	kTLSCryptV2ServerKeyPEMType = "OpenVPN tls-crypt-v2 server key"
+	kTLSCryptV2ClientKeyPEMType = "OpenVPN tls-crypt-v2 client key"
+
+	// The keys hold a cipher and an HMAC key of 64 bytes each, of which AES-256-CTR and HMAC-SHA256 use the first 32
+	kTLSCryptV2KeySize       = 128
+	kTLSCryptV2CipherKeySize = 32
+	kTLSCryptV2HMACKeySize   = 32
+	kTLSCryptV2TagSize       = sha256.Size
+	kTLSCryptV2MaxWKcSize    = 1024
+
+	// TLSCryptV2MetadataUser is the metadata_type OpenVPN gives tls-crypt-v2-verify for our metadata
+	TLSCryptV2MetadataUser = 0
+)
+
+// TLSCryptV2Key is the OpenVPN tls-crypt-v2 server key. Unlike the StaticKey, which every profile shares, it
+// never leaves the servers: each client gets its own key wrapped with it.
+type TLSCryptV2Key []byte
+
+func NewTLSCryptV2Key() TLSCryptV2Key {
+	buf := make([]byte, kTLSCryptV2KeySize)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return TLSCryptV2Key(buf)
+}
+
+func (k TLSCryptV2Key) String() string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: kTLSCryptV2ServerKeyPEMType, Bytes: k}))
+}
+
+// TLSCryptV2Metadata is wrapped in the client keys, the server passes it to tls-crypt-v2-verify
+type TLSCryptV2Metadata struct {
+	Serial   string `json:"serial"`
+	NotAfter int64  `json:"notAfter"`
+}
+
+func (m TLSCryptV2Metadata) Expired(now time.Time) bool {
+	return now.After(time.Unix(m.NotAfter, 0))
+}
+
+// ParseTLSCryptV2Metadata decodes the metadata file of tls-crypt-v2-verify, which has no metadata type byte
+func ParseTLSCryptV2Metadata(data []byte) (*TLSCryptV2Metadata, error) {
+	var m TLSCryptV2Metadata
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, errors.Wrap(err, "decoding tls-crypt-v2 metadata")
+	}
+
+	if _, err := DecodeSerial(m.Serial); err != nil {
+		return nil, errors.Wrap(err, "decoding tls-crypt-v2 metadata")
+	}
+
+	return &m, nil
+}
+
+// clientKey derives the key of a certificate, so exporting its profile again gives the same key
+func (k TLSCryptV2Key) clientKey(serial string) ([]byte, error) {
+	key := make([]byte, 2*kTLSCryptV2KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, k, nil, []byte("tls-crypt-v2 client "+serial)), key); err != nil {
+		return nil, errors.Wrap(err, "deriving client key")
+	}
+	return key, nil
+}
+
+func (k TLSCryptV2Key) keys() (cipher.Block, []byte, error) {
+	if len(k) != kTLSCryptV2KeySize {
+		return nil, nil, errors.New("invalid tls-crypt-v2 server key")
+	}
+
+	block, err := aes.NewCipher(k[:kTLSCryptV2CipherKeySize])
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "creating cipher")
+	}
+
+	return block, k[kTLSCryptV2KeySize/2 : kTLSCryptV2KeySize/2+kTLSCryptV2HMACKeySize], nil
+}
+
+// ClientKey returns the tls-crypt-v2 client key for the certificate, with its serial and expiration as metadata.
+// The key is followed by itself and the metadata wrapped with the server key as OpenVPN does: the HMAC of both is
+// the tag and the IV to encrypt them.
+func (k TLSCryptV2Key) ClientKey(info *CertificateInfo) ([]byte, error) {
+	block, hmacKey, err := k.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	clientKey, err := k.clientKey(info.Serial)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := json.Marshal(TLSCryptV2Metadata{Serial: info.Serial, NotAfter: info.NotAfter.Unix()})
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding tls-crypt-v2 metadata")
+	}
+
+	plain := append(append(clientKey, TLSCryptV2MetadataUser), metadata...)
+	size := kTLSCryptV2TagSize + len(plain) + 2
+	if size > kTLSCryptV2MaxWKcSize {
+		return nil, errors.New("tls-crypt-v2 metadata too long")
+	}
+
+	var netLen [2]byte
+	binary.BigEndian.PutUint16(netLen[:], uint16(size))
+
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write(netLen[:])
+	mac.Write(plain)
+	tag := mac.Sum(nil)
+
+	wrapped := make([]byte, len(plain))
+	cipher.NewCTR(block, tag[:aes.BlockSize]).XORKeyStream(wrapped, plain)
+
+	var out bytes.Buffer
+	out.Write(clientKey)
+	out.Write(tag)
+	out.Write(wrapped)
+	out.Write(netLen[:])
+
+	return pem.EncodeToMemory(&pem.Block{Type: kTLSCryptV2ClientKeyPEMType, Bytes: out.Bytes()}), nil
+}
+
+// unwrapClientKey does what the server does with the wrapped key a client sends, returning the metadata
+func (k TLSCryptV2Key) unwrapClientKey(clientKeyPEM []byte) ([]byte, error) {
+	block, hmacKey, err := k.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	p, _ := pem.Decode(clientKeyPEM)
+	if p == nil || p.Type != kTLSCryptV2ClientKeyPEMType {
+		return nil, errors.Errorf("expected PEM block %s", kTLSCryptV2ClientKeyPEMType)
+	}
+
+	if len(p.Bytes) < 2*kTLSCryptV2KeySize {
+		return nil, errors.New("client key too short")
+	}
+
+	wkc := p.Bytes[2*kTLSCryptV2KeySize:]
+	if len(wkc) < kTLSCryptV2TagSize+2*kTLSCryptV2KeySize+2 || int(binary.BigEndian.Uint16(wkc[len(wkc)-2:])) != len(wkc) {
+		return nil, errors.New("invalid wrapped client key length")
+	}
+
+	tag := wkc[:kTLSCryptV2TagSize]
+	plain := make([]byte, len(wkc)-kTLSCryptV2TagSize-2)
+	cipher.NewCTR(block, tag[:aes.BlockSize]).XORKeyStream(plain, wkc[kTLSCryptV2TagSize:len(wkc)-2])
+
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write(wkc[len(wkc)-2:])
+	mac.Write(plain)
+	if !hmac.Equal(mac.Sum(nil), tag) {
+		return nil, errors.New("wrapped client key authentication failed")
+	}
+
+	if !bytes.Equal(plain[:2*kTLSCryptV2KeySize], p.Bytes[:2*kTLSCryptV2KeySize]) {
+		return nil, errors.New("wrapped client key doesn't match")
+	}
+
+	return plain[2*kTLSCryptV2KeySize:], nil
+}
+
+// TLSCryptV2SettleTime is how long after the switch to tls-crypt-v2 starts the API may still hand out tls-crypt
+// profiles from its cached CA data. The certificates issued until then count as legacy.
+const TLSCryptV2SettleTime = time.Hour
+
+// TLSCryptV2Switch is the move of the servers from the shared StaticKey to tls-crypt-v2. The profiles exported
+// before Started only hold the StaticKey, so until LegacyEnd the main instance keeps tls-crypt for them while the
+// overlap instance takes the tls-crypt-v2 profiles. LegacyEnd is the expiration of the last legacy certificate, set
+// once the switch has settled.
+type TLSCryptV2Switch struct {
+	Started   time.Time `json:"started"`
+	LegacyEnd time.Time `json:"legacyEnd"`
+}
+
+// Legacy tells whether the servers still accept the tls-crypt profiles from before the switch
+func (s *TLSCryptV2Switch) Legacy(now time.Time) bool {
+	return s != nil && (s.LegacyEnd.IsZero() || now.Before(s.LegacyEnd))
+}
+
+var (
+	ErrTLSCryptV2Switching    = errors.New("switch to tls-crypt-v2 already started")
+	ErrTLSCryptV2NotSwitching = errors.New("switch to tls-crypt-v2 not started")
+	ErrTLSCryptV2Settling     = errors.New("switch to tls-crypt-v2 not settled yet")
+)
+
+// StartTLSCryptV2Switch moves new profiles to tls-crypt-v2, creating the key for CA data which has none yet
+func (k CAData) StartTLSCryptV2Switch(now time.Time) (CAData, error) {
+	if k.TLSCryptV2Switch != nil {
+		return CAData{}, ErrTLSCryptV2Switching
+	} else if k.StaticKeyRotation != nil {
+		return CAData{}, ErrStaticKeyRotating
+	}
+
+	k.TLSCryptV2Key = k.tlsCryptV2Key()
+	k.TLSCryptV2Switch = &TLSCryptV2Switch{Started: now.UTC()}
+	return k, nil
+}
+
+// SetTLSCryptV2LegacyEnd ends the legacy tls-crypt instance when the last of certs issued before the switch settled
+// expires, or right away if there are none
+func (k CAData) SetTLSCryptV2LegacyEnd(now time.Time, certs []*CertificateInfo) (CAData, error) {
+	if k.TLSCryptV2Switch == nil {
+		return CAData{}, ErrTLSCryptV2NotSwitching
+	}
+
+	settled := k.TLSCryptV2Switch.Started.Add(TLSCryptV2SettleTime)
+	if now.Before(settled) {
+		return CAData{}, ErrTLSCryptV2Settling
+	}
+
+	legacyEnd := now
+	for _, cert := range certs {
+		if cert.NotBefore.Before(settled) && cert.NotAfter.After(legacyEnd) {
+			legacyEnd = cert.NotAfter
+		}
+	}
+
+	v2Switch := *k.TLSCryptV2Switch
+	v2Switch.LegacyEnd = legacyEnd.UTC()
+	k.TLSCryptV2Switch = &v2Switch
+	return k, nil
+}
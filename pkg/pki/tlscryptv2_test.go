@@ -0,0 +1,194 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestTLSCryptV2ClientKey(t *testing.T) {
+	caKey, err := NewCAKey(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := CreateCertificate(caKey.CACert, caKey.PrivateKey, caKey.PublicKey, pkix.Name{CommonName: "test"}, ClientCert, WithDuration(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := CertInfoFromX509Cert(cert)
+
+	clientKey, err := caKey.TLSCryptV2Key.ClientKey(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Exporting the profile again gives the same key
+	again, err := caKey.TLSCryptV2Key.ClientKey(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(clientKey, again) {
+		t.Error("client key is not reproducible")
+	}
+
+	metadata, err := caKey.TLSCryptV2Key.unwrapClientKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metadata[0] != TLSCryptV2MetadataUser {
+		t.Errorf("unexpected metadata type %d", metadata[0])
+	}
+
+	parsed, err := ParseTLSCryptV2Metadata(metadata[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Serial != info.Serial || parsed.NotAfter != info.NotAfter.Unix() || parsed.Expired(time.Now()) {
+		t.Errorf("unexpected metadata %+v", parsed)
+	}
+
+	if _, err := NewTLSCryptV2Key().unwrapClientKey(clientKey); err == nil {
+		t.Error("expected error unwrapping with another server key")
+	}
+
+	block, _ := pem.Decode(clientKey)
+	block.Bytes[len(block.Bytes)-10] ^= 1
+	if _, err := caKey.TLSCryptV2Key.unwrapClientKey(pem.EncodeToMemory(block)); err == nil {
+		t.Error("expected error unwrapping a tampered key")
+	}
+}
+
+func TestTLSCryptV2KeyRenewal(t *testing.T) {
+	caKey, err := NewCAKey(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored CAData
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(stored.TLSCryptV2Key, caKey.TLSCryptV2Key) {
+		t.Error("tls-crypt-v2 key not stored")
+	}
+
+	renewed, err := stored.Renew(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(renewed.TLSCryptV2Key, caKey.TLSCryptV2Key) {
+		t.Error("tls-crypt-v2 key not kept on renewal")
+	}
+
+	// CA data from before tls-crypt-v2 gets a key when renewed
+	stored.TLSCryptV2Key = nil
+	renewed, err = stored.Renew(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(renewed.TLSCryptV2Key) != kTLSCryptV2KeySize {
+		t.Error("tls-crypt-v2 key not created on renewal")
+	}
+}
+
+func TestTLSCryptV2Switch(t *testing.T) {
+	caKey, err := NewCAKey(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CA data from before tls-crypt-v2
+	caKey.TLSCryptV2Key = nil
+	now := time.Now()
+
+	rotating, err := caKey.StartStaticKeyRotation(now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotating.StartTLSCryptV2Switch(now); err != ErrStaticKeyRotating {
+		t.Errorf("expected %v during a static key rotation, got %v", ErrStaticKeyRotating, err)
+	}
+
+	switching, err := caKey.StartTLSCryptV2Switch(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(switching.TLSCryptV2Key) != kTLSCryptV2KeySize {
+		t.Error("tls-crypt-v2 key not created")
+	}
+	if !switching.TLSCryptV2Switch.Legacy(now.Add(100 * 24 * time.Hour)) {
+		t.Error("tls-crypt not accepted before the switch settled")
+	}
+	if _, err := switching.StartTLSCryptV2Switch(now); err != ErrTLSCryptV2Switching {
+		t.Errorf("expected %v, got %v", ErrTLSCryptV2Switching, err)
+	}
+
+	data, err := json.Marshal(switching)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored CAData
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.TLSCryptV2Switch == nil || !stored.TLSCryptV2Switch.Started.Equal(switching.TLSCryptV2Switch.Started) {
+		t.Error("switch to tls-crypt-v2 not stored")
+	}
+
+	renewed, err := stored.Renew(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.TLSCryptV2Switch == nil {
+		t.Error("switch to tls-crypt-v2 not kept on renewal")
+	}
+
+	if _, err := stored.SetTLSCryptV2LegacyEnd(now.Add(time.Minute), nil); err != ErrTLSCryptV2Settling {
+		t.Errorf("expected %v, got %v", ErrTLSCryptV2Settling, err)
+	}
+
+	settled := now.Add(TLSCryptV2SettleTime)
+	certs := []*CertificateInfo{
+		{NotBefore: now.Add(-24 * time.Hour), NotAfter: now.Add(30 * 24 * time.Hour)},
+		{NotBefore: now.Add(-time.Hour), NotAfter: now.Add(90 * 24 * time.Hour)},
+		// Issued after the switch settled, the profile has tls-crypt-v2
+		{NotBefore: settled.Add(time.Minute), NotAfter: now.Add(365 * 24 * time.Hour)},
+	}
+
+	ended, err := stored.SetTLSCryptV2LegacyEnd(settled.Add(time.Minute), certs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ended.TLSCryptV2Switch.LegacyEnd.Equal(certs[1].NotAfter) {
+		t.Errorf("expected tls-crypt accepted until %s, got %s", certs[1].NotAfter, ended.TLSCryptV2Switch.LegacyEnd)
+	}
+	if !ended.TLSCryptV2Switch.Legacy(certs[1].NotAfter.Add(-time.Minute)) || ended.TLSCryptV2Switch.Legacy(certs[1].NotAfter) {
+		t.Error("tls-crypt not accepted exactly until the last legacy certificate expires")
+	}
+	if !stored.TLSCryptV2Switch.LegacyEnd.IsZero() {
+		t.Error("setting the end of tls-crypt changed the original CA data")
+	}
+
+	// Without legacy certificates tls-crypt is dropped right away
+	ended, err = stored.SetTLSCryptV2LegacyEnd(settled, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ended.TLSCryptV2Switch.Legacy(settled) {
+		t.Error("tls-crypt accepted without legacy certificates")
+	}
+}
@@ -0,0 +1,488 @@
+package pki
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+type LogEntryType string
+
+const (
+	LogEntryIssued     LogEntryType = "issued"
+	LogEntryRevoked    LogEntryType = "revoked"
+	LogEntryHeld       LogEntryType = "held"
+	LogEntryReinstated LogEntryType = "reinstated"
+
+	// LogEntryAborted records that the storage write of the previous entry of the same serial failed
+	LogEntryAborted LogEntryType = "aborted"
+
+	// LogEntryImported records a certificate stored before the issuance log was enabled, see ImportIssuanceLog
+	LogEntryImported LogEntryType = "imported"
+
+	kLogAppendAttempts = 5
+	kLogPageSize       = 500
+)
+
+var (
+	// ErrLogConflict is returned by LogStorage.AppendLogEntry when an entry with the same index exists
+	ErrLogConflict = errors.New("issuance log entry already exists")
+)
+
+// LogEntry is a record of the issuance log. Each entry commits to the previous one through PrevHash, so
+// removing or altering any entry breaks the chain from that point on.
+type LogEntry struct {
+	Index     uint64       `json:"index"`
+	Timestamp time.Time    `json:"timestamp"`
+	Type      LogEntryType `json:"type"`
+	Serial    string       `json:"serial"`
+
+	// CertHash is the SHA-256 of the certificate DER, only for issued and imported entries
+	CertHash    []byte           `json:"certHash,omitempty"`
+	Predecessor string           `json:"predecessor,omitempty"`
+	Reason      RevocationReason `json:"reason,omitempty"`
+	Actor       string           `json:"actor,omitempty"`
+
+	PrevHash []byte `json:"prevHash"`
+	Hash     []byte `json:"hash"`
+}
+
+// TreeHead is a signature by the CA over the head of the issuance log
+type TreeHead struct {
+	Size      uint64    `json:"size"`
+	Hash      []byte    `json:"hash"`
+	Timestamp time.Time `json:"timestamp"`
+	KeyId     []byte    `json:"keyId"`
+	Signature []byte    `json:"signature"`
+}
+
+type LogStorage interface {
+	AppendLogEntry(ctx context.Context, entry *LogEntry) error
+	GetLogHead(ctx context.Context) (*LogEntry, error)
+	ListLogEntries(ctx context.Context, from uint64, limit int) ([]*LogEntry, error)
+	PutTreeHead(ctx context.Context, th *TreeHead) error
+	GetTreeHead(ctx context.Context) (*TreeHead, error)
+}
+
+func writeField(h hash.Hash, data []byte) {
+	_ = binary.Write(h, binary.BigEndian, uint32(len(data)))
+	h.Write(data)
+}
+
+func (e *LogEntry) ComputeHash() []byte {
+	h := sha256.New()
+	_ = binary.Write(h, binary.BigEndian, e.Index)
+	_ = binary.Write(h, binary.BigEndian, e.Timestamp.UnixNano())
+	writeField(h, []byte(e.Type))
+	writeField(h, []byte(e.Serial))
+	writeField(h, e.CertHash)
+	writeField(h, []byte(e.Predecessor))
+	_ = binary.Write(h, binary.BigEndian, int32(e.Reason))
+	writeField(h, []byte(e.Actor))
+	writeField(h, e.PrevHash)
+	return h.Sum(nil)
+}
+
+func newLogEntry(head *LogEntry, entryType LogEntryType, info *CertificateInfo) *LogEntry {
+	entry := &LogEntry{
+		Timestamp: time.Now().UTC(),
+		Type:      entryType,
+		Serial:    info.Serial,
+		PrevHash:  make([]byte, sha256.Size),
+	}
+
+	if head != nil {
+		entry.Index = head.Index + 1
+		entry.PrevHash = head.Hash
+	}
+
+	switch entryType {
+	case LogEntryIssued, LogEntryImported:
+		sum := sha256.Sum256(info.Certificate.Raw)
+		entry.CertHash = sum[:]
+		entry.Predecessor = info.Predecessor
+	case LogEntryRevoked:
+		entry.Reason = info.RevocationReason
+		entry.Actor = info.RevokedBy
+	}
+
+	entry.Hash = entry.ComputeHash()
+	return entry
+}
+
+func (th *TreeHead) signedData() []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, th.Size)
+	_ = binary.Write(&buf, binary.BigEndian, th.Timestamp.UnixNano())
+	buf.Write(th.Hash)
+	return buf.Bytes()
+}
+
+func signData(signer crypto.Signer, data []byte) ([]byte, error) {
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		return signer.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+
+	digest := sha256.Sum256(data)
+	return signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+func verifyData(pub crypto.PublicKey, data []byte, sig []byte) error {
+	digest := sha256.Sum256(data)
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, data, sig) {
+			return errors.New("invalid Ed25519 signature")
+		}
+		return nil
+	default:
+		return errors.New("unsupported key type")
+	}
+}
+
+// Verify checks the tree head signature with the public key of the signing CA
+func (th *TreeHead) Verify(pub crypto.PublicKey) error {
+	return verifyData(pub, th.signedData(), th.Signature)
+}
+
+func (pki *PKI) SetIssuanceLog(l LogStorage) {
+	pki.log = l
+}
+
+// appendLog records an operation in the issuance log, if there is one. Concurrent writers race for the
+// next index, so the append is retried from the new head on conflict.
+func (pki *PKI) appendLog(ctx context.Context, entryType LogEntryType, info *CertificateInfo) error {
+	if pki.log == nil {
+		return nil
+	}
+
+	for attempt := 0; attempt < kLogAppendAttempts; attempt++ {
+		head, err := pki.log.GetLogHead(ctx)
+		if err != nil {
+			return errors.Wrap(err, "obtaining issuance log head")
+		}
+
+		err = pki.log.AppendLogEntry(ctx, newLogEntry(head, entryType, info))
+		if errors.Cause(err) == ErrLogConflict {
+			continue
+		}
+		return errors.Wrap(err, "appending to issuance log")
+	}
+
+	return errors.Wrap(ErrLogConflict, "appending to issuance log")
+}
+
+// logged appends the entry for info to the issuance log before write changes the storage, so no stored change is
+// missing from the log. If write fails an aborted entry cancels it. A crash in between leaves the entry without
+// its change, which VerifyIssuanceLog reports.
+func (pki *PKI) logged(ctx context.Context, entryType LogEntryType, info *CertificateInfo, write func() (*CertificateInfo, error)) (*CertificateInfo, error) {
+	if err := pki.appendLog(ctx, entryType, info); err != nil {
+		return nil, err
+	}
+
+	stored, err := write()
+	if err != nil || stored == nil {
+		if logErr := pki.appendLog(ctx, LogEntryAborted, info); logErr != nil {
+			log.WithError(logErr).WithField("serial", info.Serial).Error("Error logging aborted change")
+		}
+	}
+
+	return stored, err
+}
+
+// SignTreeHead signs the current head of the issuance log with the CA key and stores it
+func (pki *PKI) SignTreeHead(ctx context.Context) (*TreeHead, error) {
+	if pki.log == nil {
+		return nil, errors.New("no issuance log")
+	}
+
+	signer, err := pki.storage.GetSigner(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "obtaining CA key")
+	}
+
+	head, err := pki.log.GetLogHead(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "obtaining issuance log head")
+	}
+
+	th := &TreeHead{
+		Hash:      make([]byte, sha256.Size),
+		Timestamp: time.Now().UTC(),
+		KeyId:     pki.storage.GetCACert(ctx).SubjectKeyId,
+	}
+
+	if head != nil {
+		th.Size = head.Index + 1
+		th.Hash = head.Hash
+	}
+
+	th.Signature, err = signData(signer, th.signedData())
+	if err != nil {
+		return nil, errors.Wrap(err, "signing tree head")
+	}
+
+	if err := pki.log.PutTreeHead(ctx, th); err != nil {
+		return nil, errors.Wrap(err, "storing tree head")
+	}
+
+	return th, nil
+}
+
+// VerifyIssuanceLog walks the whole log checking the hash chain and the latest tree head, and cross-checks
+// it against the certificate storage. It returns a description of every inconsistency found.
+func (pki *PKI) VerifyIssuanceLog(ctx context.Context) ([]string, error) {
+	if pki.log == nil {
+		return nil, errors.New("no issuance log")
+	}
+
+	var problems []string
+	changes := make(map[string][]*LogEntry)
+	var started time.Time
+
+	prevHash := make([]byte, sha256.Size)
+	var size uint64
+	for {
+		entries, err := pki.log.ListLogEntries(ctx, size, kLogPageSize)
+		if err != nil {
+			return nil, errors.Wrap(err, "listing issuance log")
+		}
+
+		for _, entry := range entries {
+			if entry.Index != size {
+				problems = append(problems, fmt.Sprintf("entry %d: expected index %d", entry.Index, size))
+			}
+
+			if !bytes.Equal(entry.PrevHash, prevHash) {
+				problems = append(problems, fmt.Sprintf("entry %d: chain broken, previous hash mismatch", entry.Index))
+			}
+
+			if !bytes.Equal(entry.ComputeHash(), entry.Hash) {
+				problems = append(problems, fmt.Sprintf("entry %d: hash mismatch", entry.Index))
+			}
+
+			if entry.Index == 0 {
+				started = logStart(entry)
+			}
+
+			if entry.Type != LogEntryAborted {
+				changes[entry.Serial] = append(changes[entry.Serial], entry)
+			} else if n := len(changes[entry.Serial]); n > 0 {
+				changes[entry.Serial] = changes[entry.Serial][:n-1]
+			} else {
+				problems = append(problems, fmt.Sprintf("entry %d: aborts no change of %s", entry.Index, entry.Serial))
+			}
+
+			prevHash = entry.Hash
+			size = entry.Index + 1
+		}
+
+		if len(entries) < kLogPageSize {
+			break
+		}
+	}
+
+	th, err := pki.log.GetTreeHead(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "obtaining tree head")
+	}
+
+	if th == nil {
+		problems = append(problems, "no signed tree head")
+	} else {
+		if err := pki.verifyTreeHead(ctx, th); err != nil {
+			problems = append(problems, fmt.Sprintf("tree head: %s", err))
+		}
+
+		if th.Size > size {
+			problems = append(problems, fmt.Sprintf("tree head covers %d entries but the log has %d", th.Size, size))
+		} else if th.Size > 0 {
+			entries, err := pki.log.ListLogEntries(ctx, th.Size-1, 1)
+			if err != nil {
+				return nil, errors.Wrap(err, "listing issuance log")
+			}
+
+			if len(entries) != 1 || !bytes.Equal(entries[0].Hash, th.Hash) {
+				problems = append(problems, fmt.Sprintf("tree head hash doesn't match entry %d", th.Size-1))
+			}
+		}
+	}
+
+	issued := make(map[string][]byte)
+	imported := make(map[string]bool)
+	revoked := make(map[string]bool)
+	for serial, entries := range changes {
+		for _, entry := range entries {
+			switch entry.Type {
+			case LogEntryImported:
+				issued[serial] = entry.CertHash
+				imported[serial] = true
+			case LogEntryIssued:
+				issued[serial] = entry.CertHash
+				if entry.Predecessor != "" {
+					revoked[entry.Predecessor] = true
+				}
+			case LogEntryRevoked:
+				revoked[serial] = true
+			}
+		}
+	}
+
+	// Every logged certificate must still be stored unaltered
+	for serial, certHash := range issued {
+		serialBytes, err := hex.DecodeString(serial)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("certificate %s: invalid serial", serial))
+			continue
+		}
+
+		info, err := pki.storage.GetCertBySerial(ctx, serialBytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "obtaining certificate %s", serial)
+		}
+
+		if info == nil {
+			problems = append(problems, fmt.Sprintf("certificate %s: logged but missing from storage", serial))
+			continue
+		}
+
+		if sum := sha256.Sum256(info.Certificate.Raw); !bytes.Equal(sum[:], certHash) {
+			problems = append(problems, fmt.Sprintf("certificate %s: stored certificate differs from the logged one", serial))
+		}
+
+		if imported[serial] && !info.NotBefore.Before(started) {
+			problems = append(problems, fmt.Sprintf("certificate %s: imported but issued after the log started", serial))
+		}
+	}
+
+	// And every stored certificate must have been logged, the expired and server ones too
+	query := CertQuery{Limit: MaxCertQueryLimit}
+	for {
+		page, err := pki.storage.ListCerts(ctx, query)
+		if err != nil {
+			return nil, errors.Wrap(err, "listing certificates")
+		}
+
+		for _, info := range page.Certs {
+			if _, ok := issued[info.Serial]; !ok {
+				problems = append(problems, fmt.Sprintf("certificate %s: stored but never logged", info.Serial))
+			}
+
+			if info.Revoked != nil && !revoked[info.Serial] {
+				problems = append(problems, fmt.Sprintf("certificate %s: revoked but the revocation was never logged", info.Serial))
+			}
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+
+	return problems, nil
+}
+
+// logStart is the time the log started at from its first entry, in the precision of the certificate times
+func logStart(first *LogEntry) time.Time {
+	return first.Timestamp.Truncate(time.Second)
+}
+
+// ImportIssuanceLog logs the certificates stored before the issuance log was enabled, which VerifyIssuanceLog
+// would otherwise report as never logged, along with their revocations. Only the certificates issued before the
+// first entry of the log are imported, so it can be run again after a failure. It returns the serials imported.
+func (pki *PKI) ImportIssuanceLog(ctx context.Context) ([]string, error) {
+	if pki.log == nil {
+		return nil, errors.New("no issuance log")
+	}
+
+	logged := make(map[string]bool)
+	started := time.Now().Truncate(time.Second)
+
+	var size uint64
+	for {
+		entries, err := pki.log.ListLogEntries(ctx, size, kLogPageSize)
+		if err != nil {
+			return nil, errors.Wrap(err, "listing issuance log")
+		}
+
+		for _, entry := range entries {
+			if entry.Index == 0 {
+				started = logStart(entry)
+			}
+
+			logged[entry.Serial] = true
+			size = entry.Index + 1
+		}
+
+		if len(entries) < kLogPageSize {
+			break
+		}
+	}
+
+	var serials []string
+	query := CertQuery{Limit: MaxCertQueryLimit}
+	for {
+		page, err := pki.storage.ListCerts(ctx, query)
+		if err != nil {
+			return nil, errors.Wrap(err, "listing certificates")
+		}
+
+		for _, info := range page.Certs {
+			if logged[info.Serial] || !info.NotBefore.Before(started) {
+				continue
+			}
+
+			if err := pki.appendLog(ctx, LogEntryImported, info); err != nil {
+				return serials, errors.Wrapf(err, "importing certificate %s", info.Serial)
+			}
+
+			if info.Revoked != nil {
+				if err := pki.appendLog(ctx, LogEntryRevoked, info); err != nil {
+					return serials, errors.Wrapf(err, "importing revocation of %s", info.Serial)
+				}
+			}
+
+			serials = append(serials, info.Serial)
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+
+	return serials, nil
+}
+
+// verifyTreeHead checks the signature with the current CA, or the previous one for heads signed before rotation
+func (pki *PKI) verifyTreeHead(ctx context.Context, th *TreeHead) error {
+	for _, ca := range []*x509.Certificate{pki.storage.GetCACert(ctx), pki.storage.GetPrevCACert(ctx)} {
+		if ca != nil && bytes.Equal(ca.SubjectKeyId, th.KeyId) {
+			return th.Verify(ca.PublicKey)
+		}
+	}
+
+	return errors.Errorf("signed by unknown key %x", th.KeyId)
+}
@@ -0,0 +1,69 @@
+package pki
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+)
+
+func TestLogChain(t *testing.T) {
+	privKey, err := NewPrivateKey(KeyAlgorithmP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := CreateCertificate(nil, privKey, GetPublicKey(privKey), pkix.Name{CommonName: "test"}, ClientCert, WithDuration(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := CertInfoFromX509Cert(cert)
+
+	var entries []*LogEntry
+	var head *LogEntry
+	for _, entryType := range []LogEntryType{LogEntryIssued, LogEntryHeld, LogEntryReinstated, LogEntryRevoked} {
+		head = newLogEntry(head, entryType, info)
+		entries = append(entries, head)
+	}
+
+	for i, entry := range entries {
+		if entry.Index != uint64(i) {
+			t.Fatalf("unexpected index %d", entry.Index)
+		}
+
+		if i > 0 && !bytes.Equal(entry.PrevHash, entries[i-1].Hash) {
+			t.Fatalf("entry %d doesn't chain to the previous one", i)
+		}
+	}
+
+	entries[1].Serial = "00"
+	if bytes.Equal(entries[1].ComputeHash(), entries[1].Hash) {
+		t.Fatal("tampered entry still matches its hash")
+	}
+}
+
+func TestTreeHeadSignature(t *testing.T) {
+	for _, alg := range []KeyAlgorithm{KeyAlgorithmRSA2048, KeyAlgorithmP256, KeyAlgorithmEd25519} {
+		privKey, err := NewPrivateKey(alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		th := &TreeHead{Size: 3, Hash: bytes.Repeat([]byte{1}, 32), Timestamp: time.Now()}
+		th.Signature, err = signData(privKey.(crypto.Signer), th.signedData())
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+
+		if err := th.Verify(GetPublicKey(privKey)); err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+
+		th.Size++
+		if err := th.Verify(GetPublicKey(privKey)); err == nil {
+			t.Fatalf("%s: modified tree head verified", alg)
+		}
+	}
+}
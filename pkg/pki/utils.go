@@ -3,6 +3,7 @@ package pki
 import (
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
@@ -29,6 +30,17 @@ const (
 	CertTypeCA               = "CA"
 )
 
+type KeyAlgorithm string
+
+const (
+	KeyAlgorithmRSA2048 KeyAlgorithm = "RSA-2048"
+	KeyAlgorithmRSA3072 KeyAlgorithm = "RSA-3072"
+	KeyAlgorithmRSA4096 KeyAlgorithm = "RSA-4096"
+	KeyAlgorithmP256    KeyAlgorithm = "P-256"
+	KeyAlgorithmP384    KeyAlgorithm = "P-384"
+	KeyAlgorithmEd25519 KeyAlgorithm = "Ed25519"
+)
+
 var kSerialMaxValue = new(big.Int).Lsh(big.NewInt(1), 128)
 
 func DecodeSerial(serial string) ([]byte, error) {
@@ -80,6 +92,8 @@ func EncodeDERPrivateKey(key crypto.PrivateKey) ([]byte, error) {
 		return x509.MarshalPKCS1PrivateKey(key), nil
 	case *ecdsa.PrivateKey:
 		return x509.MarshalECPrivateKey(key)
+	case ed25519.PrivateKey:
+		return x509.MarshalPKCS8PrivateKey(key)
 	default:
 		return nil, errors.New("unsupported key type")
 	}
@@ -97,6 +111,8 @@ func EncodePEMPrivateKey(key crypto.PrivateKey) ([]byte, error) {
 		block = pem.Block{Type: "RSA PRIVATE KEY", Bytes: derBytes}
 	case *ecdsa.PrivateKey:
 		block = pem.Block{Type: "EC PRIVATE KEY", Bytes: derBytes}
+	case ed25519.PrivateKey:
+		block = pem.Block{Type: "PRIVATE KEY", Bytes: derBytes}
 	default:
 		return nil, errors.New("unsupported key type")
 	}
@@ -104,26 +120,110 @@ func EncodePEMPrivateKey(key crypto.PrivateKey) ([]byte, error) {
 	return pem.EncodeToMemory(&block), nil
 }
 
-func NewPrivateKey() (crypto.PrivateKey, error) {
-	switch os.Getenv("PKI_KEY_TYPE") {
+// ParseKeyAlgorithm also accepts the legacy PKI_KEY_TYPE values RSA and EC
+func ParseKeyAlgorithm(name string) (KeyAlgorithm, error) {
+	switch KeyAlgorithm(name) {
+	case KeyAlgorithmRSA2048, KeyAlgorithmRSA3072, KeyAlgorithmRSA4096, KeyAlgorithmP256, KeyAlgorithmP384, KeyAlgorithmEd25519:
+		return KeyAlgorithm(name), nil
+	}
+
+	switch name {
 	case "RSA":
-		return rsa.GenerateKey(rand.Reader, 2048)
+		return KeyAlgorithmRSA2048, nil
 	case "EC":
-		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		return KeyAlgorithmP256, nil
 	default:
-		return nil, errors.New("invalid PKI_KEY_TYPE")
+		return "", errors.Errorf("invalid key algorithm %q", name)
 	}
 }
 
-func GetPublicKey(key crypto.PrivateKey) crypto.PublicKey {
+// KeyAlgorithmFromEnv reads the algorithm from the given variable, falling back to PKI_KEY_TYPE
+func KeyAlgorithmFromEnv(key string) (KeyAlgorithm, error) {
+	if name, ok := os.LookupEnv(key); ok {
+		return ParseKeyAlgorithm(name)
+	}
+	return ParseKeyAlgorithm(os.Getenv("PKI_KEY_TYPE"))
+}
+
+// CheckCAKeyAlgorithm refuses Ed25519 for the issuing CA, as its key also signs the OCSP responses and
+// golang.org/x/crypto/ocsp only signs with RSA and ECDSA keys
+func CheckCAKeyAlgorithm(alg KeyAlgorithm) error {
+	if alg == KeyAlgorithmEd25519 {
+		return errors.Errorf("key algorithm %s can't sign OCSP responses, use an RSA or ECDSA key for the CA", alg)
+	}
+	return nil
+}
+
+// ParseCAKeyAlgorithm is ParseKeyAlgorithm for the issuing CA
+func ParseCAKeyAlgorithm(name string) (KeyAlgorithm, error) {
+	alg, err := ParseKeyAlgorithm(name)
+	if err != nil {
+		return "", err
+	}
+	return alg, CheckCAKeyAlgorithm(alg)
+}
+
+// CAKeyAlgorithmFromEnv reads the issuing CA algorithm from PKI_CA_KEY_ALGORITHM, defaulting to P-256. Unlike the
+// client keys it doesn't fall back to PKI_KEY_TYPE.
+func CAKeyAlgorithmFromEnv() (KeyAlgorithm, error) {
+	name := os.Getenv("PKI_CA_KEY_ALGORITHM")
+	if name == "" {
+		return KeyAlgorithmP256, nil
+	}
+	return ParseCAKeyAlgorithm(name)
+}
+
+func GetKeyAlgorithm(key crypto.PublicKey) (KeyAlgorithm, error) {
 	switch key := key.(type) {
-	case *rsa.PrivateKey:
-		return key.Public()
-	case *ecdsa.PrivateKey:
-		return key.Public()
+	case *rsa.PublicKey:
+		switch key.N.BitLen() {
+		case 2048:
+			return KeyAlgorithmRSA2048, nil
+		case 3072:
+			return KeyAlgorithmRSA3072, nil
+		case 4096:
+			return KeyAlgorithmRSA4096, nil
+		}
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return KeyAlgorithmP256, nil
+		case elliptic.P384():
+			return KeyAlgorithmP384, nil
+		}
+	case ed25519.PublicKey:
+		return KeyAlgorithmEd25519, nil
+	}
+
+	return "", errors.New("unsupported key type")
+}
+
+func NewPrivateKey(alg KeyAlgorithm) (crypto.PrivateKey, error) {
+	switch alg {
+	case KeyAlgorithmRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyAlgorithmRSA3072:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case KeyAlgorithmRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case KeyAlgorithmP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyAlgorithmP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyAlgorithmEd25519:
+		_, privKey, err := ed25519.GenerateKey(rand.Reader)
+		return privKey, err
 	default:
-		panic("unsupported key type")
+		return nil, errors.Errorf("invalid key algorithm %q", alg)
+	}
+}
+
+// GetPublicKey works for in-memory keys as well as for any other crypto.Signer
+func GetPublicKey(key crypto.PrivateKey) crypto.PublicKey {
+	if signer, ok := key.(crypto.Signer); ok {
+		return signer.Public()
 	}
+	return nil
 }
 
 func GetCertType(cert *x509.Certificate) CertType {
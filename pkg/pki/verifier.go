@@ -0,0 +1,162 @@
+package pki
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type VerifyPath string
+
+const (
+	// VerifyPathCurrent is a leaf issued by the current CA
+	VerifyPathCurrent VerifyPath = "current"
+	// VerifyPathPrevious is a leaf issued by the previous CA, still trusted during a rotation
+	VerifyPathPrevious VerifyPath = "previous"
+	// VerifyPathCross is a leaf issued by the current CA as seen by peers trusting only the previous CA, through
+	// the cross certificate
+	VerifyPathCross VerifyPath = "cross"
+)
+
+var ErrNoVerifyPath = errors.New("no such verification path for the CA")
+
+// Verification is a validated chain, from the leaf to the trust anchor
+type Verification struct {
+	Path  VerifyPath
+	Chain []*x509.Certificate
+
+	// Expires is when the first certificate of the chain expires, before the leaf if it outlives an issuer
+	Expires  time.Time
+	Warnings []string
+}
+
+// OutlivesIssuer tells whether the chain breaks before the leaf expires
+func (v *Verification) OutlivesIssuer() bool {
+	return v.Expires.Before(v.Chain[0].NotAfter)
+}
+
+type verifyPool struct {
+	roots         []*x509.Certificate
+	intermediates []*x509.Certificate
+}
+
+// Verifier validates client and server certificates against the current and previous CAs of the CA data, like
+// the servers and the clients do with the certificates in their configs
+type Verifier struct {
+	paths map[VerifyPath]verifyPool
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject)
+}
+
+// NewVerifier builds the paths of k. Only the certificates are used, so the data may come without keys.
+func NewVerifier(k CAData) *Verifier {
+	v := &Verifier{paths: map[VerifyPath]verifyPool{}}
+	if k.CACert == nil {
+		return v
+	}
+
+	v.paths[VerifyPathCurrent] = verifyPool{
+		roots:         []*x509.Certificate{k.trustAnchor()},
+		intermediates: k.intermediates(),
+	}
+
+	if k.PrevCACert == nil {
+		return v
+	}
+
+	// A previous intermediate chains to the current root, a previous single tier CA is its own anchor
+	prev := verifyPool{roots: []*x509.Certificate{k.PrevCACert}}
+	if !isSelfSigned(k.PrevCACert) && len(k.Chain) > 0 {
+		prev.roots = []*x509.Certificate{k.trustAnchor()}
+		prev.intermediates = append([]*x509.Certificate{k.PrevCACert}, k.Chain[:len(k.Chain)-1]...)
+	}
+	v.paths[VerifyPathPrevious] = prev
+
+	cross := verifyPool{
+		roots:         prev.roots,
+		intermediates: k.intermediates(),
+	}
+	if k.CrossCert != nil {
+		cross.intermediates = append(cross.intermediates, k.CrossCert)
+	}
+	v.paths[VerifyPathCross] = cross
+
+	return v
+}
+
+// GetVerifier returns the verifier of the current CA data
+func (pki *PKI) GetVerifier(ctx context.Context) *Verifier {
+	return NewVerifier(CAData{
+		CACert:     pki.storage.GetCACert(ctx),
+		PrevCACert: pki.storage.GetPrevCACert(ctx),
+		CrossCert:  pki.storage.GetCrossCert(ctx),
+		Chain:      pki.storage.GetCAChain(ctx),
+	})
+}
+
+// Verify validates leaf through the current CA, or else the previous one
+func (v *Verifier) Verify(leaf *x509.Certificate, now time.Time) (*Verification, error) {
+	result, err := v.VerifyPath(leaf, VerifyPathCurrent, now)
+	if err == nil {
+		return result, nil
+	}
+
+	if _, ok := v.paths[VerifyPathPrevious]; ok {
+		if result, prevErr := v.VerifyPath(leaf, VerifyPathPrevious, now); prevErr == nil {
+			return result, nil
+		}
+	}
+
+	return nil, err
+}
+
+// VerifyPath validates leaf through the path only
+func (v *Verifier) VerifyPath(leaf *x509.Certificate, path VerifyPath, now time.Time) (*Verification, error) {
+	pool, ok := v.paths[path]
+	if !ok {
+		return nil, ErrNoVerifyPath
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         x509.NewCertPool(),
+		Intermediates: x509.NewCertPool(),
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+
+	for _, cert := range pool.roots {
+		opts.Roots.AddCert(cert)
+	}
+	for _, cert := range pool.intermediates {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	chains, err := leaf.Verify(opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "verifying through the %s CA", path)
+	}
+
+	result := &Verification{
+		Path:    path,
+		Chain:   chains[0],
+		Expires: leaf.NotAfter,
+	}
+
+	for _, cert := range result.Chain[1:] {
+		if cert.NotAfter.Before(leaf.NotAfter) {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("certificate outlives %s, which expires on %s",
+				cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339)))
+		}
+		if cert.NotAfter.Before(result.Expires) {
+			result.Expires = cert.NotAfter
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,110 @@
+package pki
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func issueTestLeaf(t *testing.T, ca CAData, opts ...CertOptions) *x509.Certificate {
+	opts = append([]CertOptions{ClientCert, WithDuration(time.Hour)}, opts...)
+	cert, err := CreateCertificate(ca.CACert, ca.PrivateKey, ca.PublicKey, pkix.Name{CommonName: "test"}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestVerifier(t *testing.T) {
+	now := time.Now()
+
+	caKey, err := NewCAKey(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renewed, err := caKey.Renew(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := NewCAKey(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldLeaf, newLeaf := issueTestLeaf(t, caKey), issueTestLeaf(t, renewed, ServerCert)
+	verifier := NewVerifier(renewed)
+
+	for leaf, path := range map[*x509.Certificate]VerifyPath{oldLeaf: VerifyPathPrevious, newLeaf: VerifyPathCurrent} {
+		result, err := verifier.Verify(leaf, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.Path != path || result.OutlivesIssuer() || len(result.Warnings) > 0 {
+			t.Errorf("unexpected verification %+v, expected path %s", result, path)
+		}
+	}
+
+	if result, err := verifier.VerifyPath(newLeaf, VerifyPathCross, now); err != nil {
+		t.Errorf("new certificate not trusted through the cross certificate: %v", err)
+	} else if len(result.Chain) != 3 {
+		t.Errorf("expected the cross certificate in the chain, got %d certificates", len(result.Chain))
+	}
+
+	if _, err := verifier.Verify(issueTestLeaf(t, other), now); err == nil {
+		t.Error("foreign certificate accepted")
+	}
+
+	if _, err := NewVerifier(caKey).VerifyPath(oldLeaf, VerifyPathPrevious, now); err != ErrNoVerifyPath {
+		t.Errorf("expected no previous path, got %v", err)
+	}
+
+	long := issueTestLeaf(t, renewed, WithTimespan(now.Add(-time.Minute), renewed.CACert.NotAfter.Add(time.Hour)))
+	result, err := verifier.Verify(long, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.OutlivesIssuer() || !result.Expires.Equal(renewed.CACert.NotAfter) || len(result.Warnings) != 1 {
+		t.Errorf("expected a warning for the certificate outliving the CA, got %+v", result)
+	}
+}
+
+func TestVerifierIntermediate(t *testing.T) {
+	now := time.Now()
+
+	root, err := NewRootCA(kCAName+" Root", uuid.New().String(), KeyAlgorithmP384, 10*kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caKey, err := root.NewIntermediate(kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renewed, err := root.RenewIntermediate(caKey, kCAName, uuid.New().String(), KeyAlgorithmP256, kDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := NewVerifier(renewed)
+	for leaf, path := range map[*x509.Certificate]VerifyPath{
+		issueTestLeaf(t, caKey):   VerifyPathPrevious,
+		issueTestLeaf(t, renewed): VerifyPathCurrent,
+	} {
+		result, err := verifier.Verify(leaf, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.Path != path || !result.Chain[len(result.Chain)-1].Equal(root.CACert) {
+			t.Errorf("unexpected verification %+v, expected path %s to the root", result, path)
+		}
+	}
+}